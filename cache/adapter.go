@@ -19,6 +19,31 @@ type Adapter interface {
 	GetUploadEnv() map[string]string
 }
 
+// ChunksAdapter is implemented by the adapters supporting the chunked cache
+// format. The chunks are shared by the cache keys of a project, so the
+// adapter gives access to all the objects under the chunks prefix, rather
+// than to a single object.
+//
+// The pre-signed URLs and SAS tokens of the s3, gcs and azure adapters are
+// scoped to a single object, so these adapters only implement it when the
+// cache server of the runner is configured: the cache server proxies the
+// chunks, with URLs signed for each of them.
+type ChunksAdapter interface {
+	// GetChunksURL returns the URL of the chunks prefix: an HTTP(S) URL
+	// pre-signed for all the objects under its path, or a Go Cloud bucket URL
+	// with the prefix parameter
+	GetChunksURL() *url.URL
+}
+
+// ProxyAdapter is implemented by the adapters whose storage the jobs access
+// through the cache server of the runner, which holds the credentials of the
+// storage
+type ProxyAdapter interface {
+	// ProxyBucket returns the name of the storage in the URLs of the cache
+	// server, and the function opening it
+	ProxyBucket() (string, BucketOpener)
+}
+
 type Factory func(config *common.CacheConfig, timeout time.Duration, objectName string) (Adapter, error)
 
 type FactoriesMap struct {
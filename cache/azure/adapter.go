@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/cache/server"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
@@ -152,7 +153,7 @@ func New(config *common.CacheConfig, timeout time.Duration, objectName string) (
 		blobTokenGenerator:  getSASToken,
 	}
 
-	return a, nil
+	return server.WithPresignedChunks(a, config, timeout, objectName)
 }
 
 func init() {
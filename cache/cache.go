@@ -13,15 +13,14 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 )
 
-var createAdapter = CreateAdapter
+// chunksPrefix is the prefix of the chunks of the chunked cache format, in
+// the path of the cache objects of the project
+const chunksPrefix = "chunks"
 
-// generateObjectName returns a fully-qualified name for the cache object,
-// ensuring there's no path traversal outside.
-func generateObjectName(build *common.Build, config *common.CacheConfig, key string) (string, error) {
-	if key == "" {
-		return "", nil
-	}
+var createAdapter = CreateAdapter
 
+// projectPath returns the path of the cache objects of the project of the build
+func projectPath(build *common.Build, config *common.CacheConfig) string {
 	// runners get their own namespace, unless they're shared, in which case the
 	// namespace is empty.
 	namespace := ""
@@ -29,7 +28,17 @@ func generateObjectName(build *common.Build, config *common.CacheConfig, key str
 		namespace = path.Join("runner", build.Runner.ShortDescription())
 	}
 
-	basePath := path.Join(config.GetPath(), namespace, "project", strconv.FormatInt(build.JobInfo.ProjectID, 10))
+	return path.Join(config.GetPath(), namespace, "project", strconv.FormatInt(build.JobInfo.ProjectID, 10))
+}
+
+// generateObjectName returns a fully-qualified name for the cache object,
+// ensuring there's no path traversal outside.
+func generateObjectName(build *common.Build, config *common.CacheConfig, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+
+	basePath := projectPath(build, config)
 	fullPath := path.Join(basePath, key)
 
 	// The typical concerns regarding the use of strings.HasPrefix to detect
@@ -113,3 +122,28 @@ func GetCacheUploadEnv(build *common.Build, key string) map[string]string {
 
 	return adaptor.GetUploadEnv()
 }
+
+// GetCacheChunksURL returns the URL where the chunks of the chunked cache
+// format are stored, shared by the cache keys of the project. It returns nil
+// when the adapter doesn't support the chunked format.
+func GetCacheChunksURL(build *common.Build) *url.URL {
+	if build == nil || build.Runner == nil || build.Runner.Cache == nil {
+		return nil
+	}
+
+	config := build.Runner.Cache
+	adapter, err := createAdapter(config, build.GetBuildTimeout(), path.Join(projectPath(build, config), chunksPrefix))
+	if err != nil {
+		logrus.WithError(err).Error("Could not create cache adapter")
+		return nil
+	}
+
+	chunksAdapter, ok := adapter.(ChunksAdapter)
+	if !ok {
+		logrus.WithField("type", config.Type).
+			Warningln("The cache adapter doesn't support the chunked format, the cache is created in the zip format")
+		return nil
+	}
+
+	return chunksAdapter.GetChunksURL()
+}
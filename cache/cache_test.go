@@ -382,3 +382,88 @@ func TestCacheUploadEnv(t *testing.T) {
 		})
 	}
 }
+
+type chunksAdapter struct {
+	MockAdapter
+
+	url *url.URL
+}
+
+func (a *chunksAdapter) GetChunksURL() *url.URL {
+	return a.url
+}
+
+func TestGetCacheChunksURL(t *testing.T) {
+	chunksURL, err := url.Parse("https://cache.example.com/project/10/chunks?signature=1")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		cacheConfig        *common.CacheConfig
+		adapter            Adapter
+		createAdapterErr   error
+		expectedURL        *url.URL
+		expectedObjectName string
+		expectedLogEntry   string
+	}{
+		"no cache config": {
+			cacheConfig: nil,
+			adapter:     &chunksAdapter{url: chunksURL},
+		},
+		"adapter supports chunks": {
+			cacheConfig:        &common.CacheConfig{Shared: true},
+			adapter:            &chunksAdapter{url: chunksURL},
+			expectedURL:        chunksURL,
+			expectedObjectName: "project/10/chunks",
+		},
+		"adapter with path": {
+			cacheConfig:        &common.CacheConfig{Path: "path", Shared: true},
+			adapter:            &chunksAdapter{url: chunksURL},
+			expectedURL:        chunksURL,
+			expectedObjectName: "path/project/10/chunks",
+		},
+		"adapter doesn't support chunks": {
+			cacheConfig:        &common.CacheConfig{Type: "s3", Shared: true},
+			adapter:            new(MockAdapter),
+			expectedObjectName: "project/10/chunks",
+			expectedLogEntry:   "The cache adapter doesn't support the chunked format",
+		},
+		"adapter creation error": {
+			cacheConfig:        &common.CacheConfig{Shared: true},
+			createAdapterErr:   errors.New("test error"),
+			expectedObjectName: "project/10/chunks",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			logs, cleanUpHooks := hook.NewHook()
+			defer cleanUpHooks()
+
+			var objectName string
+
+			oldCreateAdapter := createAdapter
+			defer func() { createAdapter = oldCreateAdapter }()
+			createAdapter = func(_ *common.CacheConfig, _ time.Duration, name string) (Adapter, error) {
+				objectName = name
+				return tt.adapter, tt.createAdapterErr
+			}
+
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{Cache: tt.cacheConfig},
+				},
+			}
+			build.JobInfo.ProjectID = 10
+
+			assert.Equal(t, tt.expectedURL, GetCacheChunksURL(build))
+			assert.Equal(t, tt.expectedObjectName, objectName)
+
+			if tt.expectedLogEntry != "" {
+				lastLogMsg, err := logs.LastEntry().String()
+				require.NoError(t, err)
+				assert.Contains(t, lastLogMsg, tt.expectedLogEntry)
+				assert.Contains(t, lastLogMsg, "type=s3")
+			}
+		})
+	}
+}
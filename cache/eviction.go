@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+)
+
+const (
+	// evictionInterval is the minimum time between two evictions of the same
+	// storage, as the adapters are created for each cache operation
+	evictionInterval = 10 * time.Minute
+	evictionTimeout  = 10 * time.Minute
+)
+
+// BucketOpener opens the bucket of a storage
+type BucketOpener func(ctx context.Context) (*blob.Bucket, error)
+
+var evictions = struct {
+	lock     sync.Mutex
+	lastRuns map[string]time.Time
+}{
+	lastRuns: make(map[string]time.Time),
+}
+
+// shouldEvict returns whether the storage identified by key wasn't evicted
+// for evictionInterval, and records the eviction
+func shouldEvict(key string, now time.Time) bool {
+	evictions.lock.Lock()
+	defer evictions.lock.Unlock()
+
+	if lastRun, ok := evictions.lastRuns[key]; ok && now.Sub(lastRun) < evictionInterval {
+		return false
+	}
+
+	evictions.lastRuns[key] = now
+
+	return true
+}
+
+// EvictExpired removes in the background the objects of a storage that weren't
+// updated for ttl. The storage is identified by key, it's evicted at most once
+// per evictionInterval.
+func EvictExpired(key string, ttl time.Duration, open BucketOpener) {
+	now := time.Now()
+	if !shouldEvict(key, now) {
+		return
+	}
+
+	go func() {
+		logger := logrus.WithField("storage", key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), evictionTimeout)
+		defer cancel()
+
+		bucket, err := open(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Could not open cache storage to evict expired objects")
+			return
+		}
+		defer func() { _ = bucket.Close() }()
+
+		removed, err := Evict(ctx, bucket, now.Add(-ttl))
+		if err != nil {
+			logger.WithError(err).Error("Could not evict expired cache objects")
+		}
+
+		if removed > 0 {
+			logger.WithField("removed", removed).Info("Evicted expired cache objects")
+		}
+	}()
+}
+
+// Evict removes the objects of bucket that were last updated before deadline,
+// and returns the number of objects removed. The chunks of the chunked format
+// aren't updated when a new cache uses them, so the chunks used by the
+// manifests that aren't removed are kept.
+func Evict(ctx context.Context, bucket *blob.Bucket, deadline time.Time) (int, error) {
+	objects, err := listObjects(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	used, err := usedChunks(ctx, bucket, objects, deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	for _, obj := range objects {
+		if !obj.ModTime.Before(deadline) || used[obj.Key] {
+			continue
+		}
+
+		err = bucket.Delete(ctx, obj.Key)
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+func listObjects(ctx context.Context, bucket *blob.Bucket) ([]*blob.ListObject, error) {
+	var objects []*blob.ListObject
+
+	iter := bucket.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !obj.IsDir {
+			objects = append(objects, obj)
+		}
+	}
+}
+
+// usedChunks returns the keys of the chunks used by the manifests of objects
+// updated after deadline. The chunks of a manifest are stored under the chunks
+// prefix of its project, next to it.
+func usedChunks(
+	ctx context.Context,
+	bucket *blob.Bucket,
+	objects []*blob.ListObject,
+	deadline time.Time,
+) (map[string]bool, error) {
+	used := make(map[string]bool)
+
+	for _, obj := range objects {
+		if obj.ModTime.Before(deadline) || isChunk(obj.Key) {
+			continue
+		}
+
+		m, err := readManifest(ctx, bucket, obj.Key)
+		if errors.Is(err, chunked.ErrNotManifest) || gcerrors.Code(err) == gcerrors.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading the manifest %q: %w", obj.Key, err)
+		}
+
+		for hash := range m.Hashes() {
+			used[path.Join(path.Dir(obj.Key), chunksPrefix, hash)] = true
+		}
+	}
+
+	return used, nil
+}
+
+func isChunk(key string) bool {
+	return path.Base(path.Dir(key)) == chunksPrefix
+}
+
+func readManifest(ctx context.Context, bucket *blob.Bucket, key string) (*chunked.Manifest, error) {
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	return chunked.ReadManifest(r)
+}
@@ -0,0 +1,163 @@
+//go:build !integration
+// +build !integration
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+)
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	now := time.Now()
+	for key, age := range map[string]time.Duration{
+		"project/1/expired":        2 * time.Hour,
+		"project/1/recent":         time.Minute,
+		"project/1/chunks/expired": 3 * time.Hour,
+		"project/2/recent":         0,
+	} {
+		require.NoError(t, bucket.WriteAll(ctx, key, []byte(key), nil))
+
+		modTime := now.Add(-age)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modTime, modTime))
+	}
+
+	removed, err := Evict(ctx, bucket, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	var keys []string
+	iter := bucket.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err != nil {
+			break
+		}
+		keys = append(keys, obj.Key)
+	}
+
+	assert.Equal(t, []string{"project/1/recent", "project/2/recent"}, keys)
+}
+
+func TestEvictUsedChunks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	manifest := func(chunks ...string) []byte {
+		m := &chunked.Manifest{Format: chunked.ManifestFormat, Version: 1}
+		for _, chunk := range chunks {
+			m.Chunks = append(m.Chunks, chunked.Chunk{Hash: hash(chunk), Size: 1})
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, m.Write(&buf))
+
+		return buf.Bytes()
+	}
+
+	now := time.Now()
+	objects := []struct {
+		key     string
+		content []byte
+		age     time.Duration
+	}{
+		// the chunks stay as old as their first upload, the newer caches
+		// using them don't update them
+		{key: "project/1/chunks/" + hash("shared"), age: 3 * time.Hour},
+		{key: "project/1/chunks/" + hash("old"), age: 3 * time.Hour},
+		{key: "project/1/chunks/" + hash("new"), age: time.Minute},
+		{key: "project/1/old", content: manifest("shared", "old"), age: 2 * time.Hour},
+		{key: "project/1/new", content: manifest("shared", "new"), age: time.Minute},
+		{key: "project/1/zip", content: []byte("PK"), age: time.Minute},
+		// the chunks of a project are only used by its manifests
+		{key: "project/2/chunks/" + hash("shared"), age: 3 * time.Hour},
+	}
+
+	for _, obj := range objects {
+		content := obj.content
+		if content == nil {
+			content = []byte(obj.key)
+		}
+		require.NoError(t, bucket.WriteAll(ctx, obj.key, content, nil))
+
+		modTime := now.Add(-obj.age)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, filepath.FromSlash(obj.key)), modTime, modTime))
+	}
+
+	removed, err := Evict(ctx, bucket, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	for _, key := range []string{
+		"project/1/chunks/" + hash("shared"),
+		"project/1/chunks/" + hash("new"),
+		"project/1/new",
+		"project/1/zip",
+	} {
+		exists, err := bucket.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+}
+
+func TestEvictExpired(t *testing.T) {
+	dir := t.TempDir()
+	key := "TestEvictExpired:" + dir
+
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	require.NoError(t, bucket.WriteAll(context.Background(), "expired", []byte("expired"), nil))
+	modTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "expired"), modTime, modTime))
+
+	opened := make(chan struct{}, 2)
+	open := func(ctx context.Context) (*blob.Bucket, error) {
+		opened <- struct{}{}
+		return fileblob.OpenBucket(dir, nil)
+	}
+
+	EvictExpired(key, time.Hour, open)
+	// the storage was just evicted
+	EvictExpired(key, time.Hour, open)
+
+	<-opened
+	assert.Eventually(t, func() bool {
+		exists, err := bucket.Exists(context.Background(), "expired")
+		return err == nil && !exists
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, opened)
+
+	assert.False(t, shouldEvict(key, time.Now()))
+	assert.True(t, shouldEvict(key, time.Now().Add(evictionInterval)))
+}
@@ -0,0 +1,94 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gocloud/localblob"
+)
+
+// localAdapter stores the cache in a directory, usually a shared mount. The
+// jobs read and write the directory directly, so it must be available at the
+// same path to the runner and to the jobs.
+type localAdapter struct {
+	config     *common.CacheLocalConfig
+	objectName string
+}
+
+func (a *localAdapter) GetDownloadURL() *url.URL {
+	return nil
+}
+
+func (a *localAdapter) GetUploadURL() *url.URL {
+	return nil
+}
+
+func (a *localAdapter) GetUploadHeaders() http.Header {
+	return nil
+}
+
+func (a *localAdapter) GetGoCloudURL() *url.URL {
+	return a.bucketURL("/"+a.objectName, url.Values{})
+}
+
+func (a *localAdapter) GetUploadEnv() map[string]string {
+	return nil
+}
+
+func (a *localAdapter) GetChunksURL() *url.URL {
+	return a.bucketURL("/", url.Values{"prefix": []string{a.objectName + "/"}})
+}
+
+func (a *localAdapter) bucketURL(objectPath string, query url.Values) *url.URL {
+	query.Set("dir", a.config.Directory)
+
+	return &url.URL{
+		Scheme:   localblob.Scheme,
+		Path:     objectPath,
+		RawQuery: query.Encode(),
+	}
+}
+
+func (a *localAdapter) openBucket(context.Context) (*blob.Bucket, error) {
+	return fileblob.OpenBucket(a.config.Directory, nil)
+}
+
+func New(config *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
+	local := config.Local
+	if local == nil {
+		return nil, fmt.Errorf("missing local configuration")
+	}
+
+	if !path.IsAbs(local.Directory) && !filepath.IsAbs(local.Directory) {
+		return nil, fmt.Errorf("local cache directory %q must be an absolute path", local.Directory)
+	}
+
+	a := &localAdapter{
+		config:     local,
+		objectName: strings.TrimLeft(objectName, "/"),
+	}
+
+	if ttl := local.GetTTL(); ttl > 0 {
+		cache.EvictExpired(local.Directory, ttl, a.openBucket)
+	}
+
+	return a, nil
+}
+
+func init() {
+	err := cache.Factories().Register("local", New)
+	if err != nil {
+		panic(err)
+	}
+}
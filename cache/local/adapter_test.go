@@ -0,0 +1,135 @@
+//go:build !integration
+// +build !integration
+
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.CacheConfig
+		expectedError string
+	}{
+		"valid": {
+			config: &common.CacheConfig{Local: &common.CacheLocalConfig{Directory: "/mnt/cache"}},
+		},
+		"missing configuration": {
+			config:        &common.CacheConfig{},
+			expectedError: "missing local configuration",
+		},
+		"missing directory": {
+			config:        &common.CacheConfig{Local: &common.CacheLocalConfig{}},
+			expectedError: `local cache directory "" must be an absolute path`,
+		},
+		"relative directory": {
+			config:        &common.CacheConfig{Local: &common.CacheLocalConfig{Directory: "cache"}},
+			expectedError: `local cache directory "cache" must be an absolute path`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter, err := New(tt.config, time.Hour, "key")
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, adapter)
+		})
+	}
+}
+
+func TestAdapterURLs(t *testing.T) {
+	config := &common.CacheConfig{Local: &common.CacheLocalConfig{Directory: "/mnt/cache"}}
+
+	adapter, err := New(config, time.Hour, "/project/1/key")
+	require.NoError(t, err)
+
+	assert.Nil(t, adapter.GetDownloadURL())
+	assert.Nil(t, adapter.GetUploadURL())
+	assert.Nil(t, adapter.GetUploadHeaders())
+	assert.Empty(t, adapter.GetUploadEnv())
+	assert.Equal(t, "local:///project/1/key?dir=%2Fmnt%2Fcache", adapter.GetGoCloudURL().String())
+
+	chunksAdapter, ok := adapter.(cache.ChunksAdapter)
+	require.True(t, ok)
+	assert.Equal(
+		t,
+		"local:///?dir=%2Fmnt%2Fcache&prefix=project%2F1%2Fkey%2F",
+		chunksAdapter.GetChunksURL().String(),
+	)
+}
+
+func TestAdapterGoCloudURL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config := &common.CacheConfig{Local: &common.CacheLocalConfig{Directory: dir}}
+
+	adapter, err := New(config, time.Hour, "project/1/key")
+	require.NoError(t, err)
+
+	// the cache helper opens the bucket of the URL, and writes the object of
+	// its path
+	u := adapter.GetGoCloudURL()
+	bucket, err := blob.OpenBucket(ctx, u.String())
+	require.NoError(t, err)
+	require.NoError(t, bucket.WriteAll(ctx, strings.TrimLeft(u.Path, "/"), []byte("cache"), nil))
+	require.NoError(t, bucket.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "project", "1", "key"))
+	require.NoError(t, err)
+	assert.Equal(t, "cache", string(data))
+
+	adapter, err = New(config, time.Hour, "project/1/chunks")
+	require.NoError(t, err)
+
+	bucket, err = blob.OpenBucket(ctx, adapter.(cache.ChunksAdapter).GetChunksURL().String())
+	require.NoError(t, err)
+	require.NoError(t, bucket.WriteAll(ctx, "hash", []byte("chunk"), nil))
+	require.NoError(t, bucket.Close())
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "project", "1", "chunks", "hash"))
+	require.NoError(t, err)
+	assert.Equal(t, "chunk", string(data))
+}
+
+func TestAdapterEvictsExpiredObjects(t *testing.T) {
+	dir := t.TempDir()
+
+	expired := filepath.Join(dir, "project", "1", "expired")
+	require.NoError(t, os.MkdirAll(filepath.Dir(expired), 0700))
+	require.NoError(t, ioutil.WriteFile(expired, []byte("expired"), 0600))
+	modTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(expired, modTime, modTime))
+
+	recent := filepath.Join(dir, "project", "1", "recent")
+	require.NoError(t, ioutil.WriteFile(recent, []byte("recent"), 0600))
+
+	config := &common.CacheConfig{Local: &common.CacheLocalConfig{Directory: dir, TTL: 3600}}
+	_, err := New(config, time.Hour, "project/1/key")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(expired)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.FileExists(t, recent)
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/cache/server"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// runnerAdapter stores the cache on the cache server embedded in the runner,
+// with URLs signed by the secret of the server
+type runnerAdapter struct {
+	timeout    time.Duration
+	signer     *server.URLSigner
+	objectName string
+}
+
+func (a *runnerAdapter) GetDownloadURL() *url.URL {
+	return a.signedURL(a.objectName, false, http.MethodGet, http.MethodHead)
+}
+
+func (a *runnerAdapter) GetUploadURL() *url.URL {
+	return a.signedURL(a.objectName, false, http.MethodPut)
+}
+
+func (a *runnerAdapter) GetUploadHeaders() http.Header {
+	return nil
+}
+
+func (a *runnerAdapter) GetGoCloudURL() *url.URL {
+	return nil
+}
+
+func (a *runnerAdapter) GetUploadEnv() map[string]string {
+	return nil
+}
+
+func (a *runnerAdapter) GetChunksURL() *url.URL {
+	return a.signedURL(a.objectName+"/", true, http.MethodGet, http.MethodHead, http.MethodPut)
+}
+
+func (a *runnerAdapter) signedURL(key string, prefix bool, methods ...string) *url.URL {
+	return a.signer.URL(key, prefix, time.Now().Add(a.timeout), methods...)
+}
+
+func New(config *common.CacheConfig, timeout time.Duration, objectName string) (cache.Adapter, error) {
+	signer, err := server.NewURLSigner(config.Runner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runnerAdapter{
+		timeout:    timeout,
+		signer:     signer,
+		objectName: strings.TrimLeft(objectName, "/"),
+	}, nil
+}
+
+func init() {
+	err := cache.Factories().Register("runner", New)
+	if err != nil {
+		panic(err)
+	}
+}
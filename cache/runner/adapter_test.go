@@ -0,0 +1,149 @@
+//go:build !integration
+// +build !integration
+
+package runner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/cache/server"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.CacheRunnerConfig
+		expectedError string
+	}{
+		"valid": {
+			config: &common.CacheRunnerConfig{ServerAddress: "http://172.17.0.1:9252", Secret: "secret"},
+		},
+		"missing configuration": {
+			config:        nil,
+			expectedError: "missing runner cache server configuration",
+		},
+		"missing secret": {
+			config:        &common.CacheRunnerConfig{ServerAddress: "http://172.17.0.1:9252"},
+			expectedError: "missing secret of the runner cache server",
+		},
+		"missing server address": {
+			config:        &common.CacheRunnerConfig{Secret: "secret"},
+			expectedError: `runner cache server address "" must be an http or https URL`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter, err := New(&common.CacheConfig{Runner: tt.config}, time.Hour, "key")
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, adapter)
+		})
+	}
+}
+
+func do(t *testing.T, method string, url string, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res.StatusCode, string(data)
+}
+
+func TestAdapterURLs(t *testing.T) {
+	cacheServer, err := server.NewServer(
+		common.CacheServer{Directory: t.TempDir(), Secret: "secret"},
+		logrus.New(),
+	)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(cacheServer)
+	defer ts.Close()
+
+	config := &common.CacheConfig{
+		Runner: &common.CacheRunnerConfig{ServerAddress: ts.URL, Secret: "secret"},
+	}
+
+	adapter, err := New(config, time.Hour, "/project/1/key")
+	require.NoError(t, err)
+
+	assert.Nil(t, adapter.GetGoCloudURL())
+	assert.Empty(t, adapter.GetUploadEnv())
+	assert.Empty(t, adapter.GetUploadHeaders())
+
+	uploadURL := adapter.GetUploadURL()
+	assert.Equal(t, "/cache/project/1/key", uploadURL.Path)
+
+	code, _ := do(t, http.MethodPut, uploadURL.String(), "content")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = do(t, http.MethodGet, uploadURL.String(), "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, body := do(t, http.MethodGet, adapter.GetDownloadURL().String(), "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "content", body)
+
+	code, _ = do(t, http.MethodPut, adapter.GetDownloadURL().String(), "other")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	adapter, err = New(config, time.Hour, "project/1/chunks")
+	require.NoError(t, err)
+
+	chunksAdapter, ok := adapter.(cache.ChunksAdapter)
+	require.True(t, ok)
+
+	// the hash of the chunks is appended to the path of the chunks URL
+	chunkURL := *chunksAdapter.GetChunksURL()
+	assert.Equal(t, "/cache/project/1/chunks/", chunkURL.Path)
+	chunkURL.Path += "hash"
+
+	code, _ = do(t, http.MethodPut, chunkURL.String(), "chunk")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body = do(t, http.MethodGet, chunkURL.String(), "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "chunk", body)
+
+	code, _ = do(t, http.MethodHead, chunkURL.String(), "")
+	assert.Equal(t, http.StatusOK, code)
+
+	chunkURL.Path = "/cache/project/2/chunks/hash"
+	code, _ = do(t, http.MethodGet, chunkURL.String(), "")
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestAdapterURLsExpire(t *testing.T) {
+	config := &common.CacheConfig{
+		Runner: &common.CacheRunnerConfig{ServerAddress: "http://172.17.0.1:9252/", Secret: "secret"},
+	}
+
+	adapter, err := New(config, time.Hour, "project/1/key")
+	require.NoError(t, err)
+
+	u := adapter.GetDownloadURL()
+	assert.Equal(t, "http://172.17.0.1:9252/cache/project/1/key", strings.Split(u.String(), "?")[0])
+
+	assert.NoError(t, server.Verify("secret", "project/1/key", http.MethodGet, u.Query(), time.Now()))
+	assert.Error(t, server.Verify("secret", "project/1/key", http.MethodGet, u.Query(), time.Now().Add(2*time.Hour)))
+}
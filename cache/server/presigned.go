@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gocloud/presignedblob"
+)
+
+// presignedURLTimeout is the validity of the pre-signed URLs the cache server
+// proxies the objects with, they're used right away
+const presignedURLTimeout = 10 * time.Minute
+
+// presignedChunksAdapter is a cache adapter whose pre-signed URLs are scoped
+// to a single object, with the chunks of the chunked format proxied by the
+// cache server. The cache server signs the URL of each chunk with the adapter,
+// so the jobs only get access to the chunks of their project.
+type presignedChunksAdapter struct {
+	cache.Adapter
+
+	config     *common.CacheConfig
+	signer     *URLSigner
+	timeout    time.Duration
+	objectName string
+	bucket     string
+}
+
+// WithPresignedChunks returns adapter with support for the chunked format when
+// the cache server of the runner is configured, for the adapters of the
+// object storages: the chunks are proxied by the cache server, which accesses
+// them with the pre-signed URLs of the adapters created for each chunk.
+func WithPresignedChunks(
+	adapter cache.Adapter,
+	config *common.CacheConfig,
+	timeout time.Duration,
+	objectName string,
+) (cache.Adapter, error) {
+	if config.Runner == nil {
+		return adapter, nil
+	}
+
+	signer, err := NewURLSigner(config.Runner)
+	if err != nil {
+		return nil, fmt.Errorf("the chunks of the cache are accessed through the runner cache server: %w", err)
+	}
+
+	bucket, err := presignedBucketName(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &presignedChunksAdapter{
+		Adapter:    adapter,
+		config:     config,
+		signer:     signer,
+		timeout:    timeout,
+		objectName: strings.TrimLeft(objectName, "/"),
+		bucket:     bucket,
+	}, nil
+}
+
+// presignedBucketName identifies the storage of config in the URLs of the
+// cache server, without disclosing it
+func presignedBucketName(config *common.CacheConfig) (string, error) {
+	storage := common.CacheConfig{
+		Type:  config.Type,
+		S3:    config.S3,
+		GCS:   config.GCS,
+		Azure: config.Azure,
+	}
+
+	data, err := json.Marshal(storage)
+	if err != nil {
+		return "", fmt.Errorf("identifying the cache storage: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (a *presignedChunksAdapter) GetChunksURL() *url.URL {
+	key := ProxyKey(a.bucket, a.objectName+"/")
+
+	return a.signer.URL(key, true, time.Now().Add(a.timeout), http.MethodGet, http.MethodHead, http.MethodPut)
+}
+
+func (a *presignedChunksAdapter) ProxyBucket() (string, cache.BucketOpener) {
+	return a.bucket, a.openBucket
+}
+
+func (a *presignedChunksAdapter) openBucket(context.Context) (*blob.Bucket, error) {
+	return presignedblob.OpenBucket(&adapterSigner{config: a.config}, http.DefaultClient), nil
+}
+
+// adapterSigner signs the URLs of the objects with the cache adapters created
+// for them
+type adapterSigner struct {
+	config *common.CacheConfig
+}
+
+func (s *adapterSigner) adapter(key string) (cache.Adapter, error) {
+	return cache.CreateAdapter(s.config, presignedURLTimeout, key)
+}
+
+func (s *adapterSigner) DownloadURL(key string) (*url.URL, error) {
+	adapter, err := s.adapter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	u := adapter.GetDownloadURL()
+	if u == nil {
+		return nil, fmt.Errorf("no download URL for %q", key)
+	}
+
+	return u, nil
+}
+
+func (s *adapterSigner) UploadURL(key string) (*url.URL, http.Header, error) {
+	adapter, err := s.adapter(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := adapter.GetUploadURL()
+	if u == nil {
+		return nil, nil, fmt.Errorf("no upload URL for %q", key)
+	}
+
+	return u, adapter.GetUploadHeaders(), nil
+}
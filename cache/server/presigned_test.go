@@ -0,0 +1,187 @@
+//go:build !integration
+// +build !integration
+
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const presignedTestAdapterType = "presigned-test"
+
+// presignedTestStore stores the objects like an object storage, with the URLs
+// of the objects signed for a single method
+type presignedTestStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *presignedTestStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("method") != r.Method {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method == http.MethodPut {
+		if r.ContentLength < 0 || r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = data
+		return
+	}
+
+	data, ok := s.objects[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, key, time.Now(), bytes.NewReader(data))
+}
+
+// presignedTestAdapter signs the URLs of the objects of presignedTestStore,
+// whose URL is the BucketName of the S3 configuration
+type presignedTestAdapter struct {
+	storeURL   string
+	objectName string
+}
+
+func (a *presignedTestAdapter) signedURL(method string) *url.URL {
+	u, _ := url.Parse(a.storeURL + "/" + a.objectName + "?method=" + method)
+	return u
+}
+
+func (a *presignedTestAdapter) GetDownloadURL() *url.URL {
+	return a.signedURL(http.MethodGet)
+}
+
+func (a *presignedTestAdapter) GetUploadURL() *url.URL {
+	return a.signedURL(http.MethodPut)
+}
+
+func (a *presignedTestAdapter) GetUploadHeaders() http.Header {
+	header := make(http.Header)
+	header.Set("x-ms-blob-type", "BlockBlob")
+
+	return header
+}
+
+func (a *presignedTestAdapter) GetGoCloudURL() *url.URL {
+	return nil
+}
+
+func (a *presignedTestAdapter) GetUploadEnv() map[string]string {
+	return nil
+}
+
+func init() {
+	err := cache.Factories().Register(
+		presignedTestAdapterType,
+		func(config *common.CacheConfig, timeout time.Duration, objectName string) (cache.Adapter, error) {
+			adapter := &presignedTestAdapter{storeURL: config.S3.BucketName, objectName: objectName}
+
+			return WithPresignedChunks(adapter, config, timeout, objectName)
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestWithPresignedChunksWithoutCacheServer(t *testing.T) {
+	adapter := &presignedTestAdapter{}
+
+	wrapped, err := WithPresignedChunks(adapter, &common.CacheConfig{}, time.Hour, "project/1/key")
+	require.NoError(t, err)
+	assert.Same(t, adapter, wrapped)
+
+	_, err = WithPresignedChunks(adapter, &common.CacheConfig{Runner: &common.CacheRunnerConfig{}}, time.Hour, "key")
+	assert.EqualError(
+		t,
+		err,
+		"the chunks of the cache are accessed through the runner cache server: missing secret of the runner cache server",
+	)
+}
+
+func TestWithPresignedChunks(t *testing.T) {
+	store := &presignedTestStore{objects: make(map[string][]byte)}
+	storeServer := httptest.NewServer(store)
+	defer storeServer.Close()
+
+	config := &common.CacheConfig{
+		Type: presignedTestAdapterType,
+		S3:   &common.CacheS3Config{BucketName: storeServer.URL},
+		Runner: &common.CacheRunnerConfig{
+			ServerAddress: "http://172.17.0.1:9253",
+			Secret:        "secret",
+		},
+	}
+
+	adapter, err := cache.CreateAdapter(config, time.Hour, "project/1/chunks")
+	require.NoError(t, err)
+
+	chunksAdapter, ok := adapter.(cache.ChunksAdapter)
+	require.True(t, ok, "the adapter supports the chunked format")
+	proxyAdapter, ok := adapter.(cache.ProxyAdapter)
+	require.True(t, ok, "the chunks are proxied by the cache server")
+
+	bucket, open := proxyAdapter.ProxyBucket()
+
+	chunksURL := chunksAdapter.GetChunksURL()
+	require.NotNil(t, chunksURL)
+	assert.Equal(t, "172.17.0.1:9253", chunksURL.Host)
+	assert.Equal(t, PathPrefix+ProxyKey(bucket, "project/1/chunks/"), chunksURL.Path)
+	assert.NotContains(t, chunksURL.String(), storeServer.URL, "the storage isn't disclosed")
+
+	s := newTestServer(t)
+	s.SetBuckets(map[string]cache.BucketOpener{bucket: open})
+
+	chunkPath := func(chunk string) string {
+		u := *chunksURL
+		u.Path += chunk
+
+		return u.RequestURI()
+	}
+
+	rec := serve(s, http.MethodHead, chunkPath("chunk"), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodPut, chunkPath("chunk"), "content")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "content", string(store.objects["project/1/chunks/chunk"]))
+
+	rec = serve(s, http.MethodHead, chunkPath("chunk"), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Header().Get("Content-Length"))
+
+	rec = serve(s, http.MethodGet, chunkPath("chunk"), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "content", rec.Body.String())
+
+	// the URL of the chunks doesn't give access to the other objects
+	u := *chunksURL
+	u.Path = PathPrefix + ProxyKey(bucket, "project/2/chunks/chunk")
+	rec = serve(s, http.MethodGet, u.RequestURI(), "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
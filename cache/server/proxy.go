@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+)
+
+// ProxyPrefix starts the keys of the objects the cache server proxies to a
+// bucket, followed by the name of the bucket and the key of the object in it.
+// The keys under it are never served from the store.
+const ProxyPrefix = "proxy/"
+
+// ProxyKey returns the key of the cache server proxying key to the bucket
+func ProxyKey(bucket string, key string) string {
+	return ProxyPrefix + bucket + "/" + key
+}
+
+// proxiedBucket keeps the bucket open between the requests, it's opened again
+// after a failure
+type proxiedBucket struct {
+	open cache.BucketOpener
+
+	lock   sync.Mutex
+	bucket *blob.Bucket
+}
+
+func (b *proxiedBucket) get(ctx context.Context) (*blob.Bucket, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.bucket != nil {
+		return b.bucket, nil
+	}
+
+	bucket, err := b.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b.bucket = bucket
+
+	return bucket, nil
+}
+
+// reset closes the bucket after a failure, unless it was already reopened
+func (b *proxiedBucket) reset(bucket *blob.Bucket) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.bucket == bucket {
+		b.close()
+	}
+}
+
+func (b *proxiedBucket) close() {
+	if b.bucket != nil {
+		_ = b.bucket.Close()
+		b.bucket = nil
+	}
+}
+
+// SetBuckets sets the buckets the cache server proxies to, by name. The
+// buckets that are no longer used are closed.
+func (s *Server) SetBuckets(buckets map[string]cache.BucketOpener) {
+	s.bucketsLock.Lock()
+	defer s.bucketsLock.Unlock()
+
+	proxied := make(map[string]*proxiedBucket, len(buckets))
+	for name, open := range buckets {
+		b, ok := s.buckets[name]
+		if !ok {
+			b = &proxiedBucket{}
+		}
+
+		b.lock.Lock()
+		b.open = open
+		b.lock.Unlock()
+
+		proxied[name] = b
+	}
+
+	for name, b := range s.buckets {
+		if _, ok := proxied[name]; !ok {
+			b.lock.Lock()
+			b.close()
+			b.lock.Unlock()
+		}
+	}
+
+	s.buckets = proxied
+}
+
+func (s *Server) bucket(key string) (*proxiedBucket, string) {
+	s.bucketsLock.Lock()
+	defer s.bucketsLock.Unlock()
+
+	name, objectKey := splitKey(strings.TrimPrefix(key, ProxyPrefix))
+
+	return s.buckets[name], objectKey
+}
+
+func splitKey(key string) (string, string) {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return key, ""
+	}
+
+	return key[:i], key[i+1:]
+}
+
+func (s *Server) proxy(w http.ResponseWriter, r *http.Request, key string) {
+	b, objectKey := s.bucket(key)
+	if b == nil || objectKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger := s.logger.WithField("key", key)
+
+	bucket, err := b.get(r.Context())
+	if err != nil {
+		logger.WithError(err).Error("Could not open the bucket of the cache object")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		err = s.proxyPut(w, r, bucket, objectKey)
+	case http.MethodHead:
+		err = s.proxyHead(w, r, bucket, objectKey)
+	default:
+		err = s.proxyGet(w, r, bucket, objectKey)
+	}
+
+	if err != nil {
+		b.reset(bucket)
+
+		logger.WithError(err).Error("Could not proxy cache object")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
+
+func (s *Server) proxyGet(w http.ResponseWriter, r *http.Request, bucket *blob.Bucket, key string) error {
+	reader, err := bucket.NewReader(r.Context(), key, nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(reader.Size(), 10))
+	w.Header().Set("Last-Modified", reader.ModTime().UTC().Format(http.TimeFormat))
+
+	// the response is already started, a failure only interrupts it
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Warning("Could not send cache object")
+	}
+
+	return nil
+}
+
+func (s *Server) proxyHead(w http.ResponseWriter, r *http.Request, bucket *blob.Bucket, key string) error {
+	attrs, err := bucket.Attributes(r.Context(), key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
+	w.Header().Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+func (s *Server) proxyPut(w http.ResponseWriter, r *http.Request, bucket *blob.Bucket, key string) error {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	writer, err := bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, r.Body)
+	if err != nil {
+		// canceling the context aborts the write
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build !integration
+// +build !integration
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+)
+
+func TestServerProxy(t *testing.T) {
+	s := newTestServer(t)
+
+	dir := t.TempDir()
+	opened := 0
+	s.SetBuckets(map[string]cache.BucketOpener{
+		"bucket": func(context.Context) (*blob.Bucket, error) {
+			opened++
+			return fileblob.OpenBucket(dir, nil)
+		},
+		"failing": func(context.Context) (*blob.Bucket, error) {
+			return nil, errors.New("connection refused")
+		},
+	})
+
+	key := ProxyKey("bucket", "project/1/key")
+	assert.Equal(t, "proxy/bucket/project/1/key", key)
+
+	rec := serve(s, http.MethodGet, signedPath(key, false, http.MethodGet), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodPut, signedPath(key, false, http.MethodPut), "content")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, signedPath(key, false, http.MethodGet), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "content", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+
+	rec = serve(s, http.MethodHead, signedPath(key, false, http.MethodHead), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Header().Get("Content-Length"))
+
+	// the bucket is kept open between the requests
+	assert.Equal(t, 1, opened)
+
+	// the objects are stored in the bucket, not in the store
+	objects, _, _ := s.store.Stats()
+	assert.Zero(t, objects)
+
+	rec = serve(s, http.MethodGet, signedPath(ProxyKey("unknown", "key"), false, http.MethodGet), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, signedPath(ProxyKey("failing", "key"), false, http.MethodGet), "")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	// the keys under the proxy prefix are never stored, even without bucket
+	rec = serve(s, http.MethodPut, signedPath(ProxyKey("unknown", "key"), false, http.MethodPut), "content")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	s.SetBuckets(map[string]cache.BucketOpener{})
+
+	rec = serve(s, http.MethodGet, signedPath(key, false, http.MethodGet), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSetBucketsKeepsOpenBuckets(t *testing.T) {
+	s := newTestServer(t)
+
+	dir := t.TempDir()
+	open := func(context.Context) (*blob.Bucket, error) {
+		return fileblob.OpenBucket(dir, nil)
+	}
+
+	s.SetBuckets(map[string]cache.BucketOpener{"bucket": open})
+
+	rec := serve(s, http.MethodPut, signedPath(ProxyKey("bucket", "key"), false, http.MethodPut), "content")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	bucket := s.buckets["bucket"].bucket
+	require.NotNil(t, bucket)
+
+	// a reload of the configuration keeps the buckets still used
+	s.SetBuckets(map[string]cache.BucketOpener{"bucket": open})
+	assert.Same(t, bucket, s.buckets["bucket"].bucket)
+
+	s.SetBuckets(nil)
+	_, err := bucket.Exists(context.Background(), "key")
+	assert.Error(t, err, "the bucket is closed")
+}
@@ -0,0 +1,163 @@
+// Package server implements the cache server embedded in the runner. It
+// stores the cache on the local disk, or proxies it to the storage of the
+// cache adapters holding credentials, and serves it to the cache helper of the
+// jobs with the URLs signed by the cache adapters.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// PathPrefix is the path the cache server is served under, followed by the
+// key of the objects
+const PathPrefix = "/cache/"
+
+var (
+	objectsDesc = prometheus.NewDesc(
+		"gitlab_runner_cache_server_objects",
+		"Number of objects stored by the cache server",
+		nil,
+		nil,
+	)
+	sizeDesc = prometheus.NewDesc(
+		"gitlab_runner_cache_server_size_bytes",
+		"Size of the objects stored by the cache server",
+		nil,
+		nil,
+	)
+	evictionsDesc = prometheus.NewDesc(
+		"gitlab_runner_cache_server_evictions_total",
+		"Number of objects removed by the cache server to stay under its maximum size",
+		nil,
+		nil,
+	)
+)
+
+// Server serves the objects of a Store, to the requests with a URL signed by
+// the secret. The objects under ProxyPrefix are proxied to the buckets set
+// with SetBuckets instead, so that the jobs don't get their credentials.
+type Server struct {
+	secret string
+	store  *Store
+	logger logrus.FieldLogger
+
+	buckets     map[string]*proxiedBucket
+	bucketsLock sync.Mutex
+
+	now func() time.Time
+}
+
+func NewServer(config common.CacheServer, logger logrus.FieldLogger) (*Server, error) {
+	if config.Secret == "" {
+		return nil, fmt.Errorf("missing secret of the cache server")
+	}
+
+	store, err := NewStore(config.Directory, config.GetMaxSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		secret: config.Secret,
+		store:  store,
+		logger: logger,
+		now:    time.Now,
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, PathPrefix)
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) || !validKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := Verify(s.secret, key, r.Method, r.URL.Query(), s.now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if strings.HasPrefix(key, ProxyPrefix) {
+		s.proxy(w, r, key)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		s.put(w, r, key)
+		return
+	}
+
+	s.get(w, r, key)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	f, fi, err := s.store.Open(key)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Error("Could not open cache object")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	if r.ContentLength > s.store.maxSize {
+		http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	err := s.store.Put(key, r.Body)
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("key", key).Error("Could not store cache object")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Describe implements prometheus.Collector.
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {
+	ch <- objectsDesc
+	ch <- sizeDesc
+	ch <- evictionsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	objects, size, evictions := s.store.Stats()
+
+	ch <- prometheus.MustNewConstMetric(objectsDesc, prometheus.GaugeValue, float64(objects))
+	ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(evictions))
+}
@@ -0,0 +1,151 @@
+//go:build !integration
+// +build !integration
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(common.CacheServer{Directory: t.TempDir(), MaxSize: 1, Secret: "secret"}, logrus.New())
+	require.NoError(t, err)
+
+	return s
+}
+
+func signedPath(key string, prefix bool, methods ...string) string {
+	return PathPrefix + key + "?" + Sign("secret", key, prefix, methods, time.Now().Add(time.Hour)).Encode()
+}
+
+func serve(s *Server, method string, target string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	return rec
+}
+
+func TestNewServer(t *testing.T) {
+	_, err := NewServer(common.CacheServer{Directory: t.TempDir()}, logrus.New())
+	assert.EqualError(t, err, "missing secret of the cache server")
+}
+
+func TestServer(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, signedPath("project/1/key", false, http.MethodGet), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodPut, signedPath("project/1/key", false, http.MethodPut), "content")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, signedPath("project/1/key", false, http.MethodGet), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "content", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+
+	rec = serve(s, http.MethodHead, signedPath("project/1/key", false, http.MethodHead), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Header().Get("Content-Length"))
+
+	// the prefix URLs give access to all the objects under the prefix
+	chunks := signedPath("project/1/chunks/", true, http.MethodGet, http.MethodPut)
+	chunkURL := strings.Replace(chunks, "chunks/?", "chunks/hash?", 1)
+
+	rec = serve(s, http.MethodPut, chunkURL, "chunk")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, chunkURL, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "chunk", rec.Body.String())
+
+	metrics := `
+		# HELP gitlab_runner_cache_server_objects Number of objects stored by the cache server
+		# TYPE gitlab_runner_cache_server_objects gauge
+		gitlab_runner_cache_server_objects 2
+		# HELP gitlab_runner_cache_server_size_bytes Size of the objects stored by the cache server
+		# TYPE gitlab_runner_cache_server_size_bytes gauge
+		gitlab_runner_cache_server_size_bytes 12
+	`
+	err := testutil.CollectAndCompare(
+		s,
+		strings.NewReader(metrics),
+		"gitlab_runner_cache_server_objects",
+		"gitlab_runner_cache_server_size_bytes",
+	)
+	assert.NoError(t, err)
+}
+
+func TestServerRejectedRequests(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := map[string]struct {
+		method       string
+		target       string
+		body         string
+		expectedCode int
+	}{
+		"unsigned": {
+			method:       http.MethodGet,
+			target:       PathPrefix + "project/1/key",
+			expectedCode: http.StatusForbidden,
+		},
+		"signed for another method": {
+			method:       http.MethodPut,
+			target:       signedPath("project/1/key", false, http.MethodGet),
+			expectedCode: http.StatusForbidden,
+		},
+		"signed for another key": {
+			method: http.MethodGet,
+			target: strings.Replace(
+				signedPath("project/1/key", false, http.MethodGet),
+				"project/1/key", "project/2/key", 1,
+			),
+			expectedCode: http.StatusForbidden,
+		},
+		"outside of the server path": {
+			method:       http.MethodGet,
+			target:       "/metrics",
+			expectedCode: http.StatusNotFound,
+		},
+		"invalid key": {
+			method:       http.MethodGet,
+			target:       PathPrefix + "project//key",
+			expectedCode: http.StatusNotFound,
+		},
+		"unsupported method": {
+			method:       http.MethodDelete,
+			target:       signedPath("project/1/key", false, http.MethodDelete),
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		"too large": {
+			method:       http.MethodPut,
+			target:       signedPath("project/1/key", false, http.MethodPut),
+			body:         strings.Repeat("a", 1024*1024+1),
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			rec := serve(s, tt.method, tt.target, tt.body)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+
+			body, err := ioutil.ReadAll(rec.Body)
+			require.NoError(t, err)
+			assert.NotEmpty(t, body)
+		})
+	}
+}
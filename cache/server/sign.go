@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	expiresParam   = "expires"
+	methodsParam   = "methods"
+	prefixParam    = "prefix"
+	signatureParam = "signature"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+	errExpired          = errors.New("URL expired")
+	errMethodNotAllowed = errors.New("method not allowed by the URL")
+	errOutsidePrefix    = errors.New("object outside of the prefix of the URL")
+)
+
+// Sign returns the query of the URLs giving access to key with methods until
+// expires. When prefix is true, the query gives access to all the keys under
+// key, which must end with a slash.
+func Sign(secret string, key string, prefix bool, methods []string, expires time.Time) url.Values {
+	query := url.Values{}
+	query.Set(expiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(methodsParam, strings.Join(methods, ","))
+	if prefix {
+		query.Set(prefixParam, key)
+	}
+
+	query.Set(signatureParam, signature(secret, key, query))
+
+	return query
+}
+
+// Verify checks that query gives access to key with method at now
+func Verify(secret string, key string, method string, query url.Values, now time.Time) error {
+	sig := query.Get(signatureParam)
+	if sig == "" {
+		return errMissingSignature
+	}
+
+	signedKey := key
+	if query.Has(prefixParam) {
+		signedKey = query.Get(prefixParam)
+		if !strings.HasSuffix(signedKey, "/") || !strings.HasPrefix(key, signedKey) {
+			return errOutsidePrefix
+		}
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, signedKey, query))) {
+		return errInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return errExpired
+	}
+
+	for _, allowed := range strings.Split(query.Get(methodsParam), ",") {
+		if allowed == method {
+			return nil
+		}
+	}
+
+	return errMethodNotAllowed
+}
+
+func signature(secret string, key string, query url.Values) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(strings.Join([]string{
+		key,
+		query.Get(expiresParam),
+		query.Get(methodsParam),
+		strconv.FormatBool(query.Has(prefixParam)),
+	}, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,117 @@
+//go:build !integration
+// +build !integration
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+
+	tests := map[string]struct {
+		query         url.Values
+		key           string
+		method        string
+		now           time.Time
+		expectedError error
+	}{
+		"valid": {
+			query:  Sign("secret", "project/1/key", false, []string{http.MethodGet, http.MethodHead}, expires),
+			key:    "project/1/key",
+			method: http.MethodHead,
+			now:    now,
+		},
+		"valid prefix": {
+			query:  Sign("secret", "project/1/chunks/", true, []string{http.MethodPut}, expires),
+			key:    "project/1/chunks/hash",
+			method: http.MethodPut,
+			now:    now,
+		},
+		"missing signature": {
+			query:         url.Values{},
+			key:           "project/1/key",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errMissingSignature,
+		},
+		"other key": {
+			query:         Sign("secret", "project/1/key", false, []string{http.MethodGet}, expires),
+			key:           "project/2/key",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errInvalidSignature,
+		},
+		"other secret": {
+			query:         Sign("other", "project/1/key", false, []string{http.MethodGet}, expires),
+			key:           "project/1/key",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errInvalidSignature,
+		},
+		"key signed as prefix": {
+			query: func() url.Values {
+				query := Sign("secret", "project/1/", false, []string{http.MethodGet}, expires)
+				query.Set(prefixParam, "project/1/")
+				return query
+			}(),
+			key:           "project/1/key",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errInvalidSignature,
+		},
+		"outside of prefix": {
+			query:         Sign("secret", "project/1/chunks/", true, []string{http.MethodGet}, expires),
+			key:           "project/2/chunks/hash",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errOutsidePrefix,
+		},
+		"prefix without slash": {
+			query:         Sign("secret", "project/1", true, []string{http.MethodGet}, expires),
+			key:           "project/10/key",
+			method:        http.MethodGet,
+			now:           now,
+			expectedError: errOutsidePrefix,
+		},
+		"other method": {
+			query:         Sign("secret", "project/1/key", false, []string{http.MethodGet, http.MethodHead}, expires),
+			key:           "project/1/key",
+			method:        http.MethodPut,
+			now:           now,
+			expectedError: errMethodNotAllowed,
+		},
+		"method added": {
+			query: func() url.Values {
+				query := Sign("secret", "project/1/key", false, []string{http.MethodGet}, expires)
+				query.Set(methodsParam, "GET,PUT")
+				return query
+			}(),
+			key:           "project/1/key",
+			method:        http.MethodPut,
+			now:           now,
+			expectedError: errInvalidSignature,
+		},
+		"expired": {
+			query:         Sign("secret", "project/1/key", false, []string{http.MethodGet}, expires),
+			key:           "project/1/key",
+			method:        http.MethodGet,
+			now:           expires.Add(time.Second),
+			expectedError: errExpired,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			err := Verify("secret", tt.key, tt.method, tt.query, tt.now)
+			assert.Equal(t, tt.expectedError, err)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// URLSigner returns the URLs of the objects of the cache server, signed by its
+// secret, for the cache adapters storing their objects through the server
+type URLSigner struct {
+	serverURL *url.URL
+	secret    string
+}
+
+func NewURLSigner(config *common.CacheRunnerConfig) (*URLSigner, error) {
+	if config == nil {
+		return nil, fmt.Errorf("missing runner cache server configuration")
+	}
+
+	if config.Secret == "" {
+		return nil, fmt.Errorf("missing secret of the runner cache server")
+	}
+
+	serverURL, err := url.Parse(config.ServerAddress)
+	if err != nil {
+		return nil, fmt.Errorf("parsing runner cache server address: %w", err)
+	}
+
+	if serverURL.Scheme != "http" && serverURL.Scheme != "https" {
+		return nil, fmt.Errorf("runner cache server address %q must be an http or https URL", config.ServerAddress)
+	}
+
+	return &URLSigner{serverURL: serverURL, secret: config.Secret}, nil
+}
+
+// URL returns the URL giving access to key with methods until expires. When
+// prefix is true, the URL gives access to all the keys under key.
+func (s *URLSigner) URL(key string, prefix bool, expires time.Time, methods ...string) *url.URL {
+	u := *s.serverURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + PathPrefix + key
+	u.RawPath = ""
+	u.RawQuery = Sign(s.secret, key, prefix, methods, expires).Encode()
+
+	return &u
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tempPrefix prefixes the files being written, which aren't objects yet
+const tempPrefix = ".upload-"
+
+var (
+	errInvalidKey = errors.New("invalid key")
+	errTooLarge   = errors.New("object larger than the maximum size of the cache")
+)
+
+type object struct {
+	key  string
+	size int64
+}
+
+// Store stores the objects in a directory, up to a maximum size. When an
+// object is stored over the maximum size, the least recently used objects are
+// removed. The use of the objects isn't persisted, after a restart the
+// objects are ordered by modification time.
+type Store struct {
+	dir     string
+	maxSize int64
+
+	lock      sync.Mutex
+	size      int64
+	objects   map[string]*list.Element
+	lru       *list.List
+	evictions int
+}
+
+// NewStore creates the directory when it doesn't exist, and indexes the
+// objects it contains
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		objects: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	err = s.index()
+	if err != nil {
+		return nil, fmt.Errorf("indexing %s: %w", dir, err)
+	}
+
+	s.lock.Lock()
+	s.evict()
+	s.lock.Unlock()
+
+	return s, nil
+}
+
+func (s *Store) index() error {
+	type indexed struct {
+		object
+		modTime time.Time
+	}
+
+	var objects []indexed
+	err := filepath.Walk(s.dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		// files left by the uploads interrupted by a restart
+		if strings.HasPrefix(fi.Name(), tempPrefix) {
+			return os.Remove(p)
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, indexed{
+			object:  object{key: filepath.ToSlash(rel), size: fi.Size()},
+			modTime: fi.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].modTime.After(objects[j].modTime)
+	})
+
+	for _, o := range objects {
+		s.objects[o.key] = s.lru.PushBack(o.object)
+		s.size += o.size
+	}
+
+	return nil
+}
+
+// validKey returns whether key is the clean relative path of a file
+func validKey(key string) bool {
+	return key != "" && key != "." && path.Clean(key) == key &&
+		!strings.HasPrefix(key, "/") && !strings.HasPrefix(key, "../") && key != ".." &&
+		!strings.HasPrefix(path.Base(key), tempPrefix)
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Open opens the object of key, and marks it as used
+func (s *Store) Open(key string) (*os.File, os.FileInfo, error) {
+	if !validKey(key) {
+		return nil, nil, errInvalidKey
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	s.lock.Lock()
+	if e, ok := s.objects[key]; ok {
+		s.lru.MoveToFront(e)
+	}
+	s.lock.Unlock()
+
+	return f, fi, nil
+}
+
+// Put stores the object of key, read from r, and removes the least recently
+// used objects when the store is over its maximum size
+func (s *Store) Put(key string, r io.Reader) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+
+	p := s.path(key)
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	size, err := io.Copy(f, io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if size > s.maxSize {
+		return errTooLarge
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err = os.Rename(f.Name(), p)
+	if err != nil {
+		return err
+	}
+
+	s.remove(key)
+	s.objects[key] = s.lru.PushFront(object{key: key, size: size})
+	s.size += size
+	s.evict()
+
+	return nil
+}
+
+// remove removes key from the index, the lock must be held
+func (s *Store) remove(key string) {
+	e, ok := s.objects[key]
+	if !ok {
+		return
+	}
+
+	s.lru.Remove(e)
+	delete(s.objects, key)
+	s.size -= e.Value.(object).size
+}
+
+// evict removes the least recently used objects until the store is under its
+// maximum size, the lock must be held
+func (s *Store) evict() {
+	for s.size > s.maxSize {
+		e := s.lru.Back()
+		if e == nil {
+			return
+		}
+
+		key := e.Value.(object).key
+		s.remove(key)
+		s.evictions++
+
+		_ = os.Remove(s.path(key))
+	}
+}
+
+// Stats returns the number of objects, their size and the number of objects
+// evicted
+func (s *Store) Stats() (objects int, size int64, evictions int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.objects), s.size, s.evictions
+}
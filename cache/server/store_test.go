@@ -0,0 +1,110 @@
+//go:build !integration
+// +build !integration
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readObject(t *testing.T, s *Store, key string) string {
+	f, _, err := s.Open(key)
+	require.NoError(t, err)
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put("project/1/a", strings.NewReader("aaaa")))
+	require.NoError(t, s.Put("project/1/b", strings.NewReader("bbbb")))
+	assert.Equal(t, "aaaa", readObject(t, s, "project/1/a"))
+
+	// b is the least recently used
+	require.NoError(t, s.Put("project/1/c", strings.NewReader("cccc")))
+
+	_, _, err = s.Open("project/1/b")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "aaaa", readObject(t, s, "project/1/a"))
+	assert.Equal(t, "cccc", readObject(t, s, "project/1/c"))
+
+	// replacing an object counts its new size only
+	require.NoError(t, s.Put("project/1/c", strings.NewReader("cc")))
+	assert.Equal(t, "cc", readObject(t, s, "project/1/c"))
+
+	objects, size, evictions := s.Stats()
+	assert.Equal(t, 2, objects)
+	assert.Equal(t, int64(6), size)
+	assert.Equal(t, 1, evictions)
+}
+
+func TestStoreTooLarge(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 4)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put("a", strings.NewReader("aaaa")))
+	assert.Equal(t, errTooLarge, s.Put("b", strings.NewReader("bbbbb")))
+
+	assert.Equal(t, "aaaa", readObject(t, s, "a"))
+	_, _, err = s.Open("b")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreInvalidKeys(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	for _, key := range []string{"", ".", "..", "../a", "/a", "a/", "a//b", "a/../b", "a/" + tempPrefix + "b"} {
+		t.Run(key, func(t *testing.T) {
+			assert.Equal(t, errInvalidKey, s.Put(key, strings.NewReader("a")))
+
+			_, _, err := s.Open(key)
+			assert.Equal(t, errInvalidKey, err)
+		})
+	}
+}
+
+func TestStoreIndex(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	for key, age := range map[string]time.Duration{
+		"project/1/old":    2 * time.Hour,
+		"project/1/recent": time.Hour,
+		"project/2/new":    0,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(key))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(t, ioutil.WriteFile(p, []byte("1234"), 0600))
+		require.NoError(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+	}
+
+	temp := filepath.Join(dir, "project", "1", tempPrefix+"123")
+	require.NoError(t, ioutil.WriteFile(temp, []byte("1234"), 0600))
+
+	// the oldest object is over the maximum size
+	s, err := NewStore(dir, 10)
+	require.NoError(t, err)
+
+	objects, size, _ := s.Stats()
+	assert.Equal(t, 2, objects)
+	assert.Equal(t, int64(8), size)
+
+	assert.NoFileExists(t, filepath.Join(dir, "project", "1", "old"))
+	assert.NoFileExists(t, temp)
+	assert.Equal(t, "1234", readObject(t, s, "project/1/recent"))
+}
@@ -0,0 +1,148 @@
+package sftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/cache/server"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/gocloud/sftpblob"
+)
+
+// sftpAdapter stores the cache in a directory of an SFTP server. The jobs
+// access it through the cache server of the runner, with URLs signed like the
+// ones of the runner adapter, so the credentials of the SFTP server stay in
+// the runner.
+type sftpAdapter struct {
+	timeout    time.Duration
+	config     *common.CacheSFTPConfig
+	signer     *server.URLSigner
+	objectName string
+}
+
+func (a *sftpAdapter) GetDownloadURL() *url.URL {
+	return a.signedURL(a.objectName, false, http.MethodGet, http.MethodHead)
+}
+
+func (a *sftpAdapter) GetUploadURL() *url.URL {
+	return a.signedURL(a.objectName, false, http.MethodPut)
+}
+
+func (a *sftpAdapter) GetUploadHeaders() http.Header {
+	return nil
+}
+
+func (a *sftpAdapter) GetGoCloudURL() *url.URL {
+	return nil
+}
+
+func (a *sftpAdapter) GetUploadEnv() map[string]string {
+	return nil
+}
+
+func (a *sftpAdapter) GetChunksURL() *url.URL {
+	return a.signedURL(a.objectName+"/", true, http.MethodGet, http.MethodHead, http.MethodPut)
+}
+
+func (a *sftpAdapter) ProxyBucket() (string, cache.BucketOpener) {
+	return a.bucketName(), a.openBucket
+}
+
+func (a *sftpAdapter) signedURL(key string, prefix bool, methods ...string) *url.URL {
+	return a.signer.URL(server.ProxyKey(a.bucketName(), key), prefix, time.Now().Add(a.timeout), methods...)
+}
+
+// bucketName identifies the directory of the SFTP server in the URLs of the
+// cache server, without disclosing it
+func (a *sftpAdapter) bucketName() string {
+	sum := sha256.Sum256([]byte(a.storageURL().String()))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *sftpAdapter) addr() string {
+	return net.JoinHostPort(a.config.Host, a.config.GetPort())
+}
+
+func (a *sftpAdapter) storageURL() *url.URL {
+	return &url.URL{Scheme: sftpblob.Scheme, User: url.User(a.config.User), Host: a.addr(), Path: a.config.Directory}
+}
+
+func (a *sftpAdapter) credentials() (sftpblob.Credentials, error) {
+	creds := sftpblob.Credentials{
+		Password:              a.config.Password,
+		HostKey:               a.config.HostKey,
+		InsecureIgnoreHostKey: a.config.DisableStrictHostKeyChecking,
+	}
+
+	if a.config.IdentityFile != "" {
+		key, err := ioutil.ReadFile(a.config.IdentityFile)
+		if err != nil {
+			return creds, err
+		}
+
+		creds.PrivateKey = string(key)
+	}
+
+	return creds, nil
+}
+
+func (a *sftpAdapter) openBucket(ctx context.Context) (*blob.Bucket, error) {
+	creds, err := a.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	return sftpblob.Dial(ctx, a.addr(), a.config.User, creds, a.config.Directory)
+}
+
+func New(config *common.CacheConfig, timeout time.Duration, objectName string) (cache.Adapter, error) {
+	sftp := config.SFTP
+	if sftp == nil {
+		return nil, fmt.Errorf("missing SFTP configuration")
+	}
+
+	if sftp.Host == "" || sftp.User == "" {
+		return nil, fmt.Errorf("missing SFTP host or user")
+	}
+
+	if sftp.HostKey == "" && !sftp.DisableStrictHostKeyChecking {
+		return nil, fmt.Errorf("missing SFTP host key, required unless strict host key checking is disabled")
+	}
+
+	signer, err := server.NewURLSigner(config.Runner)
+	if err != nil {
+		return nil, fmt.Errorf("the SFTP cache is accessed through the runner cache server: %w", err)
+	}
+
+	a := &sftpAdapter{
+		timeout:    timeout,
+		config:     sftp,
+		signer:     signer,
+		objectName: strings.TrimLeft(objectName, "/"),
+	}
+
+	if ttl := sftp.GetTTL(); ttl > 0 {
+		cache.EvictExpired(a.storageURL().String(), ttl, a.openBucket)
+	}
+
+	return a, nil
+}
+
+func init() {
+	err := cache.Factories().Register("sftp", New)
+	if err != nil {
+		panic(err)
+	}
+}
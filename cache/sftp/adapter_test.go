@@ -0,0 +1,224 @@
+//go:build !integration
+// +build !integration
+
+package sftp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	"gitlab.com/gitlab-org/gitlab-runner/cache/server"
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
+)
+
+var testRunnerConfig = &common.CacheRunnerConfig{ServerAddress: "http://172.17.0.1:9252", Secret: "secret"}
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.CacheSFTPConfig
+		runner        *common.CacheRunnerConfig
+		expectedError string
+	}{
+		"valid": {
+			config: &common.CacheSFTPConfig{Host: "example.com", User: "user", HostKey: ssh.TestSSHKeyPair.PublicKey},
+			runner: testRunnerConfig,
+		},
+		"strict host key checking disabled": {
+			config: &common.CacheSFTPConfig{Host: "example.com", User: "user", DisableStrictHostKeyChecking: true},
+			runner: testRunnerConfig,
+		},
+		"missing configuration": {
+			config:        nil,
+			runner:        testRunnerConfig,
+			expectedError: "missing SFTP configuration",
+		},
+		"missing host": {
+			config:        &common.CacheSFTPConfig{User: "user", HostKey: ssh.TestSSHKeyPair.PublicKey},
+			runner:        testRunnerConfig,
+			expectedError: "missing SFTP host or user",
+		},
+		"missing host key": {
+			config:        &common.CacheSFTPConfig{Host: "example.com", User: "user"},
+			runner:        testRunnerConfig,
+			expectedError: "missing SFTP host key, required unless strict host key checking is disabled",
+		},
+		"missing runner cache server": {
+			config: &common.CacheSFTPConfig{Host: "example.com", User: "user", HostKey: ssh.TestSSHKeyPair.PublicKey},
+			expectedError: "the SFTP cache is accessed through the runner cache server: " +
+				"missing runner cache server configuration",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			adapter, err := New(&common.CacheConfig{SFTP: tt.config, Runner: tt.runner}, time.Hour, "key")
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, adapter)
+		})
+	}
+}
+
+func TestAdapterURLs(t *testing.T) {
+	config := &common.CacheConfig{
+		SFTP: &common.CacheSFTPConfig{
+			Host:      "example.com",
+			User:      "user",
+			Password:  "pass",
+			HostKey:   ssh.TestSSHKeyPair.PublicKey,
+			Directory: "/srv/cache",
+		},
+		Runner: testRunnerConfig,
+	}
+
+	adapter, err := New(config, time.Hour, "/project/1/key")
+	require.NoError(t, err)
+
+	name, _ := adapter.(cache.ProxyAdapter).ProxyBucket()
+	assert.Len(t, name, 16)
+
+	// the jobs get URLs of the cache server, never the credentials of the
+	// SFTP server
+	assert.Nil(t, adapter.GetGoCloudURL())
+	assert.Empty(t, adapter.GetUploadEnv())
+	assert.Empty(t, adapter.GetUploadHeaders())
+	assert.Equal(t, "/cache/proxy/"+name+"/project/1/key", adapter.GetDownloadURL().Path)
+	assert.Equal(t, "/cache/proxy/"+name+"/project/1/key", adapter.GetUploadURL().Path)
+
+	for _, u := range []string{adapter.GetDownloadURL().String(), adapter.GetUploadURL().String()} {
+		assert.NotContains(t, u, "pass")
+		assert.NotContains(t, u, "example.com")
+	}
+
+	chunksAdapter, ok := adapter.(cache.ChunksAdapter)
+	require.True(t, ok)
+	assert.Equal(t, "/cache/proxy/"+name+"/project/1/key/", chunksAdapter.GetChunksURL().Path)
+
+	// another directory of the server is another bucket
+	config.SFTP.Directory = "/srv/other"
+	adapter, err = New(config, time.Hour, "/project/1/key")
+	require.NoError(t, err)
+
+	otherName, _ := adapter.(cache.ProxyAdapter).ProxyBucket()
+	assert.NotEqual(t, name, otherName)
+}
+
+func do(t *testing.T, method string, url string, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res.StatusCode, string(data)
+}
+
+func TestAdapterProxy(t *testing.T) {
+	sftpServer, err := ssh.NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer sftpServer.Stop()
+
+	cacheServer, err := server.NewServer(
+		common.CacheServer{Directory: t.TempDir(), Secret: "secret"},
+		logrus.New(),
+	)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(cacheServer)
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	expired := filepath.Join(dir, "project", "1", "expired")
+	require.NoError(t, os.MkdirAll(filepath.Dir(expired), 0700))
+	require.NoError(t, ioutil.WriteFile(expired, []byte("expired"), 0600))
+	modTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(expired, modTime, modTime))
+
+	config := &common.CacheConfig{
+		SFTP: &common.CacheSFTPConfig{
+			Host:      sftpServer.Host(),
+			Port:      sftpServer.Port(),
+			User:      "user",
+			Password:  "pass",
+			HostKey:   ssh.TestSSHKeyPair.PublicKey,
+			Directory: dir,
+			TTL:       3600,
+		},
+		Runner: &common.CacheRunnerConfig{ServerAddress: ts.URL, Secret: "secret"},
+	}
+
+	adapter, err := New(config, time.Hour, "project/1/key")
+	require.NoError(t, err)
+
+	// the bucket isn't proxied until the runner sets it
+	code, _ := do(t, http.MethodPut, adapter.GetUploadURL().String(), "cache")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	name, open := adapter.(cache.ProxyAdapter).ProxyBucket()
+	cacheServer.SetBuckets(map[string]cache.BucketOpener{name: open})
+
+	code, _ = do(t, http.MethodGet, adapter.GetDownloadURL().String(), "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do(t, http.MethodPut, adapter.GetUploadURL().String(), "cache")
+	assert.Equal(t, http.StatusOK, code)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "project", "1", "key"))
+	require.NoError(t, err)
+	assert.Equal(t, "cache", string(data))
+
+	code, body := do(t, http.MethodGet, adapter.GetDownloadURL().String(), "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "cache", body)
+
+	code, _ = do(t, http.MethodHead, adapter.GetDownloadURL().String(), "")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = do(t, http.MethodPut, adapter.GetDownloadURL().String(), "other")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	adapter, err = New(config, time.Hour, "project/1/chunks")
+	require.NoError(t, err)
+
+	chunkURL := *adapter.(cache.ChunksAdapter).GetChunksURL()
+	chunkURL.Path += "hash"
+
+	code, _ = do(t, http.MethodPut, chunkURL.String(), "chunk")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body = do(t, http.MethodGet, chunkURL.String(), "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "chunk", body)
+
+	// the buckets removed from the configuration are no longer proxied
+	cacheServer.SetBuckets(nil)
+
+	code, _ = do(t, http.MethodGet, chunkURL.String(), "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(expired)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+}
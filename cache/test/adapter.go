@@ -10,15 +10,24 @@ import (
 )
 
 type testAdapter struct {
-	objectName string
-	useGoCloud bool
+	objectName  string
+	useGoCloud  bool
+	goCloudOnly bool
 }
 
 func (t *testAdapter) GetDownloadURL() *url.URL {
+	if t.goCloudOnly {
+		return nil
+	}
+
 	return t.getURL("download")
 }
 
 func (t *testAdapter) GetUploadURL() *url.URL {
+	if t.goCloudOnly {
+		return nil
+	}
+
 	return t.getURL("upload")
 }
 
@@ -53,6 +62,15 @@ func (t *testAdapter) getURL(operation string) *url.URL {
 	}
 }
 
+// testChunksAdapter supports the chunked cache format
+type testChunksAdapter struct {
+	testAdapter
+}
+
+func (t *testChunksAdapter) GetChunksURL() *url.URL {
+	return t.getURL("chunks")
+}
+
 func New(_ *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
 	return &testAdapter{objectName: objectName}, nil
 }
@@ -61,6 +79,16 @@ func NewGoCloudAdapter(_ *common.CacheConfig, _ time.Duration, objectName string
 	return &testAdapter{objectName: objectName, useGoCloud: true}, nil
 }
 
+// NewGoCloudOnlyAdapter creates an adapter without pre-signed URLs, like the
+// adapters storing the cache outside of an object storage
+func NewGoCloudOnlyAdapter(_ *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
+	return &testAdapter{objectName: objectName, useGoCloud: true, goCloudOnly: true}, nil
+}
+
+func NewChunksAdapter(_ *common.CacheConfig, _ time.Duration, objectName string) (cache.Adapter, error) {
+	return &testChunksAdapter{testAdapter: testAdapter{objectName: objectName}}, nil
+}
+
 func init() {
 	if err := cache.Factories().Register("test", New); err != nil {
 		panic(err)
@@ -69,4 +97,12 @@ func init() {
 	if err := cache.Factories().Register("goCloudTest", NewGoCloudAdapter); err != nil {
 		panic(err)
 	}
+
+	if err := cache.Factories().Register("goCloudOnlyTest", NewGoCloudOnlyAdapter); err != nil {
+		panic(err)
+	}
+
+	if err := cache.Factories().Register("chunksTest", NewChunksAdapter); err != nil {
+		panic(err)
+	}
 }
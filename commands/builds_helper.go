@@ -5,9 +5,11 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-runner/network"
 	"gitlab.com/gitlab-org/gitlab-runner/session"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -53,6 +55,8 @@ func newStatePermutationFromBuild(build *common.Build) statePermutation {
 type runnerCounter struct {
 	builds   int
 	requests int
+	// longPolling is set while a job request of the runner is held by GitLab
+	longPolling bool
 
 	requestConcurrencyExceeded int
 }
@@ -64,6 +68,9 @@ type buildsHelper struct {
 
 	jobsTotal            *prometheus.CounterVec
 	jobDurationHistogram *prometheus.HistogramVec
+
+	// circuitBreakers are listed with the jobs, when set
+	circuitBreakers *network.CircuitBreakers
 }
 
 func (b *buildsHelper) getRunnerCounter(runner *common.RunnerConfig) *runnerCounter {
@@ -147,6 +154,28 @@ func (b *buildsHelper) releaseRequest(runner *common.RunnerConfig) bool {
 	return false
 }
 
+// acquireLongPoll returns whether a job request of the runner can be held by
+// GitLab, only one request per runner is held at a time
+func (b *buildsHelper) acquireLongPoll(runner *common.RunnerConfig) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	counter := b.getRunnerCounter(runner)
+	if counter.longPolling {
+		return false
+	}
+
+	counter.longPolling = true
+	return true
+}
+
+func (b *buildsHelper) releaseLongPoll(runner *common.RunnerConfig) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.getRunnerCounter(runner).longPolling = false
+}
+
 func (b *buildsHelper) addBuild(build *common.Build) {
 	if build == nil {
 		return
@@ -299,7 +328,7 @@ func (b *buildsHelper) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (b *buildsHelper) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("X-List-Version", "2")
+	w.Header().Add("X-List-Version", "3")
 	w.Header().Add("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 
@@ -314,6 +343,21 @@ func (b *buildsHelper) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
 			job.Duration(),
 		)
 	}
+
+	if b.circuitBreakers == nil {
+		return
+	}
+
+	for _, status := range b.circuitBreakers.Statuses() {
+		_, _ = fmt.Fprintf(
+			w,
+			"circuit_breaker gitlab=%s state=%s failure_ratio=%.2f retry_in=%s\n",
+			status.URL,
+			status.State,
+			status.FailureRatio,
+			status.RetryIn.Round(time.Second),
+		)
+	}
 }
 
 func newBuildsHelper() buildsHelper {
@@ -4,6 +4,7 @@
 package commands
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/network"
 	"gitlab.com/gitlab-org/gitlab-runner/session"
 )
 
@@ -129,14 +131,46 @@ func TestBuildsHelperFindSessionByURL(t *testing.T) {
 	assert.Nil(t, foundSession)
 }
 
+// newTestCircuitBreakers returns the circuit breakers of a client that
+// requested a job from a GitLab instance with no jobs
+func newTestCircuitBreakers(t *testing.T) *network.CircuitBreakers {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	client := network.NewGitLabClient()
+	config := common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{URL: s.URL, Token: "token"},
+	}
+
+	_, ok := client.RequestJob(context.Background(), config, nil)
+	require.True(t, ok)
+
+	return client.CircuitBreakers()
+}
+
 func TestBuildsHelper_ListJobsHandler(t *testing.T) {
 	tests := map[string]struct {
-		build          *common.Build
-		expectedOutput []string
+		build           *common.Build
+		circuitBreakers *network.CircuitBreakers
+		expectedOutput  []string
 	}{
 		"no jobs": {
 			build: nil,
 		},
+		"no jobs with circuit breakers": {
+			build:           nil,
+			circuitBreakers: network.NewCircuitBreakers(),
+		},
+		"circuit breaker exists": {
+			build:           nil,
+			circuitBreakers: newTestCircuitBreakers(t),
+			expectedOutput: []string{
+				"circuit_breaker gitlab=http://127.0.0.1:",
+				"state=closed failure_ratio=0.00 retry_in=0s",
+			},
+		},
 		"job exists": {
 			build: &common.Build{
 				Runner: &common.RunnerConfig{},
@@ -161,13 +195,14 @@ func TestBuildsHelper_ListJobsHandler(t *testing.T) {
 
 			b := newBuildsHelper()
 			b.addBuild(test.build)
+			b.circuitBreakers = test.circuitBreakers
 			b.ListJobsHandler(writer, req)
 
 			resp := writer.Result()
 			defer resp.Body.Close()
 
 			assert.Equal(t, http.StatusOK, resp.StatusCode)
-			assert.Equal(t, "2", resp.Header.Get("X-List-Version"))
+			assert.Equal(t, "3", resp.Header.Get("X-List-Version"))
 			assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
 
 			body, err := ioutil.ReadAll(resp.Body)
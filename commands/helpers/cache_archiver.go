@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,8 +17,10 @@ import (
 	"github.com/urfave/cli"
 
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/meter"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
+	_ "gitlab.com/gitlab-org/gitlab-runner/helpers/gocloud/localblob" // Needed to register the local driver
 	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
 	"gitlab.com/gitlab-org/gitlab-runner/log"
 
@@ -37,9 +40,12 @@ type CacheArchiverCommand struct {
 	Timeout          int      `long:"timeout" description:"Overall timeout for cache uploading request (in minutes)"`
 	Headers          []string `long:"header" description:"HTTP headers to send with PUT request (in form of 'key:value')"`
 	CompressionLevel string   `long:"compression-level" env:"CACHE_COMPRESSION_LEVEL" description:"Compression level (fastest, fast, default, slow, slowest)"`
+	Format           string   `long:"format" description:"Format of the cache (zip, chunked)"`
+	ChunksURL        string   `long:"chunks-url" description:"URL of the chunks of the chunked format (pre-signed URL prefix or Go Cloud URL)"`
 
-	client *CacheClient
-	mux    *blob.URLMux
+	client   *CacheClient
+	mux      *blob.URLMux
+	manifest *chunked.Manifest
 }
 
 func (c *CacheArchiverCommand) getClient() *CacheClient {
@@ -50,6 +56,14 @@ func (c *CacheArchiverCommand) getClient() *CacheClient {
 	return c.client
 }
 
+func (c *CacheArchiverCommand) getMux() *blob.URLMux {
+	if c.mux == nil {
+		c.mux = blob.DefaultURLMux()
+	}
+
+	return c.mux
+}
+
 func (c *CacheArchiverCommand) upload(_ int) error {
 	file, err := os.Open(c.File)
 	if err != nil {
@@ -99,10 +113,6 @@ func (c *CacheArchiverCommand) handlePresignedURL(fi os.FileInfo, file io.Reader
 func (c *CacheArchiverCommand) handleGoCloudURL(file io.Reader) error {
 	logrus.Infoln("Uploading", filepath.Base(c.File), "to", url_helpers.CleanURL(c.GoCloudURL))
 
-	if c.mux == nil {
-		c.mux = blob.DefaultURLMux()
-	}
-
 	ctx, cancelWrite := context.WithCancel(context.Background())
 	defer cancelWrite()
 
@@ -116,7 +126,7 @@ func (c *CacheArchiverCommand) handleGoCloudURL(file io.Reader) error {
 		return fmt.Errorf("no object name provided")
 	}
 
-	b, err := c.mux.OpenBucket(ctx, c.GoCloudURL)
+	b, err := c.getMux().OpenBucket(ctx, c.GoCloudURL)
 	if err != nil {
 		return err
 	}
@@ -176,6 +186,110 @@ func (c *CacheArchiverCommand) createZipFile(filename string) error {
 	return os.Rename(f.Name(), filename)
 }
 
+// useChunkedFormat returns whether the cache is created in the chunked format,
+// which requires the chunks URL
+func (c *CacheArchiverCommand) useChunkedFormat() bool {
+	if c.Format != string(common.CacheFormatChunked) {
+		return false
+	}
+
+	if c.ChunksURL == "" && (c.URL != "" || c.GoCloudURL != "") {
+		logrus.Warningln("The cache adapter doesn't support the chunked format, falling back to the zip format")
+		return false
+	}
+
+	return true
+}
+
+// chunksDir returns the directory of the chunks stored locally for the cache
+// file
+func chunksDir(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "chunks")
+}
+
+func (c *CacheArchiverCommand) createManifestFile(filename string) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+
+	local := chunked.NewLocalStore(chunksDir(filename))
+
+	m, err := chunked.Archive(
+		context.Background(),
+		c.wd,
+		c.sortedFiles(),
+		local,
+		GetCompressionLevel(c.CompressionLevel),
+	)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "manifest_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = m.Write(f)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return err
+	}
+
+	c.manifest = m
+
+	// only the chunks of the last cache are kept locally
+	return local.Prune(m.Hashes())
+}
+
+func (c *CacheArchiverCommand) uploadChunks(_ int) error {
+	u, err := url.Parse(c.ChunksURL)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infoln("Uploading cache chunks to", url_helpers.CleanURL(c.ChunksURL))
+
+	ctx := context.Background()
+
+	remote, closeStore, err := chunked.OpenStore(ctx, u, &c.getClient().Client, c.getMux())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closeStore() }()
+
+	stats, err := chunked.Upload(ctx, c.manifest, chunked.NewLocalStore(chunksDir(c.File)), remote)
+
+	var transientErr *chunked.TransientError
+	if errors.As(err, &transientErr) {
+		return retryableErr{err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof(
+		"Uploaded %d of %d chunks (%s)",
+		stats.TransferredChunks,
+		stats.Chunks,
+		meter.FormatBytes(uint64(stats.TransferredBytes)),
+	)
+
+	return nil
+}
+
 func (c *CacheArchiverCommand) Execute(*cli.Context) {
 	log.SetRunnerFormatter()
 
@@ -197,11 +311,24 @@ func (c *CacheArchiverCommand) Execute(*cli.Context) {
 	}
 
 	// Create archive
-	err = c.createZipFile(c.File)
+	useChunkedFormat := c.useChunkedFormat()
+	if useChunkedFormat {
+		err = c.createManifestFile(c.File)
+	} else {
+		err = c.createZipFile(c.File)
+	}
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
+	// Upload the chunks before the manifest referencing them
+	if useChunkedFormat && c.ChunksURL != "" {
+		err := c.doRetry(c.uploadChunks)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+	}
+
 	// Upload archive if needed
 	if c.URL != "" || c.GoCloudURL != "" {
 		err := c.doRetry(c.upload)
@@ -0,0 +1,178 @@
+//go:build !integration
+// +build !integration
+
+package helpers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+)
+
+// chunkedCacheServer stores the objects uploaded with PUT requests, like a
+// pre-signed URL would
+type chunkedCacheServer struct {
+	lock         sync.Mutex
+	objects      map[string][]byte
+	chunkUploads int
+}
+
+func (s *chunkedCacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.objects[r.URL.Path] = data
+		if strings.HasPrefix(r.URL.Path, "/chunks/") {
+			s.chunkUploads++
+		}
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+		_, _ = w.Write(data)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *chunkedCacheServer) uploads() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.chunkUploads
+}
+
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+}
+
+func TestCacheChunkedFormat(t *testing.T) {
+	removeHook := helpers.MakeFatalToPanic()
+	defer removeHook()
+
+	cacheServer := &chunkedCacheServer{objects: make(map[string][]byte)}
+	server := httptest.NewServer(cacheServer)
+	defer server.Close()
+
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	big := make([]byte, 3*chunked.MaxChunkSize)
+	for i := range big {
+		big[i] = byte(i * 7 % 251)
+	}
+	require.NoError(t, os.MkdirAll("cached", 0755))
+	require.NoError(t, os.WriteFile("cached/big", big, 0644))
+	require.NoError(t, os.WriteFile("cached/small", []byte("small"), 0644))
+
+	archiver := CacheArchiverCommand{
+		File:         filepath.Join(dir, "archiver", "cache.zip"),
+		URL:          server.URL + "/cache",
+		ChunksURL:    server.URL + "/chunks",
+		Format:       "chunked",
+		fileArchiver: fileArchiver{Paths: []string{"cached"}},
+	}
+	assert.NotPanics(t, func() {
+		archiver.Execute(nil)
+	})
+
+	isManifest, err := chunked.IsManifestFile(archiver.File)
+	require.NoError(t, err)
+	assert.True(t, isManifest)
+	assert.Contains(t, cacheServer.objects, "/cache")
+
+	uploads := cacheServer.uploads()
+	assert.Equal(t, len(archiver.manifest.Hashes()), uploads)
+
+	require.NoError(t, os.RemoveAll("cached"))
+
+	extractor := CacheExtractorCommand{
+		File:      filepath.Join(dir, "extractor", "cache.zip"),
+		URL:       server.URL + "/cache",
+		ChunksURL: server.URL + "/chunks",
+	}
+	assert.NotPanics(t, func() {
+		extractor.Execute(nil)
+	})
+
+	data, err := os.ReadFile("cached/big")
+	require.NoError(t, err)
+	assert.Equal(t, big, data)
+
+	data, err = os.ReadFile("cached/small")
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+
+	// only the chunks of the changed files are uploaded again
+	require.NoError(t, os.WriteFile("cached/small", []byte("changed"), 0644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes("cached/small", future, future))
+
+	archiver.manifest = nil
+	assert.NotPanics(t, func() {
+		archiver.Execute(nil)
+	})
+	assert.Equal(t, uploads+1, cacheServer.uploads())
+}
+
+func TestCacheChunkedFormatFallsBackToZip(t *testing.T) {
+	OnEachZipArchiver(t, func(t *testing.T) {
+		removeHook := helpers.MakeFatalToPanic()
+		defer removeHook()
+
+		cacheServer := &chunkedCacheServer{objects: make(map[string][]byte)}
+		server := httptest.NewServer(cacheServer)
+		defer server.Close()
+
+		dir := t.TempDir()
+		chdir(t, dir)
+
+		require.NoError(t, os.WriteFile("file", []byte("content"), 0644))
+
+		archiver := CacheArchiverCommand{
+			File:         filepath.Join(dir, "cache", "cache.zip"),
+			URL:          server.URL + "/cache",
+			Format:       "chunked",
+			fileArchiver: fileArchiver{Paths: []string{"file"}},
+		}
+		assert.NotPanics(t, func() {
+			archiver.Execute(nil)
+		})
+
+		isManifest, err := chunked.IsManifestFile(archiver.File)
+		require.NoError(t, err)
+		assert.False(t, isManifest)
+		assert.Contains(t, cacheServer.objects, "/cache")
+		assert.Equal(t, 0, cacheServer.uploads())
+	})
+}
@@ -2,33 +2,45 @@ package helpers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/chunked"
 	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/meter"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
 	"gitlab.com/gitlab-org/gitlab-runner/log"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
 )
 
 type CacheExtractorCommand struct {
 	retryHelper
 	meter.TransferMeterCommand
 
-	File    string `long:"file" description:"The file containing your cache artifacts"`
-	URL     string `long:"url" description:"URL of remote cache resource"`
-	Timeout int    `long:"timeout" description:"Overall timeout for cache downloading request (in minutes)"`
+	File       string `long:"file" description:"The file containing your cache artifacts"`
+	URL        string `long:"url" description:"URL of remote cache resource"`
+	GoCloudURL string `long:"gocloud-url" description:"Go Cloud URL of remote cache resource (requires credentials)"`
+	ChunksURL  string `long:"chunks-url" description:"URL of the chunks of the chunked format"`
+	Timeout    int    `long:"timeout" description:"Overall timeout for cache downloading request (in minutes)"`
 
-	client *CacheClient
+	client   *CacheClient
+	mux      *blob.URLMux
+	manifest *chunked.Manifest
 }
 
 func (c *CacheExtractorCommand) getClient() *CacheClient {
@@ -39,6 +51,14 @@ func (c *CacheExtractorCommand) getClient() *CacheClient {
 	return c.client
 }
 
+func (c *CacheExtractorCommand) getMux() *blob.URLMux {
+	if c.mux == nil {
+		c.mux = blob.DefaultURLMux()
+	}
+
+	return c.mux
+}
+
 func checkIfUpToDate(path string, resp *http.Response) (bool, time.Time) {
 	fi, _ := os.Lstat(path)
 	date, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
@@ -60,6 +80,10 @@ func (c *CacheExtractorCommand) download(_ int) error {
 		return err
 	}
 
+	if c.GoCloudURL != "" {
+		return c.downloadGoCloudURL()
+	}
+
 	resp, err := c.getCache()
 	if err != nil {
 		return err
@@ -73,6 +97,53 @@ func (c *CacheExtractorCommand) download(_ int) error {
 		return nil
 	}
 
+	logrus.Infoln("Downloading", filepath.Base(c.File), "from", url_helpers.CleanURL(c.URL))
+
+	return c.saveCache(resp.Body, getRemoteCacheSize(resp), date)
+}
+
+func (c *CacheExtractorCommand) downloadGoCloudURL() error {
+	ctx := context.Background()
+
+	u, err := url.Parse(c.GoCloudURL)
+	if err != nil {
+		return err
+	}
+
+	objectName := strings.TrimLeft(u.Path, "/")
+	if objectName == "" {
+		return fmt.Errorf("no object name provided")
+	}
+
+	b, err := c.getMux().OpenBucket(ctx, c.GoCloudURL)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	reader, err := b.NewReader(ctx, objectName, nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		return retryableErr{err: err}
+	}
+	defer func() { _ = reader.Close() }()
+
+	fi, _ := os.Lstat(c.File)
+	if fi != nil && !reader.ModTime().After(fi.ModTime()) {
+		logrus.Infoln(filepath.Base(c.File), "is up to date")
+		return nil
+	}
+
+	logrus.Infoln("Downloading", filepath.Base(c.File), "from", url_helpers.CleanURL(c.GoCloudURL))
+
+	return c.saveCache(reader, reader.Size(), reader.ModTime())
+}
+
+// saveCache writes the downloaded cache to the cache file, with the date of
+// the remote cache as modification time
+func (c *CacheExtractorCommand) saveCache(r io.Reader, size int64, date time.Time) error {
 	file, err := ioutil.TempFile(filepath.Dir(c.File), "cache")
 	if err != nil {
 		return err
@@ -83,18 +154,16 @@ func (c *CacheExtractorCommand) download(_ int) error {
 		_ = os.Remove(file.Name())
 	}()
 
-	logrus.Infoln("Downloading", filepath.Base(c.File), "from", url_helpers.CleanURL(c.URL))
-
 	writer := meter.NewWriter(
 		file,
 		c.TransferMeterFrequency,
-		meter.LabelledRateFormat(os.Stdout, "Downloading cache", getRemoteCacheSize(resp)),
+		meter.LabelledRateFormat(os.Stdout, "Downloading cache", size),
 	)
 
 	// Close() is checked properly bellow, where the file handling is being finalized
 	defer func() { _ = writer.Close() }()
 
-	_, err = io.Copy(writer, resp.Body)
+	_, err = io.Copy(writer, r)
 	if err != nil {
 		return retryableErr{err: err}
 	}
@@ -131,6 +200,70 @@ func (c *CacheExtractorCommand) getCache() (*http.Response, error) {
 	return resp, retryOnServerError(resp)
 }
 
+func (c *CacheExtractorCommand) downloadChunks(_ int) error {
+	var remote chunked.Store
+	if c.ChunksURL != "" {
+		u, err := url.Parse(c.ChunksURL)
+		if err != nil {
+			return err
+		}
+
+		logrus.Infoln("Downloading cache chunks from", url_helpers.CleanURL(c.ChunksURL))
+
+		store, closeStore, err := chunked.OpenStore(context.Background(), u, &c.getClient().Client, c.getMux())
+		if err != nil {
+			return err
+		}
+		defer func() { _ = closeStore() }()
+
+		remote = store
+	}
+
+	stats, err := chunked.Download(context.Background(), c.manifest, chunked.NewLocalStore(chunksDir(c.File)), remote)
+
+	var transientErr *chunked.TransientError
+	if errors.As(err, &transientErr) {
+		return retryableErr{err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof(
+		"Downloaded %d of %d chunks (%s)",
+		stats.TransferredChunks,
+		stats.Chunks,
+		meter.FormatBytes(uint64(stats.TransferredBytes)),
+	)
+
+	return nil
+}
+
+// extractManifest extracts a cache in the chunked format, downloading the
+// chunks that aren't stored locally
+func (c *CacheExtractorCommand) extractManifest(wd string) error {
+	m, err := chunked.ReadManifestFile(c.File)
+	if err != nil {
+		return err
+	}
+	c.manifest = m
+
+	err = c.doRetry(c.downloadChunks)
+	if err != nil {
+		return err
+	}
+
+	local := chunked.NewLocalStore(chunksDir(c.File))
+
+	err = chunked.Extract(context.Background(), m, wd, local)
+	if err != nil {
+		return err
+	}
+
+	// only the chunks of the last cache are kept locally
+	return local.Prune(m.Hashes())
+}
+
 func (c *CacheExtractorCommand) Execute(cliContext *cli.Context) {
 	log.SetRunnerFormatter()
 
@@ -143,7 +276,7 @@ func (c *CacheExtractorCommand) Execute(cliContext *cli.Context) {
 		warningln("Missing cache file")
 	}
 
-	if c.URL != "" {
+	if c.URL != "" || c.GoCloudURL != "" {
 		err := c.doRetry(c.download)
 		if err != nil {
 			warningln(err)
@@ -154,6 +287,23 @@ func (c *CacheExtractorCommand) Execute(cliContext *cli.Context) {
 				"Instead a local version of cache will be extracted.")
 	}
 
+	isManifest, err := chunked.IsManifestFile(c.File)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	if isManifest {
+		err = c.extractManifest(wd)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+
+		return
+	}
+
 	f, size, err := openZip(c.File)
 	if os.IsNotExist(err) {
 		return
@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -207,3 +209,52 @@ func TestCacheExtractorRemoteServerFailOnInvalidServer(t *testing.T) {
 	_, err := os.Stat(cacheExtractorTestArchivedFile)
 	assert.Error(t, err)
 }
+
+func TestCacheExtractorGoCloudURL(t *testing.T) {
+	dir := t.TempDir()
+
+	file, err := os.Create(filepath.Join(dir, "cache.zip"))
+	require.NoError(t, err)
+	archive := zip.NewWriter(file)
+	_, err = archive.Create(cacheExtractorTestArchivedFile)
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	require.NoError(t, file.Close())
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	removeHook := helpers.MakeWarningToPanic()
+	defer removeHook()
+	cmd := CacheExtractorCommand{
+		File:       cacheExtractorArchive,
+		GoCloudURL: "local:///cache.zip?dir=" + url.QueryEscape(dir),
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheExtractorTestArchivedFile)
+	assert.NoError(t, err)
+
+	err = os.Chtimes(cacheExtractorArchive, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	assert.NotPanics(t, func() { cmd.Execute(nil) }, "archive is up to date")
+}
+
+func TestCacheExtractorGoCloudURLNotFound(t *testing.T) {
+	removeHook := helpers.MakeWarningToPanic()
+	defer removeHook()
+	cmd := CacheExtractorCommand{
+		File:       "non-existing-test.zip",
+		GoCloudURL: "local:///invalid-file.zip?dir=" + url.QueryEscape(t.TempDir()),
+	}
+	assert.Panics(t, func() {
+		cmd.Execute(nil)
+	})
+	_, err := os.Stat(cacheExtractorTestArchivedFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,280 @@
+package chunked
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+)
+
+// transferConcurrency is the number of chunks transferred at the same time
+// with a remote store
+const transferConcurrency = 4
+
+// TransferStats describes the chunks transferred with a remote store
+type TransferStats struct {
+	Chunks            int
+	TransferredChunks int
+	TransferredBytes  int64
+}
+
+func encoderLevel(level archive.CompressionLevel) zstd.EncoderLevel {
+	switch level {
+	case archive.FastestCompression, archive.FastCompression:
+		return zstd.SpeedFastest
+	case archive.SlowCompression:
+		return zstd.SpeedBetterCompression
+	case archive.SlowestCompression:
+		return zstd.SpeedBestCompression
+	default:
+		return zstd.SpeedDefault
+	}
+}
+
+// Archive splits the content of the files, relative to dir, in chunks stored
+// in local, and returns the manifest of the cache. The files must be sorted.
+func Archive(
+	ctx context.Context,
+	dir string,
+	files []string,
+	local *LocalStore,
+	level archive.CompressionLevel,
+) (*Manifest, error) {
+	m := newManifest()
+
+	for _, name := range files {
+		file, err := newFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if file != nil {
+			m.Files = append(m.Files, *file)
+		}
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel(level)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = encoder.Close() }()
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(writeStream(ctx, pw, dir, m.Files))
+	}()
+	defer func() { _ = pr.Close() }()
+
+	chunker := NewChunker(pr)
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk, err := storeChunk(ctx, local, encoder, data)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Chunks = append(m.Chunks, chunk)
+	}
+
+	return m, nil
+}
+
+func newFile(dir string, name string) (*File, error) {
+	fi, err := os.Lstat(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Path:    filepath.ToSlash(name),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+
+	switch {
+	case fi.Mode().IsRegular():
+		file.Size = fi.Size()
+	case fi.Mode()&os.ModeSymlink != 0:
+		file.Link, err = os.Readlink(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+	case fi.IsDir():
+	default:
+		logrus.Warningf("File ignored: %q", name)
+		return nil, nil
+	}
+
+	return file, nil
+}
+
+// writeStream writes the content of the regular files to w
+func writeStream(ctx context.Context, w io.Writer, dir string, files []File) error {
+	for _, file := range files {
+		if !file.Mode.IsRegular() {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := copyFile(w, dir, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(w io.Writer, dir string, file File) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Path)))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	n, err := io.Copy(w, io.LimitReader(f, file.Size))
+	if err != nil {
+		return err
+	}
+
+	if n != file.Size {
+		return fmt.Errorf("%s: file changed while being archived", file.Path)
+	}
+
+	return nil
+}
+
+func storeChunk(ctx context.Context, local *LocalStore, encoder *zstd.Encoder, data []byte) (Chunk, error) {
+	sum := sha256.Sum256(data)
+	chunk := Chunk{
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(data)),
+	}
+
+	exists, err := local.Exists(ctx, chunk.Hash)
+	if err != nil || exists {
+		return chunk, err
+	}
+
+	compressed := encoder.EncodeAll(data, nil)
+
+	return chunk, local.Put(ctx, chunk.Hash, bytes.NewReader(compressed), int64(len(compressed)))
+}
+
+// Upload uploads the chunks of the manifest stored in local that remote
+// doesn't have
+func Upload(ctx context.Context, m *Manifest, local *LocalStore, remote Store) (TransferStats, error) {
+	hashes := uniqueHashes(m)
+	stats := TransferStats{Chunks: len(hashes)}
+
+	var lock sync.Mutex
+	err := forEach(ctx, hashes, func(ctx context.Context, hash string) error {
+		exists, err := remote.Exists(ctx, hash)
+		if err != nil || exists {
+			return err
+		}
+
+		size, err := local.Size(hash)
+		if err != nil {
+			return err
+		}
+
+		r, err := local.Get(ctx, hash)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+
+		if err := remote.Put(ctx, hash, r, size); err != nil {
+			return err
+		}
+
+		lock.Lock()
+		stats.TransferredChunks++
+		stats.TransferredBytes += size
+		lock.Unlock()
+
+		return nil
+	})
+
+	return stats, err
+}
+
+func uniqueHashes(m *Manifest) []string {
+	seen := make(map[string]bool, len(m.Chunks))
+	hashes := make([]string, 0, len(m.Chunks))
+
+	for _, chunk := range m.Chunks {
+		if seen[chunk.Hash] {
+			continue
+		}
+
+		seen[chunk.Hash] = true
+		hashes = append(hashes, chunk.Hash)
+	}
+
+	return hashes
+}
+
+// forEach calls fn for the hashes, transferConcurrency at a time, and returns
+// the first error
+func forEach(ctx context.Context, hashes []string, fn func(ctx context.Context, hash string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan string)
+	errs := make(chan error, transferConcurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < transferConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for hash := range queue {
+				if err := fn(ctx, hash); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, hash := range hashes {
+		select {
+		case queue <- hash:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
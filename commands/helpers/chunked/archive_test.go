@@ -0,0 +1,223 @@
+//go:build !integration
+// +build !integration
+
+package chunked
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+
+	"gitlab.com/gitlab-org/gitlab-runner/commands/helpers/archive"
+)
+
+func writeFile(t *testing.T, dir string, name string, data []byte) {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0640))
+}
+
+func archiveDir(t *testing.T, dir string, local *LocalStore) *Manifest {
+	files := []string{"dir", "dir/big", "dir/small", "empty"}
+	if runtime.GOOS != "windows" {
+		files = append(files, "link")
+	}
+
+	m, err := Archive(context.Background(), dir, files, local, archive.DefaultCompression)
+	require.NoError(t, err)
+
+	return m
+}
+
+func TestArchiveUploadDownloadExtract(t *testing.T) {
+	ctx := context.Background()
+
+	src := t.TempDir()
+	big := randomData(1, 3*MaxChunkSize)
+	writeFile(t, src, "dir/big", big)
+	writeFile(t, src, "dir/small", []byte("small"))
+	writeFile(t, src, "empty", nil)
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Symlink("dir/small", filepath.Join(src, "link")))
+	}
+
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	remote := NewBucketStore(bucket)
+
+	srcLocal := NewLocalStore(t.TempDir())
+	m := archiveDir(t, src, srcLocal)
+	assert.Equal(t, int64(len(big)+len("small")), m.Size())
+
+	stats, err := Upload(ctx, m, srcLocal, remote)
+	require.NoError(t, err)
+	assert.Equal(t, len(m.Hashes()), stats.Chunks)
+	assert.Equal(t, stats.Chunks, stats.TransferredChunks)
+
+	dst := t.TempDir()
+	dstLocal := NewLocalStore(t.TempDir())
+
+	stats, err = Download(ctx, m, dstLocal, remote)
+	require.NoError(t, err)
+	assert.Equal(t, stats.Chunks, stats.TransferredChunks)
+
+	require.NoError(t, Extract(ctx, m, dst, dstLocal))
+
+	data, err := os.ReadFile(filepath.Join(dst, "dir/big"))
+	require.NoError(t, err)
+	assert.Equal(t, big, data)
+
+	data, err = os.ReadFile(filepath.Join(dst, "empty"))
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	fi, err := os.Stat(filepath.Join(dst, "dir/small"))
+	require.NoError(t, err)
+	assert.Equal(t, m.Files[2].ModTime.Unix(), fi.ModTime().Unix())
+
+	if runtime.GOOS != "windows" {
+		link, err := os.Readlink(filepath.Join(dst, "link"))
+		require.NoError(t, err)
+		assert.Equal(t, "dir/small", link)
+	}
+
+	// only the chunks of the changed files are transferred again
+	writeFile(t, src, "dir/small", []byte("changed"))
+	changed := archiveDir(t, src, srcLocal)
+
+	stats, err = Upload(ctx, changed, srcLocal, remote)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TransferredChunks)
+
+	stats, err = Download(ctx, changed, dstLocal, remote)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TransferredChunks)
+
+	require.NoError(t, Extract(ctx, changed, dst, dstLocal))
+
+	data, err = os.ReadFile(filepath.Join(dst, "dir/small"))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(data))
+}
+
+func TestDownloadWithoutRemote(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "dir/big", randomData(1, 1024))
+	writeFile(t, src, "dir/small", nil)
+	writeFile(t, src, "empty", nil)
+	require.NoError(t, os.Symlink("dir/small", filepath.Join(src, "link")))
+
+	m := archiveDir(t, src, NewLocalStore(t.TempDir()))
+
+	_, err := Download(context.Background(), m, NewLocalStore(t.TempDir()), nil)
+	assert.EqualError(t, err, "1 chunks missing and no chunks URL provided")
+}
+
+func TestExtractCorruptedChunk(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "dir/big", randomData(1, 1024))
+	writeFile(t, src, "dir/small", nil)
+	writeFile(t, src, "empty", nil)
+	require.NoError(t, os.Symlink("dir/small", filepath.Join(src, "link")))
+
+	local := NewLocalStore(t.TempDir())
+	m := archiveDir(t, src, local)
+	require.Len(t, m.Chunks, 1)
+
+	require.NoError(t, os.WriteFile(local.path(m.Chunks[0].Hash), []byte("corrupted"), 0600))
+
+	err := Extract(context.Background(), m, t.TempDir(), local)
+	assert.Error(t, err)
+
+	// the corrupted chunk is removed, to be downloaded again
+	exists, err := local.Exists(context.Background(), m.Chunks[0].Hash)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestSafePath(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"../file", "..", "dir/../../file", "/etc/passwd"} {
+		_, err := safePath(dir, name)
+		assert.Error(t, err, name)
+	}
+
+	path, err := safePath(dir, "dir/../file")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "file"), path)
+}
+
+func TestHTTPStore(t *testing.T) {
+	const hash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := map[string]struct {
+		status           int
+		expectedExists   bool
+		expectedError    bool
+		expectedNotFound bool
+		expectedRetry    bool
+	}{
+		"found": {
+			status:         http.StatusOK,
+			expectedExists: true,
+		},
+		"not found": {
+			status:           http.StatusNotFound,
+			expectedError:    true,
+			expectedNotFound: true,
+		},
+		"forbidden": {
+			status:        http.StatusForbidden,
+			expectedError: true,
+		},
+		"server error": {
+			status:        http.StatusBadGateway,
+			expectedError: true,
+			expectedRetry: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/chunks/"+hash, r.URL.Path)
+				assert.Equal(t, "signature", r.URL.Query().Get("token"))
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			u, err := url.Parse(server.URL + "/chunks/?token=signature")
+			require.NoError(t, err)
+
+			store := NewHTTPStore(u, server.Client())
+
+			exists, err := store.Exists(context.Background(), hash)
+			assert.Equal(t, tt.expectedExists, exists)
+			assert.Equal(t, tt.expectedError && !tt.expectedNotFound, err != nil)
+
+			r, err := store.Get(context.Background(), hash)
+			if !tt.expectedError {
+				require.NoError(t, err)
+				_ = r.Close()
+				return
+			}
+
+			assert.Error(t, err)
+			assert.Equal(t, tt.expectedNotFound, err == ErrChunkNotFound)
+
+			var transientErr *TransientError
+			assert.Equal(t, tt.expectedRetry, errors.As(err, &transientErr))
+		})
+	}
+}
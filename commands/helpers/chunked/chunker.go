@@ -0,0 +1,132 @@
+package chunked
+
+import (
+	"io"
+)
+
+// The chunk sizes are part of the format: changing them changes the chunk
+// boundaries, and the chunks of the existing caches aren't reused anymore.
+const (
+	MinChunkSize = 512 * 1024
+	AvgChunkSize = 1024 * 1024
+	MaxChunkSize = 4 * 1024 * 1024
+)
+
+// gear is the table of the rolling hash finding the chunk boundaries. It's
+// generated with a fixed seed, so that the boundaries are the same for all
+// the versions of GitLab Runner.
+var gear = newGearTable(0x6769746c61622d72)
+
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64
+	state := seed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
+
+// Chunker splits a stream into content-defined chunks: the boundaries depend
+// on the content around them, so that inserting or removing data only
+// changes the chunks around the change.
+type Chunker struct {
+	r io.Reader
+
+	minSize int
+	maxSize int
+	mask    uint64
+
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+// NewChunker returns a Chunker splitting r in chunks of MinChunkSize to
+// MaxChunkSize bytes, AvgChunkSize bytes on average.
+func NewChunker(r io.Reader) *Chunker {
+	return newChunker(r, MinChunkSize, AvgChunkSize, MaxChunkSize)
+}
+
+// newChunker returns a Chunker with custom sizes, avgSize must be a power of 2
+func newChunker(r io.Reader, minSize int, avgSize int, maxSize int) *Chunker {
+	return &Chunker{
+		r:       r,
+		minSize: minSize,
+		maxSize: maxSize,
+		mask:    uint64(avgSize - 1),
+		buf:     make([]byte, maxSize),
+	}
+}
+
+// Next returns the next chunk of the stream, or io.EOF when the whole stream
+// was returned. The chunk is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+// fill reads the stream until the buffer holds a full chunk, or the end of
+// the stream is reached
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.maxSize {
+		return nil
+	}
+
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cut returns the size of the chunk starting data
+func (c *Chunker) cut(data []byte) int {
+	if len(data) <= c.minSize {
+		return len(data)
+	}
+
+	if len(data) > c.maxSize {
+		data = data[:c.maxSize]
+	}
+
+	var hash uint64
+	for i := c.minSize; i < len(data); i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+
+	return len(data)
+}
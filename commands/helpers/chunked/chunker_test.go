@@ -0,0 +1,93 @@
+//go:build !integration
+// +build !integration
+
+package chunked
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	var chunks [][]byte
+
+	chunker := newChunker(bytes.NewReader(data), 1024, 4096, 16384)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+
+	return chunks
+}
+
+func TestChunker(t *testing.T) {
+	tests := map[string]struct {
+		data []byte
+	}{
+		"empty": {
+			data: []byte{},
+		},
+		"smaller than the minimum size": {
+			data: randomData(1, 100),
+		},
+		"random": {
+			data: randomData(1, 1024*1024),
+		},
+		"zeros": {
+			data: make([]byte, 100*1024),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			result := chunks(t, tt.data)
+
+			assert.Equal(t, tt.data, bytes.Join(result, []byte{}))
+			for i, chunk := range result {
+				assert.LessOrEqual(t, len(chunk), 16384)
+				if i < len(result)-1 {
+					assert.Greater(t, len(chunk), 1024)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerBoundariesDependOnContent(t *testing.T) {
+	data := randomData(1, 1024*1024)
+	changed := append(append(append([]byte(nil), data[:1000]...), []byte("inserted")...), data[1000:]...)
+
+	original := make(map[string]bool)
+	for _, chunk := range chunks(t, data) {
+		original[string(chunk)] = true
+	}
+
+	result := chunks(t, changed)
+
+	var reused int
+	for _, chunk := range result {
+		if original[string(chunk)] {
+			reused++
+		}
+	}
+
+	// only the chunks around the insertion change
+	assert.GreaterOrEqual(t, reused, len(result)-2)
+}
@@ -0,0 +1,237 @@
+package chunked
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// Download downloads the chunks of the manifest that local doesn't have from
+// remote. When remote is nil, all the chunks must be in local.
+func Download(ctx context.Context, m *Manifest, local *LocalStore, remote Store) (TransferStats, error) {
+	var missing []string
+	for _, hash := range uniqueHashes(m) {
+		exists, err := local.Exists(ctx, hash)
+		if err != nil {
+			return TransferStats{}, err
+		}
+
+		if !exists {
+			missing = append(missing, hash)
+		}
+	}
+
+	stats := TransferStats{Chunks: len(m.Hashes())}
+	if len(missing) == 0 {
+		return stats, nil
+	}
+
+	if remote == nil {
+		return stats, fmt.Errorf("%d chunks missing and no chunks URL provided", len(missing))
+	}
+
+	var lock sync.Mutex
+	err := forEach(ctx, missing, func(ctx context.Context, hash string) error {
+		r, err := remote.Get(ctx, hash)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", hash, err)
+		}
+		defer func() { _ = r.Close() }()
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return &TransientError{Err: err}
+		}
+
+		if err := local.Put(ctx, hash, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+
+		lock.Lock()
+		stats.TransferredChunks++
+		stats.TransferredBytes += int64(len(data))
+		lock.Unlock()
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// Extract extracts the files of the manifest to dir, from the chunks stored
+// in local
+func Extract(ctx context.Context, m *Manifest, dir string, local *LocalStore) error {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	stream := &streamReader{ctx: ctx, chunks: m.Chunks, local: local, decoder: decoder}
+
+	// symbolic links are created last, so that no file is written through
+	// a link of the cache
+	var links []File
+
+	for _, file := range m.Files {
+		path, err := safePath(dir, file.Path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case file.Mode&os.ModeSymlink != 0:
+			links = append(links, file)
+		case file.Mode.IsDir():
+			err = os.MkdirAll(path, 0777)
+		case file.Mode.IsRegular():
+			err = extractFile(path, file, stream)
+		}
+
+		if stream.err != nil {
+			return stream.err
+		}
+
+		if err != nil {
+			logrus.Warningf("%s: %s", file.Path, err)
+		}
+	}
+
+	for _, file := range links {
+		path, _ := safePath(dir, file.Path)
+
+		_ = os.Remove(path)
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err == nil {
+			err = os.Symlink(file.Link, path)
+		}
+		if err != nil {
+			logrus.Warningf("%s: %s", file.Path, err)
+		}
+	}
+
+	// the modes and the times are set last, as creating the files changes the
+	// times of their directories
+	for i := len(m.Files) - 1; i >= 0; i-- {
+		file := m.Files[i]
+		if file.Mode&os.ModeSymlink != 0 {
+			continue
+		}
+
+		path, _ := safePath(dir, file.Path)
+		if err := os.Chmod(path, file.Mode.Perm()); err != nil {
+			logrus.Warningf("%s: %s", file.Path, err)
+		}
+
+		if err := os.Chtimes(path, file.ModTime, file.ModTime); err != nil {
+			logrus.Warningf("%s: %s", file.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// safePath returns the path of a file of the manifest in dir, or an error
+// when it's outside of dir
+func safePath(dir string, name string) (string, error) {
+	path := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: path outside of the working directory", name)
+	}
+
+	return filepath.Join(dir, path), nil
+}
+
+func extractFile(path string, file File, stream io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		_, _ = io.CopyN(ioutil.Discard, stream, file.Size)
+		return err
+	}
+
+	// Remove file before creating a new one, otherwise we can error that file does exist
+	_ = os.Remove(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode.Perm()|0200)
+	if err != nil {
+		_, _ = io.CopyN(ioutil.Discard, stream, file.Size)
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := io.CopyN(f, stream, file.Size); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// streamReader reads the stream made of the chunks of a manifest, checking
+// that the chunks have the expected content
+type streamReader struct {
+	ctx     context.Context
+	chunks  []Chunk
+	local   *LocalStore
+	decoder *zstd.Decoder
+
+	current []byte
+	err     error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		r.current, r.err = r.readChunk(r.chunks[0])
+		r.chunks = r.chunks[1:]
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+
+	return n, nil
+}
+
+func (r *streamReader) readChunk(chunk Chunk) ([]byte, error) {
+	f, err := r.local.Get(r.ctx, chunk.Hash)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	compressed, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// corrupted chunks are removed, to be downloaded again by the next
+	// extraction
+	data, err := r.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		_ = r.local.Remove(chunk.Hash)
+		return nil, fmt.Errorf("chunk %s: %w", chunk.Hash, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != chunk.Hash || int64(len(data)) != chunk.Size {
+		_ = r.local.Remove(chunk.Hash)
+		return nil, fmt.Errorf("chunk %s: content doesn't match its hash", chunk.Hash)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,168 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestFormat identifies the manifests of the chunked cache format
+	ManifestFormat = "gitlab-runner-chunked-cache"
+
+	manifestVersion = 1
+)
+
+// ErrNotManifest is returned when reading a file that isn't a manifest, for
+// example a cache in the zip format
+var ErrNotManifest = errors.New("not a chunked cache manifest")
+
+// gzipMagic starts the manifests, which are gzip-compressed, while the zip
+// archives start with PK
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Manifest describes a cache: its files, and the chunks of the stream made of
+// the content of its regular files, in the order of the files
+type Manifest struct {
+	Format  string  `json:"format"`
+	Version int     `json:"version"`
+	Files   []File  `json:"files"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// File is a file of the cache, relative to the working directory
+type File struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	// Size is the size of the content of a regular file in the stream
+	Size int64 `json:"size,omitempty"`
+	// Link is the target of a symbolic link
+	Link string `json:"link,omitempty"`
+}
+
+// Chunk is a chunk of the stream, stored by the SHA-256 of its content
+type Chunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+func newManifest() *Manifest {
+	return &Manifest{
+		Format:  ManifestFormat,
+		Version: manifestVersion,
+	}
+}
+
+// Hashes returns the set of the hashes of the chunks of the manifest
+func (m *Manifest) Hashes() map[string]bool {
+	hashes := make(map[string]bool, len(m.Chunks))
+	for _, chunk := range m.Chunks {
+		hashes[chunk.Hash] = true
+	}
+
+	return hashes
+}
+
+// Size returns the size of the stream of the manifest
+func (m *Manifest) Size() int64 {
+	var size int64
+	for _, chunk := range m.Chunks {
+		size += chunk.Size
+	}
+
+	return size
+}
+
+// Write writes the manifest to w
+func (m *Manifest) Write(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+
+	if err := json.NewEncoder(gw).Encode(m); err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	return gw.Close()
+}
+
+// ReadManifest reads a manifest from r. It returns ErrNotManifest when r
+// isn't a manifest.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return nil, ErrNotManifest
+	}
+
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, ErrNotManifest
+	}
+	defer func() { _ = gr.Close() }()
+
+	var m Manifest
+	if err := json.NewDecoder(gr).Decode(&m); err != nil || m.Format != ManifestFormat {
+		return nil, ErrNotManifest
+	}
+
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported chunked cache manifest version %d", m.Version)
+	}
+
+	for _, chunk := range m.Chunks {
+		if !isHash(chunk.Hash) {
+			return nil, fmt.Errorf("invalid chunk hash %q", chunk.Hash)
+		}
+	}
+
+	return &m, nil
+}
+
+// isHash returns whether s is a hex-encoded SHA-256, it's used as a file
+// name and in URLs
+func isHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// ReadManifestFile reads the manifest stored in file
+func ReadManifestFile(file string) (*Manifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return ReadManifest(f)
+}
+
+// IsManifestFile returns whether file is a manifest, rather than a cache in
+// another format
+func IsManifestFile(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+
+	return bytes.Equal(magic, gzipMagic), nil
+}
@@ -0,0 +1,90 @@
+//go:build !integration
+// +build !integration
+
+package chunked
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := newManifest()
+	m.Files = []File{
+		{Path: "dir", Mode: os.ModeDir | 0755, ModTime: time.Unix(1600000000, 0).UTC()},
+		{Path: "dir/file", Mode: 0644, ModTime: time.Unix(1600000000, 0).UTC(), Size: 10},
+	}
+	m.Chunks = []Chunk{
+		{Hash: strings.Repeat("a", 64), Size: 4},
+		{Hash: strings.Repeat("b", 64), Size: 6},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, m.Write(buf))
+
+	result, err := ReadManifest(buf)
+	require.NoError(t, err)
+	assert.Equal(t, m, result)
+	assert.Equal(t, int64(10), result.Size())
+	assert.Len(t, result.Hashes(), 2)
+}
+
+func TestReadManifestErrors(t *testing.T) {
+	write := func(m *Manifest) []byte {
+		buf := new(bytes.Buffer)
+		require.NoError(t, m.Write(buf))
+		return buf.Bytes()
+	}
+
+	zipArchive := new(bytes.Buffer)
+	require.NoError(t, zip.NewWriter(zipArchive).Close())
+
+	unsupportedVersion := newManifest()
+	unsupportedVersion.Version = 2
+
+	invalidHash := newManifest()
+	invalidHash.Chunks = []Chunk{{Hash: "../../etc/passwd", Size: 1}}
+
+	otherFormat := newManifest()
+	otherFormat.Format = "other"
+
+	tests := map[string]struct {
+		data          []byte
+		expectedError string
+	}{
+		"empty": {
+			data:          nil,
+			expectedError: ErrNotManifest.Error(),
+		},
+		"zip archive": {
+			data:          zipArchive.Bytes(),
+			expectedError: ErrNotManifest.Error(),
+		},
+		"other format": {
+			data:          write(otherFormat),
+			expectedError: ErrNotManifest.Error(),
+		},
+		"unsupported version": {
+			data:          write(unsupportedVersion),
+			expectedError: "unsupported chunked cache manifest version 2",
+		},
+		"invalid hash": {
+			data:          write(invalidHash),
+			expectedError: `invalid chunk hash "../../etc/passwd"`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := ReadManifest(bytes.NewReader(tt.data))
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
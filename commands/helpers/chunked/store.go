@@ -0,0 +1,305 @@
+package chunked
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ErrChunkNotFound is returned when a chunk isn't in a store
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// TransientError is returned when a request to a remote store failed in a way
+// that can be retried, for example with a 5xx status
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Store stores the chunks by their hash. The chunks are stored compressed.
+type Store interface {
+	Exists(ctx context.Context, hash string) (bool, error)
+	Get(ctx context.Context, hash string) (io.ReadCloser, error)
+	Put(ctx context.Context, hash string, r io.Reader, size int64) error
+}
+
+// OpenStore returns the remote store of a chunks URL: an HTTP(S) URL
+// pre-signed for all the objects under its path, or a Go Cloud bucket URL.
+// The returned function closes the store.
+func OpenStore(ctx context.Context, u *url.URL, client *http.Client, mux *blob.URLMux) (Store, func() error, error) {
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return NewHTTPStore(u, client), func() error { return nil }, nil
+	}
+
+	bucket, err := mux.OpenBucketURL(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewBucketStore(bucket), bucket.Close, nil
+}
+
+// LocalStore stores the chunks in a directory, it keeps the chunks of the
+// last cache archived or extracted, so that only the other chunks are
+// transferred
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+func (s *LocalStore) Exists(_ context.Context, hash string) (bool, error) {
+	_, err := os.Stat(s.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *LocalStore) Get(_ context.Context, hash string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrChunkNotFound
+	}
+
+	return f, err
+}
+
+// Size returns the size of a stored chunk
+func (s *LocalStore) Size(hash string) (int64, error) {
+	fi, err := os.Stat(s.path(hash))
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func (s *LocalStore) Put(_ context.Context, hash string, r io.Reader, _ int64) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, "chunk_")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(hash))
+}
+
+// Remove removes a chunk
+func (s *LocalStore) Remove(hash string) error {
+	err := os.Remove(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Prune removes the chunks that aren't in keep
+func (s *LocalStore) Prune(keep map[string]bool) error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HTTPStore stores the chunks under an HTTP(S) URL, pre-signed for all the
+// objects under its path. The hash of a chunk is appended to the path.
+type HTTPStore struct {
+	url    *url.URL
+	client *http.Client
+}
+
+func NewHTTPStore(u *url.URL, client *http.Client) *HTTPStore {
+	return &HTTPStore{url: u, client: client}
+}
+
+func (s *HTTPStore) chunkURL(hash string) string {
+	u := *s.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + hash
+	u.RawPath = ""
+
+	return u.String()
+}
+
+func (s *HTTPStore) do(
+	ctx context.Context,
+	method string,
+	hash string,
+	body io.Reader,
+	size int64,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.chunkURL(hash), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, &TransientError{Err: err}
+	}
+
+	return res, nil
+}
+
+func (s *HTTPStore) Exists(ctx context.Context, hash string) (bool, error) {
+	res, err := s.do(ctx, http.MethodHead, hash, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err := statusError(res); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *HTTPStore) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, hash, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return nil, ErrChunkNotFound
+	}
+
+	if err := statusError(res); err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+func (s *HTTPStore) Put(ctx context.Context, hash string, r io.Reader, size int64) error {
+	res, err := s.do(ctx, http.MethodPut, hash, r, size)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	return statusError(res)
+}
+
+func statusError(res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+
+	err := fmt.Errorf("received: %s", res.Status)
+	if res.StatusCode/100 == 5 {
+		return &TransientError{Err: err}
+	}
+
+	return err
+}
+
+// BucketStore stores the chunks in a Go Cloud bucket, the hash of a chunk is
+// the key of its blob. Use the prefix parameter of the bucket URL to store
+// them under a prefix.
+type BucketStore struct {
+	bucket *blob.Bucket
+}
+
+func NewBucketStore(bucket *blob.Bucket) *BucketStore {
+	return &BucketStore{bucket: bucket}
+}
+
+func (s *BucketStore) Exists(ctx context.Context, hash string) (bool, error) {
+	return s.bucket.Exists(ctx, hash)
+}
+
+func (s *BucketStore) Get(ctx context.Context, hash string) (io.ReadCloser, error) {
+	r, err := s.bucket.NewReader(ctx, hash, nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, ErrChunkNotFound
+	}
+
+	return r, err
+}
+
+func (s *BucketStore) Put(ctx context.Context, hash string, r io.Reader, _ int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := s.bucket.NewWriter(ctx, hash, &blob.WriterOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		// canceling the context before closing the writer aborts the write
+		cancel()
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	clihelpers "gitlab.com/gitlab-org/golang-cli-helpers"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/kubernetes"
+)
+
+//nolint:lll
+type KubernetesCleanupCommand struct {
+	configOptions
+
+	DryRun      bool          `long:"dry-run" description:"List the leaked resources without deleting them"`
+	Namespaces  []string      `long:"namespace" description:"Namespace to sweep in addition to the one of each runner, e.g. one selected by jobs with namespace_overwrite_allowed"`
+	GracePeriod time.Duration `long:"grace-period" description:"Minimum age of a resource before it's deleted. Defaults to the resource_cleanup grace_period of each runner"`
+	Timeout     time.Duration `long:"timeout" description:"Timeout of the cleanup of a single runner"`
+}
+
+func (c *KubernetesCleanupCommand) Execute(*cli.Context) {
+	err := c.loadConfig()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	for _, runner := range c.config.Runners {
+		if runner.Executor != "kubernetes" || runner.Kubernetes == nil {
+			continue
+		}
+
+		c.cleanupRunner(runner)
+	}
+}
+
+func (c *KubernetesCleanupCommand) cleanupRunner(runner *common.RunnerConfig) {
+	gracePeriod := c.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = runner.Kubernetes.ResourceCleanup.GetGracePeriod()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	logger := runner.Log().WithField("dry-run", c.DryRun)
+	logger.WithField("grace-period", gracePeriod).Infoln("Looking for leaked resources")
+
+	leaked, err := kubernetes.CleanupLeakedResources(ctx, runner, c.Namespaces, gracePeriod, c.DryRun)
+	if err != nil {
+		logger.WithError(err).Errorln("Cleaning up leaked resources")
+		return
+	}
+
+	logger.WithField("resources", len(leaked)).Infoln("Leaked resources cleanup finished")
+}
+
+func init() {
+	cmd := &KubernetesCleanupCommand{
+		Timeout: 5 * time.Minute,
+	}
+
+	common.RegisterCommand(cli.Command{
+		Name:  "kubernetes",
+		Usage: "manage resources created by the Kubernetes executor",
+		Subcommands: []cli.Command{
+			{
+				Name:   "cleanup",
+				Usage:  "delete pods, secrets, config maps and services leaked by runners",
+				Action: cmd.Execute,
+				Flags:  clihelpers.GetFlagsFromStruct(cmd),
+			},
+		},
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +20,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"gitlab.com/gitlab-org/gitlab-runner/cache"
+	cache_server "gitlab.com/gitlab-org/gitlab-runner/cache/server"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/certificate"
@@ -64,7 +67,15 @@ type RunCommand struct {
 	failuresCollector               *prometheus_helper.FailuresCollector
 	networkRequestStatusesCollector prometheus.Collector
 
-	sessionServer *session.Server
+	sessionServer        *session.Server
+	sessionServerMetrics *session.AuthMetrics
+
+	traceSpool *network.TraceSpool
+
+	cacheServer *cache_server.Server
+	// cacheServerSetUp is set once the cache server is set up, or not
+	// configured, the configuration is loaded before
+	cacheServerSetUp bool
 
 	// abortBuilds is used to abort running builds
 	abortBuilds chan os.Signal
@@ -86,6 +97,14 @@ type RunCommand struct {
 	// runFinished is used to notify that run() did finish
 	runFinished chan bool
 
+	// longPollsCtx is canceled when the configuration is reloaded or the
+	// runner stops, to cancel the job requests held by GitLab, which use the
+	// previous configuration
+	longPollsCtx     context.Context
+	cancelLongPolls  context.CancelFunc
+	longPollsStopped bool
+	longPollsLock    sync.Mutex
+
 	currentWorkers int
 }
 
@@ -144,6 +163,9 @@ func (mr *RunCommand) loadConfig() error {
 	}
 
 	mr.healthy = nil
+	mr.resetLongPolls()
+	mr.updateExecutorProviders(mr.config.Runners)
+	mr.updateCacheServer()
 	mr.log().Println("Configuration loaded")
 	mr.log().Debugln(helpers.ToYAML(mr.config))
 
@@ -161,6 +183,21 @@ func (mr *RunCommand) loadConfig() error {
 	return nil
 }
 
+// runnersUpdater is implemented by the executor providers keeping processes for each runner,
+// e.g. the drivers of the custom executor, which are stopped when their runner is removed
+// or changed. No runners are passed when the runner exits.
+type runnersUpdater interface {
+	UpdateRunners(runners []*common.RunnerConfig)
+}
+
+func (mr *RunCommand) updateExecutorProviders(runners []*common.RunnerConfig) {
+	for _, provider := range common.GetExecutorProviders() {
+		if updater, ok := provider.(runnersUpdater); ok {
+			updater.UpdateRunners(runners)
+		}
+	}
+}
+
 func (mr *RunCommand) updateLoggingConfiguration() error {
 	reloadNeeded := false
 
@@ -197,6 +234,11 @@ func (mr *RunCommand) updateLoggingConfiguration() error {
 // channel, which is the signal that the command was properly terminated (this is the only
 // valid, properly terminated exit flow for `gitlab-runner run`).
 func (mr *RunCommand) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr.setupTraceSpool(ctx)
+	mr.setupCacheServer()
 	mr.setupMetricsAndDebugServer()
 	mr.setupSessionServer()
 
@@ -226,12 +268,18 @@ func (mr *RunCommand) run() {
 		}
 	}
 
+	// Don't wait for the job requests held by GitLab
+	mr.stopLongPolls()
+
 	// Wait for workers to shutdown
 	for mr.currentWorkers > 0 {
 		stopWorker <- true
 		mr.currentWorkers--
 	}
 
+	// Stop the processes kept by the executor providers for the runners
+	mr.updateExecutorProviders(nil)
+
 	mr.log().Info("All workers stopped. Can exit now")
 
 	close(mr.runFinished)
@@ -285,6 +333,28 @@ func (mr *RunCommand) serveMetrics(mux *http.ServeMux) {
 	registry.MustRegister(mr.networkRequestStatusesCollector)
 	// Metrics about jobs failures
 	registry.MustRegister(mr.failuresCollector)
+	// Metrics about requests rejected by the session server
+	registry.MustRegister(mr.sessionServerMetrics)
+	// Metrics about the job updates GitLab didn't receive yet
+	if mr.traceSpool != nil {
+		registry.MustRegister(mr.traceSpool)
+	}
+	// Metrics about the circuit breakers of the GitLab instances
+	if breakers := mr.circuitBreakers(); breakers != nil {
+		registry.MustRegister(breakers)
+	}
+	// Metrics about the latency and the connections of API requests
+	if reporter, ok := mr.network.(apiMetricsReporter); ok {
+		registry.MustRegister(reporter.APIMetrics())
+	}
+	// Metrics about the job requests rate and the job pickup latency
+	if polling := mr.longPolling(); polling != nil {
+		registry.MustRegister(polling)
+	}
+	// Metrics about the objects stored by the cache server
+	if mr.cacheServer != nil {
+		registry.MustRegister(mr.cacheServer)
+	}
 	// Metrics about catched errors
 	registry.MustRegister(&mr.prometheusLogHook)
 	// Metrics about the program's build version.
@@ -315,7 +385,105 @@ func (mr *RunCommand) serveMetrics(mux *http.ServeMux) {
 	)
 }
 
+// setupCacheServer starts the cache server, when it's configured, so that the
+// jobs store their cache on the runner host with the runner cache adapter, or
+// through it with the adapters keeping their credentials in the runner. It has
+// its own listener, the jobs must not reach the metrics and debug endpoints.
+func (mr *RunCommand) setupCacheServer() {
+	defer func() {
+		mr.cacheServerSetUp = true
+		mr.updateCacheServer()
+	}()
+
+	config := mr.config.CacheServer
+	if config.Directory == "" {
+		return
+	}
+
+	if config.ListenAddress == "" {
+		mr.log().Error("[cache_server].listen_address not defined, cache server disabled")
+		return
+	}
+
+	cacheServer, err := cache_server.NewServer(config, mr.log())
+	if err != nil {
+		mr.log().WithError(err).Error("Failed to create cache server")
+		return
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		mr.log().WithError(err).Fatal("Failed to create listener for cache server")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cache_server.PathPrefix, cacheServer)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			mr.log().WithError(err).Fatal("Cache server terminated")
+		}
+	}()
+
+	mr.cacheServer = cacheServer
+
+	mr.log().
+		WithField("address", listener.Addr().String()).
+		WithField("directory", config.Directory).
+		Info("Cache server listening")
+}
+
+// updateCacheServer sets the storages the cache server proxies the cache of
+// the jobs to, for the cache adapters keeping their credentials in the runner.
+// It warns about the runners whose cache adapter needs the cache server when
+// it's not running, their jobs would fail to reach it.
+func (mr *RunCommand) updateCacheServer() {
+	if !mr.cacheServerSetUp {
+		return
+	}
+
+	buckets := make(map[string]cache.BucketOpener)
+	for _, runner := range mr.config.Runners {
+		// only the adapters using the cache server have its address
+		if runner.Cache == nil || runner.Cache.Runner == nil {
+			continue
+		}
+
+		runnerLog := mr.log().WithFields(logrus.Fields{
+			"runner":     runner.ShortDescription(),
+			"cache_type": runner.Cache.Type,
+		})
+
+		adapter, err := cache.CreateAdapter(runner.Cache, 0, "")
+		if err != nil {
+			runnerLog.WithError(err).Warningln("Failed to create the cache adapter of the runner")
+			continue
+		}
+
+		proxy, ok := adapter.(cache.ProxyAdapter)
+		if !ok && runner.Cache.Type != "runner" {
+			continue
+		}
+
+		if mr.cacheServer == nil {
+			runnerLog.Warningln("The cache of the runner uses the cache server, which isn't running")
+			continue
+		}
+
+		if ok {
+			name, open := proxy.ProxyBucket()
+			buckets[name] = open
+		}
+	}
+
+	if mr.cacheServer != nil {
+		mr.cacheServer.SetBuckets(buckets)
+	}
+}
+
 func (mr *RunCommand) serveDebugData(mux *http.ServeMux) {
+	mr.buildsHelper.circuitBreakers = mr.circuitBreakers()
 	mux.HandleFunc("/debug/jobs/list", mr.buildsHelper.ListJobsHandler)
 }
 
@@ -345,6 +513,59 @@ func restrictHTTPMethods(handler http.Handler, methods ...string) http.Handler {
 	})
 }
 
+// apiMetricsReporter is implemented by the network clients that measure the
+// requests they send
+type apiMetricsReporter interface {
+	APIMetrics() *network.APIMetrics
+}
+
+// circuitBreakerReporter is implemented by the network clients that stop
+// requesting jobs from the GitLab instances that are failing
+type circuitBreakerReporter interface {
+	CircuitBreakers() *network.CircuitBreakers
+}
+
+func (mr *RunCommand) circuitBreakers() *network.CircuitBreakers {
+	reporter, ok := mr.network.(circuitBreakerReporter)
+	if !ok {
+		return nil
+	}
+
+	return reporter.CircuitBreakers()
+}
+
+// traceSpooler is implemented by the network clients that can keep the job
+// traces in a spool
+type traceSpooler interface {
+	SetTraceSpool(spool *network.TraceSpool)
+}
+
+// setupTraceSpool keeps the job traces in the spool directory and replays the
+// updates GitLab didn't receive before the runner stopped, until ctx is done
+func (mr *RunCommand) setupTraceSpool(ctx context.Context) {
+	if mr.config.TraceSpoolDir == "" {
+		return
+	}
+
+	spooler, ok := mr.network.(traceSpooler)
+	if !ok {
+		return
+	}
+
+	spool, err := network.NewTraceSpool(mr.config.TraceSpoolDir)
+	if err != nil {
+		mr.log().WithError(err).Errorln("Failed to create the trace spool, job traces will be lost if the runner stops")
+		return
+	}
+
+	mr.traceSpool = spool
+	spooler.SetTraceSpool(spool)
+
+	mr.log().WithField("path", mr.config.TraceSpoolDir).Info("Job traces are spooled")
+
+	go spool.Replay(ctx, mr.network, mr.config.Runners)
+}
+
 func (mr *RunCommand) setupSessionServer() {
 	if mr.config.SessionServer.ListenAddress == "" {
 		mr.log().Info("[session_server].listen_address not defined, session endpoints disabled")
@@ -357,6 +578,12 @@ func (mr *RunCommand) setupSessionServer() {
 			AdvertiseAddress: mr.config.SessionServer.AdvertiseAddress,
 			ListenAddress:    mr.config.SessionServer.ListenAddress,
 			ShutdownTimeout:  common.ShutdownTimeout * time.Second,
+
+			ClientCAFile:          mr.config.SessionServer.ClientCAFile,
+			AllowedNetworks:       mr.config.SessionServer.AllowedNetworks,
+			MaxFailedAuthAttempts: mr.config.SessionServer.MaxFailedAuthAttempts,
+			FailedAuthWindow:      mr.config.SessionServer.GetFailedAuthWindow(),
+			Metrics:               mr.sessionServerMetrics,
 		},
 		mr.log(),
 		certificate.X509Generator{},
@@ -502,12 +729,29 @@ func (mr *RunCommand) processRunner(
 	}
 
 	// Receive a new build
-	trace, jobData, err := mr.requestJob(runner, sessionInfo)
+	trace, jobData, jobRunner, err := mr.requestJob(runner, sessionInfo, runners)
 	if err != nil || jobData == nil {
 		return
 	}
 	defer func() { mr.traceOutcome(trace, err) }()
 
+	// The job was received while the configuration was reloaded, it runs
+	// with the loaded configuration of the runner
+	if jobRunner != runner {
+		runner = jobRunner
+
+		provider = common.GetExecutorProvider(runner.Executor)
+		if provider == nil {
+			return fmt.Errorf("executor %q not found", runner.Executor)
+		}
+
+		executorData, err = provider.Acquire(runner)
+		if err != nil {
+			return fmt.Errorf("failed to update executor: %w", err)
+		}
+		defer provider.Release(runner, executorData)
+	}
+
 	// Create a new build
 	build, err := common.NewBuild(*jobData, runner, mr.abortBuilds, executorData)
 	if err != nil {
@@ -555,6 +799,7 @@ func (mr *RunCommand) createSession(provider common.ExecutorProvider) (*session.
 	if err != nil {
 		return nil, nil, err
 	}
+	sess.MaxViewers = mr.config.SessionServer.MaxViewers
 
 	sessionInfo := &common.SessionInfo{
 		URL:           mr.sessionServer.AdvertiseAddress + sess.Endpoint,
@@ -565,24 +810,127 @@ func (mr *RunCommand) createSession(provider common.ExecutorProvider) (*session.
 	return sess, sessionInfo, err
 }
 
+// resetLongPolls cancels the job requests held by GitLab when the
+// configuration is reloaded, the next requests use the loaded configuration
+func (mr *RunCommand) resetLongPolls() {
+	mr.longPollsLock.Lock()
+	defer mr.longPollsLock.Unlock()
+
+	if mr.cancelLongPolls != nil {
+		mr.cancelLongPolls()
+	}
+
+	mr.longPollsCtx, mr.cancelLongPolls = nil, nil
+}
+
+// stopLongPolls cancels the job requests held by GitLab when the runner is
+// stopping, the next requests don't use long polling
+func (mr *RunCommand) stopLongPolls() {
+	mr.longPollsLock.Lock()
+	defer mr.longPollsLock.Unlock()
+
+	if mr.cancelLongPolls != nil {
+		mr.cancelLongPolls()
+	}
+
+	mr.longPollsStopped = true
+}
+
+// longPollsStopping returns whether the runner is stopping
+func (mr *RunCommand) longPollsStopping() bool {
+	mr.longPollsLock.Lock()
+	defer mr.longPollsLock.Unlock()
+
+	return mr.longPollsStopped
+}
+
+// longPollsContext returns the context of the job requests held by GitLab,
+// canceled when the configuration is reloaded or the runner stops
+func (mr *RunCommand) longPollsContext() context.Context {
+	mr.longPollsLock.Lock()
+	defer mr.longPollsLock.Unlock()
+
+	if mr.longPollsCtx == nil {
+		mr.longPollsCtx, mr.cancelLongPolls = context.WithCancel(context.Background())
+	}
+
+	return mr.longPollsCtx
+}
+
+// longPoller is implemented by the network clients that can negotiate long
+// polling of the job requests with GitLab
+type longPoller interface {
+	LongPolling() *network.LongPolling
+}
+
+func (mr *RunCommand) longPolling() *network.LongPolling {
+	poller, ok := mr.network.(longPoller)
+	if !ok {
+		return nil
+	}
+
+	return poller.LongPolling()
+}
+
 // requestJob will check if the runner can send another concurrent request to
 // GitLab, if not the return value is nil.
+//
+// When long polling is enabled, a single request of the runner is held by
+// GitLab until a job is available. When it ends without a job, the runner is
+// requeued to hold the next one, so that a job request is always waiting.
+// The other requests of the runner don't use long polling.
+//
+// The held request is canceled when the configuration is reloaded. GitLab
+// Workhorse doesn't assign jobs to the requests it holds, but GitLab may have
+// assigned one when the request was canceled. That job is returned with the
+// loaded configuration of the runner, and is failed when the runner was
+// removed from it.
 func (mr *RunCommand) requestJob(
 	runner *common.RunnerConfig,
 	sessionInfo *common.SessionInfo,
-) (common.JobTrace, *common.JobResponse, error) {
+	runners chan *common.RunnerConfig,
+) (common.JobTrace, *common.JobResponse, *common.RunnerConfig, error) {
 	if !mr.buildsHelper.acquireRequest(runner) {
 		mr.log().WithField("runner", runner.ShortDescription()).
 			Debugln("Failed to request job: runner requestConcurrency meet")
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 	defer mr.buildsHelper.releaseRequest(runner)
 
-	jobData, healthy := mr.doJobRequest(context.Background(), runner, sessionInfo)
+	ctx := context.Background()
+	requestRunner := runner
+	polling := mr.longPolling()
+	longPolling := polling != nil && polling.Enabled(*runner) && !mr.longPollsStopping() &&
+		mr.buildsHelper.acquireLongPoll(runner)
+	if longPolling {
+		ctx = mr.longPollsContext()
+	} else if runner.LongPollTimeout > 0 {
+		shortPollRunner := *runner
+		shortPollRunner.LongPollTimeout = 0
+		requestRunner = &shortPollRunner
+	}
+
+	jobData, healthy := mr.doJobRequest(ctx, requestRunner, sessionInfo)
 	mr.makeHealthy(runner.UniqueID(), healthy)
 
+	jobRunner := runner
+	if longPolling {
+		mr.buildsHelper.releaseLongPoll(runner)
+
+		// the request is canceled when the configuration is reloaded
+		reloaded := ctx.Err() != nil && !mr.longPollsStopping()
+		current := mr.currentRunner(runner)
+		if jobData == nil && current != nil && (reloaded || ctx.Err() == nil && polling.Held(*current)) {
+			mr.requeueRunner(current, runners)
+		}
+
+		if jobData != nil && reloaded {
+			jobRunner = current
+		}
+	}
+
 	if jobData == nil {
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 
 	// Make sure to always close output
@@ -591,21 +939,31 @@ func (mr *RunCommand) requestJob(
 		Token: jobData.Token,
 	}
 
-	trace, err := mr.network.ProcessJob(*runner, jobCredentials)
-	if err != nil {
-		jobInfo := common.UpdateJobInfo{
-			ID:            jobCredentials.ID,
-			State:         common.Failed,
-			FailureReason: common.RunnerSystemFailure,
-		}
+	jobInfo := common.UpdateJobInfo{
+		ID:            jobCredentials.ID,
+		State:         common.Failed,
+		FailureReason: common.RunnerSystemFailure,
+	}
+
+	if jobRunner == nil {
+		mr.log().WithFields(logrus.Fields{
+			"runner": runner.ShortDescription(),
+			"job":    jobData.ID,
+		}).Warningln("Failing the job received while the runner was removed from the configuration")
 
-		// send failure once
 		mr.network.UpdateJob(*runner, jobCredentials, jobInfo)
-		return nil, nil, err
+		return nil, nil, nil, nil
+	}
+
+	trace, err := mr.network.ProcessJob(*jobRunner, jobCredentials)
+	if err != nil {
+		// send failure once
+		mr.network.UpdateJob(*jobRunner, jobCredentials, jobInfo)
+		return nil, nil, nil, err
 	}
 
 	trace.SetFailuresCollector(mr.failuresCollector)
-	return trace, jobData, nil
+	return trace, jobData, jobRunner, nil
 }
 
 // doJobRequest will execute the request for a new job, respecting an interruption
@@ -648,6 +1006,23 @@ func (mr *RunCommand) requeueRunner(runner *common.RunnerConfig, runners chan *c
 	}
 }
 
+// currentRunner returns the configuration of the runner in the loaded
+// configuration, nil when the runner was removed from it
+func (mr *RunCommand) currentRunner(runner *common.RunnerConfig) *common.RunnerConfig {
+	config := mr.config
+	if config == nil {
+		return nil
+	}
+
+	for _, current := range config.Runners {
+		if current.UniqueID() == runner.UniqueID() {
+			return current
+		}
+	}
+
+	return nil
+}
+
 // updateWorkers, called periodically from run() is responsible for scaling the pool
 // of workers. By worker we don't understand a `[[runners]]` entry, but a "slot" that will
 // use one of the runners to request and handle a job.
@@ -959,6 +1334,7 @@ func init() {
 			networkRequestStatusesCollector: requestStatusesCollector,
 			prometheusLogHook:               prometheus_helper.NewLogHook(),
 			failuresCollector:               prometheus_helper.NewFailuresCollector(),
+			sessionServerMetrics:            session.NewAuthMetrics(),
 			buildsHelper:                    newBuildsHelper(),
 		},
 	)
@@ -5,7 +5,10 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
@@ -18,8 +21,11 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/runner"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/s3"
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/log/test"
+	"gitlab.com/gitlab-org/gitlab-runner/network"
 )
 
 func TestProcessRunner_BuildLimit(t *testing.T) {
@@ -212,3 +218,288 @@ func TestRunCommand_doJobRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestRunCommand_requestJobWithLongPolling(t *testing.T) {
+	reload := func(c *RunCommand) {
+		reloaded := *c.config.Runners[0]
+		c.config = &common.Config{Runners: []*common.RunnerConfig{&reloaded}}
+		c.resetLongPolls()
+	}
+
+	tests := map[string]struct {
+		supported        bool
+		cancel           func(c *RunCommand)
+		removed          bool
+		expectedRequeued bool
+	}{
+		"long polling supported": {
+			supported:        true,
+			expectedRequeued: true,
+		},
+		"long polling not supported": {
+			supported:        false,
+			expectedRequeued: false,
+		},
+		"runner removed while the request was held": {
+			supported:        true,
+			removed:          true,
+			expectedRequeued: false,
+		},
+		"runner stopping": {
+			supported:        true,
+			cancel:           (*RunCommand).stopLongPolls,
+			expectedRequeued: false,
+		},
+		"configuration reloaded while the request was held": {
+			supported:        true,
+			cancel:           reload,
+			expectedRequeued: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			// behaves like GitLab Workhorse, holding the requests sending the
+			// last update of the job queue when it supports long polling
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request common.JobRequest
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+				if request.LastUpdate == "" || !tt.supported {
+					w.Header().Set("X-GitLab-Last-Update", "1")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				if tt.cancel != nil {
+					<-r.Context().Done()
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer s.Close()
+
+			runner := &common.RunnerConfig{
+				LongPollTimeout: 30,
+				RunnerCredentials: common.RunnerCredentials{
+					URL:   s.URL,
+					Token: "token",
+				},
+			}
+
+			config := &common.Config{Runners: []*common.RunnerConfig{runner}}
+			if tt.removed {
+				config.Runners = nil
+			}
+
+			c := &RunCommand{
+				configOptionsWithListenAddress: configOptionsWithListenAddress{
+					configOptions: configOptions{config: config},
+				},
+				network:      network.NewGitLabClient(),
+				buildsHelper: newBuildsHelper(),
+			}
+
+			// the first request gets the last update of the job queue
+			_, _ = c.network.RequestJob(context.Background(), *runner, nil)
+
+			if tt.cancel != nil {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					tt.cancel(c)
+				}()
+			}
+
+			runners := make(chan *common.RunnerConfig, 1)
+			trace, jobData, jobRunner, err := c.requestJob(runner, nil, runners)
+			assert.NoError(t, err)
+			assert.Nil(t, trace)
+			assert.Nil(t, jobData)
+			assert.Nil(t, jobRunner)
+
+			assert.True(t, c.isHealthy(runner.UniqueID()))
+			assert.True(t, c.buildsHelper.acquireLongPoll(runner), "the long polling slot is released")
+
+			require.Equal(t, tt.expectedRequeued, len(runners) == 1)
+			if tt.expectedRequeued {
+				assert.Same(t, c.config.Runners[0], <-runners, "the runner is requeued with the loaded configuration")
+			}
+		})
+	}
+}
+
+// longPollingNetwork is a network client negotiating long polling
+type longPollingNetwork struct {
+	*common.MockNetwork
+
+	longPolling *network.LongPolling
+}
+
+func (n *longPollingNetwork) LongPolling() *network.LongPolling {
+	return n.longPolling
+}
+
+func TestRunCommand_requestJobReceivedWhileReloading(t *testing.T) {
+	runner := &common.RunnerConfig{
+		LongPollTimeout: 30,
+		RunnerCredentials: common.RunnerCredentials{
+			URL:   "https://gitlab.example.com",
+			Token: "token",
+		},
+		RunnerSettings: common.RunnerSettings{Executor: "old"},
+	}
+	reloaded := *runner
+	reloaded.Executor = "new"
+
+	tests := map[string]struct {
+		reloadedRunners   []*common.RunnerConfig
+		expectedJobRunner *common.RunnerConfig
+	}{
+		"runner kept": {
+			reloadedRunners:   []*common.RunnerConfig{&reloaded},
+			expectedJobRunner: &reloaded,
+		},
+		"runner removed": {
+			reloadedRunners: nil,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			jobData := &common.JobResponse{ID: 1, Token: "job-token"}
+			jobCredentials := &common.JobCredentials{ID: jobData.ID, Token: jobData.Token}
+
+			mNetwork := &common.MockNetwork{}
+			defer mNetwork.AssertExpectations(t)
+
+			c := &RunCommand{
+				configOptionsWithListenAddress: configOptionsWithListenAddress{
+					configOptions: configOptions{
+						config: &common.Config{Runners: []*common.RunnerConfig{runner}},
+					},
+				},
+				network:      &longPollingNetwork{MockNetwork: mNetwork, longPolling: network.NewLongPolling()},
+				buildsHelper: newBuildsHelper(),
+			}
+
+			// the configuration is reloaded when GitLab sends the job
+			mNetwork.On("RequestJob", mock.Anything, *runner, mock.Anything).
+				Run(func(mock.Arguments) {
+					c.config = &common.Config{Runners: tt.reloadedRunners}
+					c.resetLongPolls()
+				}).
+				Return(jobData, true).
+				Once()
+
+			mTrace := &common.MockJobTrace{}
+			defer mTrace.AssertExpectations(t)
+
+			if tt.expectedJobRunner != nil {
+				mTrace.On("SetFailuresCollector", mock.Anything).Once()
+				mNetwork.On("ProcessJob", *tt.expectedJobRunner, jobCredentials).Return(mTrace, nil).Once()
+			} else {
+				mNetwork.On("UpdateJob", *runner, jobCredentials, common.UpdateJobInfo{
+					ID:            jobData.ID,
+					State:         common.Failed,
+					FailureReason: common.RunnerSystemFailure,
+				}).Return(common.UpdateJobResult{State: common.UpdateSucceeded}).Once()
+			}
+
+			runners := make(chan *common.RunnerConfig, 1)
+			trace, receivedJob, jobRunner, err := c.requestJob(runner, nil, runners)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedJobRunner, jobRunner)
+			if tt.expectedJobRunner != nil {
+				assert.Equal(t, mTrace, trace)
+				assert.Equal(t, jobData, receivedJob)
+			} else {
+				assert.Nil(t, trace)
+				assert.Nil(t, receivedJob)
+			}
+		})
+	}
+}
+
+func TestRunCommand_setupCacheServer(t *testing.T) {
+	runnerCache := &common.CacheConfig{
+		Type:   "runner",
+		Runner: &common.CacheRunnerConfig{ServerAddress: "http://127.0.0.1:9252", Secret: "secret"},
+	}
+	s3Cache := &common.CacheConfig{
+		Type: "s3",
+		S3:   &common.CacheS3Config{ServerAddress: "s3.example.com", BucketName: "cache"},
+	}
+	s3ProxiedCache := &common.CacheConfig{
+		Type:   "s3",
+		S3:     &common.CacheS3Config{ServerAddress: "s3.example.com", BucketName: "cache"},
+		Runner: &common.CacheRunnerConfig{ServerAddress: "http://127.0.0.1:9252", Secret: "secret"},
+	}
+
+	tests := map[string]struct {
+		cacheServer     common.CacheServer
+		runnerCache     *common.CacheConfig
+		expectedRunning bool
+		expectedLogs    []string
+	}{
+		"not configured": {},
+		"runner adapter without cache server": {
+			runnerCache:  runnerCache,
+			expectedLogs: []string{"The cache of the runner uses the cache server, which isn't running"},
+		},
+		"other adapter without cache server": {
+			runnerCache: s3Cache,
+		},
+		"other adapter proxied without cache server": {
+			runnerCache:  s3ProxiedCache,
+			expectedLogs: []string{"The cache of the runner uses the cache server, which isn't running"},
+		},
+		"no listen address": {
+			cacheServer: common.CacheServer{Directory: t.TempDir(), Secret: "secret"},
+			runnerCache: runnerCache,
+			expectedLogs: []string{
+				"[cache_server].listen_address not defined, cache server disabled",
+				"The cache of the runner uses the cache server, which isn't running",
+			},
+		},
+		"own listener": {
+			cacheServer: common.CacheServer{
+				ListenAddress: "127.0.0.1:0",
+				Directory:     t.TempDir(),
+				Secret:        "secret",
+			},
+			runnerCache:     runnerCache,
+			expectedRunning: true,
+			expectedLogs:    []string{"Cache server listening"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			hook, cleanup := test.NewHook()
+			defer cleanup()
+
+			mr := &RunCommand{
+				configOptionsWithListenAddress: configOptionsWithListenAddress{
+					configOptions: configOptions{
+						config: &common.Config{
+							CacheServer: tt.cacheServer,
+							Runners: []*common.RunnerConfig{
+								{RunnerSettings: common.RunnerSettings{Cache: tt.runnerCache}},
+							},
+						},
+					},
+				},
+			}
+
+			mr.setupCacheServer()
+			assert.Equal(t, tt.expectedRunning, mr.cacheServer != nil)
+
+			var messages []string
+			for _, entry := range hook.AllEntries() {
+				messages = append(messages, entry.Message)
+			}
+			assert.Equal(t, tt.expectedLogs, messages)
+		})
+	}
+}
@@ -152,6 +152,8 @@ type Build struct {
 
 	Session *session.Session
 
+	sessionRecorder *session.Recorder
+
 	logger BuildLogger
 
 	allVariables     JobVariables
@@ -751,6 +753,27 @@ func (b *Build) waitForTerminal(ctx context.Context, timeout time.Duration) erro
 		),
 	)
 
+	return b.waitForSession(ctx, timeout)
+}
+
+// waitForDebugHold keeps a failed job, and its terminal, available until the
+// user exits the terminal or the hold times out
+func (b *Build) waitForDebugHold(ctx context.Context, hold time.Duration) error {
+	hold = b.getTerminalTimeout(ctx, hold)
+
+	b.logger.Warningln(
+		fmt.Sprintf(
+			"Job failed, holding it for %s for debugging (CI_DEBUG_HOLD_ON_FAILURE)...",
+			hold.Round(time.Second),
+		),
+	)
+	b.logger.Infoln("Open the terminal of the job to debug it:", b.JobURL()+"/terminal")
+	b.logger.Infoln("Exit the terminal to finish the job")
+
+	return b.waitForSession(ctx, hold)
+}
+
+func (b *Build) waitForSession(ctx context.Context, timeout time.Duration) error {
 	select {
 	case <-ctx.Done():
 		err := b.Session.Kill()
@@ -764,7 +787,12 @@ func (b *Build) waitForTerminal(ctx context.Context, timeout time.Duration) erro
 			timeout.Round(time.Second),
 		)
 		b.logger.Infoln(err.Error())
-		b.Session.TimeoutCh <- err
+		if b.Session.Connected() {
+			select {
+			case b.Session.TimeoutCh <- err:
+			case <-ctx.Done():
+			}
+		}
 		return err
 	case err := <-b.Session.DisconnectCh:
 		b.logger.Infoln("Terminal disconnected")
@@ -779,6 +807,35 @@ func (b *Build) waitForTerminal(ctx context.Context, timeout time.Duration) erro
 	}
 }
 
+func (b *Build) startSessionRecording(config *SessionServer) {
+	if b.Session == nil || !config.RecordingEnabled() {
+		return
+	}
+
+	name := fmt.Sprintf("runner-%s-job-%d.cast", b.Runner.ShortDescription(), b.ID)
+	b.sessionRecorder = session.NewRecorder(filepath.Join(config.RecordingsDir, name))
+	b.Session.SetRecorder(b.sessionRecorder)
+}
+
+// closeSession disconnects everyone from the terminal session of the job and
+// saves its recording
+func (b *Build) closeSession() {
+	if b.Session == nil {
+		return
+	}
+
+	err := b.Session.Close()
+	if err != nil {
+		b.Log().WithError(err).Warn("Failed to close session")
+	}
+
+	if b.sessionRecorder == nil || !b.sessionRecorder.Recorded() {
+		return
+	}
+
+	b.Log().WithField("path", b.sessionRecorder.Path()).Info("Terminal session recording saved")
+}
+
 // getTerminalTimeout checks if the the job timeout comes before the
 // configured terminal timeout.
 func (b *Build) getTerminalTimeout(ctx context.Context, timeout time.Duration) time.Duration {
@@ -919,15 +976,27 @@ func (b *Build) Run(globalConfig *Config, trace JobTrace) (err error) {
 		return fmt.Errorf("retrieving executor features: %w", err)
 	}
 
+	b.startSessionRecording(&globalConfig.SessionServer)
+
 	executor, err = b.executeBuildSection(executor, options, provider)
 
 	if err == nil {
 		err = b.run(ctx, executor)
-		if errWait := b.waitForTerminal(ctx, globalConfig.SessionServer.GetSessionTimeout()); errWait != nil {
+
+		var errWait error
+		if hold := b.getDebugHold(executor); hold > 0 {
+			errWait = b.waitForDebugHold(ctx, hold)
+		} else {
+			errWait = b.waitForTerminal(ctx, globalConfig.SessionServer.GetSessionTimeout())
+		}
+
+		if errWait != nil {
 			b.Log().WithError(errWait).Debug("Stopped waiting for terminal")
 		}
 	}
 
+	b.closeSession()
+
 	if executor != nil {
 		executor.Finish(err)
 	}
@@ -1143,6 +1212,13 @@ func (b *Build) refreshAllVariables() {
 	b.allVariables = nil
 }
 
+// AddVariables adds variables defined while preparing the job, like the addresses
+// of the services started by the executor, to the variables of the job
+func (b *Build) AddVariables(variables ...JobVariable) {
+	b.Variables = append(b.Variables, variables...)
+	b.refreshAllVariables()
+}
+
 func (b *Build) GetAllVariables() JobVariables {
 	if b.allVariables != nil {
 		return b.allVariables
@@ -1299,6 +1375,40 @@ func (b *Build) IsDebugTraceEnabled() bool {
 	return trace
 }
 
+// getDebugHold returns how long a job that failed is held for debugging in
+// its terminal, as requested with CI_DEBUG_HOLD_ON_FAILURE and limited by the
+// configuration of the runner
+func (b *Build) getDebugHold(executor Executor) time.Duration {
+	value := b.GetAllVariables().Get("CI_DEBUG_HOLD_ON_FAILURE")
+	if value == "" || b.CurrentState() != BuildRunRuntimeFailed {
+		return 0
+	}
+
+	hold, err := time.ParseDuration(value)
+	if err != nil || hold <= 0 {
+		b.logger.Warningln("Invalid CI_DEBUG_HOLD_ON_FAILURE duration:", value)
+		return 0
+	}
+
+	if b.Runner.MaxDebugHold <= 0 {
+		b.logger.Warningln("CI_DEBUG_HOLD_ON_FAILURE usage is disabled on this Runner")
+		return 0
+	}
+
+	if _, ok := executor.(terminal.InteractiveTerminal); b.Session == nil || !ok {
+		b.logger.Warningln("CI_DEBUG_HOLD_ON_FAILURE requires an interactive web terminal, which is not available")
+		return 0
+	}
+
+	maxHold := time.Duration(b.Runner.MaxDebugHold) * time.Second
+	if hold > maxHold {
+		b.logger.Warningln(fmt.Sprintf("CI_DEBUG_HOLD_ON_FAILURE is limited to %s on this Runner", maxHold))
+		hold = maxHold
+	}
+
+	return hold
+}
+
 func (b *Build) GetDockerAuthConfig() string {
 	return b.GetAllVariables().Get("DOCKER_AUTH_CONFIG")
 }
@@ -25,6 +25,8 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/timeperiod"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace/sinks"
 	"gitlab.com/gitlab-org/gitlab-runner/referees"
 )
 
@@ -226,6 +228,26 @@ type CustomConfig struct {
 	CleanupArgs        []string `toml:"cleanup_args,omitempty" json:"cleanup_args" long:"cleanup-args" description:"Arguments for the cleanup executable"`
 	CleanupExecTimeout *int     `toml:"cleanup_exec_timeout,omitempty" json:"cleanup_exec_timeout" long:"cleanup-exec-timeout" env:"CUSTOM_CLEANUP_EXEC_TIMEOUT" description:"Timeout for the cleanup executable (in seconds)"`
 
+	DriverExec      string   `toml:"driver_exec,omitempty" json:"driver_exec" long:"driver-exec" env:"CUSTOM_DRIVER_EXEC" description:"Driver executable implementing the driver protocol, used instead of the config, prepare, run and cleanup executables"`
+	DriverArgs      []string `toml:"driver_args,omitempty" json:"driver_args" long:"driver-args" description:"Arguments for the driver executable"`
+	DriverTransport string   `toml:"driver_transport,omitempty" json:"driver_transport" long:"driver-transport" env:"CUSTOM_DRIVER_TRANSPORT" description:"How the runner talks to the driver: stdio (default) or unix, for a Unix socket"`
+	DriverScope     string   `toml:"driver_scope,omitempty" json:"driver_scope" long:"driver-scope" env:"CUSTOM_DRIVER_SCOPE" description:"Whether the driver is started once per job (default) or once per runner, with job or runner"`
+
+	CapacityExec        string   `toml:"capacity_exec,omitempty" json:"capacity_exec" long:"capacity-exec" env:"CUSTOM_CAPACITY_EXEC" description:"Executable reporting whether the driver has capacity for another job, checked before requesting a job"`
+	CapacityArgs        []string `toml:"capacity_args,omitempty" json:"capacity_args" long:"capacity-args" description:"Arguments for the capacity executable"`
+	AcquireExec         string   `toml:"acquire_exec,omitempty" json:"acquire_exec" long:"acquire-exec" env:"CUSTOM_ACQUIRE_EXEC" description:"Executable acquiring the resources of a job, like a virtual machine, before requesting a job"`
+	AcquireArgs         []string `toml:"acquire_args,omitempty" json:"acquire_args" long:"acquire-args" description:"Arguments for the acquire executable"`
+	ReleaseExec         string   `toml:"release_exec,omitempty" json:"release_exec" long:"release-exec" env:"CUSTOM_RELEASE_EXEC" description:"Executable releasing the resources acquired by the acquire executable"`
+	ReleaseArgs         []string `toml:"release_args,omitempty" json:"release_args" long:"release-args" description:"Arguments for the release executable"`
+	ProviderExecTimeout *int     `toml:"provider_exec_timeout,omitempty" json:"provider_exec_timeout" long:"provider-exec-timeout" env:"CUSTOM_PROVIDER_EXEC_TIMEOUT" description:"Timeout for the capacity, acquire and release executables (in seconds)"`
+
+	CacheRestoreExec string   `toml:"cache_restore_exec,omitempty" json:"cache_restore_exec" long:"cache-restore-exec" env:"CUSTOM_CACHE_RESTORE_EXEC" description:"Executable restoring the caches of the job, replacing the restore_cache stage"`
+	CacheRestoreArgs []string `toml:"cache_restore_args,omitempty" json:"cache_restore_args" long:"cache-restore-args" description:"Arguments for the cache restore executable"`
+	CacheArchiveExec string   `toml:"cache_archive_exec,omitempty" json:"cache_archive_exec" long:"cache-archive-exec" env:"CUSTOM_CACHE_ARCHIVE_EXEC" description:"Executable archiving the caches of the job, replacing the archive_cache stages"`
+	CacheArchiveArgs []string `toml:"cache_archive_args,omitempty" json:"cache_archive_args" long:"cache-archive-args" description:"Arguments for the cache archive executable"`
+
+	WaitForServicesTimeout *int `toml:"wait_for_services_timeout,omitempty" json:"wait_for_services_timeout" long:"wait-for-services-timeout" env:"CUSTOM_WAIT_FOR_SERVICES_TIMEOUT" description:"How long to wait for the services reported by the driver to accept connections (in seconds). Set to -1 to disable"`
+
 	GracefulKillTimeout *int `toml:"graceful_kill_timeout,omitempty" json:"graceful_kill_timeout" long:"graceful-kill-timeout" env:"CUSTOM_GRACEFUL_KILL_TIMEOUT" description:"Graceful timeout for scripts execution after SIGTERM is sent to the process (in seconds). This limits the time given for scripts to perform the cleanup before exiting"`
 	ForceKillTimeout    *int `toml:"force_kill_timeout,omitempty" json:"force_kill_timeout" long:"force-kill-timeout" env:"CUSTOM_FORCE_KILL_TIMEOUT" description:"Force timeout for scripts execution (in seconds). Counted from the force kill call; if process will be not terminated, Runner will abandon process termination and log an error"`
 }
@@ -353,6 +375,9 @@ type KubernetesConfig struct {
 	ServiceAccountOverwriteAllowed                    string                             `toml:"service_account_overwrite_allowed" json:"service_account_overwrite_allowed" long:"service_account_overwrite_allowed" env:"KUBERNETES_SERVICE_ACCOUNT_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_SERVICE_ACCOUNT' value"`
 	PodAnnotations                                    map[string]string                  `toml:"pod_annotations,omitempty" json:"pod_annotations" long:"pod-annotations" description:"A toml table/json object of key-value. Value is expected to be a string. When set, this will create pods with the given annotations. Can be overwritten in build with KUBERNETES_POD_ANNOTATION_* variables"`
 	PodAnnotationsOverwriteAllowed                    string                             `toml:"pod_annotations_overwrite_allowed" json:"pod_annotations_overwrite_allowed" long:"pod_annotations_overwrite_allowed" env:"KUBERNETES_POD_ANNOTATIONS_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_POD_ANNOTATIONS_*' values"`
+	NodeSelectorOverwriteAllowed                      string                             `toml:"node_selector_overwrite_allowed" json:"node_selector_overwrite_allowed" long:"node_selector_overwrite_allowed" env:"KUBERNETES_NODE_SELECTOR_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_NODE_SELECTOR_*' values"`
+	NodeTolerationsOverwriteAllowed                   string                             `toml:"node_tolerations_overwrite_allowed" json:"node_tolerations_overwrite_allowed" long:"node_tolerations_overwrite_allowed" env:"KUBERNETES_NODE_TOLERATIONS_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_NODE_TOLERATIONS_*' values"`
+	NodeAffinityOverwriteAllowed                      string                             `toml:"node_affinity_overwrite_allowed" json:"node_affinity_overwrite_allowed" long:"node_affinity_overwrite_allowed" env:"KUBERNETES_NODE_AFFINITY_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_NODE_AFFINITY_*' values"`
 	PodSecurityContext                                KubernetesPodSecurityContext       `toml:"pod_security_context,omitempty" namespace:"pod-security-context" description:"A security context attached to each build pod"`
 	BuildContainerSecurityContext                     KubernetesContainerSecurityContext `toml:"build_container_security_context,omitempty" namespace:"build_container_security_context" description:"A security context attached to the build container inside the build pod"`
 	HelperContainerSecurityContext                    KubernetesContainerSecurityContext `toml:"helper_container_security_context,omitempty" namespace:"helper_container_security_context" description:"A security context attached to the helper container inside the build pod"`
@@ -365,6 +390,43 @@ type KubernetesConfig struct {
 	DNSPolicy                                         KubernetesDNSPolicy                `toml:"dns_policy,omitempty" json:"dns_policy" long:"dns-policy" env:"KUBERNETES_DNS_POLICY" description:"How Kubernetes should try to resolve DNS from the created pods. If unset, Kubernetes will use the default 'ClusterFirst'. Valid values are: none, default, cluster-first, cluster-first-with-host-net"`
 	DNSConfig                                         KubernetesDNSConfig                `toml:"dns_config" json:"dns_config" description:"Pod DNS config"`
 	ContainerLifecycle                                KubernetesContainerLifecyle        `toml:"container_lifecycle,omitempty" json:"container_lifecycle,omitempty" description:"Actions that the management system should take in response to container lifecycle events"`
+	PodPool                                           KubernetesPodPool                  `toml:"pod_pool,omitempty" json:"pod_pool" namespace:"pod_pool" description:"A pool of pre-created idle build pods that jobs can claim"`
+	ResourceCleanup                                   KubernetesResourceCleanup          `toml:"resource_cleanup,omitempty" json:"resource_cleanup" namespace:"resource_cleanup" description:"Periodic cleanup of pods, secrets, config maps and services leaked by the runner"`
+	ResourceQuota                                     KubernetesResourceQuota            `toml:"resource_quota,omitempty" json:"resource_quota" namespace:"resource_quota" description:"Pre-flight check of the namespace resource quotas and limit ranges before the build pod is created"`
+	Clusters                                          []KubernetesCluster                `toml:"clusters,omitempty" json:"clusters" description:"An ordered list of clusters to create the build pods in. Pods are created in the first healthy cluster and the job falls back to the next one when the pod can't be created or scheduled"`
+	ClusterHealthCheck                                KubernetesClusterHealthCheck       `toml:"cluster_health_check,omitempty" json:"cluster_health_check" namespace:"cluster_health_check" description:"Health checks of the clusters listed in clusters"`
+}
+
+//nolint:lll
+type KubernetesPodPool struct {
+	Size     int `toml:"size,omitzero" json:"size" long:"size" env:"KUBERNETES_POD_POOL_SIZE" description:"Number of idle build pods to keep ready for this runner. Setting 0 disables the pool"`
+	MaxUses  int `toml:"max_uses,omitzero" json:"max_uses" long:"max-uses" env:"KUBERNETES_POD_POOL_MAX_USES" description:"Number of jobs a pooled pod can run before it's deleted. Setting 0 means no limit"`
+	IdleTime int `toml:"idle_time,omitzero" json:"idle_time" long:"idle-time" env:"KUBERNETES_POD_POOL_IDLE_TIME" description:"Time (in seconds) a pooled pod can stay idle before it's deleted"`
+}
+
+//nolint:lll
+type KubernetesResourceCleanup struct {
+	Interval    int `toml:"interval,omitzero" json:"interval" long:"interval" env:"KUBERNETES_RESOURCE_CLEANUP_INTERVAL" description:"How often (in seconds) the runner looks for leaked resources. Setting 0 disables the cleanup"`
+	GracePeriod int `toml:"grace_period,omitzero" json:"grace_period" long:"grace-period" env:"KUBERNETES_RESOURCE_CLEANUP_GRACE_PERIOD" description:"Minimum age (in seconds) of a resource that's not used by a job before it's deleted"`
+}
+
+//nolint:lll
+type KubernetesResourceQuota struct {
+	Check       bool `toml:"check,omitzero" json:"check" long:"check" env:"KUBERNETES_RESOURCE_QUOTA_CHECK" description:"Check the resource quotas and limit ranges of the namespace before creating the build pod, and wait for the quotas to have room for it"`
+	WaitTimeout int  `toml:"wait_timeout,omitzero" json:"wait_timeout" long:"wait-timeout" env:"KUBERNETES_RESOURCE_QUOTA_WAIT_TIMEOUT" description:"The total amount of time, in seconds, to wait for the resource quotas to have room for the build pod"`
+}
+
+//nolint:lll
+type KubernetesCluster struct {
+	Name       string `toml:"name" json:"name" description:"The name of the cluster shown in the job log and metrics. Defaults to the context"`
+	Kubeconfig string `toml:"kubeconfig,omitempty" json:"kubeconfig" description:"The kubeconfig file of the cluster. The default kubeconfig loading rules are used when not set"`
+	Context    string `toml:"context,omitempty" json:"context" description:"The kubeconfig context of the cluster. The current context is used when not set"`
+}
+
+//nolint:lll
+type KubernetesClusterHealthCheck struct {
+	Interval int `toml:"interval,omitzero" json:"interval" long:"interval" env:"KUBERNETES_CLUSTER_HEALTH_CHECK_INTERVAL" description:"The amount of time, in seconds, the result of a cluster health check is reused for"`
+	Timeout  int `toml:"timeout,omitzero" json:"timeout" long:"timeout" env:"KUBERNETES_CLUSTER_HEALTH_CHECK_TIMEOUT" description:"The amount of time, in seconds, to wait for the health check of a cluster"`
 }
 
 //nolint:lll
@@ -386,6 +448,7 @@ type KubernetesVolumes struct {
 	Secrets    []KubernetesSecret    `toml:"secret" description:"The secret maps which will be mounted"`
 	EmptyDirs  []KubernetesEmptyDir  `toml:"empty_dir" description:"The empty dirs which will be mounted"`
 	CSIs       []KubernetesCSI       `toml:"csi" description:"The CSI volumes which will be mounted"`
+	Ephemerals []KubernetesEphemeral `toml:"ephemeral" description:"The generic ephemeral volumes which will be created for the build pod and mounted"`
 }
 
 //nolint:lll
@@ -442,6 +505,17 @@ type KubernetesCSI struct {
 	VolumeAttributes map[string]string `toml:"volume_attributes,omitempty" description:"Key-value pair mapping for attributes of the CSI volume."`
 }
 
+//nolint:lll
+type KubernetesEphemeral struct {
+	Name           string   `toml:"name" json:"name" description:"The name of the ephemeral volume and volumeMount to use"`
+	MountPath      string   `toml:"mount_path" description:"Path where volume should be mounted inside of container"`
+	SubPath        string   `toml:"sub_path,omitempty" description:"The sub-path of the volume to mount (defaults to volume root)"`
+	StorageClass   string   `toml:"storage_class,omitempty" description:"The storage class of the volume claim. When empty, the default storage class of the cluster is used"`
+	Size           string   `toml:"size" description:"The storage requested for the volume, for example '50Gi'"`
+	AccessModes    []string `toml:"access_modes,omitempty" description:"The access modes of the volume claim. Defaults to ReadWriteOnce"`
+	VolumeSnapshot string   `toml:"volume_snapshot,omitempty" description:"The name of a VolumeSnapshot the volume is pre-populated from. Job variables are expanded"`
+}
+
 //nolint:lll
 type KubernetesPodSecurityContext struct {
 	FSGroup            *int64  `toml:"fs_group,omitempty" long:"fs-group" env:"KUBERNETES_POD_SECURITY_CONTEXT_FS_GROUP" description:"A special supplemental group that applies to all containers in a pod"`
@@ -778,15 +852,44 @@ type CacheAzureConfig struct {
 	StorageDomain string `toml:"StorageDomain,omitempty" long:"storage-domain" env:"CACHE_AZURE_STORAGE_DOMAIN" description:"Domain name of the Azure storage (e.g. blob.core.windows.net)"`
 }
 
+//nolint:lll
+type CacheLocalConfig struct {
+	Directory string `toml:"Directory,omitempty" long:"directory" env:"CACHE_LOCAL_DIRECTORY" description:"Directory where cache will be stored, usually a shared mount, available at the same path to the runner and to the jobs"`
+	TTL       int    `toml:"TTL,omitzero" long:"ttl" env:"CACHE_LOCAL_TTL" description:"Time, in seconds, after which the cache objects that weren't updated are removed. Never removed when 0"`
+}
+
+//nolint:lll
+type CacheSFTPConfig struct {
+	Host                         string `toml:"Host,omitempty" long:"host" env:"CACHE_SFTP_HOST" description:"Address of the SFTP server"`
+	Port                         string `toml:"Port,omitempty" long:"port" env:"CACHE_SFTP_PORT" description:"Port of the SFTP server. Default is 22"`
+	User                         string `toml:"User,omitempty" long:"user" env:"CACHE_SFTP_USER" description:"User name"`
+	Password                     string `toml:"Password,omitempty" long:"password" env:"CACHE_SFTP_PASSWORD" description:"User password"`
+	IdentityFile                 string `toml:"IdentityFile,omitempty" long:"identity-file" env:"CACHE_SFTP_IDENTITY_FILE" description:"File with the private key of the user"`
+	HostKey                      string `toml:"HostKey,omitempty" long:"host-key" env:"CACHE_SFTP_HOST_KEY" description:"Public key of the SFTP server, in the authorized_keys format"`
+	DisableStrictHostKeyChecking bool   `toml:"DisableStrictHostKeyChecking,omitempty" long:"disable-strict-host-key-checking" env:"CACHE_SFTP_DISABLE_STRICT_HOST_KEY_CHECKING" description:"Don't verify the public key of the SFTP server"`
+	Directory                    string `toml:"Directory,omitempty" long:"directory" env:"CACHE_SFTP_DIRECTORY" description:"Directory of the SFTP server where cache will be stored, relative to the home directory of the user when not absolute"`
+	TTL                          int    `toml:"TTL,omitzero" long:"ttl" env:"CACHE_SFTP_TTL" description:"Time, in seconds, after which the cache objects that weren't updated are removed. Never removed when 0"`
+}
+
+//nolint:lll
+type CacheRunnerConfig struct {
+	ServerAddress string `toml:"ServerAddress,omitempty" long:"server-address" env:"CACHE_RUNNER_SERVER_ADDRESS" description:"URL of the cache server of the runner, reachable by the jobs, for example http://172.17.0.1:9253"`
+	Secret        string `toml:"Secret,omitempty" long:"secret" env:"CACHE_RUNNER_SECRET" description:"Secret of the cache server of the runner, signing the cache URLs"`
+}
+
 //nolint:lll
 type CacheConfig struct {
-	Type   string `toml:"Type,omitempty" long:"type" env:"CACHE_TYPE" description:"Select caching method"`
-	Path   string `toml:"Path,omitempty" long:"path" env:"CACHE_PATH" description:"Name of the path to prepend to the cache URL"`
-	Shared bool   `toml:"Shared,omitempty" long:"shared" env:"CACHE_SHARED" description:"Enable cache sharing between runners."`
+	Type   string      `toml:"Type,omitempty" long:"type" env:"CACHE_TYPE" description:"Select caching method"`
+	Path   string      `toml:"Path,omitempty" long:"path" env:"CACHE_PATH" description:"Name of the path to prepend to the cache URL"`
+	Shared bool        `toml:"Shared,omitempty" long:"shared" env:"CACHE_SHARED" description:"Enable cache sharing between runners."`
+	Format CacheFormat `toml:"Format,omitempty" long:"format" env:"CACHE_FORMAT" description:"Format of the cache: zip (default) or chunked"`
 
-	S3    *CacheS3Config    `toml:"s3,omitempty" json:"s3" namespace:"s3"`
-	GCS   *CacheGCSConfig   `toml:"gcs,omitempty" json:"gcs" namespace:"gcs"`
-	Azure *CacheAzureConfig `toml:"azure,omitempty" json:"azure" namespace:"azure"`
+	S3     *CacheS3Config     `toml:"s3,omitempty" json:"s3" namespace:"s3"`
+	GCS    *CacheGCSConfig    `toml:"gcs,omitempty" json:"gcs" namespace:"gcs"`
+	Azure  *CacheAzureConfig  `toml:"azure,omitempty" json:"azure" namespace:"azure"`
+	Local  *CacheLocalConfig  `toml:"local,omitempty" json:"local" namespace:"local"`
+	SFTP   *CacheSFTPConfig   `toml:"sftp,omitempty" json:"sftp" namespace:"sftp"`
+	Runner *CacheRunnerConfig `toml:"runner,omitempty" json:"runner" namespace:"runner"`
 }
 
 //nolint:lll
@@ -803,11 +906,14 @@ type RunnerSettings struct {
 	PostBuildScript string   `toml:"post_build_script,omitempty" json:"post_build_script" long:"post-build-script" env:"RUNNER_POST_BUILD_SCRIPT" description:"Runner-specific command script executed just after build executes"`
 
 	DebugTraceDisabled bool `toml:"debug_trace_disabled,omitempty" json:"debug_trace_disabled" long:"debug-trace-disabled" env:"RUNNER_DEBUG_TRACE_DISABLED" description:"When set to true Runner will disable the possibility of using the CI_DEBUG_TRACE feature"`
+	MaxDebugHold       int  `toml:"max_debug_hold,omitempty" json:"max_debug_hold" long:"max-debug-hold" env:"RUNNER_MAX_DEBUG_HOLD" description:"How long, in seconds, a failed job can be held for debugging with CI_DEBUG_HOLD_ON_FAILURE, holding is disabled when 0"`
 
-	Shell          string           `toml:"shell,omitempty" json:"shell" long:"shell" env:"RUNNER_SHELL" description:"Select bash, cmd, pwsh or powershell"`
-	CustomBuildDir *CustomBuildDir  `toml:"custom_build_dir,omitempty" json:"custom_build_dir" group:"custom build dir configuration" namespace:"custom_build_dir"`
-	Referees       *referees.Config `toml:"referees,omitempty" json:"referees" group:"referees configuration" namespace:"referees"`
-	Cache          *CacheConfig     `toml:"cache,omitempty" json:"cache" group:"cache configuration" namespace:"cache"`
+	Shell           string                       `toml:"shell,omitempty" json:"shell" long:"shell" env:"RUNNER_SHELL" description:"Select bash, cmd, pwsh or powershell"`
+	CustomBuildDir  *CustomBuildDir              `toml:"custom_build_dir,omitempty" json:"custom_build_dir" group:"custom build dir configuration" namespace:"custom_build_dir"`
+	Referees        *referees.Config             `toml:"referees,omitempty" json:"referees" group:"referees configuration" namespace:"referees"`
+	Cache           *CacheConfig                 `toml:"cache,omitempty" json:"cache" group:"cache configuration" namespace:"cache"`
+	TraceSinks      *sinks.Config                `toml:"trace_sinks,omitempty" json:"trace_sinks" group:"trace sinks configuration" namespace:"trace_sinks"`
+	SecretDetection *trace.SecretDetectionConfig `toml:"secret_detection,omitempty" json:"secret_detection" group:"secret detection configuration" namespace:"secret_detection"`
 
 	// GracefulKillTimeout and ForceKillTimeout aren't exposed to the users yet
 	// because not every executor supports it. We also have to keep in mind that
@@ -836,7 +942,9 @@ type RunnerConfig struct {
 	Name               string `toml:"name" json:"name" short:"name" long:"description" env:"RUNNER_NAME" description:"Runner name"`
 	Limit              int    `toml:"limit,omitzero" json:"limit" long:"limit" env:"RUNNER_LIMIT" description:"Maximum number of builds processed by this runner"`
 	OutputLimit        int    `toml:"output_limit,omitzero" long:"output-limit" env:"RUNNER_OUTPUT_LIMIT" description:"Maximum build trace size in kilobytes"`
+	TraceTimestamps    string `toml:"trace_timestamps,omitempty" json:"trace_timestamps" long:"trace-timestamps" env:"RUNNER_TRACE_TIMESTAMPS" description:"Prefix each line of the build trace with a timestamp: elapsed or absolute"`
 	RequestConcurrency int    `toml:"request_concurrency,omitzero" long:"request-concurrency" env:"RUNNER_REQUEST_CONCURRENCY" description:"Maximum concurrency for job requests"`
+	LongPollTimeout    int    `toml:"long_poll_timeout,omitzero" json:"long_poll_timeout" long:"long-poll-timeout" env:"RUNNER_LONG_POLL_TIMEOUT" description:"Maximum time, in seconds, the runner waits for a job request held by GitLab Workhorse until a job is available. Long polling is disabled when 0"`
 
 	RunnerCredentials
 	RunnerSettings
@@ -847,12 +955,28 @@ type SessionServer struct {
 	ListenAddress    string `toml:"listen_address,omitempty" json:"listen_address" description:"Address that the runner will communicate directly with"`
 	AdvertiseAddress string `toml:"advertise_address,omitempty" json:"advertise_address" description:"Address the runner will expose to the world to connect to the session server"`
 	SessionTimeout   int    `toml:"session_timeout,omitempty" json:"session_timeout" description:"How long a terminal session can be active after a build completes, in seconds"`
+	MaxViewers       int    `toml:"max_viewers,omitempty" json:"max_viewers" description:"How many read-only viewers a terminal session can be shared with, sharing is disabled when 0"`
+	RecordingsDir    string `toml:"recordings_dir,omitempty" json:"recordings_dir" description:"Directory where asciicast recordings of terminal sessions are saved"`
+
+	ClientCAFile          string   `toml:"client_ca_file,omitempty" json:"client_ca_file" description:"File with the CA certificates the clients of the session server must present a certificate signed by"`
+	AllowedNetworks       []string `toml:"allowed_networks,omitempty" json:"allowed_networks" description:"CIDR ranges of the clients that can connect to the session server"`
+	MaxFailedAuthAttempts int      `toml:"max_failed_auth_attempts,omitempty" json:"max_failed_auth_attempts" description:"Number of failed authentication attempts after which a client is rejected, limiting is disabled when 0"`
+	FailedAuthWindow      int      `toml:"failed_auth_window,omitempty" json:"failed_auth_window" description:"How long a client is rejected after too many failed authentication attempts, in seconds"`
+}
+
+//nolint:lll
+type CacheServer struct {
+	ListenAddress string `toml:"listen_address,omitempty" json:"listen_address" description:"Address the cache server listens on, reachable by the jobs, it doesn't serve the metrics and debug endpoints"`
+	Directory     string `toml:"directory,omitempty" json:"directory" description:"Directory where the cache server stores the cache, the server is disabled when empty"`
+	MaxSize       int64  `toml:"max_size,omitempty" json:"max_size" description:"Maximum size of the cache stored, in megabytes, the least recently used objects are removed over it"`
+	Secret        string `toml:"secret,omitempty" json:"secret" description:"Secret signing the cache URLs, shared with the runners using the cache server"`
 }
 
 //nolint:lll
 type Config struct {
 	ListenAddress string        `toml:"listen_address,omitempty" json:"listen_address"`
 	SessionServer SessionServer `toml:"session_server,omitempty" json:"session_server"`
+	CacheServer   CacheServer   `toml:"cache_server,omitempty" json:"cache_server"`
 
 	Concurrent    int             `toml:"concurrent" json:"concurrent"`
 	CheckInterval int             `toml:"check_interval" json:"check_interval" description:"Define active checking interval of jobs"`
@@ -861,6 +985,7 @@ type Config struct {
 	User          string          `toml:"user,omitempty" json:"user"`
 	Runners       []*RunnerConfig `toml:"runners" json:"runners"`
 	SentryDSN     *string         `toml:"sentry_dsn"`
+	TraceSpoolDir string          `toml:"trace_spool_dir,omitempty" json:"trace_spool_dir" description:"Directory where job traces are kept until GitLab receives their final update, to replay the updates after a restart"`
 	ModTime       time.Time       `toml:"-"`
 	Loaded        bool            `toml:"-"`
 }
@@ -870,6 +995,16 @@ type CustomBuildDir struct {
 	Enabled bool `toml:"enabled,omitempty" json:"enabled" long:"enabled" env:"CUSTOM_BUILD_DIR_ENABLED" description:"Enable job specific build directories"`
 }
 
+type CacheFormat string
+
+const (
+	// CacheFormatZip stores a cache as a single zip archive
+	CacheFormatZip CacheFormat = "zip"
+	// CacheFormatChunked stores a cache as a manifest of content-addressed
+	// chunks, shared by the caches of the project
+	CacheFormatChunked CacheFormat = "chunked"
+)
+
 type S3AuthType string
 
 const (
@@ -904,6 +1039,30 @@ func (c *CacheConfig) GetShared() bool {
 	return c.Shared
 }
 
+func (c *CacheConfig) GetFormat() CacheFormat {
+	if c.Format == "" {
+		return CacheFormatZip
+	}
+
+	return c.Format
+}
+
+func (c *CacheLocalConfig) GetTTL() time.Duration {
+	return time.Duration(c.TTL) * time.Second
+}
+
+func (c *CacheSFTPConfig) GetPort() string {
+	if c.Port == "" {
+		return "22"
+	}
+
+	return c.Port
+}
+
+func (c *CacheSFTPConfig) GetTTL() time.Duration {
+	return time.Duration(c.TTL) * time.Second
+}
+
 func (r *RunnerSettings) GetGracefulKillTimeout() time.Duration {
 	return getDuration(r.GracefulKillTimeout, process.GracefulTimeout)
 }
@@ -957,6 +1116,28 @@ func (c *SessionServer) GetSessionTimeout() time.Duration {
 	return DefaultSessionTimeout
 }
 
+func (c *SessionServer) GetFailedAuthWindow() time.Duration {
+	if c.FailedAuthWindow > 0 {
+		return time.Duration(c.FailedAuthWindow) * time.Second
+	}
+
+	return DefaultSessionFailedAuthWindow
+}
+
+// GetMaxSize returns the maximum size of the cache stored, in bytes
+func (c *CacheServer) GetMaxSize() int64 {
+	if c.MaxSize > 0 {
+		return c.MaxSize * 1024 * 1024
+	}
+
+	return DefaultCacheServerMaxSize * 1024 * 1024
+}
+
+// RecordingEnabled returns true when terminal sessions need to be recorded
+func (c *SessionServer) RecordingEnabled() bool {
+	return c.RecordingsDir != ""
+}
+
 func (c *DockerConfig) GetNanoCPUs() (int64, error) {
 	if c.CPUS == "" {
 		return 0, nil
@@ -1017,6 +1198,78 @@ func (c *KubernetesConfig) GetPollInterval() int {
 	return c.PollInterval
 }
 
+// IsEnabled returns true when the pod pool should keep idle pods for the runner
+func (p KubernetesPodPool) IsEnabled() bool {
+	return p.Size > 0
+}
+
+// GetIdleTime returns the time a pooled pod may stay idle before it's deleted
+func (p KubernetesPodPool) GetIdleTime() time.Duration {
+	if p.IdleTime <= 0 {
+		return KubernetesPodPoolIdleTime
+	}
+
+	return time.Duration(p.IdleTime) * time.Second
+}
+
+// IsEnabled returns true when the runner should periodically delete leaked resources
+func (c KubernetesResourceCleanup) IsEnabled() bool {
+	return c.Interval > 0
+}
+
+// GetInterval returns the time between two runs of the leaked resources cleanup
+func (c KubernetesResourceCleanup) GetInterval() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+// GetGracePeriod returns the minimum age of a leaked resource before it's deleted
+func (c KubernetesResourceCleanup) GetGracePeriod() time.Duration {
+	if c.GracePeriod <= 0 {
+		return KubernetesResourceCleanupGracePeriod
+	}
+
+	return time.Duration(c.GracePeriod) * time.Second
+}
+
+// GetWaitTimeout returns the time to wait for the resource quotas to have room for the build pod
+func (q KubernetesResourceQuota) GetWaitTimeout() time.Duration {
+	if q.WaitTimeout <= 0 {
+		return KubernetesResourceQuotaWaitTimeout
+	}
+
+	return time.Duration(q.WaitTimeout) * time.Second
+}
+
+// GetName returns the name of the cluster shown in the job log and metrics
+func (c KubernetesCluster) GetName() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.Context != "":
+		return c.Context
+	default:
+		return c.Kubeconfig
+	}
+}
+
+// GetInterval returns the time the result of a cluster health check is reused for
+func (h KubernetesClusterHealthCheck) GetInterval() time.Duration {
+	if h.Interval <= 0 {
+		return KubernetesClusterHealthCheckInterval
+	}
+
+	return time.Duration(h.Interval) * time.Second
+}
+
+// GetTimeout returns the time to wait for the health check of a cluster
+func (h KubernetesClusterHealthCheck) GetTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return KubernetesClusterHealthCheckTimeout
+	}
+
+	return time.Duration(h.Timeout) * time.Second
+}
+
 func (c *KubernetesConfig) GetNodeTolerations() []api.Toleration {
 	var tolerations []api.Toleration
 
@@ -1441,6 +1694,21 @@ func (c *RunnerConfig) GetRequestConcurrency() int {
 	return c.RequestConcurrency
 }
 
+// GetLongPollTimeout returns the time the runner waits for a job request held
+// by GitLab, capped to MaxLongPollTimeout, or 0 when long polling is disabled
+func (c *RunnerConfig) GetLongPollTimeout() time.Duration {
+	timeout := time.Duration(c.LongPollTimeout) * time.Second
+	if timeout <= 0 {
+		return 0
+	}
+
+	if timeout > MaxLongPollTimeout {
+		return MaxLongPollTimeout
+	}
+
+	return timeout
+}
+
 func (c *RunnerConfig) GetVariables() JobVariables {
 	variables := JobVariables{
 		{Key: "CI_RUNNER_SHORT_TOKEN", Value: c.ShortDescription(), Public: true, Internal: true, File: false},
@@ -20,11 +20,19 @@ const DefaultRestoreCacheAttempts = 1
 const DefaultExecutorStageAttempts = 1
 const KubernetesPollInterval = 3
 const KubernetesPollTimeout = 180
+const KubernetesPodPoolIdleTime = 30 * time.Minute
+const KubernetesResourceCleanupGracePeriod = 10 * time.Minute
+const KubernetesResourceQuotaWaitTimeout = 10 * time.Minute
+const KubernetesClusterHealthCheckInterval = 30 * time.Second
+const KubernetesClusterHealthCheckTimeout = 5 * time.Second
 const AfterScriptTimeout = 5 * time.Minute
 const DefaultMetricsServerPort = 9252
 const DefaultCacheRequestTimeout = 10
+const DefaultCacheServerMaxSize = 10 * 1024 // in megabytes
 const DefaultNetworkClientTimeout = 60 * time.Minute
+const MaxLongPollTimeout = 5 * time.Minute
 const DefaultSessionTimeout = 30 * time.Minute
+const DefaultSessionFailedAuthWindow = time.Minute
 const WaitForBuildFinishTimeout = 5 * time.Minute
 const SecretVariableDefaultsToFile = true
 
@@ -46,6 +46,20 @@ func (_m *MockJobTrace) Fail(err error, failureData JobFailureData) {
 	_m.Called(err, failureData)
 }
 
+// IsJobSuccessful provides a mock function with given fields:
+func (_m *MockJobTrace) IsJobSuccessful() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // IsStdout provides a mock function with given fields:
 func (_m *MockJobTrace) IsStdout() bool {
 	ret := _m.Called()
@@ -115,6 +115,35 @@ func (e *AbstractExecutor) PrepareConfiguration(options common.ExecutorPrepareOp
 	e.ProxyPool = proxy.NewPool()
 }
 
+// AddProxy registers a proxy to the ports of a job service. The connections to
+// the ports are opened with dial, so the executor decides how they're reached.
+func (e *AbstractExecutor) AddProxy(serviceName string, ports []common.Port, dial proxy.DialFunc) {
+	if len(ports) == 0 {
+		return
+	}
+
+	proxyPorts := make([]proxy.Port, len(ports))
+	for i, port := range ports {
+		proxyPorts[i] = proxy.Port{Name: port.Name, Number: port.Number, Protocol: port.Protocol}
+	}
+
+	e.ProxyPool[serviceName] = &proxy.Proxy{
+		Settings:          proxy.NewProxySettings(serviceName, proxyPorts),
+		ConnectionHandler: proxy.NewDialRequester(dial),
+	}
+}
+
+// AddBuildProxy registers a proxy to the ports of the job image, named by its
+// alias or "build" like the Kubernetes executor names it
+func (e *AbstractExecutor) AddBuildProxy(dial proxy.DialFunc) {
+	serviceName := e.Build.Image.Alias
+	if serviceName == "" {
+		serviceName = "build"
+	}
+
+	e.AddProxy(serviceName, e.Build.Image.Ports, dial)
+}
+
 func (e *AbstractExecutor) PrepareBuildAndShell() error {
 	err := e.startBuild()
 	if err != nil {
@@ -148,6 +148,8 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
+	s.AddBuildProxy(s.dialRemotePort)
+
 	return nil
 }
 
@@ -248,6 +250,8 @@ func init() {
 
 	featuresUpdater := func(features *common.FeaturesInfo) {
 		features.Variables = true
+		features.Session = true
+		features.Proxy = true
 	}
 
 	common.RegisterExecutorProvider("anka", executors.DefaultExecutorProvider{
@@ -0,0 +1,19 @@
+package anka
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+func (s *executor) Pool() proxy.Pool {
+	return s.ProxyPool
+}
+
+// dialRemotePort connects to the ports of the job image through the SSH
+// connection, so the ports don't need to be reachable from the runner
+func (s *executor) dialRemotePort(ctx context.Context, _ *proxy.Settings, port proxy.Port) (net.Conn, error) {
+	return s.sshClient.Dial(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port.Number)))
+}
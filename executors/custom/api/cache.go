@@ -0,0 +1,32 @@
+package api
+
+// CacheExecInput defines the structure of the file passed to the cache_restore_exec
+// and cache_archive_exec calls in the CACHE_INFO_FILE variable.
+//
+// It lists the caches of the job to restore or to archive, with the keys,
+// paths and policies computed by the Runner.
+type CacheExecInput struct {
+	Caches []CacheInfo `json:"caches"`
+}
+
+// CacheInfo describes a cache of the job
+type CacheInfo struct {
+	// Key is the expanded key of the cache
+	Key string `json:"key"`
+
+	// FallbackKey is the key of the cache to restore when the cache with
+	// Key doesn't exist. It's only set for cache_restore_exec.
+	FallbackKey string `json:"fallback_key,omitempty"`
+
+	// Paths are the paths of the files to cache, relative to the project directory
+	Paths []string `json:"paths"`
+
+	// Untracked tells whether the files untracked by Git are cached too
+	Untracked bool `json:"untracked"`
+
+	// Policy is the cache policy defined in the job: pull, push or pull-push
+	Policy string `json:"policy"`
+
+	// When is the condition the cache is archived on: on_success, on_failure or always
+	When string `json:"when"`
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Conn reads and writes the messages of the driver protocol, which are
+// JSON objects separated by new lines. It's safe to send messages concurrently.
+type Conn struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{
+		encoder: json.NewEncoder(w),
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// Send writes the message to the connection
+func (c *Conn) Send(message interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.encoder.Encode(message)
+}
+
+// Receive reads the next message from the connection. It's not safe to call it concurrently.
+func (c *Conn) Receive(message interface{}) error {
+	return c.decoder.Decode(message)
+}
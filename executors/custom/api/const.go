@@ -12,4 +12,16 @@ const (
 	// The name of the variable used to pass the value of path to the file that
 	// contains JSON encoded content of job API received from GitLab's API
 	JobResponseFileVariable = "JOB_RESPONSE_FILE"
+
+	// The name of the variable used to pass the value of path to the file
+	// prepare_exec writes its JSON encoded output to
+	PrepareOutputFileVariable = "PREPARE_OUTPUT_FILE"
+
+	// The name of the variable used to pass the value of path to the file that
+	// contains the JSON encoded caches passed to cache_restore_exec and cache_archive_exec
+	CacheInfoFileVariable = "CACHE_INFO_FILE"
+
+	// The name of the variable used to pass the ID of the resources returned by
+	// acquire_exec to the executables of the job and to release_exec
+	AcquiredIDVariable = "CUSTOM_ACQUIRED_ID"
 )
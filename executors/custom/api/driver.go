@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+)
+
+// DriverProtocolVersion is the version of the driver protocol. The runner
+// sends it in the hello request and refuses drivers that answer with a different one.
+const DriverProtocolVersion = 1
+
+// DriverSocketVariable is the name of the variable used to pass the path of the Unix
+// socket the driver should listen on, when the driver is configured to use a Unix socket
+const DriverSocketVariable = "CUSTOM_DRIVER_SOCKET"
+
+// RequestType is the type of a request sent by the runner to the driver
+type RequestType string
+
+const (
+	// RequestHello negotiates the protocol version. It's the first request sent on a connection.
+	RequestHello RequestType = "hello"
+	// RequestConfig replaces the config_exec executable
+	RequestConfig RequestType = "config"
+	// RequestPrepare replaces the prepare_exec executable
+	RequestPrepare RequestType = "prepare"
+	// RequestRun replaces the run_exec executable, it's sent for every stage of the job
+	RequestRun RequestType = "run"
+	// RequestCleanup replaces the cleanup_exec executable
+	RequestCleanup RequestType = "cleanup"
+	// RequestCancel cancels a running request, the driver still answers the canceled request
+	RequestCancel RequestType = "cancel"
+)
+
+// ResponseType is the type of a response sent by the driver to the runner
+type ResponseType string
+
+const (
+	// ResponseOutput streams output of a request to the job log
+	ResponseOutput ResponseType = "output"
+	// ResponseResult is the last response of a request
+	ResponseResult ResponseType = "result"
+)
+
+// Request is a message sent by the runner to the driver. The driver answers every
+// request, except cancel requests, with any number of output responses and a
+// result response, all having the ID of the request.
+type Request struct {
+	ID   uint64      `json:"id"`
+	Type RequestType `json:"type"`
+
+	Hello  *HelloRequest  `json:"hello,omitempty"`
+	Job    *Job           `json:"job,omitempty"`
+	Run    *RunRequest    `json:"run,omitempty"`
+	Cancel *CancelRequest `json:"cancel,omitempty"`
+}
+
+// HelloRequest is the payload of the hello request
+type HelloRequest struct {
+	Version int `json:"version"`
+}
+
+// Job describes the job a config, prepare, run or cleanup request is sent for.
+// A driver started once per runner receives the requests of several jobs.
+type Job struct {
+	ID int64 `json:"id"`
+
+	// Env holds the variables passed to the executables of the Custom executor,
+	// in the KEY=VALUE format, like the CUSTOM_ENV_ prefixed job variables
+	Env []string `json:"env"`
+
+	// JobResponseFile is the path of the file containing the JSON encoded job received from GitLab
+	JobResponseFile string `json:"job_response_file"`
+
+	// TempDir is the temporary directory created by the runner for the job
+	TempDir string `json:"temp_dir"`
+}
+
+// RunRequest is the payload of the run request
+type RunRequest struct {
+	// Stage is the name of the stage, like get_sources or build_script
+	Stage string `json:"stage"`
+
+	// Script is the content of the script to execute
+	Script string `json:"script"`
+
+	// ScriptFile is the path of the file the runner wrote the script to
+	ScriptFile string `json:"script_file"`
+}
+
+// CancelRequest is the payload of the cancel request
+type CancelRequest struct {
+	RequestID uint64 `json:"request_id"`
+}
+
+// Response is a message sent by the driver to the runner
+type Response struct {
+	ID   uint64       `json:"id"`
+	Type ResponseType `json:"type"`
+
+	Output *OutputChunk `json:"output,omitempty"`
+	Result *Result      `json:"result,omitempty"`
+}
+
+// OutputStream is the stream an output chunk was written to
+type OutputStream string
+
+const (
+	Stdout OutputStream = "stdout"
+	Stderr OutputStream = "stderr"
+)
+
+// OutputChunk is the payload of the output response
+type OutputChunk struct {
+	Stream OutputStream `json:"stream"`
+	Data   []byte       `json:"data"`
+}
+
+// Result is the payload of the result response. Only the field matching
+// the type of the request is set, and Error when the request failed.
+type Result struct {
+	Error *Error `json:"error,omitempty"`
+
+	Hello   *HelloResult       `json:"hello,omitempty"`
+	Config  *ConfigExecOutput  `json:"config,omitempty"`
+	Prepare *PrepareExecOutput `json:"prepare,omitempty"`
+}
+
+// HelloResult is the result of the hello request
+type HelloResult struct {
+	Version int         `json:"version"`
+	Driver  *DriverInfo `json:"driver,omitempty"`
+}
+
+// ErrorKind tells the runner how a failed request should fail the job
+type ErrorKind string
+
+const (
+	// BuildFailure fails the job like a script failure, the equivalent of
+	// exiting with BUILD_FAILURE_EXIT_CODE
+	BuildFailure ErrorKind = "build_failure"
+	// SystemFailure fails the job with a system failure, the equivalent of
+	// exiting with SYSTEM_FAILURE_EXIT_CODE
+	SystemFailure ErrorKind = "system_failure"
+)
+
+// Error is the error of a failed request
+type Error struct {
+	Kind     ErrorKind `json:"kind"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Message  string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.ExitCode != 0 {
+		return fmt.Sprintf("%s (exit code %d)", e.Message, e.ExitCode)
+	}
+
+	return e.Message
+}
+
+// NewBuildFailure returns an error failing the job like a failed script with the exit code
+func NewBuildFailure(exitCode int, err error) *Error {
+	return &Error{Kind: BuildFailure, ExitCode: exitCode, Message: err.Error()}
+}
+
+// NewSystemFailure returns an error failing the job with a system failure
+func NewSystemFailure(err error) *Error {
+	return &Error{Kind: SystemFailure, Message: err.Error()}
+}
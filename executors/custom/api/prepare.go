@@ -0,0 +1,33 @@
+package api
+
+// PrepareExecOutput defines the output structure of the prepare_exec call.
+//
+// prepare_exec writes it to the file passed in the PREPARE_OUTPUT_FILE
+// variable, so its standard output can still be used for the job log.
+type PrepareExecOutput struct {
+	// Services lists the services of the job started by the driver
+	Services []ServiceInfo `json:"services,omitempty"`
+}
+
+// ServiceInfo describes a service of the job started by the driver
+type ServiceInfo struct {
+	// Name is the name of the service image, as passed in CI_JOB_SERVICES
+	Name string `json:"name"`
+
+	// Alias is the name the service is reachable with. Its host and port are
+	// passed to the job in the CI_SERVICE_<ALIAS>_HOST and CI_SERVICE_<ALIAS>_PORT
+	// variables. When it's empty, the name of the image is used.
+	Alias string `json:"alias,omitempty"`
+
+	// Host is the address the service is reachable at from the job environment
+	Host string `json:"host"`
+
+	// Ports are the TCP ports the service listens on. The Runner waits for
+	// them to accept connections before running the job.
+	Ports []int `json:"ports,omitempty"`
+
+	// Error tells that the service failed to start. The job then fails
+	// with the runner_system_failure reason and the error is printed in the
+	// job log.
+	Error string `json:"error,omitempty"`
+}
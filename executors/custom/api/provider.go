@@ -0,0 +1,41 @@
+package api
+
+// CapacityExecOutput defines the output structure of the capacity_exec call.
+//
+// It's used by the driver to tell the Runner whether it can accept another
+// job, before the Runner requests one from GitLab.
+type CapacityExecOutput struct {
+	// Available is the number of jobs the driver can accept. When it's 0,
+	// the Runner doesn't request a job. When it's undefined, the driver
+	// is considered to have capacity.
+	Available *int `json:"available,omitempty"`
+
+	// Message is logged by the Runner when the driver doesn't have capacity
+	Message string `json:"message,omitempty"`
+}
+
+// HasCapacity returns whether the driver can accept another job
+func (c *CapacityExecOutput) HasCapacity() bool {
+	return c.Available == nil || *c.Available > 0
+}
+
+// AcquireExecOutput defines the output structure of the acquire_exec call.
+//
+// It's used by the driver to pass the resources it acquired for the next
+// job, like a virtual machine, to the Runner.
+type AcquireExecOutput struct {
+	// Acquired tells whether the resources were acquired. When it's false,
+	// the Runner doesn't request a job.
+	Acquired bool `json:"acquired"`
+
+	// ID identifies the acquired resources. It's passed in the CUSTOM_ACQUIRED_ID
+	// variable to the executables of the job and to release_exec.
+	ID string `json:"id,omitempty"`
+
+	// Message is logged by the Runner when the resources weren't acquired
+	Message string `json:"message,omitempty"`
+
+	// JobEnv holds name-value pairs made available through environment
+	// variables to the executables of the job and to release_exec
+	JobEnv map[string]string `json:"job_env,omitempty"`
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// Streams are the writers of the output of a request, which is streamed to the job log
+type Streams struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Driver is implemented by Custom executor drivers using the driver protocol instead of
+// executables. The methods may be called concurrently for different jobs when the driver
+// is started once per runner. The context of a request is canceled when the runner cancels
+// the request or closes the connection.
+//
+// Returning an *Error, for example created with NewBuildFailure, decides how the job fails.
+// Any other error fails the job with a system failure.
+type Driver interface {
+	Config(ctx context.Context, job *Job) (*ConfigExecOutput, error)
+	Prepare(ctx context.Context, job *Job, out Streams) (*PrepareExecOutput, error)
+	Run(ctx context.Context, job *Job, run *RunRequest, out Streams) error
+	Cleanup(ctx context.Context, job *Job, out Streams) error
+}
+
+// DriverInfoProvider can be implemented by a Driver to report its name and version to the runner
+type DriverInfoProvider interface {
+	DriverInfo() *DriverInfo
+}
+
+type server struct {
+	driver Driver
+	conn   *Conn
+
+	lock    sync.Mutex
+	cancels map[uint64]context.CancelFunc
+}
+
+// Serve answers the requests received on the connection until the connection is closed
+func Serve(ctx context.Context, driver Driver, conn *Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &server{
+		driver:  driver,
+		conn:    conn,
+		cancels: make(map[uint64]context.CancelFunc),
+	}
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for {
+		var request Request
+		err := conn.Receive(&request)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receiving request: %w", err)
+		}
+
+		switch request.Type {
+		case RequestHello:
+			s.sendResult(request.ID, s.hello(&request))
+		case RequestCancel:
+			if request.Cancel != nil {
+				s.cancel(request.Cancel.RequestID)
+			}
+		default:
+			requestCtx, requestCancel := context.WithCancel(ctx)
+			s.lock.Lock()
+			s.cancels[request.ID] = requestCancel
+			s.lock.Unlock()
+
+			wg.Add(1)
+			go func(request Request) {
+				defer wg.Done()
+				defer s.cancel(request.ID)
+
+				s.sendResult(request.ID, s.handle(requestCtx, &request))
+			}(request)
+		}
+	}
+}
+
+func (s *server) hello(request *Request) *Result {
+	if request.Hello == nil || request.Hello.Version != DriverProtocolVersion {
+		return &Result{
+			Error: NewSystemFailure(fmt.Errorf("unsupported driver protocol version")),
+			Hello: &HelloResult{Version: DriverProtocolVersion},
+		}
+	}
+
+	result := &HelloResult{Version: DriverProtocolVersion}
+	if provider, ok := s.driver.(DriverInfoProvider); ok {
+		result.Driver = provider.DriverInfo()
+	}
+
+	return &Result{Hello: result}
+}
+
+func (s *server) handle(ctx context.Context, request *Request) *Result {
+	if request.Job == nil {
+		return &Result{Error: NewSystemFailure(fmt.Errorf("%s request without job", request.Type))}
+	}
+
+	out := Streams{
+		Stdout: &outputWriter{conn: s.conn, id: request.ID, stream: Stdout},
+		Stderr: &outputWriter{conn: s.conn, id: request.ID, stream: Stderr},
+	}
+
+	var err error
+	result := new(Result)
+
+	switch request.Type {
+	case RequestConfig:
+		result.Config, err = s.driver.Config(ctx, request.Job)
+	case RequestPrepare:
+		result.Prepare, err = s.driver.Prepare(ctx, request.Job, out)
+	case RequestRun:
+		if request.Run == nil {
+			err = fmt.Errorf("run request without script")
+			break
+		}
+		err = s.driver.Run(ctx, request.Job, request.Run, out)
+	case RequestCleanup:
+		err = s.driver.Cleanup(ctx, request.Job, out)
+	default:
+		err = fmt.Errorf("unsupported request type %q", request.Type)
+	}
+
+	if err != nil {
+		var driverErr *Error
+		if !errors.As(err, &driverErr) {
+			driverErr = NewSystemFailure(err)
+		}
+		result.Error = driverErr
+	}
+
+	return result
+}
+
+func (s *server) cancel(id uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+}
+
+func (s *server) sendResult(id uint64, result *Result) {
+	_ = s.conn.Send(&Response{ID: id, Type: ResponseResult, Result: result})
+}
+
+type outputWriter struct {
+	conn   *Conn
+	id     uint64
+	stream OutputStream
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	err := w.conn.Send(&Response{
+		ID:     w.id,
+		Type:   ResponseOutput,
+		Output: &OutputChunk{Stream: w.stream, Data: data},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ServeStdio answers the requests received on the standard input. The responses are written
+// to the standard output, so the driver must not write anything else to it.
+func ServeStdio(driver Driver) error {
+	return Serve(context.Background(), driver, NewConn(os.Stdin, os.Stdout))
+}
+
+// ServeUnix answers the requests received on the connections to the Unix socket until the context is done
+func ServeUnix(ctx context.Context, driver Driver, path string) error {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listening on %q: %w", path, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+
+		connCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			<-connCtx.Done()
+			_ = conn.Close()
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+
+			_ = Serve(connCtx, driver, NewConn(conn, conn))
+		}()
+	}
+}
+
+// ServeDriver answers the requests of the runner on the transport it was started with:
+// the Unix socket passed in the CUSTOM_DRIVER_SOCKET variable, or the standard input and output.
+// It's meant to be called from the main function of the driver.
+func ServeDriver(driver Driver) error {
+	if path := os.Getenv(DriverSocketVariable); path != "" {
+		return ServeUnix(context.Background(), driver, path)
+	}
+
+	return ServeStdio(driver)
+}
@@ -0,0 +1,165 @@
+//go:build !integration
+// +build !integration
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDriver struct{}
+
+func (d *testDriver) DriverInfo() *DriverInfo {
+	name := "test driver"
+	return &DriverInfo{Name: &name}
+}
+
+func (d *testDriver) Config(ctx context.Context, job *Job) (*ConfigExecOutput, error) {
+	dir := fmt.Sprintf("/builds/%d", job.ID)
+	return &ConfigExecOutput{BuildsDir: &dir}, nil
+}
+
+func (d *testDriver) Prepare(ctx context.Context, job *Job, out Streams) (*PrepareExecOutput, error) {
+	_, _ = fmt.Fprintln(out.Stderr, "preparing")
+	return nil, errors.New("no machine available")
+}
+
+func (d *testDriver) Run(ctx context.Context, job *Job, run *RunRequest, out Streams) error {
+	switch run.Stage {
+	case "build_script":
+		_, _ = fmt.Fprintln(out.Stdout, run.Script)
+		return NewBuildFailure(2, errors.New("script failed"))
+	case "wait":
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	_, _ = fmt.Fprintln(out.Stdout, run.Script)
+	return nil
+}
+
+func (d *testDriver) Cleanup(ctx context.Context, job *Job, out Streams) error {
+	return nil
+}
+
+// startTestServer serves the test driver and returns the connection of the runner
+func startTestServer(t *testing.T) (*Conn, func()) {
+	requestsReader, requestsWriter := io.Pipe()
+	responsesReader, responsesWriter := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- Serve(context.Background(), new(testDriver), NewConn(requestsReader, responsesWriter))
+	}()
+
+	return NewConn(responsesReader, requestsWriter), func() {
+		_ = requestsWriter.Close()
+		assert.NoError(t, <-done)
+	}
+}
+
+func receiveResponses(t *testing.T, conn *Conn) (string, *Result) {
+	var output string
+
+	for {
+		var response Response
+		require.NoError(t, conn.Receive(&response))
+
+		switch response.Type {
+		case ResponseOutput:
+			output += string(response.Output.Stream) + ": " + string(response.Output.Data)
+		case ResponseResult:
+			return output, response.Result
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	job := &Job{ID: 1}
+
+	tests := map[string]struct {
+		request        Request
+		expectedOutput string
+		expectedResult *Result
+	}{
+		"hello": {
+			request: Request{Type: RequestHello, Hello: &HelloRequest{Version: DriverProtocolVersion}},
+			expectedResult: &Result{
+				Hello: &HelloResult{Version: DriverProtocolVersion, Driver: new(testDriver).DriverInfo()},
+			},
+		},
+		"hello with unsupported version": {
+			request: Request{Type: RequestHello, Hello: &HelloRequest{Version: 0}},
+			expectedResult: &Result{
+				Error: &Error{Kind: SystemFailure, Message: "unsupported driver protocol version"},
+				Hello: &HelloResult{Version: DriverProtocolVersion},
+			},
+		},
+		"config": {
+			request: Request{Type: RequestConfig, Job: job},
+			expectedResult: &Result{
+				Config: &ConfigExecOutput{BuildsDir: func() *string { s := "/builds/1"; return &s }()},
+			},
+		},
+		"prepare with system failure": {
+			request:        Request{Type: RequestPrepare, Job: job},
+			expectedOutput: "stderr: preparing\n",
+			expectedResult: &Result{Error: &Error{Kind: SystemFailure, Message: "no machine available"}},
+		},
+		"run": {
+			request:        Request{Type: RequestRun, Job: job, Run: &RunRequest{Stage: "step_script", Script: "echo"}},
+			expectedOutput: "stdout: echo\n",
+			expectedResult: &Result{},
+		},
+		"run with build failure": {
+			request:        Request{Type: RequestRun, Job: job, Run: &RunRequest{Stage: "build_script", Script: "exit 2"}},
+			expectedOutput: "stdout: exit 2\n",
+			expectedResult: &Result{Error: &Error{Kind: BuildFailure, ExitCode: 2, Message: "script failed"}},
+		},
+		"request without job": {
+			request:        Request{Type: RequestCleanup},
+			expectedResult: &Result{Error: &Error{Kind: SystemFailure, Message: "cleanup request without job"}},
+		},
+		"unsupported request": {
+			request: Request{Type: "unknown", Job: job},
+			expectedResult: &Result{
+				Error: &Error{Kind: SystemFailure, Message: `unsupported request type "unknown"`},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			conn, stop := startTestServer(t)
+			defer stop()
+
+			tt.request.ID = 1
+			require.NoError(t, conn.Send(&tt.request))
+
+			output, result := receiveResponses(t, conn)
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	conn, stop := startTestServer(t)
+	defer stop()
+
+	require.NoError(t, conn.Send(&Request{ID: 1, Type: RequestRun, Job: &Job{}, Run: &RunRequest{Stage: "wait"}}))
+	require.NoError(t, conn.Send(&Request{ID: 2, Type: RequestCancel, Cancel: &CancelRequest{RequestID: 1}}))
+
+	var response Response
+	require.NoError(t, conn.Receive(&response))
+	assert.Equal(t, uint64(1), response.ID)
+	assert.Equal(t, ResponseResult, response.Type)
+	assert.Equal(t, &Error{Kind: SystemFailure, Message: context.Canceled.Error()}, response.Result.Error)
+}
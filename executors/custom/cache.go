@@ -0,0 +1,96 @@
+package custom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/shells"
+)
+
+// cacheHook returns the executable replacing the cache stage, if any is configured
+func (e *executor) cacheHook(stage common.BuildStage) (string, []string, bool) {
+	switch stage {
+	case common.BuildStageRestoreCache:
+		return e.config.CacheRestoreExec, e.config.CacheRestoreArgs, e.config.CacheRestoreExec != ""
+	case common.BuildStageArchiveOnSuccessCache, common.BuildStageArchiveOnFailureCache:
+		return e.config.CacheArchiveExec, e.config.CacheArchiveArgs, e.config.CacheArchiveExec != ""
+	}
+
+	return "", nil, false
+}
+
+// runCacheHook runs cache_restore_exec or cache_archive_exec instead of the script of
+// the cache stage. Like with the script, a failure doesn't fail the job.
+func (e *executor) runCacheHook(ctx context.Context, stage common.BuildStage, executable string, args []string) error {
+	var entries []shells.CacheEntry
+	var err error
+
+	if stage == common.BuildStageRestoreCache {
+		entries, err = shells.CachesToRestore(e.Build)
+	} else {
+		entries, err = shells.CachesToArchive(e.Build, stage == common.BuildStageArchiveOnSuccessCache)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	infoFile, err := e.writeCacheInfo(stage, entries)
+	if err != nil {
+		return err
+	}
+
+	opts := prepareCommandOpts{
+		executable: executable,
+		args:       append(args, string(stage)),
+		env:        []string{fmt.Sprintf("%s=%s", api.CacheInfoFileVariable, infoFile)},
+		out:        e.defaultCommandOutputs(),
+	}
+
+	err = e.prepareCommand(ctx, opts).Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+
+		e.Warningln(fmt.Sprintf("Failed to run %s: %v", stage, err))
+	}
+
+	return nil
+}
+
+func (e *executor) writeCacheInfo(stage common.BuildStage, entries []shells.CacheEntry) (string, error) {
+	input := api.CacheExecInput{Caches: make([]api.CacheInfo, 0, len(entries))}
+	for _, entry := range entries {
+		input.Caches = append(input.Caches, api.CacheInfo{
+			Key:         entry.Key,
+			FallbackKey: entry.FallbackKey,
+			Paths:       entry.Paths,
+			Untracked:   entry.Untracked,
+			Policy:      string(entry.Policy),
+			When:        string(entry.When),
+		})
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("encoding cache info: %w", err)
+	}
+
+	infoFile := filepath.Join(e.tempDir, fmt.Sprintf("%s.json", stage))
+
+	err = ioutil.WriteFile(infoFile, data, 0600)
+	if err != nil {
+		return "", fmt.Errorf("writing cache info: %w", err)
+	}
+
+	return infoFile, nil
+}
@@ -0,0 +1,145 @@
+//go:build !integration
+// +build !integration
+
+package custom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+func TestExecutor_RunCacheHooks(t *testing.T) {
+	caches := common.Caches{
+		{Key: "deps", Paths: []string{"vendor"}, Policy: common.CachePolicyPullPush},
+		{Key: "reports", Paths: []string{"reports"}, When: common.CacheWhenOnFailure},
+	}
+
+	tests := map[string]struct {
+		stage              common.BuildStage
+		commandErr         error
+		expectedExecutable string
+		expectedArgs       []string
+		expectedCaches     []api.CacheInfo
+		expectedOutput     string
+	}{
+		"restore cache": {
+			stage:              common.BuildStageRestoreCache,
+			expectedExecutable: "restore-cache",
+			expectedArgs:       []string{"--restore", "restore_cache"},
+			expectedCaches: []api.CacheInfo{
+				{Key: "deps", Paths: []string{"vendor"}, Policy: "pull-push"},
+				{Key: "reports", Paths: []string{"reports"}, When: "on_failure"},
+			},
+		},
+		"archive cache on success": {
+			stage:              common.BuildStageArchiveOnSuccessCache,
+			expectedExecutable: "archive-cache",
+			expectedArgs:       []string{"archive_cache"},
+			expectedCaches: []api.CacheInfo{
+				{Key: "deps", Paths: []string{"vendor"}, Policy: "pull-push"},
+			},
+		},
+		"archive cache on failure": {
+			stage:              common.BuildStageArchiveOnFailureCache,
+			expectedExecutable: "archive-cache",
+			expectedArgs:       []string{"archive_cache_on_failure"},
+			expectedCaches: []api.CacheInfo{
+				{Key: "reports", Paths: []string{"reports"}, When: "on_failure"},
+			},
+		},
+		"failed hook doesn't fail the stage": {
+			stage:              common.BuildStageArchiveOnSuccessCache,
+			commandErr:         errors.New("exit status 1"),
+			expectedExecutable: "archive-cache",
+			expectedArgs:       []string{"archive_cache"},
+			expectedCaches: []api.CacheInfo{
+				{Key: "deps", Paths: []string{"vendor"}, Policy: "pull-push"},
+			},
+			expectedOutput: "Failed to run archive_cache: exit status 1",
+		},
+		"other stages use run_exec": {
+			stage:              common.BuildStageGetSources,
+			expectedExecutable: "bash",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var executables []string
+
+			etc := executorTestCase{
+				config: getRunnerConfig(&common.CustomConfig{
+					RunExec:          "bash",
+					CacheRestoreExec: "restore-cache",
+					CacheRestoreArgs: []string{"--restore"},
+					CacheArchiveExec: "archive-cache",
+				}),
+				commandErr: tt.commandErr,
+				assertCommandFactory: func(
+					t *testing.T,
+					_ executorTestCase,
+					ctx context.Context,
+					executable string,
+					args []string,
+					cmdOpts process.CommandOptions,
+					options command.Options,
+				) {
+					executables = append(executables, executable)
+					if executable == "bash" {
+						return
+					}
+
+					assert.Equal(t, tt.expectedArgs, args)
+
+					var infoFile string
+					for _, env := range cmdOpts.Env {
+						if strings.HasPrefix(env, api.CacheInfoFileVariable+"=") {
+							infoFile = strings.TrimPrefix(env, api.CacheInfoFileVariable+"=")
+						}
+					}
+					require.NotEmpty(t, infoFile)
+
+					data, err := ioutil.ReadFile(infoFile)
+					require.NoError(t, err)
+
+					var input api.CacheExecInput
+					require.NoError(t, json.Unmarshal(data, &input))
+					assert.Equal(t, tt.expectedCaches, input.Caches)
+				},
+			}
+
+			defer mockCommandFactory(t, etc)()
+
+			e, options, out := prepareExecutor(t, etc)
+			options.Build.Cache = caches
+			defer e.Cleanup()
+
+			require.NoError(t, e.Prepare(options))
+
+			err := e.Run(common.ExecutorCommand{
+				Context: context.Background(),
+				Stage:   tt.stage,
+			})
+			if tt.expectedExecutable == "bash" {
+				assert.Equal(t, tt.commandErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, []string{tt.expectedExecutable}, executables)
+			assert.Contains(t, out.String(), tt.expectedOutput)
+		})
+	}
+}
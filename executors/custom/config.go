@@ -23,6 +23,22 @@ func (c *config) GetCleanupScriptTimeout() time.Duration {
 	return getDuration(c.CleanupExecTimeout, defaultCleanupExecTimeout)
 }
 
+func (c *config) GetProviderExecTimeout() time.Duration {
+	return getDuration(c.ProviderExecTimeout, defaultProviderExecTimeout)
+}
+
+func (c *config) GetWaitForServicesTimeout() time.Duration {
+	if c.WaitForServicesTimeout == nil || *c.WaitForServicesTimeout == 0 {
+		return time.Duration(common.DefaultWaitForServicesTimeout) * time.Second
+	}
+
+	if *c.WaitForServicesTimeout < 0 {
+		return 0
+	}
+
+	return time.Duration(*c.WaitForServicesTimeout) * time.Second
+}
+
 func (c *config) GetGracefulKillTimeout() time.Duration {
 	return getDuration(c.GracefulKillTimeout, process.GracefulTimeout)
 }
@@ -31,6 +47,38 @@ func (c *config) GetForceKillTimeout() time.Duration {
 	return getDuration(c.ForceKillTimeout, process.KillTimeout)
 }
 
+func (c *config) GetDriverTransport() string {
+	if c.DriverTransport == "" {
+		return driverTransportStdio
+	}
+
+	return c.DriverTransport
+}
+
+func (c *config) GetDriverScope() string {
+	if c.DriverScope == "" {
+		return driverScopeJob
+	}
+
+	return c.DriverScope
+}
+
+func (c *config) validateDriver() error {
+	switch c.GetDriverTransport() {
+	case driverTransportStdio, driverTransportUnix:
+	default:
+		return common.MakeBuildError("custom executor has unsupported driver transport %q", c.DriverTransport)
+	}
+
+	switch c.GetDriverScope() {
+	case driverScopeJob, driverScopeRunner:
+	default:
+		return common.MakeBuildError("custom executor has unsupported driver scope %q", c.DriverScope)
+	}
+
+	return nil
+}
+
 func getDuration(source *int, defaultValue time.Duration) time.Duration {
 	if source == nil {
 		return defaultValue
@@ -5,3 +5,5 @@ import "time"
 const defaultConfigExecTimeout = time.Hour
 const defaultPrepareExecTimeout = time.Hour
 const defaultCleanupExecTimeout = time.Hour
+const defaultProviderExecTimeout = 5 * time.Minute
+const defaultDriverStartTimeout = time.Minute
@@ -28,6 +28,7 @@ type commandOutputs struct {
 type prepareCommandOpts struct {
 	executable string
 	args       []string
+	env        []string
 	out        commandOutputs
 }
 
@@ -76,16 +77,49 @@ type executor struct {
 	driverInfo *api.DriverInfo
 
 	jobEnv map[string]string
+
+	// driver is the driver process answering the requests of the job,
+	// when the executor is configured with a driver executable
+	driver  *driver
+	drivers *executorProvider
+
+	// runnerDriver is set when the driver is shared by the jobs of the runner
+	runnerDriver *runnerDriver
+
+	// acquisition holds the resources acquired with acquire_exec for the job
+	acquisition *acquisition
+}
+
+// acquireResources runs acquire_exec for the received job. A failure is retried
+// with the preparation of the job.
+func (e *executor) acquireResources() error {
+	if e.acquisition == nil || e.drivers == nil {
+		return nil
+	}
+
+	err := e.drivers.acquire(&e.Config, e.acquisition)
+	if err != nil {
+		return fmt.Errorf("acquiring resources: %w", err)
+	}
+
+	return nil
 }
 
 func (e *executor) Prepare(options common.ExecutorPrepareOptions) error {
 	e.AbstractExecutor.PrepareConfiguration(options)
 
+	e.acquisition, _ = options.Build.ExecutorData.(*acquisition)
+
 	err := e.prepareConfig()
 	if err != nil {
 		return err
 	}
 
+	err = e.acquireResources()
+	if err != nil {
+		return err
+	}
+
 	e.tempDir, err = ioutil.TempDir("", "custom-executor")
 	if err != nil {
 		return err
@@ -96,6 +130,13 @@ func (e *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
+	if e.config.DriverExec != "" {
+		err = e.connectDriver()
+		if err != nil {
+			return err
+		}
+	}
+
 	err = e.dynamicConfig()
 	if err != nil {
 		return err
@@ -108,21 +149,68 @@ func (e *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
-	// nothing to do, as there's no prepare_script
-	if e.config.PrepareExec == "" {
-		return nil
+	output, err := e.prepareEnvironment()
+	if err != nil {
+		return err
 	}
 
+	return e.prepareServices(output)
+}
+
+// prepareEnvironment runs prepare_exec, or sends the prepare request to the driver,
+// and returns the output reported by the driver
+func (e *executor) prepareEnvironment() (*api.PrepareExecOutput, error) {
 	ctx, cancelFunc := context.WithTimeout(e.Context, e.config.GetPrepareExecTimeout())
 	defer cancelFunc()
 
+	if e.driver != nil {
+		result, err := e.callDriver(ctx, &api.Request{Type: api.RequestPrepare}, e.defaultCommandOutputs())
+		if err != nil {
+			return nil, err
+		}
+
+		return result.Prepare, nil
+	}
+
+	// nothing to do, as there's no prepare_script
+	if e.config.PrepareExec == "" {
+		return nil, nil
+	}
+
+	outputFile := filepath.Join(e.tempDir, "prepare-output.json")
+
 	opts := prepareCommandOpts{
 		executable: e.config.PrepareExec,
 		args:       e.config.PrepareArgs,
+		env:        []string{fmt.Sprintf("%s=%s", api.PrepareOutputFileVariable, outputFile)},
 		out:        e.defaultCommandOutputs(),
 	}
 
-	return e.prepareCommand(ctx, opts).Run()
+	err := e.prepareCommand(ctx, opts).Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return readPrepareOutput(outputFile)
+}
+
+func readPrepareOutput(path string) (*api.PrepareExecOutput, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || (err == nil && len(data) < 1) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading prepare output: %w", err)
+	}
+
+	output := new(api.PrepareExecOutput)
+
+	err = json.Unmarshal(data, output)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing JSON output: %w", err)
+	}
+
+	return output, nil
 }
 
 func (e *executor) prepareConfig() error {
@@ -134,6 +222,10 @@ func (e *executor) prepareConfig() error {
 		CustomConfig: e.Config.Custom,
 	}
 
+	if e.config.DriverExec != "" {
+		return e.config.validateDriver()
+	}
+
 	if e.config.RunExec == "" {
 		return common.MakeBuildError("custom executor is missing RunExec")
 	}
@@ -159,6 +251,10 @@ func (e *executor) createJobResponseFile() (string, error) {
 }
 
 func (e *executor) dynamicConfig() error {
+	if e.driver != nil {
+		return e.driverConfig()
+	}
+
 	if e.config.ConfigExec == "" {
 		return nil
 	}
@@ -217,6 +313,16 @@ func (e *executor) logStartupMessage() {
 	e.Println(fmt.Sprintf("%s with driver %s %s...", usageLine, *info.Name, *info.Version))
 }
 
+func (e *executor) cleanupCommandOutputs() commandOutputs {
+	stdoutLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "out"})
+	stderrLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "err"})
+
+	return commandOutputs{
+		stdout: stdoutLogger.WriterLevel(logrus.DebugLevel),
+		stderr: stderrLogger.WriterLevel(logrus.WarnLevel),
+	}
+}
+
 func (e *executor) defaultCommandOutputs() commandOutputs {
 	return commandOutputs{
 		stdout: e.Trace,
@@ -240,21 +346,35 @@ func (e *executor) prepareCommand(ctx context.Context, opts prepareCommandOpts)
 		UseWindowsLegacyProcessStrategy: e.Build.IsFeatureFlagOn(featureflags.UseWindowsLegacyProcessStrategy),
 	}
 
+	cmdOpts.Env = append(cmdOpts.Env, e.commandEnv()...)
+	cmdOpts.Env = append(cmdOpts.Env, opts.env...)
+
+	options := command.Options{
+		JobResponseFile: e.jobResponseFile,
+	}
+
+	return commandFactory(ctx, opts.executable, opts.args, cmdOpts, options)
+}
+
+// commandEnv returns the variables passed to the executables and the driver
+func (e *executor) commandEnv() []string {
+	var env []string
+
+	if e.acquisition != nil {
+		env = append(env, e.acquisition.env()...)
+	}
+
 	// Append job_env defined variable first to avoid overwriting any CI/CD or predefined variables.
 	for k, v := range e.jobEnv {
-		cmdOpts.Env = append(cmdOpts.Env, fmt.Sprintf("%s=%s", k, v))
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
 	variables := append(e.Build.GetAllVariables(), e.getCIJobServicesEnv())
 	for _, variable := range variables {
-		cmdOpts.Env = append(cmdOpts.Env, fmt.Sprintf("CUSTOM_ENV_%s=%s", variable.Key, variable.Value))
-	}
-
-	options := command.Options{
-		JobResponseFile: e.jobResponseFile,
+		env = append(env, fmt.Sprintf("CUSTOM_ENV_%s=%s", variable.Key, variable.Value))
 	}
 
-	return commandFactory(ctx, opts.executable, opts.args, cmdOpts, options)
+	return env
 }
 
 func (e *executor) getCIJobServicesEnv() common.JobVariable {
@@ -284,6 +404,10 @@ func (e *executor) getCIJobServicesEnv() common.JobVariable {
 }
 
 func (e *executor) Run(cmd common.ExecutorCommand) error {
+	if executable, args, ok := e.cacheHook(cmd.Stage); ok {
+		return e.runCacheHook(cmd.Context, cmd.Stage, executable, args)
+	}
+
 	scriptDir, err := ioutil.TempDir(e.tempDir, "script")
 	if err != nil {
 		return err
@@ -303,6 +427,20 @@ func (e *executor) Run(cmd common.ExecutorCommand) error {
 		stage = "build_script"
 	}
 
+	if e.driver != nil {
+		request := &api.Request{
+			Type: api.RequestRun,
+			Run: &api.RunRequest{
+				Stage:      string(stage),
+				Script:     cmd.Script,
+				ScriptFile: scriptFile,
+			},
+		}
+
+		_, err := e.callDriver(cmd.Context, request, e.defaultCommandOutputs())
+		return err
+	}
+
 	args := append(e.config.RunArgs, scriptFile, string(stage))
 
 	opts := prepareCommandOpts{
@@ -327,6 +465,11 @@ func (e *executor) Cleanup() {
 
 	defer func() { _ = os.RemoveAll(e.tempDir) }()
 
+	if e.driver != nil {
+		e.cleanupDriver()
+		return
+	}
+
 	// nothing to do, as there's no cleanup_script
 	if e.config.CleanupExec == "" {
 		return
@@ -335,13 +478,7 @@ func (e *executor) Cleanup() {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), e.config.GetCleanupScriptTimeout())
 	defer cancelFunc()
 
-	stdoutLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "out"})
-	stderrLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "err"})
-
-	outputs := commandOutputs{
-		stdout: stdoutLogger.WriterLevel(logrus.DebugLevel),
-		stderr: stderrLogger.WriterLevel(logrus.WarnLevel),
-	}
+	outputs := e.cleanupCommandOutputs()
 
 	opts := prepareCommandOpts{
 		executable: e.config.CleanupExec,
@@ -379,9 +516,9 @@ func init() {
 		features.Shared = true
 	}
 
-	common.RegisterExecutorProvider("custom", executors.DefaultExecutorProvider{
+	common.RegisterExecutorProvider("custom", newExecutorProvider(executors.DefaultExecutorProvider{
 		Creator:          creator,
 		FeaturesUpdater:  featuresUpdater,
 		DefaultShellName: options.Shell.Shell,
-	})
+	}))
 }
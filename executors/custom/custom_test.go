@@ -1072,3 +1072,44 @@ func TestExecutor_ServicesEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutor_AcquisitionEnv(t *testing.T) {
+	tt := executorTestCase{
+		config: getRunnerConfig(&common.CustomConfig{
+			RunExec:     "bash",
+			PrepareExec: "echo",
+			CleanupExec: "bash",
+		}),
+		assertCommandFactory: func(
+			t *testing.T,
+			tt executorTestCase,
+			ctx context.Context,
+			executable string,
+			args []string,
+			cmdOpts process.CommandOptions,
+			options command.Options,
+		) {
+			assert.Contains(t, cmdOpts.Env, "CUSTOM_ACQUIRED_ID=vm-1")
+			assert.Contains(t, cmdOpts.Env, "VM_IP=10.0.0.1")
+		},
+	}
+
+	defer mockCommandFactory(t, tt)()
+
+	e, options, _ := prepareExecutor(t, tt)
+	options.Build.ExecutorData = &acquisition{
+		acquired: true,
+		id:       "vm-1",
+		jobEnv:   map[string]string{"VM_IP": "10.0.0.1"},
+	}
+
+	err := e.Prepare(options)
+	assert.NoError(t, err)
+
+	err = e.Run(common.ExecutorCommand{
+		Context: context.Background(),
+	})
+	assert.NoError(t, err)
+
+	e.Cleanup()
+}
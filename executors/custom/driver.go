@@ -0,0 +1,501 @@
+package custom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+const (
+	driverTransportStdio = "stdio"
+	driverTransportUnix  = "unix"
+
+	driverScopeJob    = "job"
+	driverScopeRunner = "runner"
+)
+
+var errDriverClosed = errors.New("driver connection closed")
+
+type driverOptions struct {
+	executable string
+	args       []string
+	transport  string
+	socketPath string
+	dir        string
+
+	stderr io.Writer
+	logger process.Logger
+
+	gracefulKillTimeout time.Duration
+	forceKillTimeout    time.Duration
+
+	useWindowsLegacyProcessStrategy bool
+}
+
+type driverCall struct {
+	out    commandOutputs
+	result chan *api.Result
+}
+
+// driver is a driver process implementing the driver protocol. It's started once
+// per job or once per runner and answers the requests replacing the executables.
+type driver struct {
+	options driverOptions
+
+	cmd     process.Commander
+	conn    *api.Conn
+	closer  io.Closer
+	exited  chan struct{}
+	exitErr error
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]*driverCall
+	done    chan struct{}
+	err     error
+
+	info *api.DriverInfo
+}
+
+var newDriverCommander = process.NewOSCmd
+
+// startDriver starts the driver process, connects to it and negotiates the protocol version
+func startDriver(ctx context.Context, options driverOptions) (*driver, error) {
+	d := &driver{
+		options: options,
+		exited:  make(chan struct{}),
+		pending: make(map[uint64]*driverCall),
+		done:    make(chan struct{}),
+	}
+
+	var err error
+	switch options.transport {
+	case driverTransportUnix:
+		err = d.startWithUnixSocket(ctx)
+	default:
+		err = d.startWithStdio()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go d.receive()
+
+	err = d.hello(ctx)
+	if err != nil {
+		_ = d.stop()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *driver) commandOptions() process.CommandOptions {
+	return process.CommandOptions{
+		Dir:                             d.options.dir,
+		Env:                             os.Environ(),
+		Stderr:                          d.options.stderr,
+		Logger:                          d.options.logger,
+		GracefulKillTimeout:             d.options.gracefulKillTimeout,
+		ForceKillTimeout:                d.options.forceKillTimeout,
+		UseWindowsLegacyProcessStrategy: d.options.useWindowsLegacyProcessStrategy,
+	}
+}
+
+func (d *driver) startWithStdio() error {
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("creating driver stdin: %w", err)
+	}
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return fmt.Errorf("creating driver stdout: %w", err)
+	}
+
+	cmdOpts := d.commandOptions()
+	cmdOpts.Stdin = stdinReader
+	cmdOpts.Stdout = stdoutWriter
+
+	err = d.start(cmdOpts)
+
+	// The ends used by the driver process are kept open by the process only
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		return err
+	}
+
+	d.conn = api.NewConn(stdoutReader, stdinWriter)
+	d.closer = stdinWriter
+
+	return nil
+}
+
+func (d *driver) startWithUnixSocket(ctx context.Context) error {
+	_ = os.Remove(d.options.socketPath)
+
+	cmdOpts := d.commandOptions()
+	cmdOpts.Env = append(cmdOpts.Env, fmt.Sprintf("%s=%s", api.DriverSocketVariable, d.options.socketPath))
+	cmdOpts.Stdout = d.options.stderr
+
+	err := d.start(cmdOpts)
+	if err != nil {
+		return err
+	}
+
+	// The driver creates the socket once it's started
+	for {
+		conn, err := net.Dial("unix", d.options.socketPath)
+		if err == nil {
+			d.conn = api.NewConn(conn, conn)
+			d.closer = conn
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = d.kill()
+			return fmt.Errorf("connecting to driver socket %q: %w", d.options.socketPath, err)
+		case <-d.exited:
+			return fmt.Errorf("driver exited before listening on %q: %v", d.options.socketPath, d.exitErr)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (d *driver) start(cmdOpts process.CommandOptions) error {
+	d.cmd = newDriverCommander(d.options.executable, d.options.args, cmdOpts)
+
+	err := d.cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start driver: %w", err)
+	}
+
+	go func() {
+		d.exitErr = d.cmd.Wait()
+		close(d.exited)
+	}()
+
+	return nil
+}
+
+func (d *driver) hello(ctx context.Context) error {
+	result, err := d.call(ctx, &api.Request{
+		Type:  api.RequestHello,
+		Hello: &api.HelloRequest{Version: api.DriverProtocolVersion},
+	}, commandOutputs{})
+	if err != nil {
+		return fmt.Errorf("negotiating driver protocol: %w", err)
+	}
+
+	if result.Hello == nil || result.Hello.Version != api.DriverProtocolVersion {
+		version := 0
+		if result.Hello != nil {
+			version = result.Hello.Version
+		}
+
+		return fmt.Errorf(
+			"driver protocol version %d isn't supported, the runner supports version %d",
+			version,
+			api.DriverProtocolVersion,
+		)
+	}
+
+	if result.Error != nil {
+		return fmt.Errorf("negotiating driver protocol: %w", result.Error)
+	}
+
+	d.info = result.Hello.Driver
+
+	return nil
+}
+
+// call sends the request and waits for its result, writing the output of the request to out.
+// When the context is done, the request is canceled and the driver is given the kill timeouts
+// to answer it.
+func (d *driver) call(ctx context.Context, request *api.Request, out commandOutputs) (*api.Result, error) {
+	call := &driverCall{out: out, result: make(chan *api.Result, 1)}
+
+	d.lock.Lock()
+	if d.err != nil {
+		d.lock.Unlock()
+		return nil, d.err
+	}
+	d.nextID++
+	request.ID = d.nextID
+	d.pending[request.ID] = call
+	d.lock.Unlock()
+
+	defer func() {
+		d.lock.Lock()
+		delete(d.pending, request.ID)
+		d.lock.Unlock()
+	}()
+
+	err := d.conn.Send(request)
+	if err != nil {
+		return nil, fmt.Errorf("sending %s request to driver: %w", request.Type, err)
+	}
+
+	select {
+	case result := <-call.result:
+		return result, nil
+	case <-d.done:
+		return nil, d.err
+	case <-ctx.Done():
+	}
+
+	_ = d.conn.Send(&api.Request{
+		Type:   api.RequestCancel,
+		Cancel: &api.CancelRequest{RequestID: request.ID},
+	})
+
+	select {
+	case <-call.result:
+	case <-d.done:
+	case <-time.After(d.options.gracefulKillTimeout + d.options.forceKillTimeout):
+	}
+
+	return nil, fmt.Errorf("%s request canceled: %w", request.Type, ctx.Err())
+}
+
+// receive dispatches the responses of the driver to the pending requests until the connection is closed
+func (d *driver) receive() {
+	for {
+		var response api.Response
+		err := d.conn.Receive(&response)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errDriverClosed
+			}
+
+			d.close(fmt.Errorf("receiving driver response: %w", err))
+			return
+		}
+
+		d.dispatch(&response)
+	}
+}
+
+func (d *driver) dispatch(response *api.Response) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	call, ok := d.pending[response.ID]
+	if !ok {
+		return
+	}
+
+	switch response.Type {
+	case api.ResponseOutput:
+		if response.Output == nil {
+			return
+		}
+
+		out := call.out.stdout
+		if response.Output.Stream == api.Stderr {
+			out = call.out.stderr
+		}
+		if out != nil {
+			_, _ = out.Write(response.Output.Data)
+		}
+	case api.ResponseResult:
+		result := response.Result
+		if result == nil {
+			result = new(api.Result)
+		}
+		call.result <- result
+	}
+}
+
+func (d *driver) close(err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.err != nil {
+		return
+	}
+
+	d.err = err
+	close(d.done)
+}
+
+// closed returns true when the connection to the driver was lost or the driver was stopped
+func (d *driver) closed() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop closes the connection to the driver and waits for it to exit. A driver using the standard
+// input and output exits when the connection is closed, a driver using a socket is terminated.
+func (d *driver) stop() error {
+	d.close(errDriverClosed)
+	_ = d.closer.Close()
+
+	if d.options.transport != driverTransportUnix {
+		select {
+		case <-d.exited:
+			return nil
+		case <-time.After(d.options.gracefulKillTimeout):
+		}
+	}
+
+	return d.kill()
+}
+
+func (d *driver) kill() error {
+	waitCh := make(chan error, 1)
+	go func() {
+		<-d.exited
+		waitCh <- d.exitErr
+	}()
+
+	return process.NewOSKillWait(d.options.logger, d.options.gracefulKillTimeout, d.options.forceKillTimeout).
+		KillAndWait(d.cmd, waitCh)
+}
+
+// driverResultError returns the error of a failed request, as a build error when the
+// driver asked for a build failure
+func driverResultError(result *api.Result) error {
+	if result.Error == nil {
+		return nil
+	}
+
+	if result.Error.Kind == api.BuildFailure {
+		return &common.BuildError{Inner: result.Error, ExitCode: result.Error.ExitCode}
+	}
+
+	return result.Error
+}
+
+// connectDriver starts the driver of the job, or connects to the driver of the runner
+func (e *executor) connectDriver() error {
+	ctx, cancelFunc := context.WithTimeout(e.Context, defaultDriverStartTimeout)
+	defer cancelFunc()
+
+	var err error
+	if e.config.GetDriverScope() == driverScopeRunner && e.drivers != nil {
+		e.runnerDriver, err = e.drivers.driver(ctx, &e.Config)
+		if e.runnerDriver != nil {
+			e.driver = e.runnerDriver.driver
+		}
+	} else {
+		e.driver, err = startDriver(ctx, e.driverOptions())
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to driver: %w", err)
+	}
+
+	e.driverInfo = e.driver.info
+
+	return nil
+}
+
+func (e *executor) driverOptions() driverOptions {
+	return driverOptions{
+		executable:                      e.config.DriverExec,
+		args:                            e.config.DriverArgs,
+		transport:                       e.config.GetDriverTransport(),
+		socketPath:                      filepath.Join(e.tempDir, "driver.sock"),
+		dir:                             e.tempDir,
+		stderr:                          e.Trace,
+		logger:                          common.NewProcessLoggerAdapter(e.BuildLogger),
+		gracefulKillTimeout:             e.config.GetGracefulKillTimeout(),
+		forceKillTimeout:                e.config.GetForceKillTimeout(),
+		useWindowsLegacyProcessStrategy: e.Build.IsFeatureFlagOn(featureflags.UseWindowsLegacyProcessStrategy),
+	}
+}
+
+func (e *executor) driverJob() *api.Job {
+	return &api.Job{
+		ID:              e.Build.ID,
+		Env:             e.commandEnv(),
+		JobResponseFile: e.jobResponseFile,
+		TempDir:         e.tempDir,
+	}
+}
+
+// callDriver sends the request for the job to the driver and returns its result,
+// or the error of the request
+func (e *executor) callDriver(ctx context.Context, request *api.Request, out commandOutputs) (*api.Result, error) {
+	request.Job = e.driverJob()
+
+	result, err := e.driver.call(ctx, request, out)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, driverResultError(result)
+}
+
+func (e *executor) driverConfig() error {
+	ctx, cancelFunc := context.WithTimeout(e.Context, e.config.GetConfigExecTimeout())
+	defer cancelFunc()
+
+	request := &api.Request{Type: api.RequestConfig, Job: e.driverJob()}
+
+	result, err := e.driver.call(ctx, request, commandOutputs{stderr: e.Trace})
+	if err != nil {
+		return err
+	}
+
+	err = driverResultError(result)
+	if err != nil {
+		return err
+	}
+
+	if result.Config == nil {
+		return nil
+	}
+
+	config := &ConfigExecOutput{ConfigExecOutput: *result.Config}
+	config.InjectInto(e)
+
+	if e.driverInfo == nil {
+		e.driverInfo = e.driver.info
+	}
+
+	return nil
+}
+
+// cleanupDriver sends the cleanup request and stops the driver when it was started for the job
+func (e *executor) cleanupDriver() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), e.config.GetCleanupScriptTimeout())
+	defer cancelFunc()
+
+	_, err := e.callDriver(ctx, &api.Request{Type: api.RequestCleanup}, e.cleanupCommandOutputs())
+	if err != nil {
+		e.Warningln("Cleanup request failed:", err)
+	}
+
+	if e.runnerDriver != nil {
+		e.drivers.releaseDriver(e.runnerDriver)
+		return
+	}
+
+	err = e.driver.stop()
+	if err != nil {
+		e.Warningln("Stopping driver failed:", err)
+	}
+}
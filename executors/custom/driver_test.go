@@ -0,0 +1,235 @@
+//go:build !integration
+// +build !integration
+
+package custom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+)
+
+type fakeDriver struct {
+	ignoreCancel bool
+}
+
+func (d *fakeDriver) DriverInfo() *api.DriverInfo {
+	name := "fake"
+	return &api.DriverInfo{Name: &name}
+}
+
+func (d *fakeDriver) Config(ctx context.Context, job *api.Job) (*api.ConfigExecOutput, error) {
+	return &api.ConfigExecOutput{}, nil
+}
+
+func (d *fakeDriver) Prepare(ctx context.Context, job *api.Job, out api.Streams) (*api.PrepareExecOutput, error) {
+	return &api.PrepareExecOutput{
+		Services: []api.ServiceInfo{{Name: "postgres:13", Host: "10.0.0.2", Ports: []int{5432}}},
+	}, nil
+}
+
+func (d *fakeDriver) Run(ctx context.Context, job *api.Job, run *api.RunRequest, out api.Streams) error {
+	switch run.Stage {
+	case "wait":
+		if d.ignoreCancel {
+			time.Sleep(time.Second)
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	case "fail":
+		return api.NewBuildFailure(3, errors.New("script failed"))
+	}
+
+	_, _ = fmt.Fprintf(out.Stdout, "job %d: %s", job.ID, run.Script)
+	_, _ = fmt.Fprint(out.Stderr, "done")
+
+	return nil
+}
+
+func (d *fakeDriver) Cleanup(ctx context.Context, job *api.Job, out api.Streams) error {
+	return nil
+}
+
+// newTestDriver connects a driver to the fake driver served in the test process
+func newTestDriver(t *testing.T, fake *fakeDriver) *driver {
+	requestsReader, requestsWriter := io.Pipe()
+	responsesReader, responsesWriter := io.Pipe()
+
+	go func() {
+		_ = api.Serve(context.Background(), fake, api.NewConn(requestsReader, responsesWriter))
+		_ = responsesWriter.Close()
+	}()
+
+	d := &driver{
+		options: driverOptions{
+			gracefulKillTimeout: 100 * time.Millisecond,
+			forceKillTimeout:    100 * time.Millisecond,
+		},
+		conn:    api.NewConn(responsesReader, requestsWriter),
+		closer:  requestsWriter,
+		pending: make(map[uint64]*driverCall),
+		done:    make(chan struct{}),
+	}
+
+	go d.receive()
+	require.NoError(t, d.hello(context.Background()))
+
+	t.Cleanup(func() {
+		_ = d.closer.Close()
+	})
+
+	return d
+}
+
+func TestDriver_Hello(t *testing.T) {
+	d := newTestDriver(t, new(fakeDriver))
+
+	require.NotNil(t, d.info)
+	assert.Equal(t, "fake", *d.info.Name)
+}
+
+func TestDriver_Call(t *testing.T) {
+	d := newTestDriver(t, new(fakeDriver))
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	result, err := d.call(context.Background(), &api.Request{
+		Type: api.RequestRun,
+		Job:  &api.Job{ID: 10},
+		Run:  &api.RunRequest{Stage: "build_script", Script: "echo"},
+	}, commandOutputs{stdout: stdout, stderr: stderr})
+	require.NoError(t, err)
+
+	assert.Nil(t, result.Error)
+	assert.Equal(t, "job 10: echo", stdout.String())
+	assert.Equal(t, "done", stderr.String())
+	assert.Empty(t, d.pending)
+}
+
+func TestDriver_CallCanceled(t *testing.T) {
+	tests := map[string]struct {
+		ignoreCancel bool
+	}{
+		"driver answers the canceled request": {},
+		"driver ignores the cancel request": {
+			ignoreCancel: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			d := newTestDriver(t, &fakeDriver{ignoreCancel: tt.ignoreCancel})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := d.call(ctx, &api.Request{
+				Type: api.RequestRun,
+				Job:  &api.Job{},
+				Run:  &api.RunRequest{Stage: "wait"},
+			}, commandOutputs{})
+			assert.True(t, errors.Is(err, context.DeadlineExceeded))
+			assert.Contains(t, err.Error(), "run request canceled")
+		})
+	}
+}
+
+func TestDriver_CallClosed(t *testing.T) {
+	d := newTestDriver(t, new(fakeDriver))
+
+	require.NoError(t, d.closer.Close())
+
+	select {
+	case <-d.done:
+	case <-time.After(time.Second):
+		require.Fail(t, "driver connection wasn't closed")
+	}
+
+	assert.True(t, d.closed())
+
+	_, err := d.call(context.Background(), &api.Request{Type: api.RequestCleanup}, commandOutputs{})
+	assert.True(t, errors.Is(err, errDriverClosed))
+}
+
+func TestDriverResultError(t *testing.T) {
+	d := newTestDriver(t, new(fakeDriver))
+
+	result, err := d.call(context.Background(), &api.Request{
+		Type: api.RequestRun,
+		Job:  &api.Job{},
+		Run:  &api.RunRequest{Stage: "fail"},
+	}, commandOutputs{})
+	require.NoError(t, err)
+
+	err = driverResultError(result)
+
+	var buildErr *common.BuildError
+	require.True(t, errors.As(err, &buildErr))
+	assert.Equal(t, 3, buildErr.ExitCode)
+	assert.Contains(t, err.Error(), "script failed")
+
+	assert.NoError(t, driverResultError(&api.Result{}))
+
+	err = driverResultError(&api.Result{Error: api.NewSystemFailure(errors.New("no machine available"))})
+	assert.False(t, errors.As(err, &buildErr))
+	assert.EqualError(t, err, "no machine available")
+}
+
+func TestConfig_ValidateDriver(t *testing.T) {
+	tests := map[string]struct {
+		config        common.CustomConfig
+		expectedError string
+	}{
+		"defaults": {},
+		"unix socket for runner": {
+			config: common.CustomConfig{DriverTransport: driverTransportUnix, DriverScope: driverScopeRunner},
+		},
+		"unsupported transport": {
+			config:        common.CustomConfig{DriverTransport: "tcp"},
+			expectedError: `custom executor has unsupported driver transport "tcp"`,
+		},
+		"unsupported scope": {
+			config:        common.CustomConfig{DriverScope: "host"},
+			expectedError: `custom executor has unsupported driver scope "host"`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			c := &config{CustomConfig: &tt.config}
+
+			err := c.validateDriver()
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestDriver_CallPrepare(t *testing.T) {
+	d := newTestDriver(t, new(fakeDriver))
+
+	result, err := d.call(context.Background(), &api.Request{
+		Type: api.RequestPrepare,
+		Job:  &api.Job{},
+	}, commandOutputs{})
+	require.NoError(t, err)
+
+	require.NotNil(t, result.Prepare)
+	assert.Equal(t, []api.ServiceInfo{{Name: "postgres:13", Host: "10.0.0.2", Ports: []int{5432}}}, result.Prepare.Services)
+}
@@ -0,0 +1,346 @@
+package custom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+// executorProvider extends the default executor provider with the drivers
+// started once per runner, which are shared by the executors of the runner
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+
+	lock     sync.Mutex
+	drivers  map[string]*runnerDriver
+	sequence int
+}
+
+// runnerDriver is the driver of a runner, with the configuration it was started with
+// and the number of jobs using it
+type runnerDriver struct {
+	*driver
+
+	spec  driverSpec
+	users int
+
+	// retired is set when the runner was removed or its driver configuration changed.
+	// The driver is stopped once the last job using it finished.
+	retired bool
+}
+
+// driverSpec is the part of the configuration a driver of the runner is started with
+type driverSpec struct {
+	executable string
+	args       []string
+	transport  string
+}
+
+func newDriverSpec(config *config) driverSpec {
+	return driverSpec{
+		executable: config.DriverExec,
+		args:       config.DriverArgs,
+		transport:  config.GetDriverTransport(),
+	}
+}
+
+func (s driverSpec) equal(other driverSpec) bool {
+	return reflect.DeepEqual(s, other)
+}
+
+func newExecutorProvider(provider executors.DefaultExecutorProvider) *executorProvider {
+	return &executorProvider{
+		DefaultExecutorProvider: provider,
+		drivers:                 make(map[string]*runnerDriver),
+	}
+}
+
+func (p *executorProvider) Create() common.Executor {
+	e := p.DefaultExecutorProvider.Create()
+	if executor, ok := e.(*executor); ok {
+		executor.drivers = p
+	}
+
+	return e
+}
+
+// driver returns the driver of the runner, which is started when the runner doesn't have one
+// yet, when the connection to the previous one was lost or when its configuration changed.
+// The driver must be handed back with releaseDriver once the job finished.
+func (p *executorProvider) driver(ctx context.Context, runner *common.RunnerConfig) (*runnerDriver, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	config := &config{CustomConfig: runner.Custom}
+	spec := newDriverSpec(config)
+
+	key := runner.UniqueID()
+	if d, ok := p.drivers[key]; ok {
+		if !d.closed() && d.spec.equal(spec) {
+			d.users++
+			return d, nil
+		}
+
+		if p.retire(key, d) {
+			go stopRunnerDriver(d)
+		}
+	}
+
+	logger := common.NewBuildLogger(nil, runner.Log().WithField("driver", config.DriverExec))
+	stderr := logger.WriterLevel(logrus.WarnLevel)
+
+	p.sequence++
+
+	d, err := startDriver(ctx, driverOptions{
+		executable:          config.DriverExec,
+		args:                config.DriverArgs,
+		transport:           config.GetDriverTransport(),
+		socketPath:          driverSocketPath(key, p.sequence),
+		stderr:              stderr,
+		logger:              common.NewProcessLoggerAdapter(logger),
+		gracefulKillTimeout: config.GetGracefulKillTimeout(),
+		forceKillTimeout:    config.GetForceKillTimeout(),
+	})
+	if err != nil {
+		_ = stderr.Close()
+		return nil, err
+	}
+
+	rd := &runnerDriver{driver: d, spec: spec, users: 1}
+	p.drivers[key] = rd
+
+	return rd, nil
+}
+
+// driverSocketPath returns the socket of a driver of the runner. The runner is identified by
+// a hash of its unique ID, which is too long for a socket path and contains its URL.
+func driverSocketPath(uniqueID string, sequence int) string {
+	hash := sha256.Sum256([]byte(uniqueID))
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("custom-driver-%x-%d.sock", hash[:6], sequence))
+}
+
+// releaseDriver hands back the driver of the runner once the job finished,
+// stopping it when it was retired and no other job uses it
+func (p *executorProvider) releaseDriver(d *runnerDriver) {
+	p.lock.Lock()
+	d.users--
+	stop := d.retired && d.users == 0
+	p.lock.Unlock()
+
+	if stop {
+		stopRunnerDriver(d)
+	}
+}
+
+// retire removes the driver from the drivers of the runners, so that the next job starts a new one.
+// It returns true when no job uses the driver, which must then be stopped by the caller. Otherwise,
+// the driver is stopped by releaseDriver. It must be called with the lock held.
+func (p *executorProvider) retire(key string, d *runnerDriver) bool {
+	delete(p.drivers, key)
+	d.retired = true
+
+	return d.users == 0
+}
+
+// UpdateRunners retires the drivers of the runners that were removed or whose driver configuration
+// changed when the configuration is reloaded. With no runners, when the runner exits, all drivers are
+// stopped.
+func (p *executorProvider) UpdateRunners(runners []*common.RunnerConfig) {
+	specs := make(map[string]driverSpec)
+	for _, runner := range runners {
+		if runner.Custom == nil {
+			continue
+		}
+
+		specs[runner.UniqueID()] = newDriverSpec(&config{CustomConfig: runner.Custom})
+	}
+
+	var unused []*runnerDriver
+
+	p.lock.Lock()
+	for key, d := range p.drivers {
+		spec, ok := specs[key]
+		if ok && spec.equal(d.spec) {
+			continue
+		}
+
+		if p.retire(key, d) {
+			unused = append(unused, d)
+		}
+	}
+	p.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range unused {
+		wg.Add(1)
+		go func(d *runnerDriver) {
+			defer wg.Done()
+			stopRunnerDriver(d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+func stopRunnerDriver(d *runnerDriver) {
+	err := d.stop()
+	if err != nil {
+		d.options.logger.Warn("Stopping driver failed:", err)
+	}
+}
+
+// acquisition is the ExecutorData handed to the executor when acquire_exec is configured.
+// The resources are acquired once the job was received, before it's prepared.
+type acquisition struct {
+	acquired bool
+	id       string
+	jobEnv   map[string]string
+}
+
+// env returns the variables passed to the executables of the job and to release_exec
+func (a *acquisition) env() []string {
+	env := []string{fmt.Sprintf("%s=%s", api.AcquiredIDVariable, a.id)}
+	for k, v := range a.jobEnv {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return env
+}
+
+// Acquire asks capacity_exec whether the driver can accept another job before the runner
+// requests a job. The resources of the job are acquired with acquire_exec only once the
+// job was received, see acquire.
+func (p *executorProvider) Acquire(runner *common.RunnerConfig) (common.ExecutorData, error) {
+	if runner.Custom == nil {
+		return nil, nil
+	}
+
+	config := &config{CustomConfig: runner.Custom}
+
+	if config.CapacityExec != "" {
+		capacity := new(api.CapacityExecOutput)
+
+		err := p.runHook(runner, config.CapacityExec, config.CapacityArgs, nil, capacity)
+		if err != nil {
+			return nil, fmt.Errorf("checking capacity: %w", err)
+		}
+
+		if !capacity.HasCapacity() {
+			return nil, &common.NoFreeExecutorError{Message: noCapacityMessage("no capacity", capacity.Message)}
+		}
+	}
+
+	if config.AcquireExec == "" {
+		return nil, nil
+	}
+
+	return &acquisition{}, nil
+}
+
+// acquire acquires the resources of the received job with acquire_exec
+func (p *executorProvider) acquire(runner *common.RunnerConfig, a *acquisition) error {
+	if a.acquired || runner.Custom == nil || runner.Custom.AcquireExec == "" {
+		return nil
+	}
+
+	config := &config{CustomConfig: runner.Custom}
+	acquired := new(api.AcquireExecOutput)
+
+	err := p.runHook(runner, config.AcquireExec, config.AcquireArgs, nil, acquired)
+	if err != nil {
+		return err
+	}
+
+	if !acquired.Acquired {
+		return &common.NoFreeExecutorError{Message: noCapacityMessage("no resources acquired", acquired.Message)}
+	}
+
+	a.acquired = true
+	a.id = acquired.ID
+	a.jobEnv = acquired.JobEnv
+
+	return nil
+}
+
+func noCapacityMessage(reason string, message string) string {
+	if message == "" {
+		return reason
+	}
+
+	return fmt.Sprintf("%s: %s", reason, message)
+}
+
+// Release releases the resources acquired with acquire_exec using release_exec
+func (p *executorProvider) Release(runner *common.RunnerConfig, data common.ExecutorData) {
+	acquired, ok := data.(*acquisition)
+	if !ok || !acquired.acquired || runner == nil || runner.Custom == nil || runner.Custom.ReleaseExec == "" {
+		return
+	}
+
+	config := &config{CustomConfig: runner.Custom}
+
+	err := p.runHook(runner, config.ReleaseExec, config.ReleaseArgs, acquired.env(), nil)
+	if err != nil {
+		runner.Log().WithField("acquired_id", acquired.id).WithError(err).Warningln("Releasing resources failed")
+	}
+}
+
+// runHook runs the capacity, acquire or release executable, decoding its standard
+// output into out. The standard error is logged by the runner.
+func (p *executorProvider) runHook(
+	runner *common.RunnerConfig,
+	executable string,
+	args []string,
+	env []string,
+	out interface{},
+) error {
+	config := &config{CustomConfig: runner.Custom}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), config.GetProviderExecTimeout())
+	defer cancelFunc()
+
+	logger := common.NewBuildLogger(nil, runner.Log().WithField("executable", executable))
+	stderr := logger.WriterLevel(logrus.WarnLevel)
+	defer func() { _ = stderr.Close() }()
+
+	buf := new(bytes.Buffer)
+	cmdOpts := process.CommandOptions{
+		Dir:                 os.TempDir(),
+		Env:                 env,
+		Stdout:              buf,
+		Stderr:              stderr,
+		Logger:              common.NewProcessLoggerAdapter(logger),
+		GracefulKillTimeout: config.GetGracefulKillTimeout(),
+		ForceKillTimeout:    config.GetForceKillTimeout(),
+	}
+
+	err := commandFactory(ctx, executable, args, cmdOpts, command.Options{}).Run()
+	if err != nil {
+		return err
+	}
+
+	if out == nil || buf.Len() < 1 {
+		return nil
+	}
+
+	err = json.Unmarshal(buf.Bytes(), out)
+	if err != nil {
+		return fmt.Errorf("error while parsing JSON output: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,375 @@
+//go:build !integration
+// +build !integration
+
+package custom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+type hookCall struct {
+	executable string
+	args       []string
+	env        []string
+}
+
+// mockHooks replaces the command factory with one answering the executables with
+// the given outputs, and returns the calls made
+func mockHooks(t *testing.T, outputs map[string]string, errs map[string]error) (*[]hookCall, func()) {
+	calls := new([]hookCall)
+
+	oldFactory := commandFactory
+	commandFactory = func(
+		ctx context.Context,
+		executable string,
+		args []string,
+		cmdOpts process.CommandOptions,
+		options command.Options,
+	) command.Command {
+		*calls = append(*calls, hookCall{executable: executable, args: args, env: cmdOpts.Env})
+
+		cmd := new(command.MockCommand)
+		cmd.On("Run").
+			Run(func(_ mock.Arguments) {
+				_, err := fmt.Fprint(cmdOpts.Stdout, outputs[executable])
+				require.NoError(t, err)
+			}).
+			Return(errs[executable])
+
+		return cmd
+	}
+
+	return calls, func() {
+		commandFactory = oldFactory
+	}
+}
+
+func TestExecutorProvider_Acquire(t *testing.T) {
+	hooksConfig := &common.CustomConfig{
+		CapacityExec: "capacity",
+		CapacityArgs: []string{"--runner"},
+		AcquireExec:  "acquire",
+	}
+
+	tests := map[string]struct {
+		config             *common.CustomConfig
+		outputs            map[string]string
+		errs               map[string]error
+		expectedData       common.ExecutorData
+		expectedNoFree     bool
+		expectedError      string
+		expectedExecutable []string
+	}{
+		"no custom config": {},
+		"no hooks": {
+			config: &common.CustomConfig{},
+		},
+		"capacity available": {
+			config:             &common.CustomConfig{CapacityExec: "capacity"},
+			outputs:            map[string]string{"capacity": `{"available": 2}`},
+			expectedExecutable: []string{"capacity"},
+		},
+		"capacity with empty output": {
+			config:             &common.CustomConfig{CapacityExec: "capacity"},
+			expectedExecutable: []string{"capacity"},
+		},
+		"no capacity": {
+			config:             hooksConfig,
+			outputs:            map[string]string{"capacity": `{"available": 0, "message": "quota reached"}`},
+			expectedNoFree:     true,
+			expectedError:      "no capacity: quota reached",
+			expectedExecutable: []string{"capacity"},
+		},
+		"capacity executable failure": {
+			config:             hooksConfig,
+			errs:               map[string]error{"capacity": errors.New("exit status 2")},
+			expectedError:      "checking capacity: exit status 2",
+			expectedExecutable: []string{"capacity"},
+		},
+		"invalid capacity output": {
+			config:             hooksConfig,
+			outputs:            map[string]string{"capacity": `available`},
+			expectedError:      "checking capacity: error while parsing JSON output",
+			expectedExecutable: []string{"capacity"},
+		},
+		"resources acquired with the job": {
+			config: hooksConfig,
+			outputs: map[string]string{
+				"capacity": `{"available": 1}`,
+			},
+			expectedData:       &acquisition{},
+			expectedExecutable: []string{"capacity"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			calls, restore := mockHooks(t, tt.outputs, tt.errs)
+			defer restore()
+
+			p := newExecutorProvider(executors.DefaultExecutorProvider{})
+			runner := &common.RunnerConfig{RunnerSettings: common.RunnerSettings{Custom: tt.config}}
+
+			data, err := p.Acquire(runner)
+
+			var executables []string
+			for _, call := range *calls {
+				executables = append(executables, call.executable)
+			}
+			assert.Equal(t, tt.expectedExecutable, executables)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+
+				var noFreeErr *common.NoFreeExecutorError
+				assert.Equal(t, tt.expectedNoFree, errors.As(err, &noFreeErr))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+		})
+	}
+}
+
+func TestExecutorProvider_AcquireResources(t *testing.T) {
+	tests := map[string]struct {
+		config              *common.CustomConfig
+		acquisition         *acquisition
+		outputs             map[string]string
+		errs                map[string]error
+		expectedAcquisition *acquisition
+		expectedNoFree      bool
+		expectedError       string
+		expectedExecutable  []string
+	}{
+		"resources acquired": {
+			config:      &common.CustomConfig{AcquireExec: "acquire", AcquireArgs: []string{"--job"}},
+			acquisition: &acquisition{},
+			outputs: map[string]string{
+				"acquire": `{"acquired": true, "id": "vm-1", "job_env": {"VM_IP": "10.0.0.1"}}`,
+			},
+			expectedAcquisition: &acquisition{
+				acquired: true,
+				id:       "vm-1",
+				jobEnv:   map[string]string{"VM_IP": "10.0.0.1"},
+			},
+			expectedExecutable: []string{"acquire"},
+		},
+		"resources already acquired": {
+			config:              &common.CustomConfig{AcquireExec: "acquire"},
+			acquisition:         &acquisition{acquired: true, id: "vm-1"},
+			expectedAcquisition: &acquisition{acquired: true, id: "vm-1"},
+		},
+		"resources not acquired": {
+			config:      &common.CustomConfig{AcquireExec: "acquire"},
+			acquisition: &acquisition{},
+			outputs: map[string]string{
+				"acquire": `{"acquired": false, "message": "no VM left"}`,
+			},
+			expectedNoFree:     true,
+			expectedError:      "no resources acquired: no VM left",
+			expectedExecutable: []string{"acquire"},
+		},
+		"acquire executable failure": {
+			config:             &common.CustomConfig{AcquireExec: "acquire"},
+			acquisition:        &acquisition{},
+			errs:               map[string]error{"acquire": errors.New("exit status 2")},
+			expectedError:      "exit status 2",
+			expectedExecutable: []string{"acquire"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			calls, restore := mockHooks(t, tt.outputs, tt.errs)
+			defer restore()
+
+			p := newExecutorProvider(executors.DefaultExecutorProvider{})
+			runner := &common.RunnerConfig{RunnerSettings: common.RunnerSettings{Custom: tt.config}}
+
+			err := p.acquire(runner, tt.acquisition)
+
+			var executables []string
+			for _, call := range *calls {
+				executables = append(executables, call.executable)
+			}
+			assert.Equal(t, tt.expectedExecutable, executables)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+
+				var noFreeErr *common.NoFreeExecutorError
+				assert.Equal(t, tt.expectedNoFree, errors.As(err, &noFreeErr))
+				assert.False(t, tt.acquisition.acquired)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedAcquisition, tt.acquisition)
+		})
+	}
+}
+
+func TestExecutorProvider_Release(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.CustomConfig
+		data          common.ExecutorData
+		expectedCalls []hookCall
+	}{
+		"no acquisition": {
+			config: &common.CustomConfig{ReleaseExec: "release"},
+		},
+		"no release executable": {
+			config: &common.CustomConfig{},
+			data:   &acquisition{acquired: true, id: "vm-1"},
+		},
+		"no job received": {
+			config: &common.CustomConfig{ReleaseExec: "release"},
+			data:   &acquisition{},
+		},
+		"acquisition released": {
+			config: &common.CustomConfig{ReleaseExec: "release", ReleaseArgs: []string{"--force"}},
+			data:   &acquisition{acquired: true, id: "vm-1", jobEnv: map[string]string{"VM_IP": "10.0.0.1"}},
+			expectedCalls: []hookCall{
+				{
+					executable: "release",
+					args:       []string{"--force"},
+					env:        []string{"CUSTOM_ACQUIRED_ID=vm-1", "VM_IP=10.0.0.1"},
+				},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			calls, restore := mockHooks(t, nil, nil)
+			defer restore()
+
+			p := newExecutorProvider(executors.DefaultExecutorProvider{})
+			runner := &common.RunnerConfig{RunnerSettings: common.RunnerSettings{Custom: tt.config}}
+
+			p.Release(runner, tt.data)
+
+			assert.Equal(t, tt.expectedCalls, *calls)
+		})
+	}
+}
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// newTestRunnerDriver returns a driver of the runner that exits as soon as its connection is closed
+func newTestRunnerDriver(runner *common.RunnerConfig, users int) (*runnerDriver, *closeRecorder) {
+	closer := new(closeRecorder)
+	exited := make(chan struct{})
+	close(exited)
+
+	d := &driver{
+		options: driverOptions{transport: driverTransportStdio, gracefulKillTimeout: time.Second},
+		closer:  closer,
+		done:    make(chan struct{}),
+		exited:  exited,
+	}
+
+	spec := newDriverSpec(&config{CustomConfig: runner.Custom})
+
+	return &runnerDriver{driver: d, spec: spec, users: users}, closer
+}
+
+func newTestDriverRunner(token string, driverExec string) *common.RunnerConfig {
+	return &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{URL: "https://gitlab.example.com/", Token: token},
+		RunnerSettings: common.RunnerSettings{
+			Custom: &common.CustomConfig{DriverExec: driverExec, DriverScope: driverScopeRunner},
+		},
+	}
+}
+
+func TestExecutorProvider_UpdateRunners(t *testing.T) {
+	unchanged := newTestDriverRunner("unchanged", "driver")
+	changed := newTestDriverRunner("changed", "driver")
+	removed := newTestDriverRunner("removed", "driver")
+	busy := newTestDriverRunner("busy", "driver")
+
+	p := newExecutorProvider(executors.DefaultExecutorProvider{})
+
+	unchangedDriver, unchangedCloser := newTestRunnerDriver(unchanged, 0)
+	changedDriver, changedCloser := newTestRunnerDriver(changed, 0)
+	removedDriver, removedCloser := newTestRunnerDriver(removed, 0)
+	busyDriver, busyCloser := newTestRunnerDriver(busy, 1)
+
+	p.drivers[unchanged.UniqueID()] = unchangedDriver
+	p.drivers[changed.UniqueID()] = changedDriver
+	p.drivers[removed.UniqueID()] = removedDriver
+	p.drivers[busy.UniqueID()] = busyDriver
+
+	p.UpdateRunners([]*common.RunnerConfig{
+		unchanged,
+		newTestDriverRunner("changed", "new-driver"),
+	})
+
+	assert.Equal(t, map[string]*runnerDriver{unchanged.UniqueID(): unchangedDriver}, p.drivers)
+
+	assert.False(t, unchangedCloser.closed)
+	assert.True(t, changedCloser.closed, "driver with a changed configuration is stopped")
+	assert.True(t, removedCloser.closed, "driver of a removed runner is stopped")
+	assert.False(t, busyCloser.closed, "driver used by a job is kept until the job finished")
+	assert.True(t, busyDriver.retired)
+
+	p.releaseDriver(busyDriver)
+	assert.True(t, busyCloser.closed)
+
+	p.UpdateRunners(nil)
+	assert.Empty(t, p.drivers)
+	assert.True(t, unchangedCloser.closed, "all drivers are stopped on exit")
+}
+
+func TestExecutorProvider_ReleaseDriver(t *testing.T) {
+	runner := newTestDriverRunner("token", "driver")
+
+	p := newExecutorProvider(executors.DefaultExecutorProvider{})
+	d, closer := newTestRunnerDriver(runner, 2)
+	p.drivers[runner.UniqueID()] = d
+
+	p.releaseDriver(d)
+	p.releaseDriver(d)
+
+	assert.Equal(t, 0, d.users)
+	assert.False(t, closer.closed, "driver of the runner is kept for the next jobs")
+}
+
+func TestDriverSocketPath(t *testing.T) {
+	first := newTestDriverRunner("first-token", "driver")
+	second := newTestDriverRunner("second-token", "driver")
+
+	firstPath := driverSocketPath(first.UniqueID(), 1)
+
+	assert.Equal(t, os.TempDir(), filepath.Dir(firstPath))
+	assert.NotContains(t, firstPath, "https")
+	assert.NotEqual(t, firstPath, driverSocketPath(second.UniqueID(), 1))
+	assert.NotEqual(t, firstPath, driverSocketPath(first.UniqueID(), 2))
+}
@@ -0,0 +1,157 @@
+package custom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+)
+
+const serviceProbeInterval = time.Second
+
+var serviceVariableNameRegexp = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// probeServicePort is replaced in tests
+var probeServicePort = func(ctx context.Context, address string) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// prepareServices fails the job when the driver reported a service which failed to start,
+// passes the addresses of the services to the job and waits for them to accept connections
+func (e *executor) prepareServices(output *api.PrepareExecOutput) error {
+	if output == nil || len(output.Services) == 0 {
+		return nil
+	}
+
+	for _, service := range output.Services {
+		if service.Error != "" {
+			e.Errorln(fmt.Sprintf("Service %s failed to start: %s", service.Name, service.Error))
+
+			// GitLab only accepts the failure reasons it knows, the services are
+			// started by the driver like the rest of the job environment, so it's
+			// a failure of the runner system rather than of the script
+			return &common.BuildError{
+				Inner:         fmt.Errorf("service %s failed to start: %s", service.Name, service.Error),
+				FailureReason: common.RunnerSystemFailure,
+			}
+		}
+	}
+
+	var variables common.JobVariables
+	for _, service := range output.Services {
+		variables = append(variables, serviceVariables(service)...)
+	}
+	e.Build.AddVariables(variables...)
+
+	e.waitForServices(output.Services)
+
+	return nil
+}
+
+// serviceVariables returns the CI_SERVICE_<ALIAS>_HOST and CI_SERVICE_<ALIAS>_PORT variables of the service
+func serviceVariables(service api.ServiceInfo) common.JobVariables {
+	name := serviceVariableName(service)
+	if name == "" {
+		return nil
+	}
+
+	variables := common.JobVariables{
+		{Key: "CI_SERVICE_" + name + "_HOST", Value: service.Host, Public: true, Internal: true},
+	}
+
+	if len(service.Ports) > 0 {
+		variables = append(variables, common.JobVariable{
+			Key:      "CI_SERVICE_" + name + "_PORT",
+			Value:    strconv.Itoa(service.Ports[0]),
+			Public:   true,
+			Internal: true,
+		})
+	}
+
+	return variables
+}
+
+func serviceVariableName(service api.ServiceInfo) string {
+	name := service.Alias
+	if name == "" {
+		// use the name of the image without the registry, the path and the tag,
+		// like the default alias of the services of the Docker executor
+		name = service.Name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if i := strings.IndexAny(name, ":@"); i >= 0 {
+			name = name[:i]
+		}
+	}
+
+	name = serviceVariableNameRegexp.ReplaceAllString(strings.ToUpper(name), "_")
+
+	return strings.Trim(name, "_")
+}
+
+// waitForServices waits for the ports of the services to accept connections. Like the
+// Docker executor, it only prints a warning for the services which aren't ready in time.
+func (e *executor) waitForServices(services []api.ServiceInfo) {
+	timeout := e.config.GetWaitForServicesTimeout()
+	if timeout <= 0 {
+		return
+	}
+
+	ctx, cancelFunc := context.WithTimeout(e.Context, timeout)
+	defer cancelFunc()
+
+	e.Println("Waiting for services to be up and running...")
+
+	var wg sync.WaitGroup
+	for _, service := range services {
+		for _, port := range service.Ports {
+			wg.Add(1)
+			go func(service api.ServiceInfo, port int) {
+				defer wg.Done()
+
+				address := net.JoinHostPort(service.Host, strconv.Itoa(port))
+
+				err := waitForServicePort(ctx, address)
+				if err != nil {
+					e.Warningln(fmt.Sprintf(
+						"Service %s probably didn't start properly, %s didn't accept connections: %v",
+						service.Name,
+						address,
+						err,
+					))
+				}
+			}(service, port)
+		}
+	}
+	wg.Wait()
+}
+
+func waitForServicePort(ctx context.Context, address string) error {
+	for {
+		err := probeServicePort(ctx, address)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(serviceProbeInterval):
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//go:build !integration
+// +build !integration
+
+package custom
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+func TestServiceVariables(t *testing.T) {
+	tests := map[string]struct {
+		service           api.ServiceInfo
+		expectedVariables common.JobVariables
+	}{
+		"alias": {
+			service: api.ServiceInfo{Name: "postgres:13", Alias: "db", Host: "10.0.0.2", Ports: []int{5432, 5433}},
+			expectedVariables: common.JobVariables{
+				{Key: "CI_SERVICE_DB_HOST", Value: "10.0.0.2", Public: true, Internal: true},
+				{Key: "CI_SERVICE_DB_PORT", Value: "5432", Public: true, Internal: true},
+			},
+		},
+		"name of the image": {
+			service: api.ServiceInfo{Name: "registry.example.com/tools/redis-cache:6", Host: "10.0.0.3"},
+			expectedVariables: common.JobVariables{
+				{Key: "CI_SERVICE_REDIS_CACHE_HOST", Value: "10.0.0.3", Public: true, Internal: true},
+			},
+		},
+		"no name": {
+			service: api.ServiceInfo{Host: "10.0.0.4"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expectedVariables, serviceVariables(tt.service))
+		})
+	}
+}
+
+func TestExecutor_PrepareServices(t *testing.T) {
+	tests := map[string]struct {
+		prepareOutput     string
+		unreachable       bool
+		expectedVariables map[string]string
+		expectedOutput    string
+		expectedErr       error
+	}{
+		"no prepare output": {},
+		"services ready": {
+			prepareOutput: `{"services": [{"name": "postgres:13", "alias": "db", "host": "10.0.0.2", "ports": [5432]}]}`,
+			expectedVariables: map[string]string{
+				"CI_SERVICE_DB_HOST": "10.0.0.2",
+				"CI_SERVICE_DB_PORT": "5432",
+			},
+			expectedOutput: "Waiting for services to be up and running...",
+		},
+		"service not ready": {
+			prepareOutput: `{"services": [{"name": "postgres:13", "host": "10.0.0.2", "ports": [5432]}]}`,
+			unreachable:   true,
+			expectedVariables: map[string]string{
+				"CI_SERVICE_POSTGRES_HOST": "10.0.0.2",
+			},
+			expectedOutput: "Service postgres:13 probably didn't start properly, 10.0.0.2:5432 didn't accept connections",
+		},
+		"service failed": {
+			prepareOutput:  `{"services": [{"name": "postgres:13", "error": "image not found"}]}`,
+			expectedErr:    &common.BuildError{FailureReason: common.RunnerSystemFailure},
+			expectedOutput: "Service postgres:13 failed to start: image not found",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			oldProbe := probeServicePort
+			defer func() { probeServicePort = oldProbe }()
+			probeServicePort = func(ctx context.Context, address string) error {
+				if tt.unreachable {
+					return errors.New("connection refused")
+				}
+
+				return nil
+			}
+
+			timeout := 1
+			etc := executorTestCase{
+				config: getRunnerConfig(&common.CustomConfig{
+					RunExec:                "bash",
+					PrepareExec:            "echo",
+					WaitForServicesTimeout: &timeout,
+				}),
+				assertCommandFactory: func(
+					t *testing.T,
+					_ executorTestCase,
+					ctx context.Context,
+					executable string,
+					args []string,
+					cmdOpts process.CommandOptions,
+					options command.Options,
+				) {
+					for _, env := range cmdOpts.Env {
+						if !strings.HasPrefix(env, api.PrepareOutputFileVariable+"=") || tt.prepareOutput == "" {
+							continue
+						}
+
+						path := strings.TrimPrefix(env, api.PrepareOutputFileVariable+"=")
+						require.NoError(t, ioutil.WriteFile(path, []byte(tt.prepareOutput), 0600))
+					}
+				},
+			}
+
+			defer mockCommandFactory(t, etc)()
+
+			e, options, out := prepareExecutor(t, etc)
+			defer e.Cleanup()
+
+			err := e.Prepare(options)
+			if tt.expectedErr != nil {
+				assert.True(t, errors.Is(err, tt.expectedErr), "expected %v, got %v", tt.expectedErr, err)
+				assert.Contains(t, out.String(), tt.expectedOutput)
+				return
+			}
+
+			require.NoError(t, err)
+
+			variables := e.Build.GetAllVariables()
+			for key, value := range tt.expectedVariables {
+				assert.Equal(t, value, variables.Get(key))
+			}
+
+			assert.Contains(t, out.String(), tt.expectedOutput)
+		})
+	}
+}
@@ -12,3 +12,7 @@ const osTypeLinux = "linux"
 const osTypeWindows = "windows"
 
 const metadataOSType = "OSType"
+
+// dockerDesktopOperatingSystem is the operating system reported by Docker Desktop,
+// which runs the containers in a virtual machine
+const dockerDesktopOperatingSystem = "Docker Desktop"
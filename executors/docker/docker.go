@@ -538,6 +538,7 @@ func (e *executor) createFromServiceDefinition(
 			e.Debugln("Created service", serviceDefinition.Name, "as", container.ID)
 			e.services = append(e.services, container)
 			e.temporary = append(e.temporary, container.ID)
+			e.addServiceProxy(serviceIndex, serviceDefinition, container.ID)
 		}
 		linksMap[linkName] = container
 	}
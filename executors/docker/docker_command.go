@@ -63,6 +63,8 @@ func (s *commandExecutor) Prepare(options common.ExecutorPrepareOptions) error {
 		s.Println("Not using umask - FF_DISABLE_UMASK_FOR_DOCKER_EXECUTOR is set!")
 	}
 
+	s.addBuildProxy()
+
 	return nil
 }
 
@@ -346,6 +348,7 @@ func init() {
 		features.Session = true
 		features.Terminal = true
 		features.ServiceVariables = true
+		features.Proxy = true
 	}
 
 	common.RegisterExecutorProvider("docker", executors.DefaultExecutorProvider{
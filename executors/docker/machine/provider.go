@@ -564,7 +564,12 @@ func (m *machineProvider) CanCreate() bool {
 }
 
 func (m *machineProvider) GetFeatures(features *common.FeaturesInfo) error {
-	return m.provider.GetFeatures(features)
+	err := m.provider.GetFeatures(features)
+
+	// The containers run on the machines, which the runner can't reach the ports of
+	features.Proxy = false
+
+	return err
 }
 
 func (m *machineProvider) GetConfigInfo(input *common.RunnerConfig, output *common.ConfigInfo) {
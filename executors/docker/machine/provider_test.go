@@ -762,3 +762,25 @@ func TestIntermediateMachineList(t *testing.T) {
 	intermediateMachine := p.intermediateMachineList([]string{"machine1", "machine2"})
 	assert.Equal(t, expectedIntermediateMachines, intermediateMachine)
 }
+
+func TestMachineProviderGetFeatures(t *testing.T) {
+	executorProvider := new(common.MockExecutorProvider)
+	defer executorProvider.AssertExpectations(t)
+
+	executorProvider.On("GetFeatures", mock.Anything).
+		Run(func(args mock.Arguments) {
+			features := args.Get(0).(*common.FeaturesInfo)
+			features.Services = true
+			features.Proxy = true
+		}).
+		Return(nil).
+		Once()
+
+	p := &machineProvider{provider: executorProvider}
+
+	var features common.FeaturesInfo
+	require.NoError(t, p.GetFeatures(&features))
+
+	assert.True(t, features.Services)
+	assert.False(t, features.Proxy, "the ports of the containers on the machines can't be proxied")
+}
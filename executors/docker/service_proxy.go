@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+func (s *commandExecutor) Pool() proxy.Pool {
+	return s.ProxyPool
+}
+
+// proxyUnavailableReason returns why the containers of the job can't be reached from the runner
+// host, when the Docker daemon runs on another host, e.g. with docker+machine, or in a virtual
+// machine like Docker Desktop. Their ports are then not proxied.
+func (e *executor) proxyUnavailableReason() string {
+	host := e.Config.Docker.Host
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+
+	if !isLocalDockerHost(host) {
+		return fmt.Sprintf("the Docker daemon %s is not local", host)
+	}
+
+	if e.info.OperatingSystem == dockerDesktopOperatingSystem {
+		return "the containers of Docker Desktop are not reachable from the host"
+	}
+
+	return ""
+}
+
+// isLocalDockerHost returns true when the Docker daemon runs on the runner host
+func isLocalDockerHost(host string) bool {
+	if host == "" {
+		return true
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case "unix", "npipe":
+		return true
+	case "tcp", "http", "https":
+		ip := net.ParseIP(u.Hostname())
+		return u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())
+	}
+
+	return false
+}
+
+// warnProxyUnavailable tells the user why the ports of a service aren't proxied
+func (e *executor) warnProxyUnavailable(serviceName string, ports []common.Port) bool {
+	reason := e.proxyUnavailableReason()
+	if reason == "" {
+		return false
+	}
+
+	if len(ports) > 0 {
+		e.Warningln(fmt.Sprintf("The ports of %s can't be proxied: %s", serviceName, reason))
+	}
+
+	return true
+}
+
+// addBuildProxy registers the ports of the job image. The build container is
+// created when the first stage runs, so it's looked up on every request.
+func (s *commandExecutor) addBuildProxy() {
+	if s.warnProxyUnavailable("the job image", s.Build.Image.Ports) {
+		return
+	}
+
+	s.AddBuildProxy(func(ctx context.Context, _ *proxy.Settings, port proxy.Port) (net.Conn, error) {
+		buildContainer := s.getBuildContainer()
+		if buildContainer == nil {
+			return nil, fmt.Errorf("build container not created: %w", proxy.ErrServiceNotReady)
+		}
+
+		return s.dialContainer(ctx, buildContainer.ID, port.Number)
+	})
+}
+
+// addServiceProxy registers the ports of a service, it's named like the
+// Kubernetes executor names it so jobs can use the same name on both
+func (e *executor) addServiceProxy(serviceIndex int, definition common.Image, containerID string) {
+	serviceName := definition.Alias
+	if serviceName == "" {
+		serviceName = fmt.Sprintf("proxy-svc-%d", serviceIndex)
+	}
+
+	if e.warnProxyUnavailable(fmt.Sprintf("service %s", definition.Name), definition.Ports) {
+		return
+	}
+
+	e.AddProxy(serviceName, definition.Ports, func(ctx context.Context, _ *proxy.Settings, port proxy.Port) (net.Conn, error) {
+		return e.dialContainer(ctx, containerID, port.Number)
+	})
+}
+
+func (e *executor) dialContainer(ctx context.Context, containerID string, port int) (net.Conn, error) {
+	container, err := e.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting container %s: %w", containerID, err)
+	}
+
+	if container.State == nil || !container.State.Running {
+		return nil, fmt.Errorf("container %s is not running: %w", containerID, proxy.ErrServiceNotReady)
+	}
+
+	address := e.containerIPAddress(container)
+	if address == "" {
+		return nil, fmt.Errorf("container %s has no IP address: %w", containerID, proxy.ErrServiceNotReady)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+}
+
+// containerIPAddress returns the address of the container in the network of
+// the job
+func (e *executor) containerIPAddress(container types.ContainerJSON) string {
+	if e.networkMode.IsHost() {
+		return "127.0.0.1"
+	}
+
+	if container.NetworkSettings == nil {
+		return ""
+	}
+
+	if network, ok := container.NetworkSettings.Networks[e.networkMode.UserDefined()]; ok && network.IPAddress != "" {
+		return network.IPAddress
+	}
+
+	if container.NetworkSettings.IPAddress != "" {
+		return container.NetworkSettings.IPAddress
+	}
+
+	for _, network := range container.NetworkSettings.Networks {
+		if network != nil && network.IPAddress != "" {
+			return network.IPAddress
+		}
+	}
+
+	return ""
+}
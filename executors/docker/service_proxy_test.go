@@ -0,0 +1,168 @@
+//go:build !integration
+// +build !integration
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+func TestContainerIPAddress(t *testing.T) {
+	tests := map[string]struct {
+		networkMode     container.NetworkMode
+		networkSettings *types.NetworkSettings
+		expectedAddress string
+	}{
+		"host network": {
+			networkMode:     "host",
+			expectedAddress: "127.0.0.1",
+		},
+		"no network settings": {
+			networkMode: "bridge",
+		},
+		"default bridge": {
+			networkMode: "bridge",
+			networkSettings: &types.NetworkSettings{
+				DefaultNetworkSettings: types.DefaultNetworkSettings{IPAddress: "172.17.0.2"},
+			},
+			expectedAddress: "172.17.0.2",
+		},
+		"network per build": {
+			networkMode: "runner-net",
+			networkSettings: &types.NetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"other":      {IPAddress: "10.0.0.2"},
+					"runner-net": {IPAddress: "172.18.0.3"},
+				},
+			},
+			expectedAddress: "172.18.0.3",
+		},
+		"only other networks": {
+			networkMode: "bridge",
+			networkSettings: &types.NetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"other": {IPAddress: "10.0.0.2"},
+				},
+			},
+			expectedAddress: "10.0.0.2",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			e := &executor{networkMode: tt.networkMode}
+
+			address := e.containerIPAddress(types.ContainerJSON{NetworkSettings: tt.networkSettings})
+			assert.Equal(t, tt.expectedAddress, address)
+		})
+	}
+}
+
+func newServiceProxyTestExecutor(config *common.DockerConfig, info types.Info) *executor {
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{ProxyPool: proxy.NewPool()},
+		info:             info,
+	}
+	e.Config.Docker = config
+	e.Build = &common.Build{}
+
+	return e
+}
+
+func TestServiceProxy(t *testing.T) {
+	c := new(docker.MockClient)
+	defer c.AssertExpectations(t)
+
+	t.Setenv("DOCKER_HOST", "")
+
+	e := newServiceProxyTestExecutor(&common.DockerConfig{}, types.Info{})
+	e.client = c
+
+	ports := []common.Port{{Number: 80, Protocol: "http", Name: "web"}}
+	e.addServiceProxy(0, common.Image{Name: "nginx", Ports: ports}, "nginx-id")
+	e.addServiceProxy(1, common.Image{Name: "nginx", Alias: "web", Ports: ports}, "web-id")
+	e.addServiceProxy(2, common.Image{Name: "postgres"}, "postgres-id")
+
+	require.Len(t, e.ProxyPool, 2)
+	require.Contains(t, e.ProxyPool, "proxy-svc-0")
+	require.Contains(t, e.ProxyPool, "web")
+	assert.Equal(t, []proxy.Port{{Number: 80, Protocol: "http", Name: "web"}}, e.ProxyPool["web"].Settings.Ports)
+
+	c.On("ContainerInspect", mock.Anything, "web-id").
+		Return(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    "web-id",
+			State: &types.ContainerState{Running: false},
+		}}, nil).
+		Once()
+
+	_, err := e.dialContainer(context.Background(), "web-id", 80)
+	assert.True(t, errors.Is(err, proxy.ErrServiceNotReady), "unexpected error: %v", err)
+}
+
+func TestServiceProxyUnavailable(t *testing.T) {
+	ports := []common.Port{{Number: 80, Protocol: "http", Name: "web"}}
+
+	tests := map[string]struct {
+		host           string
+		envHost        string
+		info           types.Info
+		expectedReason string
+	}{
+		"default socket": {},
+		"unix socket": {
+			host: "unix:///var/run/docker.sock",
+		},
+		"windows named pipe": {
+			host: "npipe:////./pipe/docker_engine",
+		},
+		"local TCP daemon": {
+			host: "tcp://127.0.0.1:2375",
+		},
+		"remote TCP daemon": {
+			host:           "tcp://10.0.0.5:2376",
+			expectedReason: "the Docker daemon tcp://10.0.0.5:2376 is not local",
+		},
+		"remote daemon from the environment": {
+			envHost:        "ssh://user@docker-host",
+			expectedReason: "the Docker daemon ssh://user@docker-host is not local",
+		},
+		"Docker Desktop": {
+			info:           types.Info{OperatingSystem: "Docker Desktop"},
+			expectedReason: "the containers of Docker Desktop are not reachable from the host",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			t.Setenv("DOCKER_HOST", tt.envHost)
+
+			config := &common.DockerConfig{}
+			config.Host = tt.host
+
+			e := newServiceProxyTestExecutor(config, tt.info)
+
+			assert.Equal(t, tt.expectedReason, e.proxyUnavailableReason())
+
+			e.addServiceProxy(0, common.Image{Name: "nginx", Alias: "web", Ports: ports}, "web-id")
+			if tt.expectedReason != "" {
+				assert.Empty(t, e.ProxyPool)
+			} else {
+				assert.Contains(t, e.ProxyPool, "web")
+			}
+		})
+	}
+}
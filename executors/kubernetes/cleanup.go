@@ -0,0 +1,407 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	// runnerIDLabel and jobIDLabel are set on every resource created by the executor,
+	// so that resources leaked by a runner that died can be found and deleted
+	runnerIDLabel = "runner.gitlab.com/runner-id"
+	jobIDLabel    = "runner.gitlab.com/job-id"
+)
+
+// runnerIDLabelValue returns the value of the runner ID label for the runner
+func runnerIDLabelValue(runner *common.RunnerCredentials) string {
+	return sanitizeLabel(runner.ShortDescription())
+}
+
+// jobTracker holds the IDs of the jobs handled by executors of this process for every runner,
+// and the namespaces their resources were created in
+type jobTracker struct {
+	lock       sync.Mutex
+	jobs       map[string]map[string]bool
+	namespaces map[string]map[string]bool
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{
+		jobs:       make(map[string]map[string]bool),
+		namespaces: make(map[string]map[string]bool),
+	}
+}
+
+func (t *jobTracker) add(runnerID string, jobID int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.jobs[runnerID] == nil {
+		t.jobs[runnerID] = make(map[string]bool)
+	}
+	t.jobs[runnerID][strconv.FormatInt(jobID, 10)] = true
+}
+
+func (t *jobTracker) remove(runnerID string, jobID int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.jobs[runnerID], strconv.FormatInt(jobID, 10))
+}
+
+func (t *jobTracker) has(runnerID string, jobID string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.jobs[runnerID][jobID]
+}
+
+// addNamespace records a namespace the runner created resources in, so that it's
+// also swept for leaked resources when the namespace was overwritten by a job
+func (t *jobTracker) addNamespace(runnerID string, namespace string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.namespaces[runnerID] == nil {
+		t.namespaces[runnerID] = make(map[string]bool)
+	}
+	t.namespaces[runnerID][namespace] = true
+}
+
+func (t *jobTracker) namespacesOf(runnerID string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	namespaces := make([]string, 0, len(t.namespaces[runnerID]))
+	for namespace := range t.namespaces[runnerID] {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+// LeakedResource describes a resource created for a job that's no longer handled by the runner
+type LeakedResource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	JobID     string
+	Age       time.Duration
+}
+
+func (r LeakedResource) String() string {
+	return fmt.Sprintf("%s %s/%s (job %s, age %s)", r.Kind, r.Namespace, r.Name, r.JobID, r.Age.Round(time.Second))
+}
+
+// resourceCleaner finds and deletes the pods, secrets, config maps and services labeled with the runner ID
+// that belong to jobs the runner doesn't handle anymore. Resources owned by another resource, e.g. the
+// scripts config map owned by the build pod, are left to the Kubernetes garbage collector.
+type resourceCleaner struct {
+	client      kubernetes.Interface
+	namespace   string
+	runnerID    string
+	gracePeriod time.Duration
+	dryRun      bool
+
+	// isTracked reports resources that are still in use. When nil, no resource is in use.
+	isTracked func(kind string, obj metav1.Object) bool
+
+	logger logrus.FieldLogger
+}
+
+type cleanupResourceType struct {
+	kind   string
+	list   func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error)
+	delete func(ctx context.Context, name string) error
+}
+
+//nolint:funlen
+func (c *resourceCleaner) resourceTypes() []cleanupResourceType {
+	core := c.client.CoreV1()
+
+	return []cleanupResourceType{
+		{
+			kind: "pod",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := core.Pods(c.namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				objects := make([]metav1.Object, len(list.Items))
+				for i := range list.Items {
+					objects[i] = &list.Items[i]
+				}
+				return objects, nil
+			},
+			delete: func(ctx context.Context, name string) error {
+				return core.Pods(c.namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &PropagationPolicy})
+			},
+		},
+		{
+			kind: "secret",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := core.Secrets(c.namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				objects := make([]metav1.Object, len(list.Items))
+				for i := range list.Items {
+					objects[i] = &list.Items[i]
+				}
+				return objects, nil
+			},
+			delete: func(ctx context.Context, name string) error {
+				return core.Secrets(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			kind: "configmap",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := core.ConfigMaps(c.namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				objects := make([]metav1.Object, len(list.Items))
+				for i := range list.Items {
+					objects[i] = &list.Items[i]
+				}
+				return objects, nil
+			},
+			delete: func(ctx context.Context, name string) error {
+				return core.ConfigMaps(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			kind: "service",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := core.Services(c.namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				objects := make([]metav1.Object, len(list.Items))
+				for i := range list.Items {
+					objects[i] = &list.Items[i]
+				}
+				return objects, nil
+			},
+			delete: func(ctx context.Context, name string) error {
+				return core.Services(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+	}
+}
+
+// Cleanup deletes the leaked resources, or only lists them in dry run mode.
+// It returns the leaked resources that were found.
+func (c *resourceCleaner) Cleanup(ctx context.Context) ([]LeakedResource, error) {
+	selector := labels.SelectorFromSet(labels.Set{runnerIDLabel: c.runnerID}).String()
+
+	var leaked []LeakedResource
+	for _, resourceType := range c.resourceTypes() {
+		objects, err := resourceType.list(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return leaked, fmt.Errorf("listing %ss: %w", resourceType.kind, err)
+		}
+
+		for _, obj := range objects {
+			resource, ok := c.checkLeaked(resourceType.kind, obj)
+			if !ok {
+				continue
+			}
+
+			leaked = append(leaked, resource)
+			c.delete(ctx, resourceType, resource)
+		}
+	}
+
+	return leaked, nil
+}
+
+func (c *resourceCleaner) checkLeaked(kind string, obj metav1.Object) (LeakedResource, bool) {
+	resource := LeakedResource{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		JobID:     obj.GetLabels()[jobIDLabel],
+		Age:       time.Since(obj.GetCreationTimestamp().Time),
+	}
+
+	switch {
+	case len(obj.GetOwnerReferences()) > 0:
+		return resource, false
+	case obj.GetDeletionTimestamp() != nil:
+		return resource, false
+	case resource.Age < c.gracePeriod:
+		return resource, false
+	case c.isTracked != nil && c.isTracked(kind, obj):
+		return resource, false
+	}
+
+	return resource, true
+}
+
+func (c *resourceCleaner) delete(ctx context.Context, resourceType cleanupResourceType, resource LeakedResource) {
+	logger := c.logger.WithField("resource", resource.String())
+
+	if c.dryRun {
+		logger.Infoln("Found leaked resource")
+		return
+	}
+
+	err := resourceType.delete(ctx, resource.Name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		logger.WithError(err).Warningln("Deleting leaked resource")
+		return
+	}
+
+	logger.Infoln("Deleted leaked resource")
+}
+
+// CleanupLeakedResources deletes the resources created by the runner for jobs that are no longer running,
+// or only lists them in dry run mode. As it doesn't know which jobs the runner is handling, it should be
+// used when the runner is stopped or with a grace period longer than the longest running job.
+// Besides the namespace of the runner, the given namespaces are swept, e.g. the ones jobs
+// selected with namespace_overwrite_allowed.
+func CleanupLeakedResources(
+	ctx context.Context,
+	runner *common.RunnerConfig,
+	namespaces []string,
+	gracePeriod time.Duration,
+	dryRun bool,
+) ([]LeakedResource, error) {
+	if runner.Kubernetes == nil {
+		return nil, fmt.Errorf("runner %s isn't using the kubernetes executor", runner.ShortDescription())
+	}
+
+	cleaners, err := newResourceCleaners(runner, namespaces, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaked []LeakedResource
+	for _, cleaner := range cleaners {
+		cleaner.gracePeriod = gracePeriod
+		cleaner.dryRun = dryRun
+
+		resources, err := cleaner.Cleanup(ctx)
+		leaked = append(leaked, resources...)
+		if err != nil {
+			return leaked, err
+		}
+	}
+
+	return leaked, nil
+}
+
+// newResourceCleaners returns a cleaner for every namespace in the cluster of the runner,
+// or in every cluster when the runner lists several clusters. The namespace of the runner
+// is always swept, in addition to the given namespaces.
+func newResourceCleaners(
+	runner *common.RunnerConfig,
+	namespaces []string,
+	isTracked func(kind string, obj metav1.Object) bool,
+) ([]*resourceCleaner, error) {
+	namespaces = cleanupNamespaces(runner.Kubernetes, namespaces)
+
+	if len(runner.Kubernetes.Clusters) == 0 {
+		kubeConfig, err := getKubeClientConfig(runner.Kubernetes, &overwrites{})
+		if err != nil {
+			return nil, fmt.Errorf("getting Kubernetes config: %w", err)
+		}
+
+		return newResourceCleaner(runner, kubeConfig, namespaces, runner.Log(), isTracked)
+	}
+
+	var cleaners []*resourceCleaner
+	for _, cluster := range runner.Kubernetes.Clusters {
+		kubeConfig, err := loadClusterConfig(cluster)
+		if err != nil {
+			return nil, fmt.Errorf("getting Kubernetes config of cluster %q: %w", cluster.GetName(), err)
+		}
+
+		logger := runner.Log().WithField("cluster", cluster.GetName())
+
+		clusterCleaners, err := newResourceCleaner(runner, kubeConfig, namespaces, logger, isTracked)
+		if err != nil {
+			return nil, err
+		}
+
+		cleaners = append(cleaners, clusterCleaners...)
+	}
+
+	return cleaners, nil
+}
+
+// cleanupNamespaces returns the namespace of the runner followed by the other given namespaces
+func cleanupNamespaces(config *common.KubernetesConfig, namespaces []string) []string {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	result := []string{namespace}
+	seen := map[string]bool{namespace: true}
+	for _, namespace := range namespaces {
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+
+		seen[namespace] = true
+		result = append(result, namespace)
+	}
+
+	return result
+}
+
+func newResourceCleaner(
+	runner *common.RunnerConfig,
+	kubeConfig *restclient.Config,
+	namespaces []string,
+	logger logrus.FieldLogger,
+	isTracked func(kind string, obj metav1.Object) bool,
+) ([]*resourceCleaner, error) {
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Kubernetes: %w", err)
+	}
+
+	cleaners := make([]*resourceCleaner, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		cleaners = append(cleaners, &resourceCleaner{
+			client:      client,
+			namespace:   namespace,
+			runnerID:    runnerIDLabelValue(&runner.RunnerCredentials),
+			gracePeriod: runner.Kubernetes.ResourceCleanup.GetGracePeriod(),
+			isTracked:   isTracked,
+			logger:      logger.WithField("namespace", namespace),
+		})
+	}
+
+	return cleaners, nil
+}
+
+// resourceLabels returns the labels identifying the resources created for the job
+func (s *executor) resourceLabels() map[string]string {
+	return map[string]string{
+		runnerIDLabel: runnerIDLabelValue(&s.Build.Runner.RunnerCredentials),
+		jobIDLabel:    strconv.FormatInt(s.Build.JobResponse.ID, 10),
+	}
+}
@@ -0,0 +1,171 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func newLeakTestMeta(name string, runnerID string, jobID string, age time.Duration) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "default",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		Labels: map[string]string{
+			runnerIDLabel: runnerID,
+			jobIDLabel:    jobID,
+		},
+	}
+}
+
+func newLeakTestClient() *fake.Clientset {
+	owned := newLeakTestMeta("owned-scripts", "runner", "1", time.Hour)
+	owned.OwnerReferences = []metav1.OwnerReference{{Kind: ownerReferenceKind, Name: "leaked-pod"}}
+
+	objects := []runtime.Object{
+		&api.Pod{ObjectMeta: newLeakTestMeta("leaked-pod", "runner", "1", time.Hour)},
+		&api.Pod{ObjectMeta: newLeakTestMeta("running-pod", "runner", "2", time.Hour)},
+		&api.Pod{ObjectMeta: newLeakTestMeta("new-pod", "runner", "3", time.Minute)},
+		&api.Pod{ObjectMeta: newLeakTestMeta("other-runner-pod", "other", "4", time.Hour)},
+		&api.Secret{ObjectMeta: newLeakTestMeta("leaked-secret", "runner", "5", time.Hour)},
+		&api.ConfigMap{ObjectMeta: newLeakTestMeta("leaked-scripts", "runner", "5", time.Hour)},
+		&api.ConfigMap{ObjectMeta: owned},
+		&api.Service{ObjectMeta: newLeakTestMeta("leaked-service", "runner", "6", time.Hour)},
+	}
+
+	return fake.NewSimpleClientset(objects...)
+}
+
+func leakedNames(resources []LeakedResource) []string {
+	var names []string
+	for _, resource := range resources {
+		names = append(names, resource.Kind+"/"+resource.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestResourceCleanerCleanup(t *testing.T) {
+	expectedLeaked := []string{
+		"configmap/leaked-scripts",
+		"pod/leaked-pod",
+		"secret/leaked-secret",
+		"service/leaked-service",
+	}
+
+	tests := map[string]struct {
+		dryRun          bool
+		expectedDeleted []string
+	}{
+		"deletes leaked resources": {
+			dryRun:          false,
+			expectedDeleted: expectedLeaked,
+		},
+		"dry run": {
+			dryRun:          true,
+			expectedDeleted: nil,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := newLeakTestClient()
+
+			cleaner := &resourceCleaner{
+				client:      client,
+				namespace:   "default",
+				runnerID:    "runner",
+				gracePeriod: 10 * time.Minute,
+				dryRun:      tt.dryRun,
+				isTracked: func(kind string, obj metav1.Object) bool {
+					return obj.GetLabels()[jobIDLabel] == "2"
+				},
+				logger: logrus.New(),
+			}
+
+			leaked, err := cleaner.Cleanup(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, expectedLeaked, leakedNames(leaked))
+
+			var deleted []string
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "delete" {
+					deleted = append(deleted, action.GetResource().Resource)
+				}
+			}
+			assert.Len(t, deleted, len(tt.expectedDeleted))
+
+			_, err = client.CoreV1().Pods("default").Get(context.Background(), "running-pod", metav1.GetOptions{})
+			assert.NoError(t, err)
+			_, err = client.CoreV1().ConfigMaps("default").Get(context.Background(), "owned-scripts", metav1.GetOptions{})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestJobTracker(t *testing.T) {
+	tracker := newJobTracker()
+
+	tracker.add("runner", 1)
+	tracker.add("runner", 2)
+	tracker.add("other", 3)
+
+	assert.True(t, tracker.has("runner", "1"))
+	assert.True(t, tracker.has("runner", "2"))
+	assert.False(t, tracker.has("runner", "3"))
+	assert.False(t, tracker.has("unknown", "1"))
+
+	tracker.remove("runner", 1)
+	assert.False(t, tracker.has("runner", "1"))
+
+	tracker.addNamespace("runner", "team-b")
+	tracker.addNamespace("runner", "team-a")
+	tracker.addNamespace("runner", "team-b")
+	tracker.addNamespace("other", "team-c")
+
+	assert.Equal(t, []string{"team-a", "team-b"}, tracker.namespacesOf("runner"))
+	assert.Empty(t, tracker.namespacesOf("unknown"))
+}
+
+func TestCleanupNamespaces(t *testing.T) {
+	tests := map[string]struct {
+		namespace  string
+		namespaces []string
+		expected   []string
+	}{
+		"default namespace": {
+			expected: []string{"default"},
+		},
+		"configured namespace": {
+			namespace: "ci",
+			expected:  []string{"ci"},
+		},
+		"overwritten namespaces": {
+			namespace:  "ci",
+			namespaces: []string{"team-a", "ci", "", "team-b", "team-a"},
+			expected:   []string{"ci", "team-a", "team-b"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := &common.KubernetesConfig{Namespace: tt.namespace}
+			assert.Equal(t, tt.expected, cleanupNamespaces(config, tt.namespaces))
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	api "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+var (
+	errNoHealthyCluster = errors.New("no healthy Kubernetes cluster available")
+	errNoClusterQuota   = errors.New("no quota available")
+)
+
+type clusterHealthState struct {
+	checked time.Time
+	err     error
+}
+
+// clusterRegistry is shared by all Kubernetes executors. It caches the health of the clusters
+// listed in the runners configuration and holds the metrics of the pods placed in them.
+type clusterRegistry struct {
+	lock   sync.Mutex
+	health map[string]*clusterHealthState
+
+	loadConfig  func(cluster common.KubernetesCluster) (*restclient.Config, error)
+	checkHealth func(ctx context.Context, config *restclient.Config) error
+
+	placements *prometheus.CounterVec
+	failovers  *prometheus.CounterVec
+	healthy    *prometheus.GaugeVec
+}
+
+func newClusterRegistry() *clusterRegistry {
+	return &clusterRegistry{
+		health:      make(map[string]*clusterHealthState),
+		loadConfig:  loadClusterConfig,
+		checkHealth: checkClusterHealth,
+		placements: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_kubernetes_cluster_placements_total",
+				Help: "The total number of build pods placed in a Kubernetes cluster.",
+			},
+			[]string{"runner", "cluster"},
+		),
+		failovers: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_kubernetes_cluster_failovers_total",
+				Help: "The total number of jobs that fell back to the next cluster after failing to create " +
+					"or schedule the build pod in a Kubernetes cluster.",
+			},
+			[]string{"runner", "cluster"},
+		),
+		healthy: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gitlab_runner_kubernetes_cluster_healthy",
+				Help: "Whether the last health check of a Kubernetes cluster succeeded.",
+			},
+			[]string{"runner", "cluster"},
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *clusterRegistry) Describe(ch chan<- *prometheus.Desc) {
+	r.placements.Describe(ch)
+	r.failovers.Describe(ch)
+	r.healthy.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *clusterRegistry) Collect(ch chan<- prometheus.Metric) {
+	r.placements.Collect(ch)
+	r.failovers.Collect(ch)
+	r.healthy.Collect(ch)
+}
+
+// check returns the result of the last health check of the cluster, or checks it again
+// when the result is older than the health check interval of the runner
+func (r *clusterRegistry) check(
+	runner *common.RunnerConfig,
+	cluster common.KubernetesCluster,
+	config *restclient.Config,
+) error {
+	key := runner.UniqueID() + "/" + cluster.GetName()
+	healthCheck := runner.Kubernetes.ClusterHealthCheck
+
+	r.lock.Lock()
+	state := r.health[key]
+	r.lock.Unlock()
+
+	if state != nil && time.Since(state.checked) < healthCheck.GetInterval() {
+		return state.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheck.GetTimeout())
+	defer cancel()
+
+	err := r.checkHealth(ctx, config)
+
+	r.lock.Lock()
+	r.health[key] = &clusterHealthState{checked: time.Now(), err: err}
+	r.lock.Unlock()
+
+	healthy := 1.0
+	if err != nil {
+		healthy = 0
+	}
+	r.healthy.WithLabelValues(runner.ShortDescription(), cluster.GetName()).Set(healthy)
+
+	return err
+}
+
+// loadClusterConfig loads the client configuration of the cluster context from its kubeconfig file
+func loadClusterConfig(cluster common.KubernetesCluster) (*restclient.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if cluster.Kubeconfig != "" {
+		rules.ExplicitPath = cluster.Kubeconfig
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+
+	config.UserAgent = common.AppVersion.UserAgent()
+
+	return config, nil
+}
+
+// checkClusterHealth checks that the API server of the cluster is ready to serve requests
+func checkClusterHealth(ctx context.Context, config *restclient.Config) error {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("checking readiness: %w", err)
+	}
+
+	return nil
+}
+
+func (s *executor) clusterRegistry() *clusterRegistry {
+	if s.clusters == nil {
+		s.clusters = newClusterRegistry()
+	}
+
+	return s.clusters
+}
+
+// selectCluster connects to the first healthy cluster of the runner, starting with the cluster at index from
+func (s *executor) selectCluster(from int) error {
+	clusters := s.Config.Kubernetes.Clusters
+	registry := s.clusterRegistry()
+
+	for i := from; i < len(clusters); i++ {
+		cluster := clusters[i]
+
+		config, err := registry.loadConfig(cluster)
+		if err == nil {
+			err = registry.check(&s.Config, cluster, config)
+		}
+		if err != nil {
+			s.Warningln(fmt.Sprintf("Skipping Kubernetes cluster %q: %v", cluster.GetName(), err))
+			continue
+		}
+
+		if s.configurationOverwrites.bearerToken != "" {
+			config.BearerToken = s.configurationOverwrites.bearerToken
+		}
+
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			s.Warningln(fmt.Sprintf("Skipping Kubernetes cluster %q: connecting: %v", cluster.GetName(), err))
+			continue
+		}
+
+		closeKubeClient(s.kubeClient)
+
+		s.clusterIndex = i
+		s.kubeConfig = config
+		s.kubeClient = client
+		s.featureChecker = &kubeClientFeatureChecker{kubeClient: client}
+
+		s.Println(fmt.Sprintf("Using Kubernetes cluster %q", cluster.GetName()))
+
+		return nil
+	}
+
+	return errNoHealthyCluster
+}
+
+// hasFallbackCluster returns true when the job can fall back to another cluster of the runner
+func (s *executor) hasFallbackCluster() bool {
+	return s.clusterIndex+1 < len(s.Config.Kubernetes.Clusters)
+}
+
+// failoverCluster deletes the resources created for the job in the current cluster, after the
+// build pod couldn't be created or scheduled in it, and connects to the next healthy cluster.
+// It returns false when there is no cluster left to fall back to.
+func (s *executor) failoverCluster(err error) bool {
+	if !s.hasFallbackCluster() {
+		return false
+	}
+
+	cluster := s.Config.Kubernetes.Clusters[s.clusterIndex].GetName()
+	s.Warningln(fmt.Sprintf("Setting up the build pod in Kubernetes cluster %q failed: %v", cluster, err))
+	s.clusterRegistry().failovers.WithLabelValues(s.Config.ShortDescription(), cluster).Inc()
+
+	if s.podEvents != nil {
+		s.podEvents.stop()
+		s.podEvents = nil
+	}
+
+	s.cleanupResources()
+	s.pod = nil
+	s.credentials = nil
+	s.configMap = nil
+	s.services = nil
+
+	err = s.selectCluster(s.clusterIndex + 1)
+	if err != nil {
+		s.Warningln(err.Error())
+		return false
+	}
+
+	return true
+}
+
+// recordClusterPlacement counts the build pod in the metrics of the cluster it was placed in
+func (s *executor) recordClusterPlacement() {
+	if len(s.Config.Kubernetes.Clusters) == 0 {
+		return
+	}
+
+	cluster := s.Config.Kubernetes.Clusters[s.clusterIndex].GetName()
+	s.clusterRegistry().placements.WithLabelValues(s.Config.ShortDescription(), cluster).Inc()
+}
+
+// checkClusterQuota checks once that the quotas of the namespace have room for the pod.
+// When they don't, an error is returned, so that the job falls back to the next cluster
+// instead of waiting for the quotas of the current one.
+func (s *executor) checkClusterQuota(ctx context.Context, pod *api.Pod) error {
+	reason, err := checkResourceQuota(ctx, s.kubeClient, pod)
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		return fmt.Errorf("%w: %s", errNoClusterQuota, reason)
+	}
+
+	return nil
+}
+
+// isClusterFailoverError returns true for the errors that are specific to the current cluster:
+// its API server can't be reached or is unavailable, or it has no quota or capacity for the pod.
+// Other errors, e.g. an invalid pod or a failed image pull, would happen in any cluster.
+func isClusterFailoverError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var netErr net.Error
+	var unschedulableErr *podUnschedulableError
+	var quotaErr *quotaViolationError
+
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case kubeerrors.IsServiceUnavailable(err), kubeerrors.IsServerTimeout(err),
+		kubeerrors.IsTimeout(err), kubeerrors.IsTooManyRequests(err):
+		return true
+	case kubeerrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota"):
+		return true
+	case errors.Is(err, errNoClusterQuota), errors.As(err, &quotaErr), errors.As(err, &unschedulableErr):
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,337 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	restclient "k8s.io/client-go/rest"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+func newTestClusterRegistry(unhealthy ...string) (*clusterRegistry, map[string]int) {
+	checks := make(map[string]int)
+
+	registry := newClusterRegistry()
+	registry.loadConfig = func(cluster common.KubernetesCluster) (*restclient.Config, error) {
+		return &restclient.Config{Host: "https://" + cluster.GetName()}, nil
+	}
+	registry.checkHealth = func(ctx context.Context, config *restclient.Config) error {
+		checks[config.Host]++
+		for _, name := range unhealthy {
+			if config.Host == "https://"+name {
+				return errors.New("not ready")
+			}
+		}
+
+		return nil
+	}
+
+	return registry, checks
+}
+
+func newTestClustersExecutor(registry *clusterRegistry, out *bytes.Buffer, clusters ...string) *executor {
+	config := &common.KubernetesConfig{}
+	for _, name := range clusters {
+		config.Clusters = append(config.Clusters, common.KubernetesCluster{Name: name})
+	}
+
+	e := newExecutor()
+	e.clusters = registry
+	e.configurationOverwrites = &overwrites{}
+	e.AbstractExecutor = executors.AbstractExecutor{
+		Config: common.RunnerConfig{
+			RunnerCredentials: common.RunnerCredentials{Token: "runner-token"},
+			RunnerSettings:    common.RunnerSettings{Kubernetes: config},
+		},
+		BuildLogger: common.NewBuildLogger(&common.Trace{Writer: out}, logrus.WithFields(logrus.Fields{})),
+	}
+
+	return e
+}
+
+func TestClusterRegistryCheck(t *testing.T) {
+	registry, checks := newTestClusterRegistry("unhealthy")
+
+	runner := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{Token: "runner-token"},
+		RunnerSettings: common.RunnerSettings{
+			Kubernetes: &common.KubernetesConfig{
+				ClusterHealthCheck: common.KubernetesClusterHealthCheck{Interval: 60},
+			},
+		},
+	}
+
+	healthy := common.KubernetesCluster{Name: "healthy"}
+	unhealthy := common.KubernetesCluster{Name: "unhealthy"}
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, registry.check(runner, healthy, &restclient.Config{Host: "https://healthy"}))
+		assert.EqualError(t, registry.check(runner, unhealthy, &restclient.Config{Host: "https://unhealthy"}), "not ready")
+	}
+
+	// The results are reused during the health check interval
+	assert.Equal(t, map[string]int{"https://healthy": 1, "https://unhealthy": 1}, checks)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.healthy.WithLabelValues("runner-t", "healthy")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(registry.healthy.WithLabelValues("runner-t", "unhealthy")))
+}
+
+func TestSelectCluster(t *testing.T) {
+	tests := map[string]struct {
+		clusters      []string
+		unhealthy     []string
+		expectedHost  string
+		expectedIndex int
+		expectedErr   error
+	}{
+		"first cluster is healthy": {
+			clusters:     []string{"first", "second"},
+			expectedHost: "https://first",
+		},
+		"first cluster is unhealthy": {
+			clusters:      []string{"first", "second", "third"},
+			unhealthy:     []string{"first"},
+			expectedHost:  "https://second",
+			expectedIndex: 1,
+		},
+		"no healthy cluster": {
+			clusters:    []string{"first", "second"},
+			unhealthy:   []string{"first", "second"},
+			expectedErr: errNoHealthyCluster,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			registry, _ := newTestClusterRegistry(tt.unhealthy...)
+			out := new(bytes.Buffer)
+			e := newTestClustersExecutor(registry, out, tt.clusters...)
+
+			err := e.selectCluster(0)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedHost, e.kubeConfig.Host)
+			assert.Equal(t, tt.expectedIndex, e.clusterIndex)
+			assert.NotNil(t, e.kubeClient)
+			assert.Contains(t, out.String(), `Using Kubernetes cluster "`+tt.clusters[tt.expectedIndex]+`"`)
+			for _, name := range tt.unhealthy {
+				assert.Contains(t, out.String(), `Skipping Kubernetes cluster "`+name+`": not ready`)
+			}
+		})
+	}
+}
+
+func TestFailoverCluster(t *testing.T) {
+	registry, _ := newTestClusterRegistry("second")
+	out := new(bytes.Buffer)
+	e := newTestClustersExecutor(registry, out, "first", "second", "third")
+
+	require.NoError(t, e.selectCluster(0))
+	e.recordClusterPlacement()
+
+	assert.True(t, e.failoverCluster(errors.New("pod failed to enter running state: Pending")))
+	assert.Equal(t, 2, e.clusterIndex)
+	assert.Equal(t, "https://third", e.kubeConfig.Host)
+	assert.Contains(
+		t,
+		out.String(),
+		`Setting up the build pod in Kubernetes cluster "first" failed: pod failed to enter running state: Pending`,
+	)
+	e.recordClusterPlacement()
+
+	assert.False(t, e.failoverCluster(errors.New("pod failed to enter running state: Pending")))
+	assert.Equal(t, 2, e.clusterIndex)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.failovers.WithLabelValues("runner-t", "first")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.placements.WithLabelValues("runner-t", "first")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.placements.WithLabelValues("runner-t", "third")))
+}
+
+func TestIsClusterFailoverError(t *testing.T) {
+	podsResource := schema.GroupResource{Resource: "pods"}
+
+	tests := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"connection refused": {
+			err: fmt.Errorf("setting up build pod: %w", &url.Error{
+				Op:  "Post",
+				URL: "https://cluster/api/v1/namespaces/default/pods",
+				Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			}),
+			expected: true,
+		},
+		"API server unavailable": {
+			err:      kubeerrors.NewServiceUnavailable("etcd is unavailable"),
+			expected: true,
+		},
+		"API server throttling": {
+			err:      kubeerrors.NewTooManyRequests("slow down", 1),
+			expected: true,
+		},
+		"exceeded quota": {
+			err: kubeerrors.NewForbidden(
+				podsResource,
+				"runner-pod",
+				errors.New("exceeded quota: compute, requested: cpu=2, used: cpu=8, limited: cpu=8"),
+			),
+			expected: true,
+		},
+		"no quota available": {
+			err:      fmt.Errorf("%w: resource quota compute: cpu", errNoClusterQuota),
+			expected: true,
+		},
+		"quota violation": {
+			err:      &quotaViolationError{reason: "resource quota compute: cpu"},
+			expected: true,
+		},
+		"pod can't be scheduled": {
+			err:      fmt.Errorf("waiting for pod running: %w", &podUnschedulableError{reason: "Insufficient cpu"}),
+			expected: true,
+		},
+		"pod start timed out": {
+			err:      errors.New("timed out waiting for pod to start"),
+			expected: false,
+		},
+		"forbidden": {
+			err:      kubeerrors.NewForbidden(podsResource, "runner-pod", errors.New("missing permission")),
+			expected: false,
+		},
+		"invalid pod": {
+			err:      kubeerrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "runner-pod", nil),
+			expected: false,
+		},
+		"image pull failed": {
+			err:      &common.BuildError{Inner: errors.New("image pull failed")},
+			expected: false,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isClusterFailoverError(context.Background(), tt.err))
+		})
+	}
+
+	t.Run("canceled job", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.False(t, isClusterFailoverError(ctx, kubeerrors.NewServiceUnavailable("etcd is unavailable")))
+	})
+}
+
+func TestCheckClusterHealth(t *testing.T) {
+	ready := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" || !ready {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	config := &restclient.Config{Host: server.URL}
+
+	assert.NoError(t, checkClusterHealth(context.Background(), config))
+
+	ready = false
+	assert.Error(t, checkClusterHealth(context.Background(), config))
+}
+
+func TestLoadClusterConfig(t *testing.T) {
+	kubeconfig := `
+apiVersion: v1
+kind: Config
+current-context: first
+clusters:
+- name: first
+  cluster:
+    server: https://first.example.com
+- name: second
+  cluster:
+    server: https://second.example.com
+contexts:
+- name: first
+  context:
+    cluster: first
+    user: runner
+- name: second
+  context:
+    cluster: second
+    user: runner
+users:
+- name: runner
+  user:
+    token: token
+`
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(path, []byte(kubeconfig), 0600))
+
+	tests := map[string]struct {
+		cluster      common.KubernetesCluster
+		expectedHost string
+		expectedErr  bool
+	}{
+		"current context": {
+			cluster:      common.KubernetesCluster{Kubeconfig: path},
+			expectedHost: "https://first.example.com",
+		},
+		"selected context": {
+			cluster:      common.KubernetesCluster{Kubeconfig: path, Context: "second"},
+			expectedHost: "https://second.example.com",
+		},
+		"unknown context": {
+			cluster:     common.KubernetesCluster{Kubeconfig: path, Context: "unknown"},
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config, err := loadClusterConfig(tt.cluster)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedHost, config.Host)
+			assert.Equal(t, "token", config.BearerToken)
+		})
+	}
+}
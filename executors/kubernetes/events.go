@@ -0,0 +1,251 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+)
+
+// podEventWarning is a warning event, aggregated by object, reason and message for the summary
+type podEventWarning struct {
+	object  string
+	reason  string
+	message string
+	count   int32
+}
+
+// podEventsWatcher watches the events of the build pod and its services. While the pod is being
+// prepared, the events are printed to the job log, so that users can see why a pod stays pending.
+// The warnings are kept to be summarized when the job fails.
+type podEventsWatcher struct {
+	client        kubernetes.Interface
+	namespace     string
+	since         time.Time
+	retryInterval time.Duration
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	lock     sync.Mutex
+	out      io.Writer
+	seen     map[types.UID]int32
+	warnings []*podEventWarning
+}
+
+func newPodEventsWatcher(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	out io.Writer,
+	since time.Time,
+	retryInterval time.Duration,
+) *podEventsWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &podEventsWatcher{
+		client:        client,
+		namespace:     namespace,
+		since:         since,
+		retryInterval: retryInterval,
+		ctx:           ctx,
+		cancel:        cancel,
+		out:           out,
+		seen:          make(map[types.UID]int32),
+	}
+}
+
+// watch starts watching the events of the object until the watcher is stopped
+func (w *podEventsWatcher) watch(kind string, name string) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		for {
+			w.watchOnce(kind, name)
+
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-time.After(w.retryInterval):
+			}
+		}
+	}()
+}
+
+// watchOnce handles the events of the object until the watch is closed by the API server.
+// Events received again after the watch is restarted are skipped.
+func (w *podEventsWatcher) watchOnce(kind string, name string) {
+	selector := fields.Set{
+		"involvedObject.kind": kind,
+		"involvedObject.name": name,
+	}.AsSelector().String()
+
+	watcher, err := w.client.CoreV1().Events(w.namespace).Watch(w.ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case result, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+
+			event, ok := result.Object.(*api.Event)
+			if !ok || result.Type == watch.Deleted {
+				continue
+			}
+
+			if event.InvolvedObject.Kind == kind && event.InvolvedObject.Name == name {
+				w.handle(event)
+			}
+		}
+	}
+}
+
+func (w *podEventsWatcher) handle(event *api.Event) {
+	if !w.since.IsZero() && eventTime(event).Before(w.since) {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	count := event.Count
+	if count < 1 {
+		count = 1
+	}
+
+	seen, ok := w.seen[event.UID]
+	if ok && count <= seen {
+		return
+	}
+	w.seen[event.UID] = count
+
+	object := fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name)
+
+	if event.Type == api.EventTypeWarning {
+		w.addWarning(object, event, count-seen)
+	}
+
+	if w.out == nil {
+		return
+	}
+
+	color := ""
+	if event.Type == api.EventTypeWarning {
+		color = helpers.ANSI_YELLOW
+	}
+
+	_, _ = fmt.Fprintf(
+		w.out,
+		"%s%s %s: %s: %s%s\n",
+		color,
+		event.Type,
+		object,
+		event.Reason,
+		event.Message,
+		helpers.ANSI_RESET,
+	)
+}
+
+func (w *podEventsWatcher) addWarning(object string, event *api.Event, count int32) {
+	for _, warning := range w.warnings {
+		if warning.object == object && warning.reason == event.Reason && warning.message == event.Message {
+			warning.count += count
+			return
+		}
+	}
+
+	w.warnings = append(w.warnings, &podEventWarning{
+		object:  object,
+		reason:  event.Reason,
+		message: event.Message,
+		count:   count,
+	})
+}
+
+func eventTime(event *api.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+// stopPrinting stops printing the events to the job log, the warnings are still kept for the summary
+func (w *podEventsWatcher) stopPrinting() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.out = nil
+}
+
+// writeSummary writes the warnings received for the pod and its services
+func (w *podEventsWatcher) writeSummary(out io.Writer) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.warnings) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(
+		out,
+		"%sWarning events of the build pod and its services:%s\n",
+		helpers.ANSI_BOLD_YELLOW,
+		helpers.ANSI_RESET,
+	)
+	for _, warning := range w.warnings {
+		_, _ = fmt.Fprintf(out, "\t%s: %s (x%d): %s\n", warning.object, warning.reason, warning.count, warning.message)
+	}
+}
+
+// stop stops watching the events and waits for the watches to end
+func (w *podEventsWatcher) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// watchPodEvents starts watching the events of the build pod and its services. Only the events
+// that happened after since are handled, which allows skipping the events of previous jobs
+// when the pod is reused.
+func (s *executor) watchPodEvents(since time.Time) {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s.podEvents = newPodEventsWatcher(
+		ctx,
+		s.kubeClient,
+		s.pod.Namespace,
+		s.Trace,
+		since,
+		time.Duration(s.Config.Kubernetes.GetPollInterval())*time.Second,
+	)
+
+	s.podEvents.watch("Pod", s.pod.Name)
+	for _, service := range s.services {
+		s.podEvents.watch("Service", service.Name)
+	}
+}
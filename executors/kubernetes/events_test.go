@@ -0,0 +1,133 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type safeBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+func newTestEvent(uid string, kind string, name string, eventType string, reason string, count int32) *api.Event {
+	return &api.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      uid,
+			Namespace: "default",
+			UID:       types.UID(uid),
+		},
+		InvolvedObject: api.ObjectReference{Kind: kind, Name: name, Namespace: "default"},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        reason + " message",
+		Count:          count,
+		LastTimestamp:  metav1.Now(),
+	}
+}
+
+func TestPodEventsWatcherHandle(t *testing.T) {
+	out := new(bytes.Buffer)
+	w := newPodEventsWatcher(context.Background(), nil, "default", out, time.Time{}, time.Second)
+
+	w.handle(newTestEvent("1", "Pod", "pod", api.EventTypeNormal, "Scheduled", 1))
+	w.handle(newTestEvent("2", "Pod", "pod", api.EventTypeWarning, "BackOff", 1))
+	// The same event received again after the watch is restarted
+	w.handle(newTestEvent("2", "Pod", "pod", api.EventTypeWarning, "BackOff", 1))
+	w.handle(newTestEvent("2", "Pod", "pod", api.EventTypeWarning, "BackOff", 3))
+
+	w.stopPrinting()
+	w.handle(newTestEvent("3", "Service", "svc", api.EventTypeWarning, "FailedCreate", 1))
+	w.handle(newTestEvent("4", "Pod", "pod", api.EventTypeNormal, "Pulled", 1))
+
+	assert.Equal(
+		t,
+		"Normal Pod/pod: Scheduled: Scheduled message\033[0;m\n"+
+			"\033[0;33mWarning Pod/pod: BackOff: BackOff message\033[0;m\n"+
+			"\033[0;33mWarning Pod/pod: BackOff: BackOff message\033[0;m\n",
+		out.String(),
+	)
+
+	summary := new(bytes.Buffer)
+	w.writeSummary(summary)
+	assert.Equal(
+		t,
+		"\033[33;1mWarning events of the build pod and its services:\033[0;m\n"+
+			"\tPod/pod: BackOff (x3): BackOff message\n"+
+			"\tService/svc: FailedCreate (x1): FailedCreate message\n",
+		summary.String(),
+	)
+}
+
+func TestPodEventsWatcherSkipsOldEvents(t *testing.T) {
+	out := new(bytes.Buffer)
+	w := newPodEventsWatcher(context.Background(), nil, "default", out, time.Now(), time.Second)
+
+	old := newTestEvent("1", "Pod", "pod", api.EventTypeWarning, "BackOff", 1)
+	old.LastTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	w.handle(old)
+
+	assert.Empty(t, out.String())
+
+	summary := new(bytes.Buffer)
+	w.writeSummary(summary)
+	assert.Empty(t, summary.String())
+}
+
+func TestPodEventsWatcherWatch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	out := new(safeBuffer)
+
+	w := newPodEventsWatcher(context.Background(), client, "default", out, time.Time{}, 10*time.Millisecond)
+	w.watch("Pod", "pod")
+	defer w.stop()
+
+	require.Eventually(t, func() bool {
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "watch" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	events := []*api.Event{
+		newTestEvent("1", "Pod", "pod", api.EventTypeWarning, "FailedScheduling", 1),
+		newTestEvent("2", "Pod", "other-pod", api.EventTypeWarning, "BackOff", 1),
+	}
+	for _, event := range events {
+		_, err := client.CoreV1().Events("default").Create(context.Background(), event, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return out.String() == "\033[0;33mWarning Pod/pod: FailedScheduling: FailedScheduling message\033[0;m\n"
+	}, time.Second, 10*time.Millisecond)
+}
@@ -15,6 +15,7 @@ import (
 	"golang.org/x/net/context"
 	api "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
@@ -52,6 +53,8 @@ const (
 
 	apiVersion         = "v1"
 	ownerReferenceKind = "Pod"
+
+	volumeSnapshotAPIGroup = "snapshot.storage.k8s.io"
 )
 
 var (
@@ -144,6 +147,20 @@ type executor struct {
 
 	// Flag if a repo mount and emptyDir volume are needed
 	requireDefaultBuildsDirVolume *bool
+
+	// podPoolLease is set when the job can run in a pod from the runner's pod pool
+	podPoolLease *podPoolLease
+
+	// jobs tracks the jobs handled by the executors of the runner, so that their
+	// resources aren't deleted by the leaked resources cleanup
+	jobs *jobTracker
+
+	podEvents *podEventsWatcher
+
+	// clusters holds the health of the clusters listed in the configuration of the runner,
+	// clusterIndex is the index of the cluster the executor is connected to
+	clusters     *clusterRegistry
+	clusterIndex int
 }
 
 type serviceCreateResponse struct {
@@ -154,10 +171,18 @@ type serviceCreateResponse struct {
 func (s *executor) Prepare(options common.ExecutorPrepareOptions) (err error) {
 	s.AbstractExecutor.PrepareConfiguration(options)
 
+	if s.jobs != nil {
+		s.jobs.add(runnerIDLabelValue(&s.Build.Runner.RunnerCredentials), s.Build.JobResponse.ID)
+	}
+
 	if err = s.prepareOverwrites(options.Build.GetAllVariables()); err != nil {
 		return fmt.Errorf("couldn't prepare overwrites: %w", err)
 	}
 
+	if s.jobs != nil {
+		s.jobs.addNamespace(runnerIDLabelValue(&s.Build.Runner.RunnerCredentials), s.configurationOverwrites.namespace)
+	}
+
 	var pullPolicies []api.PullPolicy
 	if pullPolicies, err = s.Config.Kubernetes.GetPullPolicies(); err != nil {
 		return fmt.Errorf("couldn't get pull policy: %w", err)
@@ -174,14 +199,12 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) (err error) {
 		return fmt.Errorf("check defaults error: %w", err)
 	}
 
-	s.kubeConfig, err = getKubeClientConfig(s.Config.Kubernetes, s.configurationOverwrites)
-	if err != nil {
-		return fmt.Errorf("getting Kubernetes config: %w", err)
+	if err = s.checkVolumes(); err != nil {
+		return fmt.Errorf("check volumes error: %w", err)
 	}
 
-	s.kubeClient, err = kubernetes.NewForConfig(s.kubeConfig)
-	if err != nil {
-		return fmt.Errorf("connecting to Kubernetes: %w", err)
+	if err = s.prepareKubeClient(); err != nil {
+		return err
 	}
 
 	s.helperImageInfo, err = s.prepareHelperImage()
@@ -211,9 +234,33 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) (err error) {
 		return fmt.Errorf("kubernetes doesn't support shells that require script file")
 	}
 
+	if lease, ok := options.Build.ExecutorData.(*podPoolLease); ok && s.isPodPoolEligible() {
+		s.podPoolLease = lease
+	}
+
 	return err
 }
 
+// prepareKubeClient connects to the cluster of the runner, or to the first healthy cluster
+// when the runner lists several clusters
+func (s *executor) prepareKubeClient() (err error) {
+	if len(s.Config.Kubernetes.Clusters) > 0 {
+		return s.selectCluster(0)
+	}
+
+	s.kubeConfig, err = getKubeClientConfig(s.Config.Kubernetes, s.configurationOverwrites)
+	if err != nil {
+		return fmt.Errorf("getting Kubernetes config: %w", err)
+	}
+
+	s.kubeClient, err = kubernetes.NewForConfig(s.kubeConfig)
+	if err != nil {
+		return fmt.Errorf("connecting to Kubernetes: %w", err)
+	}
+
+	return nil
+}
+
 func (s *executor) setupDefaultExecutorOptions(os string) {
 	if os == helperimage.OSTypeWindows {
 		s.DefaultBuildsDir = `C:\builds`
@@ -234,13 +281,13 @@ func (s *executor) prepareHelperImage() (helperimage.Info, error) {
 	}
 
 	// use node selector labels to better select the correct image
-	if s.Config.Kubernetes.NodeSelector != nil {
+	if s.configurationOverwrites.nodeSelector != nil {
 		for label, option := range map[string]*string{
 			api.LabelArchStable:           &config.Architecture,
 			api.LabelOSStable:             &config.OSType,
 			nodeSelectorWindowsBuildLabel: &config.OperatingSystem,
 		} {
-			value := s.Config.Kubernetes.NodeSelector[label]
+			value := s.configurationOverwrites.nodeSelector[label]
 			if value != "" {
 				*option = value
 			}
@@ -276,15 +323,16 @@ func (s *executor) Run(cmd common.ExecutorCommand) error {
 
 func (s *executor) runWithExecLegacy(cmd common.ExecutorCommand) error {
 	if s.pod == nil {
-		err := s.setupCredentials()
-		if err != nil {
-			return err
+		err := s.setupPodWithExecLegacy()
+		for err != nil && isClusterFailoverError(cmd.Context, err) && s.failoverCluster(err) {
+			err = s.setupPodWithExecLegacy()
 		}
-
-		err = s.setupBuildPod(nil)
 		if err != nil {
 			return err
 		}
+
+		s.recordClusterPlacement()
+		s.watchPodEvents(time.Time{})
 	}
 
 	containerName := buildContainerName
@@ -318,6 +366,15 @@ func (s *executor) runWithExecLegacy(cmd common.ExecutorCommand) error {
 	}
 }
 
+func (s *executor) setupPodWithExecLegacy() error {
+	err := s.setupCredentials()
+	if err != nil {
+		return err
+	}
+
+	return s.setupBuildPod(nil)
+}
+
 func (s *executor) runWithAttach(cmd common.ExecutorCommand) error {
 	err := s.ensurePodsConfigured(cmd.Context)
 	if err != nil {
@@ -363,6 +420,35 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return nil
 	}
 
+	if s.podPoolLease != nil && s.claimPooledPod(ctx) {
+		s.watchPodEvents(time.Now())
+		s.podEvents.stopPrinting()
+
+		go s.processLogs(ctx)
+		return nil
+	}
+
+	err := s.setupPod(ctx)
+	for err != nil && isClusterFailoverError(ctx, err) && s.failoverCluster(err) {
+		err = s.setupPod(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.recordClusterPlacement()
+
+	if s.podPoolLease != nil {
+		s.addPodToPool()
+	}
+
+	go s.processLogs(ctx)
+
+	return nil
+}
+
+// setupPod creates the build pod and its resources and waits for the pod to be running
+func (s *executor) setupPod(ctx context.Context) error {
 	err := s.setupCredentials()
 	if err != nil {
 		return fmt.Errorf("setting up credentials: %w", err)
@@ -382,7 +468,10 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return fmt.Errorf("setting up build pod: %w", err)
 	}
 
+	s.watchPodEvents(time.Time{})
+
 	status, err := waitForPodRunning(ctx, s.kubeClient, s.pod, s.Trace, s.Config.Kubernetes)
+	s.podEvents.stopPrinting()
 	if err != nil {
 		return fmt.Errorf("waiting for pod running: %w", err)
 	}
@@ -391,8 +480,6 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return fmt.Errorf("pod failed to enter running state: %s", status)
 	}
 
-	go s.processLogs(ctx)
-
 	return nil
 }
 
@@ -499,10 +586,14 @@ func (s *executor) buildPermissionsInitContainer(os string) (api.Container, erro
 
 	default:
 		chmod := "touch %[1]s && (chmod 777 %[1]s || exit 0)"
+		if s.podPoolLease != nil {
+			// Pooled pods are reused by many jobs, each of them writing to its own log file
+			chmod = "chmod 777 %[2]s || exit 0"
+		}
 		container.Command = []string{
 			"sh",
 			"-c",
-			fmt.Sprintf(chmod, s.logFile()),
+			fmt.Sprintf(chmod, s.logFile(), s.logsDir()),
 		}
 	}
 
@@ -585,8 +676,9 @@ func (s *executor) setupScriptsConfigMap() error {
 
 	configMap := &api.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: fmt.Sprintf("%s-scripts", s.Build.ProjectUniqueName()),
+			GenerateName: fmt.Sprintf("%s-scripts", s.podName()),
 			Namespace:    s.configurationOverwrites.namespace,
+			Labels:       s.resourceLabels(),
 		},
 		Data: scripts,
 	}
@@ -648,12 +740,32 @@ func (s *executor) Finish(err error) {
 		s.pod = nil
 	}
 
+	if s.isUsingPooledPod() && !isPooledPodReusable(err) {
+		s.podPoolLease.pod.broken = true
+	}
+
+	if s.podEvents != nil {
+		s.podEvents.stopPrinting()
+		if err != nil {
+			s.podEvents.writeSummary(s.Trace)
+		}
+	}
+
 	s.AbstractExecutor.Finish(err)
 }
 
 func (s *executor) Cleanup() {
+	if s.podEvents != nil {
+		s.podEvents.stop()
+	}
+
 	s.cleanupResources()
 	closeKubeClient(s.kubeClient)
+
+	if s.jobs != nil {
+		s.jobs.remove(runnerIDLabelValue(&s.Build.Runner.RunnerCredentials), s.Build.JobResponse.ID)
+	}
+
 	s.AbstractExecutor.Cleanup()
 }
 
@@ -663,6 +775,13 @@ func (s *executor) Cleanup() {
 // This does not apply for services as they are created with the owner from the start
 // thus deletion of the pod automatically means deletion of the services if any
 func (s *executor) cleanupResources() {
+	if s.isUsingPooledPod() {
+		// The pod and its scripts config map are returned to the pod pool
+		// or deleted by it when the provider releases the lease
+		s.Debugln("Leaving pooled pod", s.pod.Name, "to the pod pool")
+		return
+	}
+
 	if s.pod != nil {
 		// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
 		err := s.kubeClient.
@@ -721,7 +840,11 @@ func (s *executor) buildContainer(opts containerBuildOpts) (api.Container, error
 	} else if opts.name == buildContainerName {
 		optionName = "images"
 		allowedImages = s.Config.Kubernetes.AllowedImages
-		envVars = s.Build.GetAllVariables().PublicOrInternal()
+		// Pooled pods are reused by other jobs, so job variables can't be part of
+		// the pod spec. The generated scripts export them anyway.
+		if s.podPoolLease == nil {
+			envVars = s.Build.GetAllVariables().PublicOrInternal()
+		}
 	}
 
 	verifyAllowedImageOptions := common.VerifyAllowedImageOptions{
@@ -798,14 +921,26 @@ func (s *executor) getCommandAndArgs(imageDefinition common.Image, command ...st
 }
 
 func (s *executor) logFile() string {
+	if s.podPoolLease != nil {
+		return path.Join(s.logsDir(), fmt.Sprintf("output-%d.log", s.Build.JobResponse.ID))
+	}
+
 	return path.Join(s.logsDir(), "output.log")
 }
 
 func (s *executor) logsDir() string {
+	if s.podPoolLease != nil {
+		return podPoolLogsDir
+	}
+
 	return fmt.Sprintf("/logs-%d-%d", s.Build.JobInfo.ProjectID, s.Build.JobResponse.ID)
 }
 
 func (s *executor) scriptsDir() string {
+	if s.podPoolLease != nil {
+		return podPoolScriptsDir
+	}
+
 	return fmt.Sprintf("/scripts-%d-%d", s.Build.JobInfo.ProjectID, s.Build.JobResponse.ID)
 }
 
@@ -917,6 +1052,14 @@ func (s *executor) getVolumeMountsForConfig() []api.VolumeMount {
 		})
 	}
 
+	for _, mount := range s.Config.Kubernetes.Volumes.Ephemerals {
+		mounts = append(mounts, api.VolumeMount{
+			Name:      mount.Name,
+			MountPath: mount.MountPath,
+			SubPath:   mount.SubPath,
+		})
+	}
+
 	return mounts
 }
 
@@ -977,6 +1120,7 @@ func (s *executor) getVolumesForConfig() []api.Volume {
 	volumes = append(volumes, s.getVolumesForConfigMaps()...)
 	volumes = append(volumes, s.getVolumesForEmptyDirs()...)
 	volumes = append(volumes, s.getVolumesForCSIs()...)
+	volumes = append(volumes, s.getVolumesForEphemerals()...)
 
 	return volumes
 }
@@ -1113,6 +1257,76 @@ func (s *executor) getVolumesForCSIs() []api.Volume {
 	return volumes
 }
 
+func (s *executor) getVolumesForEphemerals() []api.Volume {
+	var volumes []api.Volume
+
+	for _, volume := range s.Config.Kubernetes.Volumes.Ephemerals {
+		// The size is validated when preparing the executor
+		size, _ := resource.ParseQuantity(volume.Size)
+
+		accessModes := []api.PersistentVolumeAccessMode{api.ReadWriteOnce}
+		if len(volume.AccessModes) > 0 {
+			accessModes = nil
+			for _, mode := range volume.AccessModes {
+				accessModes = append(accessModes, api.PersistentVolumeAccessMode(mode))
+			}
+		}
+
+		spec := api.PersistentVolumeClaimSpec{
+			AccessModes: accessModes,
+			Resources: api.ResourceRequirements{
+				Requests: api.ResourceList{api.ResourceStorage: size},
+			},
+		}
+
+		if volume.StorageClass != "" {
+			storageClass := volume.StorageClass
+			spec.StorageClassName = &storageClass
+		}
+
+		if volume.VolumeSnapshot != "" {
+			apiGroup := volumeSnapshotAPIGroup
+			spec.DataSource = &api.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     s.Build.GetAllVariables().ExpandValue(volume.VolumeSnapshot),
+			}
+		}
+
+		volumes = append(volumes, api.Volume{
+			Name: volume.Name,
+			VolumeSource: api.VolumeSource{
+				Ephemeral: &api.EphemeralVolumeSource{
+					VolumeClaimTemplate: &api.PersistentVolumeClaimTemplate{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: s.resourceLabels(),
+						},
+						Spec: spec,
+					},
+				},
+			},
+		})
+	}
+
+	return volumes
+}
+
+// checkVolumes validates the volumes configuration that can't be checked when the config is loaded
+func (s *executor) checkVolumes() error {
+	for _, volume := range s.Config.Kubernetes.Volumes.Ephemerals {
+		size, err := resource.ParseQuantity(volume.Size)
+		if err != nil {
+			return fmt.Errorf("invalid size %q of ephemeral volume %q: %w", volume.Size, volume.Name, err)
+		}
+
+		if size.IsZero() {
+			return fmt.Errorf("missing size of ephemeral volume %q", volume.Name)
+		}
+	}
+
+	return nil
+}
+
 func (s *executor) isDefaultBuildsDirVolumeRequired() bool {
 	if s.requireDefaultBuildsDirVolume != nil {
 		return *s.requireDefaultBuildsDirVolume
@@ -1153,8 +1367,9 @@ func (s *executor) isSharedBuildsDirRequired() bool {
 	}
 
 	// Require shared builds dir when builds dir volume is anything except an emptyDir
+	// or an ephemeral volume, which are both created for the pod
 	for _, volume := range s.getVolumes() {
-		if volume.Name == buildVolumeName && volume.VolumeSource.EmptyDir != nil {
+		if volume.Name == buildVolumeName && (volume.VolumeSource.EmptyDir != nil || volume.VolumeSource.Ephemeral != nil) {
 			required = false
 			break
 		}
@@ -1189,6 +1404,7 @@ func (s *executor) setupCredentials() error {
 	secret := api.Secret{}
 	secret.GenerateName = s.Build.ProjectUniqueName()
 	secret.Namespace = s.configurationOverwrites.namespace
+	secret.Labels = s.resourceLabels()
 	secret.Type = api.SecretTypeDockercfg
 	secret.Data = map[string][]byte{}
 	secret.Data[api.DockerConfigKey] = dockerCfgContent
@@ -1234,6 +1450,13 @@ func (s *executor) setupBuildPod(initContainers []api.Container) error {
 		return err
 	}
 
+	if s.Config.Kubernetes.ResourceQuota.Check {
+		err = s.waitForResourceQuota(&podConfig)
+		if err != nil {
+			return fmt.Errorf("checking resource quota: %w", err)
+		}
+	}
+
 	s.Debugln("Creating build pod")
 
 	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
@@ -1269,7 +1492,11 @@ func (s *executor) createPodConfigPrepareOpts(initContainers []api.Container) (p
 
 	// We set a default label to the pod. This label will be used later
 	// by the services, to link each service to the pod
-	labels := map[string]string{"pod": s.Build.ProjectUniqueName()}
+	labels := s.resourceLabels()
+	labels["pod"] = s.podName()
+	if s.podPoolLease != nil {
+		labels[podPoolLabel] = sanitizeLabel(s.Build.Runner.ShortDescription())
+	}
 	for k, v := range s.Build.Runner.Kubernetes.PodLabels {
 		labels[k] = sanitizeLabel(s.Build.Variables.ExpandValue(v))
 	}
@@ -1295,6 +1522,16 @@ func (s *executor) createPodConfigPrepareOpts(initContainers []api.Container) (p
 	}, nil
 }
 
+// podName returns the name prefix of the build pod. Pooled pods can be used by jobs
+// of any project, so their name only refers to the runner.
+func (s *executor) podName() string {
+	if s.podPoolLease != nil {
+		return dns.MakeRFC1123Compatible(fmt.Sprintf("runner-%s-pool", s.Build.Runner.ShortDescription()))
+	}
+
+	return s.Build.ProjectUniqueName()
+}
+
 func (s *executor) defaultCapDrop() []string {
 	os := s.helperImageInfo.OSType
 	// windows does not support security context capabilities
@@ -1355,7 +1592,7 @@ func (s *executor) preparePodConfig(opts podConfigPrepareOpts) (api.Pod, error)
 
 	pod := api.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: s.Build.ProjectUniqueName(),
+			GenerateName: s.podName(),
 			Namespace:    s.configurationOverwrites.namespace,
 			Labels:       opts.labels,
 			Annotations:  opts.annotations,
@@ -1364,8 +1601,8 @@ func (s *executor) preparePodConfig(opts podConfigPrepareOpts) (api.Pod, error)
 			Volumes:            s.getVolumes(),
 			ServiceAccountName: s.configurationOverwrites.serviceAccount,
 			RestartPolicy:      api.RestartPolicyNever,
-			NodeSelector:       s.Config.Kubernetes.NodeSelector,
-			Tolerations:        s.Config.Kubernetes.GetNodeTolerations(),
+			NodeSelector:       s.configurationOverwrites.nodeSelector,
+			Tolerations:        s.configurationOverwrites.getNodeTolerations(),
 			InitContainers:     opts.initContainers,
 			Containers: append([]api.Container{
 				buildContainer,
@@ -1375,7 +1612,7 @@ func (s *executor) preparePodConfig(opts podConfigPrepareOpts) (api.Pod, error)
 			ImagePullSecrets:              opts.imagePullSecrets,
 			SecurityContext:               s.Config.Kubernetes.GetPodSecurityContext(),
 			HostAliases:                   opts.hostAliases,
-			Affinity:                      s.Config.Kubernetes.GetAffinity(),
+			Affinity:                      s.configurationOverwrites.applyNodeAffinityLabels(s.Config.Kubernetes.GetAffinity()),
 			DNSPolicy:                     s.getDNSPolicy(),
 			DNSConfig:                     s.Config.Kubernetes.GetDNSConfig(),
 			RuntimeClassName:              s.Config.Kubernetes.RuntimeClassName,
@@ -1544,11 +1781,12 @@ func (s *executor) prepareServiceConfig(
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName:    name,
 			Namespace:       s.configurationOverwrites.namespace,
+			Labels:          s.resourceLabels(),
 			OwnerReferences: ownerReferences,
 		},
 		Spec: api.ServiceSpec{
 			Ports:    ports,
-			Selector: map[string]string{"pod": s.Build.ProjectUniqueName()},
+			Selector: map[string]string{"pod": s.podName()},
 			Type:     api.ServiceTypeClusterIP,
 		},
 	}
@@ -1676,6 +1914,9 @@ func (s *executor) runInContainerWithExecLegacy(
 		defer close(errCh)
 
 		status, err := waitForPodRunning(ctx, s.kubeClient, s.pod, s.Trace, s.Config.Kubernetes)
+		if s.podEvents != nil {
+			s.podEvents.stopPrinting()
+		}
 		if err != nil {
 			errCh <- err
 			return
@@ -1836,11 +2077,11 @@ func featuresFn(features *common.FeaturesInfo) {
 }
 
 func init() {
-	common.RegisterExecutorProvider("kubernetes", executors.DefaultExecutorProvider{
+	common.RegisterExecutorProvider("kubernetes", newExecutorProvider(executors.DefaultExecutorProvider{
 		Creator: func() common.Executor {
 			return newExecutor()
 		},
 		FeaturesUpdater:  featuresFn,
 		DefaultShellName: executorOptions.Shell.Shell,
-	})
+	}))
 }
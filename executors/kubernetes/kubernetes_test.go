@@ -246,6 +246,29 @@ func testVolumeMountsFeatureFlag(t *testing.T, featureFlagName string, featureFl
 				},
 			},
 		},
+		"ephemeral volumes with build dir": {
+			GlobalConfig: &common.Config{},
+			RunnerConfig: common.RunnerConfig{
+				RunnerSettings: common.RunnerSettings{
+					BuildsDir: "/path/to/builds/dir",
+					Kubernetes: &common.KubernetesConfig{
+						Volumes: common.KubernetesVolumes{
+							Ephemerals: []common.KubernetesEphemeral{
+								{Name: "builds", MountPath: "/path/to/builds/dir", Size: "10Gi"},
+								{Name: "cache", MountPath: "/cache", SubPath: "subpath", Size: "1Gi"},
+							},
+						},
+					},
+				},
+			},
+			Build: &common.Build{
+				Runner: &common.RunnerConfig{},
+			},
+			Expected: []api.VolumeMount{
+				{Name: "builds", MountPath: "/path/to/builds/dir"},
+				{Name: "cache", MountPath: "/cache", SubPath: "subpath"},
+			},
+		},
 		"user-provided volume with build dir": {
 			GlobalConfig: &common.Config{},
 			RunnerConfig: common.RunnerConfig{
@@ -530,6 +553,100 @@ func testVolumesFeatureFlag(t *testing.T, featureFlagName string, featureFlagVal
 				{Name: "logs", VolumeSource: api.VolumeSource{EmptyDir: &api.EmptyDirVolumeSource{}}},
 			},
 		},
+		"ephemeral volumes with build dir": {
+			GlobalConfig: &common.Config{},
+			RunnerConfig: common.RunnerConfig{
+				RunnerSettings: common.RunnerSettings{
+					BuildsDir: "/path/to/builds/dir",
+					Kubernetes: &common.KubernetesConfig{
+						Volumes: common.KubernetesVolumes{
+							Ephemerals: []common.KubernetesEphemeral{
+								{Name: "builds", MountPath: "/path/to/builds/dir", Size: "10Gi"},
+								{
+									Name:           "cache",
+									MountPath:      "/cache",
+									StorageClass:   "fast",
+									Size:           "1Gi",
+									AccessModes:    []string{"ReadWriteMany"},
+									VolumeSnapshot: "cache-$CI_PROJECT_ID",
+								},
+							},
+						},
+					},
+				},
+			},
+			Build: &common.Build{
+				JobResponse: common.JobResponse{
+					ID: 1,
+					Variables: common.JobVariables{
+						{Key: "CI_PROJECT_ID", Value: "10"},
+					},
+				},
+				Runner: &common.RunnerConfig{},
+			},
+			Expected: []api.Volume{
+				{
+					Name: "builds",
+					VolumeSource: api.VolumeSource{
+						Ephemeral: &api.EphemeralVolumeSource{
+							VolumeClaimTemplate: &api.PersistentVolumeClaimTemplate{
+								ObjectMeta: metav1.ObjectMeta{
+									Labels: map[string]string{runnerIDLabel: "", jobIDLabel: "1"},
+								},
+								Spec: api.PersistentVolumeClaimSpec{
+									AccessModes: []api.PersistentVolumeAccessMode{api.ReadWriteOnce},
+									Resources: api.ResourceRequirements{
+										Requests: api.ResourceList{api.ResourceStorage: resource.MustParse("10Gi")},
+									},
+								},
+							},
+						},
+					},
+				},
+				{
+					Name: "cache",
+					VolumeSource: api.VolumeSource{
+						Ephemeral: &api.EphemeralVolumeSource{
+							VolumeClaimTemplate: &api.PersistentVolumeClaimTemplate{
+								ObjectMeta: metav1.ObjectMeta{
+									Labels: map[string]string{runnerIDLabel: "", jobIDLabel: "1"},
+								},
+								Spec: api.PersistentVolumeClaimSpec{
+									AccessModes: []api.PersistentVolumeAccessMode{api.ReadWriteMany},
+									StorageClassName: func() *string {
+										storageClass := "fast"
+										return &storageClass
+									}(),
+									Resources: api.ResourceRequirements{
+										Requests: api.ResourceList{api.ResourceStorage: resource.MustParse("1Gi")},
+									},
+									DataSource: &api.TypedLocalObjectReference{
+										APIGroup: func() *string {
+											apiGroup := volumeSnapshotAPIGroup
+											return &apiGroup
+										}(),
+										Kind: "VolumeSnapshot",
+										Name: "cache-10",
+									},
+								},
+							},
+						},
+					},
+				},
+				{
+					Name: "scripts", VolumeSource: api.VolumeSource{
+						ConfigMap: &api.ConfigMapVolumeSource{
+							LocalObjectReference: api.LocalObjectReference{
+								Name: fakeConfigMap().Name,
+							},
+							DefaultMode: &mode,
+							Optional:    &optional,
+						},
+					},
+				},
+				{Name: "logs", VolumeSource: api.VolumeSource{EmptyDir: &api.EmptyDirVolumeSource{}}},
+			},
+		},
 	}
 
 	for tn, tt := range tests {
@@ -991,6 +1108,12 @@ func TestPrepare(t *testing.T) {
 		helperRequests:  api.ResourceList{},
 	}
 
+	overwritesWithNodeSelector := func(nodeSelector map[string]string) *overwrites {
+		o := *defaultOverwrites
+		o.nodeSelector = nodeSelector
+		return &o
+	}
+
 	defaultHelperImage := helperimage.Info{
 		Architecture:            "x86_64",
 		OSType:                  helperimage.OSTypeLinux,
@@ -1745,7 +1868,10 @@ func TestPrepare(t *testing.T) {
 						Name: "test-image",
 					},
 				},
-				configurationOverwrites: defaultOverwrites,
+				configurationOverwrites: overwritesWithNodeSelector(map[string]string{
+					api.LabelArchStable: "arm64",
+					api.LabelOSStable:   "linux",
+				}),
 				helperImageInfo: helperimage.Info{
 					OSType:                  "linux",
 					Architecture:            "arm64",
@@ -1785,7 +1911,11 @@ func TestPrepare(t *testing.T) {
 						Name: "test-image",
 					},
 				},
-				configurationOverwrites: defaultOverwrites,
+				configurationOverwrites: overwritesWithNodeSelector(map[string]string{
+					api.LabelArchStable:           "amd64",
+					api.LabelOSStable:             "windows",
+					nodeSelectorWindowsBuildLabel: "10.0.19041",
+				}),
 				helperImageInfo: helperimage.Info{
 					OSType:                  "windows",
 					Architecture:            "x86_64",
@@ -2411,10 +2541,12 @@ func TestSetupBuildPod(t *testing.T) {
 			},
 			VerifyFn: func(t *testing.T, test setupBuildPodTestDef, pod *api.Pod) {
 				assert.Equal(t, map[string]string{
-					"test":    "label",
-					"another": "label",
-					"var":     "sometestvar",
-					"pod":     pod.GenerateName,
+					"test":        "label",
+					"another":     "label",
+					"var":         "sometestvar",
+					"pod":         pod.GenerateName,
+					runnerIDLabel: "",
+					jobIDLabel:    "0",
 				}, pod.ObjectMeta.Labels)
 			},
 			Variables: []common.JobVariable{
@@ -2639,6 +2771,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "build",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
@@ -2657,6 +2790,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "proxy-svc-0",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
@@ -2680,6 +2814,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "proxy-svc-1",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
@@ -4261,6 +4396,9 @@ func (f FakeBuildTrace) SetMasked(masked []string)                         {}
 func (f FakeBuildTrace) IsStdout() bool {
 	return false
 }
+func (f FakeBuildTrace) IsJobSuccessful() bool {
+	return true
+}
 
 func TestCommandTerminatedError_Is(t *testing.T) {
 	tests := map[string]struct {
@@ -4970,3 +5108,48 @@ func TestBuildContainerSecurityContext(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckVolumes(t *testing.T) {
+	tests := map[string]struct {
+		ephemerals    []common.KubernetesEphemeral
+		expectedError string
+	}{
+		"no ephemeral volumes": {},
+		"valid size": {
+			ephemerals: []common.KubernetesEphemeral{{Name: "builds", Size: "10Gi"}},
+		},
+		"invalid size": {
+			ephemerals:    []common.KubernetesEphemeral{{Name: "builds", Size: "ten"}},
+			expectedError: `invalid size "ten" of ephemeral volume "builds"`,
+		},
+		"missing size": {
+			ephemerals:    []common.KubernetesEphemeral{{Name: "builds", Size: "0"}},
+			expectedError: `missing size of ephemeral volume "builds"`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			e := &executor{
+				AbstractExecutor: executors.AbstractExecutor{
+					Config: common.RunnerConfig{
+						RunnerSettings: common.RunnerSettings{
+							Kubernetes: &common.KubernetesConfig{
+								Volumes: common.KubernetesVolumes{Ephemerals: tt.ephemerals},
+							},
+						},
+					},
+				},
+			}
+
+			err := e.checkVolumes()
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
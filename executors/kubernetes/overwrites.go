@@ -3,6 +3,7 @@ package kubernetes
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	api "k8s.io/api/core/v1"
@@ -21,6 +22,15 @@ const (
 	// PodAnnotationsOverwriteVariablePrefix is the prefix for all the JobVariable keys containing
 	// user overwritten PodAnnotations
 	PodAnnotationsOverwriteVariablePrefix = "KUBERNETES_POD_ANNOTATIONS_"
+	// NodeSelectorOverwriteVariablePrefix is the prefix for all the JobVariable keys containing
+	// user overwritten NodeSelector
+	NodeSelectorOverwriteVariablePrefix = "KUBERNETES_NODE_SELECTOR_"
+	// NodeTolerationsOverwriteVariablePrefix is the prefix for all the JobVariable keys containing
+	// user overwritten NodeTolerations
+	NodeTolerationsOverwriteVariablePrefix = "KUBERNETES_NODE_TOLERATIONS_"
+	// NodeAffinityOverwriteVariablePrefix is the prefix for all the JobVariable keys containing
+	// node labels required by the user through the node affinity
+	NodeAffinityOverwriteVariablePrefix = "KUBERNETES_NODE_AFFINITY_"
 	// CPULimitOverwriteVariableValue is the key for the JobVariable containing user overwritten cpu limit
 	CPULimitOverwriteVariableValue = "KUBERNETES_CPU_LIMIT"
 	// CPURequestOverwriteVariableValue is the key for the JobVariable containing user overwritten cpu limit
@@ -107,6 +117,10 @@ type overwrites struct {
 	bearerToken    string
 	podAnnotations map[string]string
 
+	nodeSelector       map[string]string
+	nodeTolerations    map[string]string
+	nodeAffinityLabels map[string]string
+
 	buildLimits     api.ResourceList
 	serviceLimits   api.ResourceList
 	helperLimits    api.ResourceList
@@ -174,6 +188,11 @@ func createOverwrites(
 		return nil, err
 	}
 
+	err = o.evaluateNodeSelectionOverwrite(config, variables, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	err = o.evaluateMaxBuildResourcesOverwrite(config, variables, logger)
 	if err != nil {
 		return nil, err
@@ -192,6 +211,48 @@ func createOverwrites(
 	return o, nil
 }
 
+func (o *overwrites) evaluateNodeSelectionOverwrite(
+	config *common.KubernetesConfig,
+	variables common.JobVariables,
+	logger common.BuildLogger,
+) (err error) {
+	o.nodeSelector, err = o.evaluateMapOverwrite(
+		"NodeSelector",
+		config.NodeSelector,
+		config.NodeSelectorOverwriteAllowed,
+		variables,
+		NodeSelectorOverwriteVariablePrefix,
+		logger,
+	)
+	if err != nil {
+		return fmt.Errorf("invalid node selector specified: %w", err)
+	}
+
+	o.nodeTolerations, err = o.evaluateNodeTolerationsOverwrite(
+		config.NodeTolerations,
+		config.NodeTolerationsOverwriteAllowed,
+		variables,
+		logger,
+	)
+	if err != nil {
+		return fmt.Errorf("invalid node tolerations specified: %w", err)
+	}
+
+	o.nodeAffinityLabels, err = o.evaluateMapOverwrite(
+		"NodeAffinity",
+		nil,
+		config.NodeAffinityOverwriteAllowed,
+		variables,
+		NodeAffinityOverwriteVariablePrefix,
+		logger,
+	)
+	if err != nil {
+		return fmt.Errorf("invalid node affinity specified: %w", err)
+	}
+
+	return nil
+}
+
 func (o *overwrites) evaluateMaxBuildResourcesOverwrite(
 	config *common.KubernetesConfig,
 	variables common.JobVariables,
@@ -429,6 +490,114 @@ func (o *overwrites) evaluateMapOverwrite(
 	return finalValues, nil
 }
 
+// splitTolerationOverwrite splits provided string on the last ":" and returns (toleration, effect).
+// The toleration has the same "key=value" or "key" format as the keys of the node_tolerations setting.
+// When there's no effect, the toleration matches all the effects.
+func splitTolerationOverwrite(str string) (string, string, error) {
+	toleration, effect := str, ""
+	if i := strings.LastIndex(str, ":"); i >= 0 {
+		toleration, effect = str[:i], str[i+1:]
+	}
+
+	if toleration == "" || strings.HasPrefix(toleration, "=") {
+		return "", "", &malformedOverwriteError{value: str, pattern: "key[=value][:effect]"}
+	}
+
+	return toleration, effect, nil
+}
+
+func (o *overwrites) evaluateNodeTolerationsOverwrite(
+	values map[string]string,
+	regex string,
+	variables common.JobVariables,
+	logger common.BuildLogger,
+) (map[string]string, error) {
+	if regex == "" {
+		logger.Debugln("Regex allowing overrides for NodeTolerations is empty, disabling override.")
+		return values, nil
+	}
+
+	finalValues := make(map[string]string)
+	for k, v := range values {
+		finalValues[k] = v
+	}
+
+	for _, variable := range variables {
+		if !strings.HasPrefix(variable.Key, NodeTolerationsOverwriteVariablePrefix) {
+			continue
+		}
+
+		if err := overwriteRegexCheck(regex, variable.Value); err != nil {
+			return nil, err
+		}
+
+		toleration, effect, err := splitTolerationOverwrite(variable.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		finalValues[toleration] = effect
+		logger.Println(fmt.Sprintf("%q %q overwritten with %q", "NodeTolerations", toleration, effect))
+	}
+
+	return finalValues, nil
+}
+
+// getNodeTolerations returns the tolerations of the build pod
+func (o *overwrites) getNodeTolerations() []api.Toleration {
+	config := common.KubernetesConfig{NodeTolerations: o.nodeTolerations}
+
+	return config.GetNodeTolerations()
+}
+
+// applyNodeAffinityLabels adds the node labels required by the job to every required node selector term
+// of the affinity. Comma-separated values of a label match nodes with any of the values.
+func (o *overwrites) applyNodeAffinityLabels(affinity *api.Affinity) *api.Affinity {
+	if len(o.nodeAffinityLabels) == 0 {
+		return affinity
+	}
+
+	keys := make([]string, 0, len(o.nodeAffinityLabels))
+	for key := range o.nodeAffinityLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	requirements := make([]api.NodeSelectorRequirement, 0, len(keys))
+	for _, key := range keys {
+		requirements = append(requirements, api.NodeSelectorRequirement{
+			Key:      key,
+			Operator: api.NodeSelectorOpIn,
+			Values:   strings.Split(o.nodeAffinityLabels[key], ","),
+		})
+	}
+
+	if affinity == nil {
+		affinity = &api.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &api.NodeAffinity{}
+	}
+
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &api.NodeSelector{
+			NodeSelectorTerms: []api.NodeSelectorTerm{{MatchExpressions: requirements}},
+		}
+
+		return affinity
+	}
+
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchExpressions = append(
+			required.NodeSelectorTerms[i].MatchExpressions,
+			requirements...,
+		)
+	}
+
+	return affinity
+}
+
 func (o *overwrites) evaluateMaxResourceListOverwrite(
 	cpuFieldName,
 	memoryFieldName,
@@ -468,6 +468,228 @@ func TestOverwrites(t *testing.T) {
 	}
 }
 
+//nolint:funlen
+func TestNodeSelectionOverwrites(t *testing.T) {
+	logger := stdoutLogger()
+
+	tests := []struct {
+		Name                       string
+		Config                     *common.KubernetesConfig
+		Variables                  map[string]string
+		ExpectedNodeSelector       map[string]string
+		ExpectedNodeTolerations    map[string]string
+		ExpectedNodeAffinityLabels map[string]string
+		Error                      error
+	}{
+		{
+			Name: "No overwrites allowed",
+			Config: &common.KubernetesConfig{
+				NodeSelector:    map[string]string{"kubernetes.io/arch": "amd64"},
+				NodeTolerations: map[string]string{"dedicated=ci": "NoSchedule"},
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_SELECTOR_ARCH": "kubernetes.io/arch=arm64",
+				"KUBERNETES_NODE_TOLERATIONS_1": "gpu=true:NoSchedule",
+				"KUBERNETES_NODE_AFFINITY_ZONE": "topology.kubernetes.io/zone=eu-west-1a",
+			},
+			ExpectedNodeSelector:    map[string]string{"kubernetes.io/arch": "amd64"},
+			ExpectedNodeTolerations: map[string]string{"dedicated=ci": "NoSchedule"},
+		},
+		{
+			Name: "All overwrites allowed",
+			Config: &common.KubernetesConfig{
+				NodeSelector: map[string]string{
+					"kubernetes.io/arch": "amd64",
+					"kubernetes.io/os":   "linux",
+				},
+				NodeTolerations:                 map[string]string{"dedicated=ci": "NoSchedule"},
+				NodeSelectorOverwriteAllowed:    ".*",
+				NodeTolerationsOverwriteAllowed: ".*",
+				NodeAffinityOverwriteAllowed:    ".*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_SELECTOR_ARCH": "kubernetes.io/arch=arm64",
+				"KUBERNETES_NODE_SELECTOR_GPU":  "nvidia.com/gpu=true",
+				"KUBERNETES_NODE_TOLERATIONS_1": "gpu=true:NoSchedule",
+				"KUBERNETES_NODE_TOLERATIONS_2": "spot:PreferNoSchedule",
+				"KUBERNETES_NODE_TOLERATIONS_3": "maintenance",
+				"KUBERNETES_NODE_AFFINITY_ZONE": "topology.kubernetes.io/zone=eu-west-1a,eu-west-1b",
+			},
+			ExpectedNodeSelector: map[string]string{
+				"kubernetes.io/arch": "arm64",
+				"kubernetes.io/os":   "linux",
+				"nvidia.com/gpu":     "true",
+			},
+			ExpectedNodeTolerations: map[string]string{
+				"dedicated=ci": "NoSchedule",
+				"gpu=true":     "NoSchedule",
+				"spot":         "PreferNoSchedule",
+				"maintenance":  "",
+			},
+			ExpectedNodeAffinityLabels: map[string]string{
+				"topology.kubernetes.io/zone": "eu-west-1a,eu-west-1b",
+			},
+		},
+		{
+			Name: "Overwrites allowed without overwrite variables",
+			Config: &common.KubernetesConfig{
+				NodeSelector:                 map[string]string{"kubernetes.io/arch": "amd64"},
+				NodeSelectorOverwriteAllowed: ".*",
+				NodeAffinityOverwriteAllowed: ".*",
+			},
+			ExpectedNodeSelector:       map[string]string{"kubernetes.io/arch": "amd64"},
+			ExpectedNodeAffinityLabels: map[string]string{},
+		},
+		{
+			Name: "NodeSelector failure",
+			Config: &common.KubernetesConfig{
+				NodeSelectorOverwriteAllowed: "kubernetes.io/arch=.*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_SELECTOR_GPU": "nvidia.com/gpu=true",
+			},
+			Error: new(malformedOverwriteError),
+		},
+		{
+			Name: "NodeSelector malformed key",
+			Config: &common.KubernetesConfig{
+				NodeSelectorOverwriteAllowed: ".*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_SELECTOR_GPU": "nvidia.com/gpu",
+			},
+			Error: new(malformedOverwriteError),
+		},
+		{
+			Name: "NodeTolerations failure",
+			Config: &common.KubernetesConfig{
+				NodeTolerationsOverwriteAllowed: "gpu=.*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_TOLERATIONS_1": "dedicated=ci:NoSchedule",
+			},
+			Error: new(malformedOverwriteError),
+		},
+		{
+			Name: "NodeTolerations malformed key",
+			Config: &common.KubernetesConfig{
+				NodeTolerationsOverwriteAllowed: ".*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_TOLERATIONS_1": "=true:NoSchedule",
+			},
+			Error: new(malformedOverwriteError),
+		},
+		{
+			Name: "NodeAffinity failure",
+			Config: &common.KubernetesConfig{
+				NodeAffinityOverwriteAllowed: "topology.kubernetes.io/zone=.*",
+			},
+			Variables: map[string]string{
+				"KUBERNETES_NODE_AFFINITY_GPU": "nvidia.com/gpu=true",
+			},
+			Error: new(malformedOverwriteError),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			variables := buildOverwriteVariables(variableOverwrites{}, test.Variables)
+
+			values, err := createOverwrites(test.Config, variables, logger)
+			assert.ErrorIs(t, err, test.Error)
+			if test.Error != nil {
+				return
+			}
+
+			assert.Equal(t, test.ExpectedNodeSelector, values.nodeSelector)
+			assert.Equal(t, test.ExpectedNodeTolerations, values.nodeTolerations)
+			assert.Equal(t, test.ExpectedNodeAffinityLabels, values.nodeAffinityLabels)
+		})
+	}
+}
+
+func TestApplyNodeAffinityLabels(t *testing.T) {
+	zoneRequirement := api.NodeSelectorRequirement{
+		Key:      "topology.kubernetes.io/zone",
+		Operator: api.NodeSelectorOpIn,
+		Values:   []string{"eu-west-1a", "eu-west-1b"},
+	}
+	gpuRequirement := api.NodeSelectorRequirement{
+		Key:      "nvidia.com/gpu",
+		Operator: api.NodeSelectorOpIn,
+		Values:   []string{"true"},
+	}
+	archRequirement := api.NodeSelectorRequirement{
+		Key:      "kubernetes.io/arch",
+		Operator: api.NodeSelectorOpIn,
+		Values:   []string{"arm64"},
+	}
+
+	tests := []struct {
+		Name     string
+		Labels   map[string]string
+		Affinity *api.Affinity
+		Expected *api.Affinity
+	}{
+		{
+			Name:     "No labels",
+			Affinity: &api.Affinity{},
+			Expected: &api.Affinity{},
+		},
+		{
+			Name: "Empty affinity",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone": "eu-west-1a,eu-west-1b",
+				"nvidia.com/gpu":              "true",
+			},
+			Affinity: &api.Affinity{},
+			Expected: &api.Affinity{
+				NodeAffinity: &api.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &api.NodeSelector{
+						NodeSelectorTerms: []api.NodeSelectorTerm{
+							{MatchExpressions: []api.NodeSelectorRequirement{gpuRequirement, zoneRequirement}},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "Labels added to every required term",
+			Labels: map[string]string{
+				"nvidia.com/gpu": "true",
+			},
+			Affinity: &api.Affinity{
+				NodeAffinity: &api.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &api.NodeSelector{
+						NodeSelectorTerms: []api.NodeSelectorTerm{
+							{MatchExpressions: []api.NodeSelectorRequirement{zoneRequirement}},
+							{MatchExpressions: []api.NodeSelectorRequirement{archRequirement}},
+						},
+					},
+				},
+			},
+			Expected: &api.Affinity{
+				NodeAffinity: &api.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &api.NodeSelector{
+						NodeSelectorTerms: []api.NodeSelectorTerm{
+							{MatchExpressions: []api.NodeSelectorRequirement{zoneRequirement, gpuRequirement}},
+							{MatchExpressions: []api.NodeSelectorRequirement{archRequirement, gpuRequirement}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			o := &overwrites{nodeAffinityLabels: test.Labels}
+			assert.Equal(t, test.Expected, o.applyNodeAffinityLabels(test.Affinity))
+		})
+	}
+}
+
 func Test_overwriteTooHighError_Is(t *testing.T) {
 	tests := []struct {
 		err        error
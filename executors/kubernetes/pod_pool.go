@@ -0,0 +1,619 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/container/helperimage"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
+)
+
+const (
+	podPoolLabel = "gitlab-runner-pod-pool"
+
+	podPoolScriptsDir = "/scripts-pool"
+	podPoolLogsDir    = "/logs-pool"
+
+	// podPoolJobIDKey is stored in the scripts ConfigMap of a pooled pod next to the
+	// job scripts. It's used to detect when the kubelet has synced the scripts for the
+	// job that claimed the pod.
+	podPoolJobIDKey = "job_id"
+
+	// podPoolReapInterval is how often the idle pods are checked for expiry,
+	// so that they're deleted even when no job is requested
+	podPoolReapInterval = time.Minute
+)
+
+// pooledPod is an idle build pod, together with the scripts ConfigMap mounted in it,
+// that a job can claim instead of creating and scheduling a new pod.
+type pooledPod struct {
+	pod       *api.Pod
+	configMap *api.ConfigMap
+
+	// projectID is the project of the jobs the pod can run. The files of a
+	// job are kept in the pod, so it's never shared between projects.
+	projectID int64
+
+	uses      int
+	idleSince time.Time
+
+	// broken is set when the pod can't be reused, e.g. the job that used it
+	// was aborted or failed with a system failure
+	broken bool
+}
+
+func (p *pooledPod) buildContainer() *api.Container {
+	for i, container := range p.pod.Spec.Containers {
+		if container.Name == buildContainerName {
+			return &p.pod.Spec.Containers[i]
+		}
+	}
+
+	return nil
+}
+
+// podPoolLease is the ExecutorData handed to the executor when the runner
+// has the pod pool enabled. The executor stores the pod it used in it, so that
+// the pod can be returned to the pool when the provider releases the lease.
+type podPoolLease struct {
+	pool *podPool
+	pod  *pooledPod
+}
+
+type podPool struct {
+	lock sync.Mutex
+
+	idle     []*pooledPod
+	creating int
+
+	// pods holds the namespaced names of all pods managed by the pool, idle or used by a job
+	pods map[string]bool
+
+	// template is the pod spec used to pre-create idle pods. It's taken from the
+	// last pod created by a job eligible to run in a pooled pod.
+	template          *api.Pod
+	templateProjectID int64
+	client            kubernetes.Interface
+
+	idleTime     time.Duration
+	reapInterval time.Duration
+	reaper       sync.Once
+
+	newClient func(config *restclient.Config) (kubernetes.Interface, error)
+}
+
+func newPodPool() *podPool {
+	return &podPool{
+		pods:         make(map[string]bool),
+		reapInterval: podPoolReapInterval,
+		newClient: func(config *restclient.Config) (kubernetes.Interface, error) {
+			return kubernetes.NewForConfig(config)
+		},
+	}
+}
+
+// claim removes and returns an idle pod accepted by the match function.
+// It returns nil when no such pod exists.
+func (p *podPool) claim(match func(pp *pooledPod) bool) *pooledPod {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, pp := range p.idle {
+		if !match(pp) {
+			continue
+		}
+
+		p.idle = append(p.idle[:i], p.idle[i+1:]...)
+		return pp
+	}
+
+	return nil
+}
+
+func podPoolKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// track marks a pod as managed by the pool
+func (p *podPool) track(pod *api.Pod) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pods[podPoolKey(pod.Namespace, pod.Name)] = true
+}
+
+// has checks if the pod with the given name is managed by the pool
+func (p *podPool) has(namespace string, name string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.pods[podPoolKey(namespace, name)]
+}
+
+// release returns a pod used by a job to the pool, or deletes it when it's broken
+// or it reached the max uses limit.
+func (p *podPool) release(config common.KubernetesPodPool, pp *pooledPod) {
+	pp.uses++
+
+	switch {
+	case pp.broken:
+		p.discard(pp, "pod is broken")
+		return
+	case config.MaxUses > 0 && pp.uses >= config.MaxUses:
+		p.discard(pp, "max uses reached")
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pp.idleSince = time.Now()
+	p.idle = append(p.idle, pp)
+}
+
+// setTemplate stores the pod used as a template for pre-created pods. The pull secret created
+// for the job's credentials is owned by the job's pod, so it's removed from the template.
+func (p *podPool) setTemplate(
+	kubeConfig *restclient.Config,
+	pod *api.Pod,
+	credentials *api.Secret,
+	projectID int64,
+) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.client == nil {
+		client, err := p.newClient(kubeConfig)
+		if err != nil {
+			return fmt.Errorf("creating pod pool client: %w", err)
+		}
+
+		p.client = client
+	}
+
+	template := pod.DeepCopy()
+	if credentials != nil {
+		var pullSecrets []api.LocalObjectReference
+		for _, secret := range template.Spec.ImagePullSecrets {
+			if secret.Name != credentials.Name {
+				pullSecrets = append(pullSecrets, secret)
+			}
+		}
+		template.Spec.ImagePullSecrets = pullSecrets
+	}
+
+	p.template = template
+	p.templateProjectID = projectID
+
+	return nil
+}
+
+// maintain deletes the pods that were idle for too long and starts creating new pods
+// in the background until the number of idle pods reaches the configured size.
+// It also starts deleting the expired pods periodically, as maintain is only
+// called when jobs are requested.
+func (p *podPool) maintain(config *common.KubernetesConfig) {
+	p.reaper.Do(func() {
+		go p.reap()
+	})
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.idleTime = config.PodPool.GetIdleTime()
+	p.removeExpired()
+
+	if p.template == nil || p.client == nil {
+		return
+	}
+
+	pollInterval := time.Duration(config.GetPollInterval()) * time.Second
+	startTimeout := time.Duration(config.GetPollAttempts()) * pollInterval
+
+	for missing := config.PodPool.Size - len(p.idle) - p.creating; missing > 0; missing-- {
+		p.creating++
+		go p.create(p.template, p.templateProjectID, startTimeout, pollInterval)
+	}
+}
+
+// reap deletes the expired idle pods every reapInterval. It runs for the
+// lifetime of the pool, so that the pods of a runner removed from the
+// configuration are deleted too.
+func (p *podPool) reap() {
+	ticker := time.NewTicker(p.reapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.lock.Lock()
+		p.removeExpired()
+		p.lock.Unlock()
+	}
+}
+
+// removeExpired deletes the pods idle for longer than the idle time, the lock
+// must be held
+func (p *podPool) removeExpired() {
+	var idle []*pooledPod
+	for _, pp := range p.idle {
+		if time.Since(pp.idleSince) > p.idleTime {
+			go p.remove(pp, "idle time exceeded")
+			continue
+		}
+
+		idle = append(idle, pp)
+	}
+	p.idle = idle
+}
+
+func (p *podPool) create(
+	template *api.Pod,
+	projectID int64,
+	startTimeout time.Duration,
+	pollInterval time.Duration,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	pp, err := p.createFromTemplate(ctx, template, pollInterval)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.creating--
+	if err != nil {
+		logrus.WithError(err).Warningln("Creating pooled pod")
+		return
+	}
+
+	pp.projectID = projectID
+	pp.idleSince = time.Now()
+	p.idle = append(p.idle, pp)
+}
+
+func (p *podPool) createFromTemplate(
+	ctx context.Context,
+	template *api.Pod,
+	pollInterval time.Duration,
+) (*pooledPod, error) {
+	configMap, err := p.client.CoreV1().
+		ConfigMaps(template.Namespace).
+		Create(ctx, &api.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: fmt.Sprintf("%s-scripts", template.GenerateName),
+				Namespace:    template.Namespace,
+				Labels:       template.Labels,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating scripts config map: %w", err)
+	}
+
+	podConfig := api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: template.GenerateName,
+			Namespace:    template.Namespace,
+			Labels:       template.Labels,
+			Annotations:  template.Annotations,
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	podConfig.Spec.NodeName = ""
+	for i, volume := range podConfig.Spec.Volumes {
+		if volume.Name == "scripts" && volume.ConfigMap != nil {
+			podConfig.Spec.Volumes[i].ConfigMap.Name = configMap.Name
+		}
+	}
+
+	pod, err := p.client.CoreV1().Pods(template.Namespace).Create(ctx, &podConfig, metav1.CreateOptions{})
+	if err != nil {
+		p.deleteConfigMap(configMap)
+		return nil, fmt.Errorf("creating pod: %w", err)
+	}
+
+	p.track(pod)
+	pp := &pooledPod{pod: pod, configMap: configMap}
+
+	configMap = configMap.DeepCopy()
+	configMap.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: apiVersion,
+			Kind:       ownerReferenceKind,
+			Name:       pod.GetName(),
+			UID:        pod.GetUID(),
+		},
+	})
+	pp.configMap, err = p.client.CoreV1().ConfigMaps(template.Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		p.remove(&pooledPod{pod: pod, configMap: configMap}, "setting config map owner")
+		return nil, fmt.Errorf("setting owner of scripts config map: %w", err)
+	}
+
+	err = p.waitForPodRunning(ctx, pod, pollInterval)
+	if err != nil {
+		p.remove(pp, "pod not running")
+		return nil, err
+	}
+
+	return pp, nil
+}
+
+func (p *podPool) waitForPodRunning(ctx context.Context, pod *api.Pod, pollInterval time.Duration) error {
+	for {
+		current, err := p.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("getting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+
+		running, err := isRunning(current)
+		if err != nil {
+			return err
+		}
+		if running {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for pod %s/%s to be running: %w", pod.Namespace, pod.Name, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// discard removes a claimed pod that won't be returned to the pool
+func (p *podPool) discard(pp *pooledPod, reason string) {
+	go p.remove(pp, reason)
+}
+
+func (p *podPool) remove(pp *pooledPod, reason string) {
+	p.lock.Lock()
+	client := p.client
+	delete(p.pods, podPoolKey(pp.pod.Namespace, pp.pod.Name))
+	p.lock.Unlock()
+
+	if client == nil {
+		return
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"pod":       pp.pod.Name,
+		"namespace": pp.pod.Namespace,
+		"uses":      pp.uses,
+		"reason":    reason,
+	})
+	logger.Debugln("Removing pooled pod")
+
+	err := client.CoreV1().
+		Pods(pp.pod.Namespace).
+		Delete(context.Background(), pp.pod.Name, metav1.DeleteOptions{
+			PropagationPolicy: &PropagationPolicy,
+		})
+	if err != nil && !IsKubernetesPodNotFoundError(err) {
+		logger.WithError(err).Warningln("Error removing pooled pod")
+	}
+}
+
+func (p *podPool) deleteConfigMap(configMap *api.ConfigMap) {
+	err := p.client.CoreV1().
+		ConfigMaps(configMap.Namespace).
+		Delete(context.Background(), configMap.Name, metav1.DeleteOptions{})
+	if err != nil {
+		logrus.WithError(err).Warningln("Error removing pooled pod scripts config map")
+	}
+}
+
+// isPodPoolEligible checks if the job can run in a pod shared with other jobs.
+// Services and exposed ports are part of the pod spec, so jobs using them always
+// get their own pod.
+func (s *executor) isPodPoolEligible() bool {
+	switch {
+	case s.Build.IsFeatureFlagOn(featureflags.UseLegacyKubernetesExecutionStrategy):
+		return false
+	case s.helperImageInfo.OSType != helperimage.OSTypeLinux:
+		return false
+	case len(s.options.Services) > 0 || len(s.options.Image.Ports) > 0:
+		return false
+	}
+
+	return true
+}
+
+func (s *executor) isUsingPooledPod() bool {
+	return s.podPoolLease != nil &&
+		s.podPoolLease.pod != nil &&
+		s.pod != nil &&
+		s.pod.Name == s.podPoolLease.pod.pod.Name
+}
+
+// isPooledPodReusable returns false when the job ended in a way that may leave
+// processes running in the pod, e.g. when it was aborted or had a system failure
+func isPooledPodReusable(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var buildErr *common.BuildError
+	return errors.As(err, &buildErr) && buildErr.ExitCode != 0
+}
+
+// canUsePooledPod checks if the pooled pod was created for the project of the
+// job, with the same settings the job would use for its own pod
+func (s *executor) canUsePooledPod(pp *pooledPod) bool {
+	container := pp.buildContainer()
+	if container == nil {
+		return false
+	}
+
+	image := s.Build.GetAllVariables().ExpandValue(s.options.Image.Name)
+
+	return pp.projectID == s.Build.JobInfo.ProjectID &&
+		pp.pod.Namespace == s.configurationOverwrites.namespace &&
+		pp.pod.Spec.ServiceAccountName == s.configurationOverwrites.serviceAccount &&
+		container.Image == image &&
+		equality.Semantic.DeepEqual(container.Resources.Requests, s.configurationOverwrites.buildRequests) &&
+		equality.Semantic.DeepEqual(container.Resources.Limits, s.configurationOverwrites.buildLimits) &&
+		equality.Semantic.DeepEqual(pp.pod.Spec.NodeSelector, s.configurationOverwrites.nodeSelector) &&
+		sameTolerations(pp.pod.Spec.Tolerations, s.configurationOverwrites.getNodeTolerations()) &&
+		sameAffinity(
+			pp.pod.Spec.Affinity,
+			s.configurationOverwrites.applyNodeAffinityLabels(s.Config.Kubernetes.GetAffinity()),
+		)
+}
+
+// sameTolerations compares tolerations regardless of their order, which isn't stable
+// as they're built from a map
+func sameTolerations(a []api.Toleration, b []api.Toleration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, toleration := range a {
+		found := false
+		for i := range b {
+			if toleration.MatchToleration(&b[i]) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sameAffinity(a *api.Affinity, b *api.Affinity) bool {
+	if a == nil {
+		a = &api.Affinity{}
+	}
+	if b == nil {
+		b = &api.Affinity{}
+	}
+
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+// claimPooledPod tries to run the job in an idle pod from the pod pool. It returns false
+// when no matching pod is available and a new one has to be created.
+func (s *executor) claimPooledPod(ctx context.Context) bool {
+	pp := s.podPoolLease.pool.claim(s.canUsePooledPod)
+	if pp == nil {
+		s.Debugln("No idle pod available in the pod pool")
+		return false
+	}
+
+	err := s.usePooledPod(ctx, pp)
+	if err != nil {
+		s.Warningln(fmt.Sprintf("Using pooled pod %s/%s: %v", pp.pod.Namespace, pp.pod.Name, err))
+		s.podPoolLease.pool.discard(pp, "claim failed")
+		s.pod = nil
+		s.configMap = nil
+
+		return false
+	}
+
+	s.podPoolLease.pod = pp
+
+	return true
+}
+
+func (s *executor) usePooledPod(ctx context.Context, pp *pooledPod) error {
+	shell, err := s.retrieveShell()
+	if err != nil {
+		return err
+	}
+
+	scripts, err := s.generateScripts(shell)
+	if err != nil {
+		return err
+	}
+	scripts[podPoolJobIDKey] = strconv.FormatInt(s.Build.JobResponse.ID, 10)
+
+	configMap := pp.configMap.DeepCopy()
+	configMap.Data = scripts
+
+	s.configMap, err = s.kubeClient.
+		CoreV1().
+		ConfigMaps(configMap.Namespace).
+		Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("updating scripts config map: %w", err)
+	}
+
+	pp.configMap = s.configMap
+	s.pod = pp.pod
+
+	s.Println(fmt.Sprintf("Using pooled pod %s/%s used by %d jobs before", pp.pod.Namespace, pp.pod.Name, pp.uses))
+
+	return s.waitForPooledPodScripts(ctx)
+}
+
+// waitForPooledPodScripts waits until the kubelet synced the updated scripts config map
+// into the pooled pod, and prepares the log file of the job
+func (s *executor) waitForPooledPodScripts(ctx context.Context) error {
+	check := fmt.Sprintf(
+		`test "$(cat %[1]s)" = "%[2]d" && touch %[3]s && chmod 777 %[3]s`,
+		path.Join(s.scriptsDir(), podPoolJobIDKey),
+		s.Build.JobResponse.ID,
+		s.logFile(),
+	)
+
+	pollInterval := time.Duration(s.Config.Kubernetes.GetPollInterval()) * time.Second
+	for attempt := 0; attempt <= s.Config.Kubernetes.GetPollAttempts(); attempt++ {
+		exec := ExecOptions{
+			PodName:       s.pod.Name,
+			Namespace:     s.pod.Namespace,
+			ContainerName: helperContainerName,
+			Command:       []string{"sh", "-c", check},
+			Config:        s.kubeConfig,
+			Client:        s.kubeClient,
+			Executor:      &DefaultRemoteExecutor{},
+		}
+
+		err := exec.Run()
+		if err == nil {
+			return nil
+		}
+
+		s.Debugln("Waiting for scripts to be synced into the pooled pod:", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	return errors.New("timed out waiting for scripts to be synced into the pooled pod")
+}
+
+// addPodToPool makes the pod created for the job available to other jobs once this one
+// finishes, and uses it as the template for pre-created pods
+func (s *executor) addPodToPool() {
+	if s.podPoolLease.pod != nil {
+		s.podPoolLease.pool.discard(s.podPoolLease.pod, "replaced by a new pod")
+	}
+
+	s.podPoolLease.pod = &pooledPod{pod: s.pod, configMap: s.configMap, projectID: s.Build.JobInfo.ProjectID}
+	s.podPoolLease.pool.track(s.pod)
+
+	err := s.podPoolLease.pool.setTemplate(s.kubeConfig, s.pod, s.credentials, s.Build.JobInfo.ProjectID)
+	if err != nil {
+		s.Warningln("Adding pod to the pod pool:", err)
+	}
+}
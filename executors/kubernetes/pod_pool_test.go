@@ -0,0 +1,289 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+func newFakePodPoolClient() *fake.Clientset {
+	client := fake.NewSimpleClientset()
+
+	generated := 0
+	client.PrependReactor("create", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, ok := action.(k8stesting.CreateAction).GetObject().(metav1.Object)
+		if ok && obj.GetName() == "" {
+			generated++
+			obj.SetName(fmt.Sprintf("%s%d", obj.GetGenerateName(), generated))
+		}
+
+		if pod, ok := obj.(*api.Pod); ok {
+			pod.Status.Phase = api.PodRunning
+		}
+
+		return false, nil, nil
+	})
+
+	return client
+}
+
+func newTestPooledPod(client kubernetes.Interface, name string, image string) *pooledPod {
+	pod := &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{Name: buildContainerName, Image: image},
+				{Name: helperContainerName, Image: "helper"},
+			},
+		},
+	}
+
+	if client != nil {
+		_, _ = client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	}
+
+	return &pooledPod{pod: pod, configMap: &api.ConfigMap{}, idleSince: time.Now()}
+}
+
+func podExists(client kubernetes.Interface, name string) bool {
+	_, err := client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+	return err == nil
+}
+
+func TestPodPoolClaim(t *testing.T) {
+	pool := newPodPool()
+	pool.idle = []*pooledPod{
+		newTestPooledPod(nil, "alpine-pod", "alpine"),
+		newTestPooledPod(nil, "ubuntu-pod", "ubuntu"),
+	}
+
+	matchImage := func(image string) func(pp *pooledPod) bool {
+		return func(pp *pooledPod) bool {
+			return pp.buildContainer().Image == image
+		}
+	}
+
+	assert.Nil(t, pool.claim(matchImage("debian")))
+
+	pp := pool.claim(matchImage("ubuntu"))
+	require.NotNil(t, pp)
+	assert.Equal(t, "ubuntu-pod", pp.pod.Name)
+	assert.Len(t, pool.idle, 1)
+
+	assert.Nil(t, pool.claim(matchImage("ubuntu")))
+}
+
+func TestPodPoolRelease(t *testing.T) {
+	tests := map[string]struct {
+		maxUses        int
+		uses           int
+		broken         bool
+		expectReturned bool
+	}{
+		"returned to the pool": {
+			maxUses:        0,
+			uses:           10,
+			expectReturned: true,
+		},
+		"below max uses": {
+			maxUses:        3,
+			uses:           1,
+			expectReturned: true,
+		},
+		"max uses reached": {
+			maxUses:        3,
+			uses:           2,
+			expectReturned: false,
+		},
+		"broken pod": {
+			maxUses:        0,
+			broken:         true,
+			expectReturned: false,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := newFakePodPoolClient()
+			pool := newPodPool()
+			pool.client = client
+
+			pp := newTestPooledPod(client, "pod", "alpine")
+			pp.uses = tt.uses
+			pp.broken = tt.broken
+
+			pool.release(common.KubernetesPodPool{Size: 1, MaxUses: tt.maxUses}, pp)
+
+			assert.Equal(t, tt.uses+1, pp.uses)
+			if tt.expectReturned {
+				assert.Len(t, pool.idle, 1)
+				assert.True(t, podExists(client, "pod"))
+				return
+			}
+
+			assert.Empty(t, pool.idle)
+			assert.Eventually(t, func() bool {
+				return !podExists(client, "pod")
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestPodPoolMaintain(t *testing.T) {
+	client := newFakePodPoolClient()
+
+	pool := newPodPool()
+	pool.client = client
+
+	expired := newTestPooledPod(client, "expired", "alpine")
+	expired.idleSince = time.Now().Add(-time.Hour)
+	pool.idle = []*pooledPod{expired}
+
+	config := &common.KubernetesConfig{
+		PollInterval: 1,
+		PodPool: common.KubernetesPodPool{
+			Size:     2,
+			IdleTime: 60,
+		},
+	}
+
+	// Without a template, the pool can only remove expired pods
+	pool.maintain(config)
+	assert.Empty(t, pool.idle)
+	assert.Eventually(t, func() bool {
+		return !podExists(client, "expired")
+	}, time.Second, 10*time.Millisecond)
+
+	template := newTestPooledPod(nil, "template", "alpine").pod
+	template.GenerateName = "runner-pool-"
+	template.Spec.ImagePullSecrets = []api.LocalObjectReference{{Name: "config-secret"}, {Name: "job-secret"}}
+	template.Spec.Volumes = []api.Volume{
+		{
+			Name: "scripts",
+			VolumeSource: api.VolumeSource{
+				ConfigMap: &api.ConfigMapVolumeSource{
+					LocalObjectReference: api.LocalObjectReference{Name: "job-scripts"},
+				},
+			},
+		},
+	}
+
+	err := pool.setTemplate(nil, template, &api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "job-secret"}}, 10)
+	require.NoError(t, err)
+
+	pool.maintain(config)
+	assert.Eventually(t, func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+
+		return len(pool.idle) == 2 && pool.creating == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, pp := range pool.idle {
+		assert.Equal(t, int64(10), pp.projectID)
+		assert.Equal(t, []api.LocalObjectReference{{Name: "config-secret"}}, pp.pod.Spec.ImagePullSecrets)
+		assert.Equal(t, pp.configMap.Name, pp.pod.Spec.Volumes[0].ConfigMap.Name)
+		require.Len(t, pp.configMap.OwnerReferences, 1)
+		assert.Equal(t, pp.pod.Name, pp.configMap.OwnerReferences[0].Name)
+	}
+
+	// The pool is full, so no new pods are created
+	pool.maintain(config)
+	assert.Equal(t, 0, pool.creating)
+}
+
+func TestPodPoolReapsExpiredPods(t *testing.T) {
+	client := newFakePodPoolClient()
+
+	pool := newPodPool()
+	pool.client = client
+	pool.reapInterval = 10 * time.Millisecond
+	pool.idle = []*pooledPod{newTestPooledPod(client, "idle", "alpine")}
+
+	config := &common.KubernetesConfig{
+		PodPool: common.KubernetesPodPool{Size: 1, IdleTime: 60},
+	}
+	pool.maintain(config)
+	assert.Len(t, pool.idle, 1)
+
+	// no job is requested after the pod expires
+	pool.lock.Lock()
+	pool.idle[0].idleSince = time.Now().Add(-time.Hour)
+	pool.lock.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return !podExists(client, "idle")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	assert.Empty(t, pool.idle)
+}
+
+func TestCanUsePooledPod(t *testing.T) {
+	newExecutor := func(projectID int64) *executor {
+		e := &executor{
+			AbstractExecutor: executors.AbstractExecutor{
+				Build:  &common.Build{JobResponse: common.JobResponse{JobInfo: common.JobInfo{ProjectID: projectID}}},
+				Config: common.RunnerConfig{RunnerSettings: common.RunnerSettings{Kubernetes: &common.KubernetesConfig{}}},
+			},
+			options:                 &kubernetesOptions{Image: common.Image{Name: "alpine"}},
+			configurationOverwrites: &overwrites{namespace: "default"},
+		}
+
+		return e
+	}
+
+	pp := newTestPooledPod(nil, "pod", "alpine")
+	pp.projectID = 10
+
+	assert.True(t, newExecutor(10).canUsePooledPod(pp))
+	assert.False(t, newExecutor(20).canUsePooledPod(pp), "pods aren't shared between projects")
+}
+
+func TestIsPooledPodReusable(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		reusable bool
+	}{
+		"job succeeded": {
+			err:      nil,
+			reusable: true,
+		},
+		"script failure": {
+			err:      &common.BuildError{Inner: errors.New("exit code 1"), ExitCode: 1},
+			reusable: true,
+		},
+		"pod failure": {
+			err:      &common.BuildError{Inner: errors.New("pod status is failed")},
+			reusable: false,
+		},
+		"job aborted": {
+			err:      errors.New("build aborted"),
+			reusable: false,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.reusable, isPooledPodReusable(tt.err))
+		})
+	}
+}
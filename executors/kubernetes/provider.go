@@ -0,0 +1,185 @@
+package kubernetes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+type resourceCleanupState struct {
+	lastRun time.Time
+	running bool
+}
+
+// executorProvider extends the default executor provider with the state shared by
+// all Kubernetes executors of a runner: the pool of idle build pods, the jobs
+// used to find leaked resources and the health of the clusters jobs are placed in
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+
+	lock     sync.Mutex
+	pools    map[string]*podPool
+	cleanups map[string]*resourceCleanupState
+
+	jobs     *jobTracker
+	clusters *clusterRegistry
+
+	newResourceCleaners func(
+		runner *common.RunnerConfig,
+		namespaces []string,
+		isTracked func(kind string, obj metav1.Object) bool,
+	) ([]*resourceCleaner, error)
+}
+
+func newExecutorProvider(provider executors.DefaultExecutorProvider) *executorProvider {
+	return &executorProvider{
+		DefaultExecutorProvider: provider,
+		pools:                   make(map[string]*podPool),
+		cleanups:                make(map[string]*resourceCleanupState),
+		jobs:                    newJobTracker(),
+		clusters:                newClusterRegistry(),
+		newResourceCleaners:     newResourceCleaners,
+	}
+}
+
+func (p *executorProvider) Create() common.Executor {
+	e := p.DefaultExecutorProvider.Create()
+	if executor, ok := e.(*executor); ok {
+		executor.jobs = p.jobs
+		executor.clusters = p.clusters
+	}
+
+	return e
+}
+
+// Describe implements prometheus.Collector.
+func (p *executorProvider) Describe(ch chan<- *prometheus.Desc) {
+	p.clusters.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *executorProvider) Collect(ch chan<- prometheus.Metric) {
+	p.clusters.Collect(ch)
+}
+
+func (p *executorProvider) pool(config *common.RunnerConfig) *podPool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pool, ok := p.pools[config.UniqueID()]
+	if !ok {
+		pool = newPodPool()
+		p.pools[config.UniqueID()] = pool
+	}
+
+	return pool
+}
+
+func (p *executorProvider) Acquire(config *common.RunnerConfig) (common.ExecutorData, error) {
+	if config.Kubernetes == nil {
+		return nil, nil
+	}
+
+	if config.Kubernetes.ResourceCleanup.IsEnabled() {
+		p.cleanupLeakedResources(config)
+	}
+
+	// The pods of the pool are created in a single cluster, so the pool can't be
+	// used when the jobs of the runner can be placed in several clusters
+	if !config.Kubernetes.PodPool.IsEnabled() || len(config.Kubernetes.Clusters) > 0 {
+		return nil, nil
+	}
+
+	pool := p.pool(config)
+	pool.maintain(config.Kubernetes)
+
+	return &podPoolLease{pool: pool}, nil
+}
+
+func (p *executorProvider) Release(config *common.RunnerConfig, data common.ExecutorData) {
+	lease, ok := data.(*podPoolLease)
+	if !ok || lease.pod == nil {
+		return
+	}
+
+	if config == nil || config.Kubernetes == nil {
+		lease.pool.discard(lease.pod, "missing configuration")
+		return
+	}
+
+	lease.pool.release(config.Kubernetes.PodPool, lease.pod)
+	lease.pool.maintain(config.Kubernetes)
+}
+
+// cleanupLeakedResources starts the cleanup of leaked resources in the background
+// when the configured interval passed since its last run for the runner
+func (p *executorProvider) cleanupLeakedResources(config *common.RunnerConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	state, ok := p.cleanups[config.UniqueID()]
+	if !ok {
+		state = &resourceCleanupState{}
+		p.cleanups[config.UniqueID()] = state
+	}
+
+	if state.running || time.Since(state.lastRun) < config.Kubernetes.ResourceCleanup.GetInterval() {
+		return
+	}
+
+	state.running = true
+	state.lastRun = time.Now()
+
+	pool := p.pools[config.UniqueID()]
+	runner := *config
+
+	go func() {
+		defer func() {
+			p.lock.Lock()
+			state.running = false
+			p.lock.Unlock()
+		}()
+
+		p.runResourceCleanup(&runner, pool)
+	}()
+}
+
+func (p *executorProvider) runResourceCleanup(config *common.RunnerConfig, pool *podPool) {
+	runnerID := runnerIDLabelValue(&config.RunnerCredentials)
+
+	isTracked := func(kind string, obj metav1.Object) bool {
+		if kind == "pod" && pool != nil && pool.has(obj.GetNamespace(), obj.GetName()) {
+			return true
+		}
+
+		return p.jobs.has(runnerID, obj.GetLabels()[jobIDLabel])
+	}
+
+	logger := config.Log()
+
+	cleaners, err := p.newResourceCleaners(config, p.jobs.namespacesOf(runnerID), isTracked)
+	if err != nil {
+		logger.WithError(err).Warningln("Preparing leaked resources cleanup")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Kubernetes.ResourceCleanup.GetInterval())
+	defer cancel()
+
+	for _, cleaner := range cleaners {
+		leaked, err := cleaner.Cleanup(ctx)
+		if err != nil {
+			cleaner.logger.WithError(err).Warningln("Cleaning up leaked resources")
+		}
+
+		if len(leaked) > 0 {
+			cleaner.logger.WithField("resources", len(leaked)).Infoln("Cleaned up leaked resources")
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+func TestExecutorProviderPodPoolAcquireRelease(t *testing.T) {
+	provider := newExecutorProvider(executors.DefaultExecutorProvider{})
+
+	config := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{Token: "token"},
+		RunnerSettings: common.RunnerSettings{
+			Kubernetes: &common.KubernetesConfig{},
+		},
+	}
+
+	data, err := provider.Acquire(config)
+	require.NoError(t, err)
+	assert.Nil(t, data, "pod pool is disabled")
+
+	config.Kubernetes.PodPool.Size = 1
+	data, err = provider.Acquire(config)
+	require.NoError(t, err)
+	require.IsType(t, &podPoolLease{}, data)
+
+	lease := data.(*podPoolLease)
+	assert.Same(t, provider.pool(config), lease.pool)
+
+	lease.pod = newTestPooledPod(nil, "pod", "alpine")
+	provider.Release(config, lease)
+	assert.Len(t, lease.pool.idle, 1)
+	assert.Equal(t, 1, lease.pod.uses)
+}
+
+func TestExecutorProviderCreateSetsJobTracker(t *testing.T) {
+	provider := newExecutorProvider(executors.DefaultExecutorProvider{
+		Creator: func() common.Executor {
+			return newExecutor()
+		},
+	})
+
+	e := provider.Create()
+	require.IsType(t, &executor{}, e)
+	assert.Same(t, provider.jobs, e.(*executor).jobs)
+}
+
+func TestExecutorProviderCleanupLeakedResources(t *testing.T) {
+	client := newLeakTestClient()
+
+	provider := newExecutorProvider(executors.DefaultExecutorProvider{})
+	provider.newResourceCleaners = func(
+		runner *common.RunnerConfig,
+		namespaces []string,
+		isTracked func(kind string, obj metav1.Object) bool,
+	) ([]*resourceCleaner, error) {
+		assert.Equal(t, []string{"overwritten"}, namespaces)
+
+		return []*resourceCleaner{{
+			client:      client,
+			namespace:   "default",
+			runnerID:    "runner",
+			gracePeriod: runner.Kubernetes.ResourceCleanup.GetGracePeriod(),
+			isTracked:   isTracked,
+			logger:      logrus.New(),
+		}}, nil
+	}
+	provider.jobs.add("runner", 2)
+	provider.jobs.addNamespace("runner", "overwritten")
+
+	config := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{Token: "runner"},
+		RunnerSettings: common.RunnerSettings{
+			Kubernetes: &common.KubernetesConfig{
+				ResourceCleanup: common.KubernetesResourceCleanup{Interval: 3600},
+			},
+		},
+	}
+
+	pool := provider.pool(config)
+	pool.track(&api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "leaked-pod", Namespace: "default"}})
+
+	_, err := provider.Acquire(config)
+	require.NoError(t, err)
+
+	podExists := func(name string) bool {
+		_, err := client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		return err == nil
+	}
+
+	assert.Eventually(t, func() bool {
+		_, err := client.CoreV1().Services("default").Get(context.Background(), "leaked-service", metav1.GetOptions{})
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.True(t, podExists("leaked-pod"), "pod managed by the pod pool")
+	assert.True(t, podExists("running-pod"), "pod of a tracked job")
+
+	// The cleanup doesn't run again before the interval passes
+	provider.lock.Lock()
+	lastRun := provider.cleanups[config.UniqueID()].lastRun
+	provider.lock.Unlock()
+
+	_, err = provider.Acquire(config)
+	require.NoError(t, err)
+
+	provider.lock.Lock()
+	assert.Equal(t, lastRun, provider.cleanups[config.UniqueID()].lastRun)
+	provider.lock.Unlock()
+}
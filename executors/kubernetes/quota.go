@@ -0,0 +1,374 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// quotaResources maps the resources tracked by a ResourceQuota to the pod
+// resources they count. Resources not listed here aren't checked.
+var quotaResources = map[api.ResourceName]struct {
+	resource api.ResourceName
+	limits   bool
+}{
+	api.ResourceCPU:                      {resource: api.ResourceCPU},
+	api.ResourceRequestsCPU:              {resource: api.ResourceCPU},
+	api.ResourceLimitsCPU:                {resource: api.ResourceCPU, limits: true},
+	api.ResourceMemory:                   {resource: api.ResourceMemory},
+	api.ResourceRequestsMemory:           {resource: api.ResourceMemory},
+	api.ResourceLimitsMemory:             {resource: api.ResourceMemory, limits: true},
+	api.ResourceEphemeralStorage:         {resource: api.ResourceEphemeralStorage},
+	api.ResourceRequestsEphemeralStorage: {resource: api.ResourceEphemeralStorage},
+	api.ResourceLimitsEphemeralStorage:   {resource: api.ResourceEphemeralStorage, limits: true},
+}
+
+var quotaPodCountResources = []api.ResourceName{api.ResourcePods, "count/pods"}
+
+// quotaViolationError is returned when the pod can never be admitted in the namespace,
+// no matter how long the runner waits
+type quotaViolationError struct {
+	reason string
+}
+
+func (e *quotaViolationError) Error() string {
+	return e.reason
+}
+
+// waitForResourceQuota checks that the pod is allowed by the limit ranges of its namespace and
+// waits for the resource quotas of the namespace to have room for it. The requests and limits
+// of the pod are the ones the quotas would count, after the limit range defaults are applied.
+func waitForResourceQuota(
+	ctx context.Context,
+	c kubernetes.Interface,
+	pod *api.Pod,
+	out io.Writer,
+	config *common.KubernetesConfig,
+) error {
+	timeout := config.ResourceQuota.GetWaitTimeout()
+	pollInterval := time.Duration(config.GetPollInterval()) * time.Second
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		reason, err := checkResourceQuota(ctx, c, pod)
+		if err != nil || reason == "" {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(out, "Waiting for quota in namespace %s: %s\n", pod.Namespace, reason)
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %s waiting for quota: %s", timeout, reason)
+			}
+
+			return ctx.Err()
+		}
+	}
+}
+
+// checkResourceQuota returns the reason the pod can't be created yet, or an empty
+// string if the quotas of the namespace have room for it
+func checkResourceQuota(ctx context.Context, c kubernetes.Interface, pod *api.Pod) (string, error) {
+	limitRanges, err := c.CoreV1().LimitRanges(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing limit ranges: %w", err)
+	}
+
+	containers := applyLimitRangeDefaults(pod, limitRanges.Items)
+
+	err = checkLimitRanges(containers, len(pod.Spec.InitContainers), limitRanges.Items)
+	if err != nil {
+		return "", err
+	}
+
+	quotas, err := c.CoreV1().ResourceQuotas(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing resource quotas: %w", err)
+	}
+
+	requests, limits := podResources(containers, len(pod.Spec.InitContainers))
+
+	var reasons []string
+	for _, quota := range quotas.Items {
+		reason, err := checkQuota(quota, containers, requests, limits)
+		if err != nil {
+			return "", err
+		}
+
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	return strings.Join(reasons, ", "), nil
+}
+
+// applyLimitRangeDefaults returns the resources of the init containers followed by the
+// resources of the containers of the pod, with the container defaults of the limit ranges
+// applied the way the LimitRanger admission plugin does
+func applyLimitRangeDefaults(pod *api.Pod, limitRanges []api.LimitRange) []api.ResourceRequirements {
+	var containers []api.ResourceRequirements
+	for _, container := range append(append([]api.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		resources := api.ResourceRequirements{
+			Requests: container.Resources.Requests.DeepCopy(),
+			Limits:   container.Resources.Limits.DeepCopy(),
+		}
+		if resources.Requests == nil {
+			resources.Requests = api.ResourceList{}
+		}
+		if resources.Limits == nil {
+			resources.Limits = api.ResourceList{}
+		}
+
+		for _, limitRange := range limitRanges {
+			for _, item := range limitRange.Spec.Limits {
+				if item.Type != api.LimitTypeContainer {
+					continue
+				}
+
+				setMissingResources(resources.Limits, item.Default)
+				setMissingResources(resources.Requests, item.DefaultRequest)
+			}
+		}
+
+		// A container with a limit and no request gets a request equal to its limit
+		setMissingResources(resources.Requests, resources.Limits)
+
+		containers = append(containers, resources)
+	}
+
+	return containers
+}
+
+func setMissingResources(list api.ResourceList, defaults api.ResourceList) {
+	for name, quantity := range defaults {
+		if _, ok := list[name]; !ok {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// checkLimitRanges returns an error when a container or the pod is outside of the
+// minimum and maximum resources allowed by the limit ranges
+func checkLimitRanges(containers []api.ResourceRequirements, initContainers int, limitRanges []api.LimitRange) error {
+	requests, limits := podResources(containers, initContainers)
+
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			switch item.Type {
+			case api.LimitTypeContainer:
+				for _, container := range containers {
+					err := checkLimitRangeItem(limitRange.Name, "container", item, container.Requests, container.Limits)
+					if err != nil {
+						return err
+					}
+				}
+			case api.LimitTypePod:
+				err := checkLimitRangeItem(limitRange.Name, "pod", item, requests, limits)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkLimitRangeItem(
+	limitRange string,
+	kind string,
+	item api.LimitRangeItem,
+	requests api.ResourceList,
+	limits api.ResourceList,
+) error {
+	for name, minimum := range item.Min {
+		if request, ok := requests[name]; ok && request.Cmp(minimum) < 0 {
+			return &quotaViolationError{reason: fmt.Sprintf(
+				"limit range %s: minimum %s usage per %s is %s, but request is %s",
+				limitRange, name, kind, minimum.String(), request.String(),
+			)}
+		}
+	}
+
+	for name, maximum := range item.Max {
+		if limit, ok := limits[name]; ok && limit.Cmp(maximum) > 0 {
+			return &quotaViolationError{reason: fmt.Sprintf(
+				"limit range %s: maximum %s usage per %s is %s, but limit is %s",
+				limitRange, name, kind, maximum.String(), limit.String(),
+			)}
+		}
+	}
+
+	return nil
+}
+
+// podResources returns the requests and limits of the pod counted by the resource quotas:
+// the sum of the containers' resources, or the resources of an init container when higher
+func podResources(containers []api.ResourceRequirements, initContainers int) (api.ResourceList, api.ResourceList) {
+	requests, limits := sumResources(containers[initContainers:])
+
+	for _, container := range containers[:initContainers] {
+		maxResources(requests, container.Requests)
+		maxResources(limits, container.Limits)
+	}
+
+	return requests, limits
+}
+
+func sumResources(containers []api.ResourceRequirements) (api.ResourceList, api.ResourceList) {
+	requests := api.ResourceList{}
+	limits := api.ResourceList{}
+
+	for _, container := range containers {
+		addResources(requests, container.Requests)
+		addResources(limits, container.Limits)
+	}
+
+	return requests, limits
+}
+
+func addResources(list api.ResourceList, resources api.ResourceList) {
+	for name, quantity := range resources {
+		total := list[name]
+		total.Add(quantity)
+		list[name] = total
+	}
+}
+
+func maxResources(list api.ResourceList, resources api.ResourceList) {
+	for name, quantity := range resources {
+		if current, ok := list[name]; !ok || quantity.Cmp(current) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// checkQuota returns the reason the quota has no room for the pod, or an empty string. Scoped quotas
+// are skipped, as whether they match the pod depends on the scheduling of the pod.
+func checkQuota(
+	quota api.ResourceQuota,
+	containers []api.ResourceRequirements,
+	requests api.ResourceList,
+	limits api.ResourceList,
+) (string, error) {
+	if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+		return "", nil
+	}
+
+	hard := quota.Status.Hard
+	if len(hard) == 0 {
+		hard = quota.Spec.Hard
+	}
+
+	var exceeded []string
+	for name, maximum := range hard {
+		needed, tracked, err := quotaUsage(name, containers, requests, limits)
+		if err != nil {
+			return "", &quotaViolationError{reason: fmt.Sprintf("resource quota %s: %v", quota.Name, err)}
+		}
+		if !tracked {
+			continue
+		}
+
+		if needed.Cmp(maximum) > 0 {
+			return "", &quotaViolationError{reason: fmt.Sprintf(
+				"resource quota %s: pod requires %s of %s, but the quota allows %s",
+				quota.Name, needed.String(), name, maximum.String(),
+			)}
+		}
+
+		used := quota.Status.Used[name]
+		total := used.DeepCopy()
+		total.Add(needed)
+		if total.Cmp(maximum) > 0 {
+			exceeded = append(exceeded, fmt.Sprintf(
+				"%s requested %s, used %s, limited %s", name, needed.String(), used.String(), maximum.String(),
+			))
+		}
+	}
+
+	if len(exceeded) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("resource quota %s exceeded (%s)", quota.Name, strings.Join(exceeded, ", ")), nil
+}
+
+// quotaUsage returns the quantity of the quota resource the pod uses. Like the Kubernetes quota
+// admission, it fails when the quota tracks a compute resource a container doesn't set.
+func quotaUsage(
+	name api.ResourceName,
+	containers []api.ResourceRequirements,
+	requests api.ResourceList,
+	limits api.ResourceList,
+) (resource.Quantity, bool, error) {
+	for _, countResource := range quotaPodCountResources {
+		if name == countResource {
+			return *resource.NewQuantity(1, resource.DecimalSI), true, nil
+		}
+	}
+
+	quotaResource, ok := quotaResources[name]
+	if !ok {
+		return resource.Quantity{}, false, nil
+	}
+
+	list := requests
+	for _, container := range containers {
+		set := container.Requests
+		if quotaResource.limits {
+			set = container.Limits
+		}
+
+		if _, ok := set[quotaResource.resource]; !ok {
+			return resource.Quantity{}, false, fmt.Errorf("all containers must specify %s", name)
+		}
+	}
+
+	if quotaResource.limits {
+		list = limits
+	}
+
+	return list[quotaResource.resource], true, nil
+}
+
+// waitForResourceQuota waits for the namespace of the build pod to have room for it. When the job
+// can fall back to another cluster, the quotas are checked only once instead.
+// When the service account of the runner can't read the quotas, the check is skipped.
+func (s *executor) waitForResourceQuota(pod *api.Pod) error {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var err error
+	if s.hasFallbackCluster() {
+		err = s.checkClusterQuota(ctx, pod)
+	} else {
+		err = waitForResourceQuota(ctx, s.kubeClient, pod, s.Trace, s.Config.Kubernetes)
+	}
+
+	if kubeerrors.IsForbidden(err) {
+		s.Warningln(fmt.Sprintf("Skipping the resource quota check: %v", err))
+		return nil
+	}
+
+	return err
+}
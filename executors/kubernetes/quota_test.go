@@ -0,0 +1,225 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func newQuotaTestPod(buildRequests api.ResourceList, buildLimits api.ResourceList) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec: api.PodSpec{
+			InitContainers: []api.Container{
+				{
+					Name: "init-permissions",
+					Resources: api.ResourceRequirements{
+						Requests: api.ResourceList{api.ResourceCPU: resource.MustParse("100m")},
+					},
+				},
+			},
+			Containers: []api.Container{
+				{
+					Name:      buildContainerName,
+					Resources: api.ResourceRequirements{Requests: buildRequests, Limits: buildLimits},
+				},
+				{
+					Name: helperContainerName,
+					Resources: api.ResourceRequirements{
+						Requests: api.ResourceList{api.ResourceCPU: resource.MustParse("100m")},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newTestResourceQuota(hard api.ResourceList, used api.ResourceList) *api.ResourceQuota {
+	return &api.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       api.ResourceQuotaSpec{Hard: hard},
+		Status:     api.ResourceQuotaStatus{Hard: hard, Used: used},
+	}
+}
+
+func newTestLimitRange(items ...api.LimitRangeItem) *api.LimitRange {
+	return &api.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "default"},
+		Spec:       api.LimitRangeSpec{Limits: items},
+	}
+}
+
+//nolint:funlen
+func TestCheckResourceQuota(t *testing.T) {
+	cpu := func(q string) api.ResourceList {
+		return api.ResourceList{api.ResourceCPU: resource.MustParse(q)}
+	}
+
+	tests := map[string]struct {
+		pod            *api.Pod
+		objects        []runtime.Object
+		expectedReason string
+		expectedErr    string
+	}{
+		"no quota": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+		},
+		"quota with room": {
+			pod:     newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{newTestResourceQuota(cpu("2"), cpu("1"))},
+		},
+		"quota exceeded": {
+			pod:            newQuotaTestPod(cpu("500m"), nil),
+			objects:        []runtime.Object{newTestResourceQuota(cpu("2"), cpu("1500m"))},
+			expectedReason: "resource quota quota exceeded (cpu requested 600m, used 1500m, limited 2)",
+		},
+		"pod count exceeded": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{newTestResourceQuota(
+				api.ResourceList{api.ResourcePods: resource.MustParse("2")},
+				api.ResourceList{api.ResourcePods: resource.MustParse("2")},
+			)},
+			expectedReason: "resource quota quota exceeded (pods requested 1, used 2, limited 2)",
+		},
+		"scoped quota is skipped": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{func() runtime.Object {
+				quota := newTestResourceQuota(cpu("1"), cpu("1"))
+				quota.Spec.Scopes = []api.ResourceQuotaScope{api.ResourceQuotaScopeBestEffort}
+				return quota
+			}()},
+		},
+		"pod larger than quota": {
+			pod:         newQuotaTestPod(cpu("4"), nil),
+			objects:     []runtime.Object{newTestResourceQuota(cpu("2"), nil)},
+			expectedErr: "resource quota quota: pod requires 4100m of cpu, but the quota allows 2",
+		},
+		"quota tracks limits not set": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{newTestResourceQuota(
+				api.ResourceList{api.ResourceLimitsCPU: resource.MustParse("2")},
+				nil,
+			)},
+			expectedErr: "resource quota quota: all containers must specify limits.cpu",
+		},
+		"limit range defaults are counted": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{
+				newTestLimitRange(api.LimitRangeItem{
+					Type:    api.LimitTypeContainer,
+					Default: cpu("1"),
+				}),
+				newTestResourceQuota(
+					api.ResourceList{api.ResourceLimitsCPU: resource.MustParse("4")},
+					api.ResourceList{api.ResourceLimitsCPU: resource.MustParse("3")},
+				),
+			},
+			expectedReason: "resource quota quota exceeded (limits.cpu requested 2, used 3, limited 4)",
+		},
+		"limit range container maximum": {
+			pod: newQuotaTestPod(cpu("500m"), cpu("2")),
+			objects: []runtime.Object{newTestLimitRange(api.LimitRangeItem{
+				Type: api.LimitTypeContainer,
+				Max:  cpu("1"),
+			})},
+			expectedErr: "limit range limits: maximum cpu usage per container is 1, but limit is 2",
+		},
+		"limit range container minimum": {
+			pod: newQuotaTestPod(cpu("500m"), nil),
+			objects: []runtime.Object{newTestLimitRange(api.LimitRangeItem{
+				Type: api.LimitTypeContainer,
+				Min:  cpu("200m"),
+			})},
+			expectedErr: "limit range limits: minimum cpu usage per container is 200m, but request is 100m",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.objects...)
+
+			reason, err := checkResourceQuota(context.Background(), client, tt.pod)
+			if tt.expectedErr != "" {
+				var violation *quotaViolationError
+				require.True(t, errors.As(err, &violation), "expected quota violation, got %v", err)
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedReason, reason)
+		})
+	}
+}
+
+func TestWaitForResourceQuota(t *testing.T) {
+	cpu := func(q string) api.ResourceList {
+		return api.ResourceList{api.ResourceCPU: resource.MustParse(q)}
+	}
+
+	config := &common.KubernetesConfig{
+		PollInterval:  1,
+		ResourceQuota: common.KubernetesResourceQuota{Check: true, WaitTimeout: 5},
+	}
+
+	t.Run("waits for quota", func(t *testing.T) {
+		quota := newTestResourceQuota(cpu("1"), cpu("1"))
+		client := fake.NewSimpleClientset(quota)
+
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			quota.Status.Used = cpu("0")
+			_, _ = client.CoreV1().ResourceQuotas("default").UpdateStatus(
+				context.Background(),
+				quota,
+				metav1.UpdateOptions{},
+			)
+		}()
+
+		out := new(bytes.Buffer)
+		err := waitForResourceQuota(context.Background(), client, newQuotaTestPod(cpu("100m"), nil), out, config)
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "Waiting for quota in namespace default: resource quota quota exceeded")
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		client := fake.NewSimpleClientset(newTestResourceQuota(cpu("1"), cpu("1")))
+
+		config := *config
+		config.ResourceQuota.WaitTimeout = 1
+
+		pod := newQuotaTestPod(cpu("100m"), nil)
+		err := waitForResourceQuota(context.Background(), client, pod, new(bytes.Buffer), &config)
+		assert.EqualError(t, err, "timed out after 1s waiting for quota: "+
+			"resource quota quota exceeded (cpu requested 200m, used 1, limited 1)")
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+		client.PrependReactor("list", "limitranges", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeerrors.NewForbidden(schema.GroupResource{Resource: "limitranges"}, "", errors.New("denied"))
+		})
+
+		pod := newQuotaTestPod(cpu("100m"), nil)
+		err := waitForResourceQuota(context.Background(), client, pod, new(bytes.Buffer), config)
+		assert.True(t, kubeerrors.IsForbidden(err))
+	})
+}
@@ -140,18 +140,44 @@ type podPhaseResponse struct {
 	done  bool
 	phase api.PodPhase
 	err   error
+
+	// unschedulable holds why the scheduler can't place the pending pod, if it can't
+	unschedulable string
+}
+
+// podUnschedulableError is returned when the pod didn't start in time because
+// the scheduler found no node for it, e.g. when the cluster is out of capacity
+type podUnschedulableError struct {
+	reason string
+}
+
+func (e *podUnschedulableError) Error() string {
+	return fmt.Sprintf("timed out waiting for pod to start: pod can't be scheduled: %s", e.reason)
+}
+
+// unschedulableReason returns the message of the scheduler when it can't place the pod
+func unschedulableReason(pod *api.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == api.PodScheduled &&
+			condition.Status == api.ConditionFalse &&
+			condition.Reason == api.PodReasonUnschedulable {
+			return condition.Message
+		}
+	}
+
+	return ""
 }
 
 func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseResponse {
 	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
 	pod, err := c.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
 	if err != nil {
-		return podPhaseResponse{true, api.PodUnknown, err}
+		return podPhaseResponse{done: true, phase: api.PodUnknown, err: err}
 	}
 
 	ready, err := isRunning(pod)
 	if err != nil || ready {
-		return podPhaseResponse{true, pod.Status.Phase, err}
+		return podPhaseResponse{done: true, phase: pod.Status.Phase, err: err}
 	}
 
 	// check status of containers
@@ -167,14 +193,14 @@ func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseR
 		switch waiting.Reason {
 		case "InvalidImageName":
 			err = &common.BuildError{Inner: fmt.Errorf("image pull failed: %s", waiting.Message)}
-			return podPhaseResponse{true, api.PodUnknown, err}
+			return podPhaseResponse{done: true, phase: api.PodUnknown, err: err}
 		case "ErrImagePull", "ImagePullBackOff":
 			msg := fmt.Sprintf("image pull failed: %s", waiting.Message)
 			imagePullErr := &pull.ImagePullError{Message: msg, Image: container.Image}
 			return podPhaseResponse{
-				true,
-				api.PodUnknown,
-				&common.BuildError{Inner: imagePullErr, FailureReason: common.ScriptFailure},
+				done:  true,
+				phase: api.PodUnknown,
+				err:   &common.BuildError{Inner: imagePullErr, FailureReason: common.ScriptFailure},
 			}
 		}
 	}
@@ -201,7 +227,7 @@ func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseR
 		)
 	}
 
-	return podPhaseResponse{false, pod.Status.Phase, nil}
+	return podPhaseResponse{done: false, phase: pod.Status.Phase, unschedulable: unschedulableReason(pod)}
 }
 
 func triggerPodPhaseCheck(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) <-chan podPhaseResponse {
@@ -218,7 +244,7 @@ func triggerPodPhaseCheck(c *kubernetes.Clientset, pod *api.Pod, out io.Writer)
 // PodFailed has been reached. In the case of PodRunning, it will also wait until
 // all containers within the pod are also Ready.
 // It returns error if the call to retrieve pod details fails or the timeout is
-// reached.
+// reached, a podUnschedulableError when the scheduler couldn't place the pod.
 // The timeout and polling values are configurable through KubernetesConfig
 // parameters.
 func waitForPodRunning(
@@ -230,10 +256,12 @@ func waitForPodRunning(
 ) (api.PodPhase, error) {
 	pollInterval := config.GetPollInterval()
 	pollAttempts := config.GetPollAttempts()
+	unschedulable := ""
 	for i := 0; i <= pollAttempts; i++ {
 		select {
 		case r := <-triggerPodPhaseCheck(c, pod, out):
 			if !r.done {
+				unschedulable = r.unschedulable
 				time.Sleep(time.Duration(pollInterval) * time.Second)
 				continue
 			}
@@ -242,6 +270,9 @@ func waitForPodRunning(
 			return api.PodUnknown, ctx.Err()
 		}
 	}
+	if unschedulable != "" {
+		return api.PodUnknown, &podUnschedulableError{reason: unschedulable}
+	}
 	return api.PodUnknown, errors.New("timed out waiting for pod to start")
 }
 
@@ -542,3 +542,36 @@ func TestSanitizeLabel(t *testing.T) {
 		})
 	}
 }
+
+func TestUnschedulableReason(t *testing.T) {
+	tests := map[string]struct {
+		conditions []api.PodCondition
+		expected   string
+	}{
+		"no conditions": {},
+		"scheduled": {
+			conditions: []api.PodCondition{
+				{Type: api.PodScheduled, Status: api.ConditionTrue},
+			},
+		},
+		"unschedulable": {
+			conditions: []api.PodCondition{
+				{Type: api.PodInitialized, Status: api.ConditionTrue},
+				{
+					Type:    api.PodScheduled,
+					Status:  api.ConditionFalse,
+					Reason:  api.PodReasonUnschedulable,
+					Message: "0/3 nodes are available: 3 Insufficient cpu.",
+				},
+			},
+			expected: "0/3 nodes are available: 3 Insufficient cpu.",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			pod := &api.Pod{Status: api.PodStatus{Conditions: tt.conditions}}
+			assert.Equal(t, tt.expected, unschedulableReason(pod))
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package shell
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+func (s *executor) Pool() proxy.Pool {
+	return s.ProxyPool
+}
+
+// dialLocalPort connects to the ports of the job image, the processes of the
+// job listen on the host of the runner
+func dialLocalPort(ctx context.Context, _ *proxy.Settings, port proxy.Port) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port.Number)))
+}
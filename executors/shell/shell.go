@@ -55,6 +55,8 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
+	s.AddBuildProxy(dialLocalPort)
+
 	s.Println("Using Shell executor...")
 	return nil
 }
@@ -173,6 +175,7 @@ func RegisterExecutor(executorName string, runnerCommandPath string) {
 		if runtime.GOOS != "windows" {
 			features.Session = true
 			features.Terminal = true
+			features.Proxy = true
 		}
 	}
 
@@ -6,16 +6,22 @@ package shell
 import (
 	"context"
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os/exec"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/executors"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
 	"gitlab.com/gitlab-org/gitlab-runner/shells/shellstest"
 )
 
@@ -156,3 +162,36 @@ func setupProcessMocks(t *testing.T) (*process.MockKillWaiter, *process.MockComm
 		newCommander = oldCmd
 	}
 }
+
+func TestBuildProxy(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("served " + r.URL.Path))
+	}))
+	defer service.Close()
+
+	_, port, err := net.SplitHostPort(service.Listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			ProxyPool: proxy.NewPool(),
+			Build: &common.Build{
+				JobResponse: common.JobResponse{
+					Image: common.Image{Ports: []common.Port{{Number: portNumber, Protocol: "http", Name: "web"}}},
+				},
+			},
+		},
+	}
+	e.AddBuildProxy(dialLocalPort)
+
+	require.Contains(t, e.Pool(), "build")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/proxy/build/web/index.html", nil)
+	e.Pool()["build"].ConnectionHandler.ProxyRequest(w, r, "index.html", "web", e.Pool()["build"].Settings)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "served /index.html", w.Body.String())
+}
@@ -44,6 +44,8 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return fmt.Errorf("ssh command Connect() error: %w", err)
 	}
 
+	s.AddBuildProxy(s.dialRemotePort)
+
 	return nil
 }
 
@@ -89,6 +91,8 @@ func init() {
 	featuresUpdater := func(features *common.FeaturesInfo) {
 		features.Variables = true
 		features.Shared = true
+		features.Session = true
+		features.Proxy = true
 	}
 
 	common.RegisterExecutorProvider("ssh", executors.DefaultExecutorProvider{
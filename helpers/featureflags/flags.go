@@ -25,6 +25,7 @@ const (
 	EnableJobCleanup                     string = "FF_ENABLE_JOB_CLEANUP"
 	KubernetesHonorEntrypoint            string = "FF_KUBERNETES_HONOR_ENTRYPOINT"
 	PosixlyCorrectEscapes                string = "FF_POSIXLY_CORRECT_ESCAPES"
+	UseHTTP2ForGitLabAPI                 string = "FF_USE_HTTP2_FOR_GITLAB_API"
 )
 
 type FeatureFlag struct {
@@ -195,6 +196,15 @@ var flags = []FeatureFlag{
 			"are used rather than [`bash`-style ANSI-C quoting](https://www.gnu.org/software/bash/manual/html_node/Quoting.html). " +
 			"This should be enabled if the job environment uses a POSIX-compliant shell.",
 	},
+	{
+		Name:            UseHTTP2ForGitLabAPI,
+		DefaultValue:    false,
+		Deprecated:      false,
+		ToBeRemovedWith: "",
+		Description: "When enabled, the job requests and the job updates of the runner use HTTP/2 when the " +
+			"GitLab instance supports it, so they're multiplexed on a single connection. It must be set in " +
+			"the `[runners.feature_flags]` section of `config.toml`.",
+	},
 }
 
 func GetAll() []FeatureFlag {
@@ -0,0 +1,45 @@
+// Package localblob opens the buckets storing the blobs in a local directory,
+// typically a mount shared by several hosts, with fileblob.
+//
+// For blob.OpenBucket, localblob registers for the scheme "local". The URLs
+// have the form local:///?dir=/path, where dir is the directory of the blobs.
+// The path of the URL is ignored, unlike the file URLs of fileblob, so that it
+// holds the name of the blob like the URLs of the cloud providers.
+package localblob
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+)
+
+// Scheme is the URL scheme localblob registers its URLOpener under on
+// blob.DefaultMux
+const Scheme = "local"
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// URLOpener opens the local URLs, like local:///?dir=/path
+type URLOpener struct{}
+
+// OpenBucketURL opens a blob.Bucket based on u. The directory isn't created
+// when it doesn't exist, as it's usually a mount point.
+func (o *URLOpener) OpenBucketURL(_ context.Context, u *url.URL) (*blob.Bucket, error) {
+	for param := range u.Query() {
+		if param != "dir" {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+	}
+
+	dir := u.Query().Get("dir")
+	if dir == "" {
+		return nil, fmt.Errorf("open bucket %v: missing dir query parameter", u)
+	}
+
+	return fileblob.OpenBucket(dir, nil)
+}
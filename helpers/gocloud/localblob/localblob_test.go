@@ -0,0 +1,62 @@
+//go:build !integration
+// +build !integration
+
+package localblob
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestURLOpener(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bucketURL := (&url.URL{
+		Scheme:   Scheme,
+		Path:     "/project/1/key",
+		RawQuery: url.Values{"dir": []string{dir}}.Encode(),
+	}).String()
+
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	require.NoError(t, bucket.WriteAll(ctx, "project/1/key", []byte("content"), nil))
+	require.NoError(t, bucket.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "project", "1", "key"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	bucket, err = blob.OpenBucket(ctx, bucketURL+"&prefix=chunks/")
+	require.NoError(t, err)
+	require.NoError(t, bucket.WriteAll(ctx, "hash", []byte("chunk"), nil))
+	require.NoError(t, bucket.Close())
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "chunks", "hash"))
+	require.NoError(t, err)
+	assert.Equal(t, "chunk", string(data))
+}
+
+func TestURLOpenerErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"missing dir":     "local:///key",
+		"invalid param":   "local:///key?dir=" + url.QueryEscape(dir) + "&other=1",
+		"dir not created": "local:///key?dir=" + url.QueryEscape(filepath.Join(dir, "missing")),
+	}
+
+	for tn, bucketURL := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := blob.OpenBucket(context.Background(), bucketURL)
+			assert.Error(t, err)
+		})
+	}
+}
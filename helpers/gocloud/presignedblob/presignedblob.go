@@ -0,0 +1,321 @@
+// Package presignedblob provides a blob implementation accessing the blobs
+// through pre-signed URLs, like the ones of the object storages that can only
+// be signed for a single object. Use OpenBucket to construct a *blob.Bucket
+// from a Signer.
+//
+// Only reading, writing and the attributes of the blobs are supported: the
+// pre-signed URLs don't allow listing, copying or deleting them.
+package presignedblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+var (
+	errNotFound       = errors.New("blob not found")
+	errNotImplemented = errors.New("not implemented")
+)
+
+// Signer returns the pre-signed URLs of the blobs
+type Signer interface {
+	// DownloadURL returns the URL of the blob of key, signed for GET
+	DownloadURL(key string) (*url.URL, error)
+	// UploadURL returns the URL of the blob of key, signed for PUT, and the
+	// headers the upload must be sent with
+	UploadURL(key string) (*url.URL, http.Header, error)
+}
+
+// OpenBucket returns a *blob.Bucket accessing the blobs through the URLs of
+// signer, with client
+func OpenBucket(signer Signer, client *http.Client) *blob.Bucket {
+	return blob.NewBucket(&bucket{signer: signer, client: client})
+}
+
+type bucket struct {
+	signer Signer
+	client *http.Client
+}
+
+// statusError is returned for the responses with an unexpected status
+type statusError struct {
+	status     string
+	statusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received: %s", e.status)
+}
+
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	var statusErr *statusError
+
+	switch {
+	case errors.Is(err, errNotFound):
+		return gcerrors.NotFound
+	case errors.Is(err, errNotImplemented):
+		return gcerrors.Unimplemented
+	case errors.As(err, &statusErr) && (statusErr.statusCode == http.StatusForbidden ||
+		statusErr.statusCode == http.StatusUnauthorized):
+		return gcerrors.PermissionDenied
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+func (b *bucket) As(interface{}) bool {
+	return false
+}
+
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return errors.As(err, i)
+}
+
+// get sends a GET request of the blob of key. The status of the response is
+// checked, the body must be closed.
+func (b *bucket) get(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+	u, err := b.signer.DownloadURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		_ = res.Body.Close()
+		return nil, errNotFound
+	case res.StatusCode/100 != 2:
+		_ = res.Body.Close()
+		return nil, &statusError{status: res.Status, statusCode: res.StatusCode}
+	}
+
+	return res, nil
+}
+
+// Attributes sends a GET request rather than a HEAD request, which isn't
+// allowed by the URLs signed for GET, and only reads the headers
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	res, err := b.get(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = res.Body.Close()
+
+	return &driver.Attributes{
+		ContentType: res.Header.Get("Content-Type"),
+		ETag:        res.Header.Get("ETag"),
+		ModTime:     modTime(res),
+		Size:        res.ContentLength,
+	}, nil
+}
+
+func modTime(res *http.Response) time.Time {
+	t, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+func (b *bucket) ListPaged(context.Context, *driver.ListOptions) (*driver.ListPage, error) {
+	return nil, errNotImplemented
+}
+
+func (b *bucket) NewRangeReader(
+	ctx context.Context,
+	key string,
+	offset int64,
+	length int64,
+	_ *driver.ReaderOptions,
+) (driver.Reader, error) {
+	header := make(http.Header)
+	switch {
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := b.get(ctx, key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	size := res.ContentLength
+	if res.StatusCode == http.StatusPartialContent {
+		size = rangeSize(res.Header.Get("Content-Range"))
+	}
+
+	var body io.Reader = res.Body
+	if length == 0 {
+		// only the attributes are read
+		body = io.LimitReader(res.Body, 0)
+	}
+
+	return &reader{
+		r:      body,
+		closer: res.Body,
+		attrs: driver.ReaderAttributes{
+			ContentType: res.Header.Get("Content-Type"),
+			ModTime:     modTime(res),
+			Size:        size,
+		},
+	}, nil
+}
+
+// rangeSize returns the size of the blob in a Content-Range header, like
+// bytes 0-9/100, or -1 when it's unknown
+func rangeSize(contentRange string) int64 {
+	var start, end, size int64
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+type reader struct {
+	r      io.Reader
+	closer io.Closer
+	attrs  driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.closer.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(interface{}) bool {
+	return false
+}
+
+// NewTypedWriter returns a writer buffering the blob in a temporary file, the
+// pre-signed uploads need the size of the blob. The blobs are uploaded as
+// application/octet-stream, unless the headers of the upload URL set another
+// type: the URLs may be signed for a content type, which must be sent as is.
+func (b *bucket) NewTypedWriter(
+	ctx context.Context,
+	key string,
+	_ string,
+	_ *driver.WriterOptions,
+) (driver.Writer, error) {
+	f, err := ioutil.TempFile("", "presignedblob-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{ctx: ctx, bucket: b, key: key, f: f}, nil
+}
+
+type writer struct {
+	ctx    context.Context
+	bucket *bucket
+	key    string
+	f      *os.File
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *writer) Close() error {
+	defer func() {
+		_ = w.f.Close()
+		_ = os.Remove(w.f.Name())
+	}()
+
+	// the write is aborted when the context is canceled
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	size, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return w.bucket.put(w.ctx, w.key, w.f, size)
+}
+
+func (b *bucket) put(ctx context.Context, key string, body io.Reader, size int64) error {
+	u, header, err := b.signer.UploadURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), ioutil.NopCloser(body))
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return &statusError{status: res.Status, statusCode: res.StatusCode}
+	}
+
+	return nil
+}
+
+func (b *bucket) Copy(context.Context, string, string, *driver.CopyOptions) error {
+	return errNotImplemented
+}
+
+func (b *bucket) Delete(context.Context, string) error {
+	return errNotImplemented
+}
+
+func (b *bucket) SignedURL(context.Context, string, *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
+
+func (b *bucket) Close() error {
+	return nil
+}
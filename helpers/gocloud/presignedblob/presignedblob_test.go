@@ -0,0 +1,156 @@
+//go:build !integration
+// +build !integration
+
+package presignedblob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/gcerrors"
+)
+
+// objectStore stores the objects like an object storage, with the URLs
+// signed for a single method
+type objectStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *objectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("method") != r.Method {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method == http.MethodPut {
+		if r.ContentLength < 0 || r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = data
+		return
+	}
+
+	data, ok := s.objects[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, key, time.Date(2021, 10, 19, 7, 30, 0, 0, time.UTC), bytes.NewReader(data))
+}
+
+type testSigner struct {
+	url string
+}
+
+func (s *testSigner) signedURL(key string, method string) *url.URL {
+	u, _ := url.Parse(s.url + "/" + key + "?method=" + method)
+	return u
+}
+
+func (s *testSigner) DownloadURL(key string) (*url.URL, error) {
+	return s.signedURL(key, http.MethodGet), nil
+}
+
+func (s *testSigner) UploadURL(key string) (*url.URL, http.Header, error) {
+	header := make(http.Header)
+	header.Set("x-ms-blob-type", "BlockBlob")
+
+	return s.signedURL(key, http.MethodPut), header, nil
+}
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	store := &objectStore{objects: make(map[string][]byte)}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	bucket := OpenBucket(&testSigner{url: server.URL}, server.Client())
+	defer func() { _ = bucket.Close() }()
+
+	_, err := bucket.ReadAll(ctx, "project/1/key")
+	assert.Equal(t, gcerrors.NotFound, gcerrors.Code(err))
+
+	exists, err := bucket.Exists(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, bucket.WriteAll(ctx, "project/1/key", []byte("content"), nil))
+	assert.Equal(t, "content", string(store.objects["project/1/key"]))
+
+	data, err := bucket.ReadAll(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	attrs, err := bucket.Attributes(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.Equal(t, time.Date(2021, 10, 19, 7, 30, 0, 0, time.UTC), attrs.ModTime.UTC())
+
+	r, err := bucket.NewRangeReader(ctx, "project/1/key", 2, 3, nil)
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "nte", string(data))
+	assert.Equal(t, int64(7), r.Size())
+
+	iter := bucket.List(nil)
+	_, err = iter.Next(ctx)
+	assert.Equal(t, gcerrors.Unimplemented, gcerrors.Code(err))
+
+	err = bucket.Delete(ctx, "project/1/key")
+	assert.Equal(t, gcerrors.Unimplemented, gcerrors.Code(err))
+}
+
+func TestBucketWriteCanceled(t *testing.T) {
+	store := &objectStore{objects: make(map[string][]byte)}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	bucket := OpenBucket(&testSigner{url: server.URL}, server.Client())
+	defer func() { _ = bucket.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := bucket.NewWriter(ctx, "key", nil)
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+
+	cancel()
+	assert.Error(t, w.Close())
+	assert.Empty(t, store.objects, "the canceled write isn't uploaded")
+}
+
+func TestBucketPermissionDenied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	bucket := OpenBucket(&testSigner{url: server.URL}, server.Client())
+	defer func() { _ = bucket.Close() }()
+
+	_, err := bucket.ReadAll(context.Background(), "key")
+	assert.Equal(t, gcerrors.PermissionDenied, gcerrors.Code(err))
+}
@@ -0,0 +1,56 @@
+package sftpblob
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var errNoHostKey = errors.New("no host key to verify the SFTP server")
+
+// Credentials authenticate the user, and verify the SFTP server
+type Credentials struct {
+	Password string
+	// PrivateKey is a PEM-encoded private key
+	PrivateKey string
+	// HostKey is the public key of the server, in the authorized_keys format
+	HostKey               string
+	InsecureIgnoreHostKey bool
+}
+
+// ClientConfig returns the SSH configuration authenticating user with the
+// credentials
+func (c Credentials) ClientConfig(user string) (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{User: user}
+
+	if c.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(c.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	if c.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(c.Password))
+	}
+
+	switch {
+	case c.InsecureIgnoreHostKey:
+		//nolint:gosec
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case c.HostKey != "":
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing host key: %w", err)
+		}
+
+		config.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	default:
+		return nil, errNoHostKey
+	}
+
+	return config, nil
+}
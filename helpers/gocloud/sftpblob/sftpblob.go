@@ -0,0 +1,448 @@
+// Package sftpblob provides a blob implementation storing the blobs as files
+// in a directory of an SFTP server. Use Dial to connect to the server, or
+// OpenBucket to construct a *blob.Bucket from an SFTP client.
+//
+// The package doesn't register a URL scheme: the credentials of the server
+// must stay in the process opening the bucket.
+package sftpblob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Scheme is the URL scheme of the SFTP servers
+const Scheme = "sftp"
+
+const (
+	defaultPageSize = 1000
+
+	// tempPrefix starts the names of the files being written, they're
+	// renamed when the write completes
+	tempPrefix = ".sftpblob-"
+)
+
+var errNotImplemented = errors.New("not implemented")
+
+// Dial connects to the SFTP server at addr, and returns a *blob.Bucket storing
+// the blobs in dir. Closing the bucket closes the connection.
+func Dial(ctx context.Context, addr string, user string, creds Credentials, dir string) (*blob.Bucket, error) {
+	config, err := creds.ClientConfig(user)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, err
+	}
+
+	return blob.NewBucket(&bucket{client: client, conn: sshClient, dir: dir}), nil
+}
+
+// OpenBucket returns a *blob.Bucket storing the blobs in dir, with an SFTP
+// client. Closing the bucket doesn't close the client.
+func OpenBucket(client *sftp.Client, dir string) *blob.Bucket {
+	return blob.NewBucket(&bucket{client: client, dir: dir})
+}
+
+type bucket struct {
+	client *sftp.Client
+	// conn is the SSH connection of the client, when the bucket owns it
+	conn io.Closer
+	dir  string
+}
+
+// path returns the path of the file of a key, or an error when the key
+// would be outside of the directory
+func (b *bucket) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || strings.HasSuffix(key, "/") || clean != "/"+key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	if b.dir == "" {
+		return key, nil
+	}
+
+	return path.Join(b.dir, key), nil
+}
+
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return gcerrors.NotFound
+	case errors.Is(err, errNotImplemented):
+		return gcerrors.Unimplemented
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**sftp.Client)
+	if !ok {
+		return false
+	}
+
+	*p = b.client
+	return true
+}
+
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return errors.As(err, i)
+}
+
+func (b *bucket) Attributes(_ context.Context, key string) (*driver.Attributes, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := b.client.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	return &driver.Attributes{
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+	}, nil
+}
+
+func (b *bucket) ListPaged(_ context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	objects, err := b.list(opts.Prefix, opts.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts.PageToken) > 0 {
+		token := string(opts.PageToken)
+		i := sort.Search(len(objects), func(i int) bool { return objects[i].Key > token })
+		objects = objects[i:]
+	}
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	page := &driver.ListPage{Objects: objects}
+	if len(objects) > pageSize {
+		page.Objects = objects[:pageSize]
+		page.NextPageToken = []byte(objects[pageSize-1].Key)
+	}
+
+	return page, nil
+}
+
+// list returns the objects of the keys starting with prefix, sorted by key.
+// With a delimiter, the keys with the delimiter after the prefix are
+// grouped in a directory object.
+func (b *bucket) list(prefix string, delimiter string) ([]*driver.ListObject, error) {
+	root := b.dir
+	if root == "" {
+		root = "."
+	}
+
+	var objects []*driver.ListObject
+	dirs := make(map[string]bool)
+
+	walker := b.client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == root && errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		fi := walker.Stat()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), tempPrefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				dir := key[:len(prefix)+i+len(delimiter)]
+				if !dirs[dir] {
+					dirs[dir] = true
+					objects = append(objects, &driver.ListObject{Key: dir, IsDir: true})
+				}
+
+				continue
+			}
+		}
+
+		objects = append(objects, &driver.ListObject{
+			Key:     key,
+			ModTime: fi.ModTime(),
+			Size:    fi.Size(),
+		})
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (b *bucket) NewRangeReader(
+	_ context.Context,
+	key string,
+	offset int64,
+	length int64,
+	_ *driver.ReaderOptions,
+) (driver.Reader, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.client.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		_ = f.Close()
+		return nil, os.ErrNotExist
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+
+	return &reader{
+		r:      r,
+		closer: f,
+		attrs: driver.ReaderAttributes{
+			ModTime: fi.ModTime(),
+			Size:    fi.Size(),
+		},
+	}, nil
+}
+
+type reader struct {
+	r      io.Reader
+	closer io.Closer
+	attrs  driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.closer.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(interface{}) bool {
+	return false
+}
+
+func (b *bucket) NewTypedWriter(
+	ctx context.Context,
+	key string,
+	_ string,
+	_ *driver.WriterOptions,
+) (driver.Writer, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.mkdirAll(path.Dir(p)); err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+
+	tempPath := path.Join(path.Dir(p), tempPrefix+hex.EncodeToString(suffix))
+
+	f, err := b.client.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{ctx: ctx, bucket: b, f: f, path: p, tempPath: tempPath}, nil
+}
+
+// mkdirAll creates dir and its parents, like os.MkdirAll
+func (b *bucket) mkdirAll(dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+
+	fi, err := b.client.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s: not a directory", dir)
+		}
+
+		return nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := b.mkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+
+	err = b.client.Mkdir(dir)
+	if err != nil {
+		// the directory may have been created concurrently
+		if fi, statErr := b.client.Stat(dir); statErr == nil && fi.IsDir() {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// writer writes to a temporary file, renamed when the writer is closed, so
+// that the blobs are never read partially written
+type writer struct {
+	ctx      context.Context
+	bucket   *bucket
+	f        *sftp.File
+	path     string
+	tempPath string
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *writer) Close() error {
+	err := w.f.Close()
+	if err == nil {
+		// the write is aborted when the context is canceled
+		err = w.ctx.Err()
+	}
+
+	if err != nil {
+		_ = w.bucket.client.Remove(w.tempPath)
+		return err
+	}
+
+	// renaming doesn't replace an existing file with SFTP
+	err = w.bucket.client.Remove(w.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = w.bucket.client.Remove(w.tempPath)
+		return err
+	}
+
+	return w.bucket.client.Rename(w.tempPath, w.path)
+}
+
+func (b *bucket) Copy(ctx context.Context, dstKey string, srcKey string, _ *driver.CopyOptions) error {
+	r, err := b.NewRangeReader(ctx, srcKey, 0, -1, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := b.NewTypedWriter(ctx, dstKey, "", nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (b *bucket) Delete(_ context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	return b.client.Remove(p)
+}
+
+func (b *bucket) SignedURL(context.Context, string, *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
+
+func (b *bucket) Close() error {
+	if b.conn == nil {
+		return nil
+	}
+
+	err := b.client.Close()
+	if connErr := b.conn.Close(); err == nil {
+		err = connErr
+	}
+
+	return err
+}
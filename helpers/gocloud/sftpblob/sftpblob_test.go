@@ -0,0 +1,205 @@
+//go:build !integration
+// +build !integration
+
+package sftpblob
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
+)
+
+func newStubServer(t *testing.T) *ssh.StubSSHServer {
+	server, err := ssh.NewStubServer("user", "pass")
+	require.NoError(t, err)
+	t.Cleanup(server.Stop)
+
+	return server
+}
+
+func openTestBucket(t *testing.T, dir string) *blob.Bucket {
+	server := newStubServer(t)
+
+	creds := Credentials{Password: "pass", HostKey: ssh.TestSSHKeyPair.PublicKey}
+	bucket, err := Dial(context.Background(), net.JoinHostPort(server.Host(), server.Port()), "user", creds, dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = bucket.Close() })
+
+	return bucket
+}
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket := openTestBucket(t, dir)
+
+	require.NoError(t, bucket.WriteAll(ctx, "project/1/key", []byte("content"), nil))
+	require.NoError(t, bucket.WriteAll(ctx, "project/1/other", []byte("other"), nil))
+	require.NoError(t, bucket.WriteAll(ctx, "project/2/key", []byte("key"), nil))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "project", "1", "key"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	data, err = bucket.ReadAll(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	r, err := bucket.NewRangeReader(ctx, "project/1/key", 2, 3, nil)
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "nte", string(data))
+	assert.Equal(t, int64(7), r.Size())
+	require.NoError(t, r.Close())
+
+	attrs, err := bucket.Attributes(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), attrs.Size)
+	assert.False(t, attrs.ModTime.IsZero())
+
+	// the writes replace the existing blobs
+	require.NoError(t, bucket.WriteAll(ctx, "project/1/key", []byte("replaced"), nil))
+	data, err = bucket.ReadAll(ctx, "project/1/key")
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(data))
+
+	require.NoError(t, bucket.Copy(ctx, "project/3/key", "project/1/key", nil))
+	data, err = bucket.ReadAll(ctx, "project/3/key")
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(data))
+
+	require.NoError(t, bucket.Delete(ctx, "project/3/key"))
+	_, err = bucket.ReadAll(ctx, "project/3/key")
+	assert.Equal(t, gcerrors.NotFound, gcerrors.Code(err))
+
+	assert.Equal(t, gcerrors.NotFound, gcerrors.Code(bucket.Delete(ctx, "project/3/key")))
+
+	_, err = bucket.Attributes(ctx, "project/1")
+	assert.Equal(t, gcerrors.NotFound, gcerrors.Code(err))
+
+	_, err = bucket.SignedURL(ctx, "project/1/key", nil)
+	assert.Equal(t, gcerrors.Unimplemented, gcerrors.Code(err))
+}
+
+func TestBucketInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	bucket := openTestBucket(t, t.TempDir())
+
+	for _, key := range []string{"../key", "dir/../../key", "dir/", "/key", "dir//key"} {
+		err := bucket.WriteAll(ctx, key, []byte("content"), nil)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestBucketList(t *testing.T) {
+	ctx := context.Background()
+	bucket := openTestBucket(t, filepath.Join(t.TempDir(), "missing"))
+
+	list := func(opts *blob.ListOptions, pageSize int) []string {
+		var keys []string
+
+		token := blob.FirstPageToken
+		for {
+			objects, next, err := bucket.ListPage(ctx, token, pageSize, opts)
+			require.NoError(t, err)
+
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+
+			if len(next) == 0 {
+				return keys
+			}
+			token = next
+		}
+	}
+
+	// the directory is created with the first blob
+	assert.Empty(t, list(nil, 10))
+
+	for _, key := range []string{"b/2", "a/1", "b/1", "c"} {
+		require.NoError(t, bucket.WriteAll(ctx, key, []byte(key), nil))
+	}
+
+	assert.Equal(t, []string{"a/1", "b/1", "b/2", "c"}, list(nil, 10))
+	assert.Equal(t, []string{"a/1", "b/1", "b/2", "c"}, list(nil, 1))
+	assert.Equal(t, []string{"b/1", "b/2"}, list(&blob.ListOptions{Prefix: "b/"}, 10))
+	assert.Equal(t, []string{"a/", "b/", "c"}, list(&blob.ListOptions{Delimiter: "/"}, 10))
+}
+
+func TestBucketAbortedWrite(t *testing.T) {
+	dir := t.TempDir()
+	bucket := openTestBucket(t, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w, err := bucket.NewWriter(ctx, "key", nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("content"))
+	require.NoError(t, err)
+
+	cancel()
+	assert.Error(t, w.Close())
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCredentials(t *testing.T) {
+	tests := map[string]struct {
+		creds         Credentials
+		expectedAuth  int
+		expectedError string
+	}{
+		"password": {
+			creds:        Credentials{Password: "pass", HostKey: ssh.TestSSHKeyPair.PublicKey},
+			expectedAuth: 1,
+		},
+		"private key and password": {
+			creds: Credentials{
+				Password:              "pass",
+				PrivateKey:            ssh.TestSSHKeyPair.PrivateKey,
+				InsecureIgnoreHostKey: true,
+			},
+			expectedAuth: 2,
+		},
+		"invalid private key": {
+			creds:         Credentials{PrivateKey: "invalid", InsecureIgnoreHostKey: true},
+			expectedError: "parsing private key: ssh: no key found",
+		},
+		"invalid host key": {
+			creds:         Credentials{Password: "pass", HostKey: "invalid"},
+			expectedError: "parsing host key: ssh: no key found",
+		},
+		"no host key": {
+			creds:         Credentials{Password: "pass"},
+			expectedError: errNoHostKey.Error(),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config, err := tt.creds.ClientConfig("user")
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "user", config.User)
+			assert.Len(t, config.Auth, tt.expectedAuth)
+			assert.NotNil(t, config.HostKeyCallback)
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -193,6 +194,42 @@ func (s *Client) Run(ctx context.Context, cmd Command) error {
 	}
 }
 
+// Dial opens a connection to addr from the SSH server, so the ports of the
+// remote host can be reached even when they're only listening locally
+func (s *Client) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.client == nil {
+		return nil, errors.New("not connected")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	resultCh := make(chan dialResult, 1)
+	go func() {
+		conn, err := s.client.Dial(network, addr)
+		resultCh <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		go func() {
+			if result := <-resultCh; result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+
+	case result := <-resultCh:
+		return result.conn, result.err
+	}
+}
+
 func (s *Client) Cleanup() {
 	if s.client != nil {
 		_ = s.client.Close()
@@ -4,8 +4,11 @@
 package ssh_test
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -85,6 +88,53 @@ func TestStrictHostCheckingWithKnownHostsFile(t *testing.T) {
 	}
 }
 
+func TestDial(t *testing.T) {
+	s, err := ssh.NewStubServer("testuser", "testpass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	c := s.Client()
+	_, err = c.Dial(context.Background(), "tcp", listener.Addr().String())
+	assert.Error(t, err, "should not dial before connecting")
+
+	disableHostChecking := true
+	c.Config.DisableStrictHostKeyChecking = &disableHostChecking
+	require.NoError(t, c.Connect())
+	defer c.Cleanup()
+
+	conn, err := c.Dial(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	data := make([]byte, 4)
+	_, err = io.ReadFull(conn, data)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(data))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.Dial(ctx, "tcp", listener.Addr().String())
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
 //nolint:lll
 var knownHostsWithGitlabOnly = `gitlab.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCsj2bNKTBSpIYDEGk9KxsGh3mySTRgMtXL583qmBpzeQ+jqCMRgBqB98u3z++J1sKlXHWfM9dyhSevkMwSbhoR8XIq/U0tCNyokEi/ueaBMCvbcTHhO7FcwzY92WK4Yt0aGROY5qX2UKSeOvuP4D6TPqKF1onrSzH9bx9XUf2lEdWT/ia1NEKjunUqu1xOB/StKDHMoX4/OKyIzuS0q/T1zOATthvasJFoPrAjkohTyaDUz2LN5JoH839hViyEG82yB+MjcFV5MU3N1l1QL3cVUCh93xSaua1N85qivl+siMkPGbO5xR/En4iEY6K2XPASUEMaieWVNTRCtJ4S8H+9
 gitlab.com ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBFSMqzJeV9rUzU4kWitGjeR4PWSa29SPqJ1fVkhtj3Hw9xjLVXVYrU9QlYWrOLXBpQ6KWjbjTDTdDkoohFzgbEY=
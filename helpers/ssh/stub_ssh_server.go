@@ -2,11 +2,14 @@ package ssh
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/pkg/sftp"
 	"github.com/tevino/abool"
 	cryptoSSH "golang.org/x/crypto/ssh"
 )
@@ -152,9 +155,105 @@ func (s *StubSSHServer) mainLoop(listener net.Listener) {
 		}
 
 		// upgrade to ssh connection
-		_, _, _, _ = cryptoSSH.NewServerConn(conn, s.Config)
-		// This is enough just for handling incoming connections
+		_, chans, reqs, err := cryptoSSH.NewServerConn(conn, s.Config)
+		if err != nil {
+			continue
+		}
+
+		go cryptoSSH.DiscardRequests(reqs)
+		go s.handleChannels(chans)
+	}
+}
+
+// handleChannels only supports the direct-tcpip channels that forward the
+// connections of the clients, and the sessions of the sftp subsystem, other
+// channels are rejected
+func (s *StubSSHServer) handleChannels(chans <-chan cryptoSSH.NewChannel) {
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			go forwardChannel(newChannel)
+		case "session":
+			go serveSFTP(newChannel)
+		default:
+			_ = newChannel.Reject(cryptoSSH.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// serveSFTP serves the local filesystem to the sessions requesting the sftp
+// subsystem
+func serveSFTP(newChannel cryptoSSH.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for req := range reqs {
+		var subsystem struct{ Name string }
+		ok := req.Type == "subsystem" &&
+			cryptoSSH.Unmarshal(req.Payload, &subsystem) == nil &&
+			subsystem.Name == "sftp"
+		_ = req.Reply(ok, nil)
+
+		if !ok {
+			continue
+		}
+
+		go cryptoSSH.DiscardRequests(reqs)
+
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+
+		_ = server.Serve()
+		return
+	}
+}
+
+func forwardChannel(newChannel cryptoSSH.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+
+	err := cryptoSSH.Unmarshal(newChannel.ExtraData(), &target)
+	if err != nil {
+		_ = newChannel.Reject(cryptoSSH.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(cryptoSSH.ConnectionFailed, err.Error())
+		return
 	}
+	defer conn.Close()
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	go cryptoSSH.DiscardRequests(reqs)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(channel, conn)
+		_ = channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		done <- struct{}{}
+	}()
+
+	<-done
 }
 
 func (s *StubSSHServer) Client() Client {
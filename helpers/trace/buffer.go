@@ -27,8 +27,11 @@ type Buffer struct {
 	lw   *limitWriter
 	w    io.WriteCloser
 
-	logFile  *os.File
-	checksum hash.Hash32
+	logFile    *os.File
+	checksum   hash.Hash32
+	timestamps *timestampTransform
+	secrets    *secretTransform
+	finished   bool
 }
 
 type inverseLengthSort []string
@@ -71,7 +74,14 @@ func (b *Buffer) SetMasked(values []string) {
 		transformers = append(transformers, newPhraseTransform(value))
 	}
 
+	if b.secrets != nil {
+		transformers = append(transformers, b.secrets)
+	}
+
 	transformers = append(transformers, defaultTransformers...)
+	if b.timestamps != nil {
+		transformers = append(transformers, b.timestamps)
+	}
 
 	b.w = transform.NewWriter(b.lw, transform.Chain(transformers...))
 }
@@ -131,12 +141,56 @@ func (b *Buffer) Write(p []byte) (int, error) {
 }
 
 func (b *Buffer) Finish() {
-	b.lock.RLock()
-	defer b.lock.RUnlock()
+	b.lock.Lock()
+	defer b.lock.Unlock()
 
 	if b.w != nil {
 		_ = b.w.Close()
 	}
+
+	if !b.finished {
+		b.finished = true
+		b.writeRedactionsSummary()
+	}
+}
+
+// Redactions returns the number of secrets redacted by each secret detector
+func (b *Buffer) Redactions() map[string]int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	redactions := make(map[string]int)
+	if b.secrets != nil {
+		for name, count := range b.secrets.redactions {
+			redactions[name] = count
+		}
+	}
+
+	return redactions
+}
+
+// writeRedactionsSummary writes the number of redacted secrets at the end of
+// the trace. Like the log limit notice, it's written even when the trace
+// exceeded its limit.
+func (b *Buffer) writeRedactionsSummary() {
+	if b.secrets == nil {
+		return
+	}
+
+	total, summary := b.secrets.summary()
+	if total == 0 {
+		return
+	}
+
+	n, _ := fmt.Fprintf(
+		b.lw.w,
+		"\n%s%d secret(s) redacted from the job log: %s%s\n",
+		helpers.ANSI_BOLD_YELLOW,
+		total,
+		summary,
+		helpers.ANSI_RESET,
+	)
+	b.lw.written += int64(n)
 }
 
 func (b *Buffer) Close() {
@@ -199,19 +253,85 @@ func (w *limitWriter) writeLimitExceededMessage() {
 	w.written += int64(n)
 }
 
-func New() (*Buffer, error) {
-	logFile, err := newLogFile()
+// Option configures a Buffer
+type Option func(*options)
+
+type options struct {
+	path            string
+	tee             io.Writer
+	timestamps      TimestampFormat
+	secretDetectors []*SecretDetector
+}
+
+// WithFile stores the trace in the file at path, instead of a temporary file,
+// so it can be read back if the process stops before the trace is sent
+func WithFile(path string) Option {
+	return func(o *options) {
+		o.path = path
+	}
+}
+
+// WithTee copies the masked trace to w. An error of w fails the write of the
+// trace, so w should neither fail nor block.
+func WithTee(w io.Writer) Option {
+	return func(o *options) {
+		o.tee = w
+	}
+}
+
+// WithTimestamps prefixes each line of the trace with a timestamp in the
+// given format. The values are masked before the timestamps are added.
+func WithTimestamps(format TimestampFormat) Option {
+	return func(o *options) {
+		o.timestamps = format
+	}
+}
+
+// WithSecretDetectors redacts the secrets found by the detectors, in
+// addition to the masked values
+func WithSecretDetectors(detectors []*SecretDetector) Option {
+	return func(o *options) {
+		o.secretDetectors = detectors
+	}
+}
+
+func New(opts ...Option) (*Buffer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var timestamps *timestampTransform
+	if o.timestamps != "" {
+		var err error
+		timestamps, err = newTimestampTransform(o.timestamps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logFile, err := newLogFile(o.path)
 	if err != nil {
 		return nil, err
 	}
 
 	buffer := &Buffer{
-		logFile:  logFile,
-		checksum: crc32.NewIEEE(),
+		logFile:    logFile,
+		checksum:   crc32.NewIEEE(),
+		timestamps: timestamps,
+	}
+
+	if len(o.secretDetectors) > 0 {
+		buffer.secrets = newSecretTransform(o.secretDetectors)
+	}
+
+	writers := []io.Writer{buffer.logFile, buffer.checksum}
+	if o.tee != nil {
+		writers = append(writers, o.tee)
 	}
 
 	buffer.lw = &limitWriter{
-		w:       io.MultiWriter(buffer.logFile, buffer.checksum),
+		w:       io.MultiWriter(writers...),
 		written: 0,
 		limit:   defaultBytesLimit,
 	}
@@ -221,7 +341,11 @@ func New() (*Buffer, error) {
 	return buffer, nil
 }
 
-func newLogFile() (*os.File, error) {
+func newLogFile(path string) (*os.File, error) {
+	if path != "" {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	}
+
 	return ioutil.TempFile("", "trace")
 }
 
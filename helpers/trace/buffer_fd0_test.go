@@ -160,7 +160,7 @@ func setNewRLimitNOFILE(t *testing.T, maxFDsCount int) func() {
 }
 
 func createNewLogFile(t *testing.T) (*os.File, error) {
-	file, err := newLogFile()
+	file, err := newLogFile("")
 	if file == nil {
 		t.Log("Couldn't create log file:", err)
 	} else {
@@ -4,7 +4,10 @@
 package trace
 
 import (
+	"bytes"
+	"io/ioutil"
 	"math"
+	"path/filepath"
 	"sync"
 	"testing"
 	"unicode/utf8"
@@ -330,3 +333,42 @@ func BenchmarkBuffer10kWithURLScrub(b *testing.B) {
 		}()
 	}
 }
+
+func TestBufferWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+
+	buffer, err := New(WithFile(path))
+	require.NoError(t, err)
+
+	_, err = buffer.Write([]byte("job output"))
+	require.NoError(t, err)
+	buffer.Finish()
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "job output", string(content))
+
+	buffer.Close()
+	assert.NoFileExists(t, path)
+}
+
+func TestBufferWithTee(t *testing.T) {
+	tee := new(bytes.Buffer)
+
+	buffer, err := New(WithTee(tee))
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	buffer.SetMasked([]string{"secret"})
+	buffer.SetLimit(30)
+
+	_, err = buffer.Write([]byte("the secret is masked, this is cut"))
+	require.NoError(t, err)
+	buffer.Finish()
+
+	content, err := buffer.Bytes(0, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, string(content), tee.String())
+	assert.Contains(t, tee.String(), "the [MASKED] is masked")
+	assert.Contains(t, tee.String(), "Job's log exceeded limit of 30 bytes.")
+}
@@ -0,0 +1,291 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+const (
+	// maxSecretSize is the size of the data kept back when a line is longer
+	// than the transformer's buffer, so that a secret at the end of the buffer
+	// is detected when the rest of the line is written. Longer secrets split
+	// at this point aren't detected.
+	maxSecretSize = 1024
+
+	// maxSecretBlockSize is the size after which a block that didn't end, e.g.
+	// a truncated private key, isn't redacted anymore
+	maxSecretBlockSize = 16 * 1024
+)
+
+// SecretDetector finds secrets in the trace, e.g. the tokens printed by the
+// tools a job runs, that aren't the values of masked variables
+type SecretDetector struct {
+	Name string
+
+	// pattern matches the secret. When it has groups, only the first group is
+	// redacted.
+	pattern *regexp.Regexp
+	// end matches the end of a block starting with pattern, e.g. a private
+	// key. Everything from the start of the block to its end is redacted.
+	end *regexp.Regexp
+}
+
+// NewSecretDetector returns a detector redacting the matches of pattern. When
+// pattern has groups, only the first group is redacted.
+func NewSecretDetector(name string, pattern string) (*SecretDetector, error) {
+	re, err := compileSecretPattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("secret detector %q: %w", name, err)
+	}
+
+	return &SecretDetector{Name: name, pattern: re}, nil
+}
+
+func compileSecretPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.MatchString("") {
+		return nil, errors.New("pattern matches an empty string")
+	}
+
+	return re, nil
+}
+
+func mustSecretDetector(name string, pattern string, end string) *SecretDetector {
+	detector := &SecretDetector{Name: name, pattern: regexp.MustCompile(pattern)}
+	if end != "" {
+		detector.end = regexp.MustCompile(end)
+	}
+
+	return detector
+}
+
+// builtinSecretDetectors are used unless disabled in the configuration
+var builtinSecretDetectors = []*SecretDetector{
+	mustSecretDetector("aws_access_key_id", `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`, ""),
+	mustSecretDetector(
+		"aws_secret_access_key",
+		`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([0-9A-Za-z/+=]{40})\b`,
+		"",
+	),
+	mustSecretDetector("gitlab_token", `\b(?:glpat|gldt|glrt|glptt)-[0-9A-Za-z_-]{20,}`, ""),
+	mustSecretDetector("jwt", `\beyJ[0-9A-Za-z_-]{5,}\.eyJ[0-9A-Za-z_-]{5,}\.[0-9A-Za-z_-]*`, ""),
+	mustSecretDetector("private_key", `-----BEGIN[ A-Z0-9]*PRIVATE KEY-----`, `-----END[ A-Z0-9]*PRIVATE KEY-----`),
+}
+
+//nolint:lll
+type SecretDetectionConfig struct {
+	DisabledDetectors []string               `toml:"disabled_detectors,omitempty" json:"disabled_detectors" description:"Names of the built-in detectors that aren't used"`
+	Detectors         []SecretDetectorConfig `toml:"detectors,omitempty" json:"detectors" description:"Additional detectors"`
+}
+
+//nolint:lll
+type SecretDetectorConfig struct {
+	Name    string `toml:"name" json:"name" description:"Name of the detector, reported with the number of redacted secrets"`
+	Pattern string `toml:"pattern" json:"pattern" description:"Regular expression matching the secrets, only the first group is redacted when the expression has groups"`
+}
+
+// Compile returns the built-in detectors that aren't disabled and the
+// additional detectors of the configuration
+func (c *SecretDetectionConfig) Compile() ([]*SecretDetector, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	disabled := make(map[string]bool)
+	for _, name := range c.DisabledDetectors {
+		disabled[name] = true
+	}
+
+	var detectors []*SecretDetector
+	for _, detector := range builtinSecretDetectors {
+		if !disabled[detector.Name] {
+			detectors = append(detectors, detector)
+		}
+	}
+
+	for _, config := range c.Detectors {
+		detector, err := NewSecretDetector(config.Name, config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		detectors = append(detectors, detector)
+	}
+
+	return detectors, nil
+}
+
+// secretTransform redacts the secrets found by the detectors. The data is
+// processed up to the last line ending, so secrets split across writes are
+// detected, the same way the values of masked variables are.
+//
+// The transformer is shared by the transformer chains the Buffer creates
+// when the masked values change, so Reset doesn't reset the redactions nor
+// the block being redacted.
+type secretTransform struct {
+	detectors  []*SecretDetector
+	redactions map[string]int
+
+	block     *SecretDetector
+	blockSize int
+}
+
+func newSecretTransform(detectors []*SecretDetector) *secretTransform {
+	return &secretTransform{
+		detectors:  detectors,
+		redactions: make(map[string]int),
+	}
+}
+
+func (t *secretTransform) Reset() {}
+
+func (t *secretTransform) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		region := secretRegion(src[nSrc:], atEOF)
+		if len(region) == 0 {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+
+		n, m, err := t.redact(dst[nDst:], region)
+		nDst += n
+		nSrc += m
+		if err != nil {
+			return nDst, nSrc, err
+		}
+	}
+
+	return nDst, nSrc, nil
+}
+
+// secretRegion returns the data that can be searched for secrets: up to the
+// last line ending, or all of it at the end of the trace
+func secretRegion(src []byte, atEOF bool) []byte {
+	if atEOF {
+		return src
+	}
+
+	if i := bytes.LastIndexAny(src, safeTokens); i >= 0 {
+		return src[:i+1]
+	}
+
+	if len(src) >= maxPhraseSize {
+		return src[:len(src)-maxSecretSize]
+	}
+
+	return nil
+}
+
+func (t *secretTransform) redact(dst, region []byte) (nDst, nSrc int, err error) {
+	for nSrc < len(region) {
+		if t.block != nil {
+			n := len(region) - nSrc
+			if loc := t.block.end.FindIndex(region[nSrc:]); loc != nil {
+				n = loc[1]
+				t.block = nil
+			}
+
+			if t.blockSize+n >= maxSecretBlockSize {
+				n = maxSecretBlockSize - t.blockSize
+				t.block = nil
+			}
+
+			t.blockSize += n
+			nSrc += n
+			continue
+		}
+
+		detector, matchStart, start, end := t.find(region[nSrc:])
+		if detector == nil {
+			err = copyn(dst, region, &nDst, &nSrc, len(region)-nSrc)
+			return nDst, nSrc, err
+		}
+
+		err = copyn(dst, region, &nDst, &nSrc, matchStart)
+		if err != nil {
+			return nDst, nSrc, err
+		}
+
+		// the part of the match before the secret is only copied together
+		// with the mask, otherwise the secret no longer matches once the
+		// transformation continues with a new dst
+		if len(dst[nDst:]) < start-matchStart+len(mask) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+
+		err = copyn(dst, region, &nDst, &nSrc, start-matchStart)
+		if err != nil {
+			return nDst, nSrc, err
+		}
+
+		err = replace(dst, &nDst, &nSrc, []byte(mask), end-start)
+		if err != nil {
+			return nDst, nSrc, err
+		}
+
+		t.redactions[detector.Name]++
+
+		if detector.end != nil {
+			t.block = detector
+			t.blockSize = 0
+		}
+	}
+
+	return nDst, nSrc, nil
+}
+
+// find returns the secret that starts first in data, and the longest one
+// when several start at the same position, along with the start of the
+// whole match
+func (t *secretTransform) find(data []byte) (*SecretDetector, int, int, int) {
+	var found *SecretDetector
+	var matchStart, start, end int
+
+	for _, detector := range t.detectors {
+		loc := detector.pattern.FindSubmatchIndex(data)
+		if loc == nil {
+			continue
+		}
+
+		match := loc[0]
+		if len(loc) >= 4 && loc[2] >= 0 {
+			loc = loc[2:4]
+		}
+
+		if loc[0] == loc[1] {
+			continue
+		}
+
+		if found == nil || loc[0] < start || (loc[0] == start && loc[1] > end) {
+			found, matchStart, start, end = detector, match, loc[0], loc[1]
+		}
+	}
+
+	return found, matchStart, start, end
+}
+
+// summary describes the redactions, e.g. "jwt (1), private_key (2)"
+func (t *secretTransform) summary() (int, string) {
+	names := make([]string, 0, len(t.redactions))
+	total := 0
+	for name, count := range t.redactions {
+		names = append(names, name)
+		total += count
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s (%d)", name, t.redactions[name])
+	}
+
+	return total, strings.Join(names, ", ")
+}
@@ -0,0 +1,150 @@
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// bufferedSink writes the output to a sink in its own goroutine. The output
+// waits in a buffer of limited size: when the sink can't keep up the output
+// that doesn't fit is dropped, and when the sink fails the output it failed
+// to write is dropped. In both cases the job isn't affected.
+type bufferedSink struct {
+	sink          Sink
+	limit         int
+	flushInterval time.Duration
+	logger        logrus.FieldLogger
+
+	lock      sync.Mutex
+	queue     [][]byte
+	queued    int
+	dropped   int
+	overflown bool
+	closed    bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newBufferedSink(sink Sink, buffering buffering, logger logrus.FieldLogger) *bufferedSink {
+	s := &bufferedSink{
+		sink:          sink,
+		limit:         buffering.limit * 1024,
+		flushInterval: buffering.flushInterval,
+		logger:        logger,
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *bufferedSink) write(p []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed || len(p) == 0 {
+		return
+	}
+
+	if s.queued+len(p) > s.limit {
+		if !s.overflown {
+			s.logger.Warningln("Trace sink can't keep up with the job output, dropping output")
+			s.overflown = true
+		}
+		s.dropped += len(p)
+		return
+	}
+
+	s.queue = append(s.queue, append([]byte(nil), p...))
+	s.queued += len(p)
+
+	s.signal()
+}
+
+func (s *bufferedSink) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the queued output and returns it, with a flag telling whether
+// the sink is closed
+func (s *bufferedSink) take() ([][]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue := s.queue
+	s.queue = nil
+	s.queued = 0
+	s.overflown = false
+
+	return queue, s.closed
+}
+
+func (s *bufferedSink) run() {
+	defer close(s.done)
+
+	var flush <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.wake:
+			queue, closed := s.take()
+			for _, p := range queue {
+				s.handleError("write", len(p), s.sink.Write(p))
+			}
+
+			if closed {
+				s.handleError("flush", 0, s.sink.Flush())
+				s.handleError("close", 0, s.sink.Close())
+				return
+			}
+		case <-flush:
+			s.handleError("flush", 0, s.sink.Flush())
+		}
+	}
+}
+
+func (s *bufferedSink) handleError(operation string, size int, err error) {
+	if err == nil {
+		return
+	}
+
+	s.logger.WithError(err).Warningln("Trace sink failed to " + operation + " the job output")
+
+	s.lock.Lock()
+	s.dropped += size
+	s.lock.Unlock()
+}
+
+func (s *bufferedSink) close(timeout time.Duration) {
+	s.lock.Lock()
+	s.closed = true
+	s.signal()
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		s.logger.Warningln("Timed out waiting for the trace sink to write the job output")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.dropped > 0 {
+		s.logger.WithField("bytes", s.dropped).Warningln("Part of the job output wasn't written to the trace sink")
+	}
+}
@@ -0,0 +1,125 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//nolint:lll
+type FileConfig struct {
+	Dir         string `toml:"dir" json:"dir" description:"Directory where the output of each job is written to its own file"`
+	MaxSize     int    `toml:"max_size,omitzero" json:"max_size" description:"Size, in kilobytes, after which the file of a job is rotated, files aren't rotated when 0"`
+	MaxBackups  int    `toml:"max_backups,omitzero" json:"max_backups" description:"Number of rotated files kept for each job, all of them are kept when 0"`
+	BufferLimit int    `toml:"buffer_limit,omitzero" json:"buffer_limit" description:"Size, in kilobytes, of the output that can wait to be written to the file, defaults to 1024"`
+}
+
+type fileSink struct {
+	config FileConfig
+	path   string
+
+	file     *os.File
+	size     int
+	rotation int
+}
+
+func newFileSink(config *Config, job Job) (Sink, buffering, error) {
+	if config.File == nil {
+		return nil, buffering{}, nil
+	}
+
+	if config.File.Dir == "" {
+		return nil, buffering{}, errors.New("missing dir")
+	}
+
+	err := os.MkdirAll(config.File.Dir, 0700)
+	if err != nil {
+		return nil, buffering{}, fmt.Errorf("creating directory: %w", err)
+	}
+
+	s := &fileSink{
+		config: *config.File,
+		path:   filepath.Join(config.File.Dir, fmt.Sprintf("runner-%s-job-%d.log", job.Runner, job.ID)),
+	}
+
+	err = s.open()
+	if err != nil {
+		return nil, buffering{}, err
+	}
+
+	return s, buffering{limit: config.File.BufferLimit}, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.file = file
+	s.size = 0
+
+	return nil
+}
+
+func (s *fileSink) Write(p []byte) error {
+	if s.file == nil {
+		err := s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(p)
+	s.size += n
+	if err != nil {
+		return err
+	}
+
+	if s.config.MaxSize > 0 && s.size >= s.config.MaxSize*1024 {
+		return s.rotate()
+	}
+
+	return nil
+}
+
+// rotate renames the file with the next rotation number, and removes the
+// rotated files above MaxBackups. The oldest file has the lowest number.
+func (s *fileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+
+	s.rotation++
+	err = os.Rename(s.path, fmt.Sprintf("%s.%d", s.path, s.rotation))
+	if err != nil {
+		return err
+	}
+
+	if s.config.MaxBackups > 0 && s.rotation > s.config.MaxBackups {
+		err = os.Remove(fmt.Sprintf("%s.%d", s.path, s.rotation-s.config.MaxBackups))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) Flush() error {
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
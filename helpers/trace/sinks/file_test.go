@@ -0,0 +1,48 @@
+//go:build !integration
+// +build !integration
+
+package sinks
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	sink, _, err := newFileSink(&Config{File: &FileConfig{Dir: dir, MaxSize: 1, MaxBackups: 2}}, testJob)
+	require.NoError(t, err)
+
+	for _, c := range "abcd" {
+		require.NoError(t, sink.Write([]byte(strings.Repeat(string(c), 1024))))
+	}
+	require.NoError(t, sink.Write([]byte("current")))
+	require.NoError(t, sink.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "runner-abcdefgh-job-10.log")
+	assert.ElementsMatch(t, []string{path, path + ".3", path + ".4"}, files)
+
+	for file, expected := range map[string]string{
+		path:        "current",
+		path + ".3": strings.Repeat("c", 1024),
+		path + ".4": strings.Repeat("d", 1024),
+	} {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), file)
+	}
+}
+
+func TestFileSinkMissingDir(t *testing.T) {
+	_, _, err := newFileSink(&Config{File: &FileConfig{}}, testJob)
+	assert.EqualError(t, err, "missing dir")
+}
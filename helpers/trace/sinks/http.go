@@ -0,0 +1,156 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultHTTPFlushInterval = 5 * time.Second
+	defaultHTTPTimeout       = 30 * time.Second
+
+	// maxHTTPBatchSize is the size after which the lines are sent without
+	// waiting for the next flush
+	maxHTTPBatchSize = 1024 * 1024
+)
+
+//nolint:lll
+type HTTPConfig struct {
+	URL           string            `toml:"url" json:"url" description:"URL the lines of the job output are sent to, as JSON lines, with POST requests"`
+	Headers       map[string]string `toml:"headers,omitempty" json:"headers" description:"A toml table/json object of headers added to the requests, e.g. for authentication"`
+	FlushInterval int               `toml:"flush_interval,omitzero" json:"flush_interval" description:"How often, in seconds, the lines are sent, defaults to 5"`
+	Timeout       int               `toml:"timeout,omitzero" json:"timeout" description:"Timeout, in seconds, of the requests, defaults to 30"`
+	BufferLimit   int               `toml:"buffer_limit,omitzero" json:"buffer_limit" description:"Size, in kilobytes, of the output that can wait to be sent, defaults to 1024"`
+}
+
+// httpRecord is sent for each line of the output
+type httpRecord struct {
+	Time       time.Time `json:"time"`
+	JobID      int64     `json:"job_id"`
+	Runner     string    `json:"runner"`
+	RunnerName string    `json:"runner_name,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Line       int       `json:"line"`
+	Output     string    `json:"output"`
+}
+
+type httpSink struct {
+	config HTTPConfig
+	job    Job
+	client *http.Client
+
+	lines lineSplitter
+	line  int
+	batch bytes.Buffer
+}
+
+func newHTTPSink(config *Config, job Job) (Sink, buffering, error) {
+	if config.HTTP == nil {
+		return nil, buffering{}, nil
+	}
+
+	if config.HTTP.URL == "" {
+		return nil, buffering{}, errors.New("missing url")
+	}
+
+	timeout := defaultHTTPTimeout
+	if config.HTTP.Timeout > 0 {
+		timeout = time.Duration(config.HTTP.Timeout) * time.Second
+	}
+
+	flushInterval := defaultHTTPFlushInterval
+	if config.HTTP.FlushInterval > 0 {
+		flushInterval = time.Duration(config.HTTP.FlushInterval) * time.Second
+	}
+
+	s := &httpSink{
+		config: *config.HTTP,
+		job:    job,
+		client: &http.Client{Timeout: timeout},
+	}
+
+	return s, buffering{limit: config.HTTP.BufferLimit, flushInterval: flushInterval}, nil
+}
+
+func (s *httpSink) Write(p []byte) error {
+	for _, line := range s.lines.lines(p) {
+		err := s.add(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.batch.Len() >= maxHTTPBatchSize {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+func (s *httpSink) add(line []byte) error {
+	s.line++
+
+	return json.NewEncoder(&s.batch).Encode(httpRecord{
+		Time:       time.Now().UTC(),
+		JobID:      s.job.ID,
+		Runner:     s.job.Runner,
+		RunnerName: s.job.RunnerName,
+		URL:        s.job.URL,
+		Line:       s.line,
+		Output:     string(line),
+	})
+}
+
+// Flush sends the complete lines, the batch is dropped when it fails to be
+// sent
+func (s *httpSink) Flush() error {
+	if s.batch.Len() == 0 {
+		return nil
+	}
+
+	defer s.batch.Reset()
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(s.batch.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	if line := s.lines.flush(); len(line) > 0 {
+		err := s.add(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.Flush()
+	s.client.CloseIdleConnections()
+
+	return err
+}
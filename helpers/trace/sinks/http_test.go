@@ -0,0 +1,84 @@
+//go:build !integration
+// +build !integration
+
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSink(t *testing.T) {
+	var records []httpRecord
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var record httpRecord
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+	}))
+	defer server.Close()
+
+	sink, buffering, err := newHTTPSink(&Config{HTTP: &HTTPConfig{
+		URL:           server.URL,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		FlushInterval: 2,
+		BufferLimit:   10,
+	}}, testJob)
+	require.NoError(t, err)
+	assert.Equal(t, 10, buffering.limit)
+	assert.Equal(t, 2*time.Second, buffering.flushInterval)
+
+	require.NoError(t, sink.Write([]byte("first line\nsecond")))
+	require.NoError(t, sink.Flush())
+	require.NoError(t, sink.Flush())
+	assert.Equal(t, 1, requests, "empty batches aren't sent")
+
+	require.NoError(t, sink.Write([]byte(" line\nlast")))
+	require.NoError(t, sink.Close())
+	assert.Equal(t, 2, requests)
+
+	require.Len(t, records, 3)
+	for i, output := range []string{"first line", "second line", "last"} {
+		assert.Equal(t, i+1, records[i].Line)
+		assert.Equal(t, output, records[i].Output)
+		assert.Equal(t, testJob.ID, records[i].JobID)
+		assert.Equal(t, testJob.Runner, records[i].Runner)
+		assert.Equal(t, testJob.RunnerName, records[i].RunnerName)
+		assert.Equal(t, testJob.URL, records[i].URL)
+		assert.False(t, records[i].Time.IsZero())
+	}
+}
+
+func TestHTTPSinkFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink, _, err := newHTTPSink(&Config{HTTP: &HTTPConfig{URL: server.URL}}, testJob)
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write([]byte("line\n")))
+	assert.EqualError(t, sink.Flush(), "unexpected response: 503 Service Unavailable")
+	assert.NoError(t, sink.Flush(), "failed batches are dropped")
+
+	_, _, err = newHTTPSink(&Config{HTTP: &HTTPConfig{}}, testJob)
+	assert.EqualError(t, err, "missing url")
+}
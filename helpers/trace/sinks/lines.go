@@ -0,0 +1,44 @@
+package sinks
+
+import (
+	"bytes"
+)
+
+// lineSplitter splits the output into lines, for the sinks that send a
+// message per line. The last line is kept until it's complete or flushed.
+type lineSplitter struct {
+	partial []byte
+}
+
+// lines returns the complete lines of the output written so far, without
+// their line endings
+func (l *lineSplitter) lines(p []byte) [][]byte {
+	var lines [][]byte
+
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := append(l.partial, p[:i]...)
+		l.partial = nil
+
+		lines = append(lines, bytes.TrimSuffix(line, []byte("\r")))
+		p = p[i+1:]
+	}
+
+	if len(p) > 0 {
+		l.partial = append(l.partial, p...)
+	}
+
+	return lines
+}
+
+// flush returns the incomplete last line, if any
+func (l *lineSplitter) flush() []byte {
+	line := l.partial
+	l.partial = nil
+
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
@@ -0,0 +1,136 @@
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultBufferLimit is the number of kilobytes of output that can wait to be
+// written to a sink. Output that doesn't fit is dropped, so a slow sink never
+// blocks the job.
+const defaultBufferLimit = 1024
+
+// closeTimeout is how long the output still in the buffers can take to be
+// written to the sinks when the job finishes
+var closeTimeout = 30 * time.Second
+
+//nolint:lll
+type Config struct {
+	File   *FileConfig   `toml:"file,omitempty" json:"file" namespace:"file"`
+	Syslog *SyslogConfig `toml:"syslog,omitempty" json:"syslog" namespace:"syslog"`
+	HTTP   *HTTPConfig   `toml:"http,omitempty" json:"http" namespace:"http"`
+}
+
+// Job describes the job whose output is written to the sinks
+type Job struct {
+	ID         int64
+	Runner     string
+	RunnerName string
+	URL        string
+}
+
+// Sink receives the output of a job. Sinks are used by a single goroutine,
+// they don't have to be safe for concurrent use.
+type Sink interface {
+	Write(p []byte) error
+	// Flush is called periodically, and before Close, to send the output that
+	// the sink keeps in memory
+	Flush() error
+	Close() error
+}
+
+// buffering configures the buffer of a sink
+type buffering struct {
+	// limit is the number of kilobytes of output that can wait to be written
+	limit int
+	// flushInterval is how often the sink is flushed, it's flushed only when
+	// closed when 0
+	flushInterval time.Duration
+}
+
+type sinkFactory struct {
+	name string
+	// create returns a nil Sink when the sink isn't configured
+	create func(config *Config, job Job) (Sink, buffering, error)
+}
+
+var sinkFactories = []sinkFactory{
+	{name: "file", create: newFileSink},
+	{name: "syslog", create: newSyslogSink},
+	{name: "http", create: newHTTPSink},
+}
+
+// Tee copies the output of a job to the configured sinks. Each sink has its
+// own buffer and goroutine: writing to the Tee never blocks nor fails.
+type Tee struct {
+	sinks []*bufferedSink
+}
+
+// Open creates the sinks configured for the job. Sinks that fail to be created
+// are skipped. Open returns nil when no sinks are configured.
+func Open(config *Config, job Job, logger logrus.FieldLogger) *Tee {
+	if config == nil {
+		return nil
+	}
+
+	logger = logger.WithFields(logrus.Fields{
+		"job":    job.ID,
+		"runner": job.Runner,
+	})
+
+	tee := &Tee{}
+	for _, factory := range sinkFactories {
+		sinkLogger := logger.WithField("sink", factory.name)
+
+		sink, buffering, err := factory.create(config, job)
+		if err != nil {
+			sinkLogger.WithError(err).Warningln("Failed to open trace sink, the job output won't be written to it")
+			continue
+		}
+		if sink == nil {
+			continue
+		}
+
+		if buffering.limit <= 0 {
+			buffering.limit = defaultBufferLimit
+		}
+
+		tee.sinks = append(tee.sinks, newBufferedSink(sink, buffering, sinkLogger))
+	}
+
+	if len(tee.sinks) == 0 {
+		return nil
+	}
+
+	return tee
+}
+
+// Write queues p in the buffer of every sink
+func (t *Tee) Write(p []byte) (int, error) {
+	for _, sink := range t.sinks {
+		sink.write(p)
+	}
+
+	return len(p), nil
+}
+
+// Close waits for the sinks to write the output that's still in their buffers
+// and closes them. Sinks that don't finish in time are abandoned.
+func (t *Tee) Close() {
+	if t == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, sink := range t.sinks {
+		wg.Add(1)
+		go func(sink *bufferedSink) {
+			defer wg.Done()
+			sink.close(closeTimeout)
+		}(sink)
+	}
+
+	wg.Wait()
+}
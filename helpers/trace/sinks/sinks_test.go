@@ -0,0 +1,205 @@
+//go:build !integration
+// +build !integration
+
+package sinks
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testJob = Job{ID: 10, Runner: "abcdefgh", RunnerName: "runner", URL: "https://gitlab.example.com"}
+
+// blockingSink records what it receives, and blocks until unblocked
+type blockingSink struct {
+	lock    sync.Mutex
+	written []byte
+	closed  bool
+	err     error
+
+	unblock chan struct{}
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{unblock: make(chan struct{})}
+}
+
+func (s *blockingSink) Write(p []byte) error {
+	<-s.unblock
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.written = append(s.written, p...)
+	return nil
+}
+
+func (s *blockingSink) Flush() error {
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+func (s *blockingSink) output() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return string(s.written)
+}
+
+func TestOpen(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+
+	assert.Nil(t, Open(nil, testJob, logger))
+	assert.Nil(t, Open(&Config{}, testJob, logger))
+	assert.Nil(t, Open(&Config{File: &FileConfig{}}, testJob, logger), "invalid sinks are skipped")
+
+	dir := t.TempDir()
+	tee := Open(&Config{File: &FileConfig{Dir: dir}, HTTP: &HTTPConfig{}}, testJob, logger)
+	require.NotNil(t, tee)
+	assert.Len(t, tee.sinks, 1)
+
+	n, err := tee.Write([]byte("job output\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 11, n)
+
+	tee.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "runner-abcdefgh-job-10.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "job output\n", string(data))
+
+	var nilTee *Tee
+	assert.NotPanics(t, nilTee.Close)
+}
+
+func TestBufferedSinkDropsOutputWhenFull(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	sink := newBlockingSink()
+	buffered := newBufferedSink(sink, buffering{limit: 1}, logger)
+
+	// the first write is taken by the sink, which blocks
+	buffered.write([]byte("first\n"))
+	require.Eventually(t, func() bool {
+		buffered.lock.Lock()
+		defer buffered.lock.Unlock()
+		return buffered.queued == 0
+	}, time.Second, time.Millisecond)
+
+	buffered.write(make([]byte, 1000))
+	buffered.write(make([]byte, 100))
+
+	close(sink.unblock)
+	buffered.close(time.Second)
+
+	assert.Len(t, sink.output(), 1006)
+	assert.True(t, sink.closed)
+	assert.Equal(t, 100, buffered.dropped)
+
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{
+		"Trace sink can't keep up with the job output, dropping output",
+		"Part of the job output wasn't written to the trace sink",
+	}, messages)
+}
+
+func TestBufferedSinkFailure(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	sink := newBlockingSink()
+	sink.err = errors.New("sink failed")
+	close(sink.unblock)
+
+	buffered := newBufferedSink(sink, buffering{limit: 1}, logger)
+	buffered.write([]byte("output"))
+	buffered.close(time.Second)
+
+	assert.Equal(t, 6, buffered.dropped)
+	assert.True(t, sink.closed)
+	require.NotEmpty(t, hook.AllEntries())
+	assert.Equal(t, "Trace sink failed to write the job output", hook.AllEntries()[0].Message)
+}
+
+func TestBufferedSinkCloseTimeout(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	sink := newBlockingSink()
+	defer close(sink.unblock)
+
+	buffered := newBufferedSink(sink, buffering{limit: 1}, logger)
+	buffered.write([]byte("output"))
+	buffered.close(10 * time.Millisecond)
+
+	assert.Equal(t, "Timed out waiting for the trace sink to write the job output", hook.LastEntry().Message)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+
+	// writes after the sink is closed are ignored
+	buffered.write([]byte("more output"))
+	assert.Empty(t, buffered.queue)
+}
+
+func TestBufferedSinkFlushInterval(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+
+	flushed := make(chan struct{}, 1)
+	sink := &flushSink{flushed: flushed}
+
+	buffered := newBufferedSink(sink, buffering{limit: 1, flushInterval: time.Millisecond}, logger)
+	defer buffered.close(time.Second)
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "sink wasn't flushed")
+	}
+}
+
+type flushSink struct {
+	blockingSink
+	flushed chan struct{}
+}
+
+func (s *flushSink) Flush() error {
+	select {
+	case s.flushed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func TestLineSplitter(t *testing.T) {
+	var splitter lineSplitter
+
+	assert.Empty(t, splitter.lines([]byte("partial")))
+	assert.Equal(
+		t,
+		[][]byte{[]byte("partial line"), []byte("windows line"), nil},
+		splitter.lines([]byte(" line\nwindows line\r\n\nlast")),
+	)
+	assert.Equal(t, []byte("last"), splitter.flush())
+	assert.Empty(t, splitter.flush())
+}
@@ -0,0 +1,83 @@
+package sinks
+
+import (
+	"fmt"
+	"io"
+)
+
+const defaultSyslogTag = "gitlab-runner"
+
+//nolint:lll
+type SyslogConfig struct {
+	Network     string `toml:"network,omitempty" json:"network" description:"Network of the syslog server: tcp, udp or unix, the local syslog server is used when empty"`
+	Address     string `toml:"address,omitempty" json:"address" description:"Address of the syslog server"`
+	Tag         string `toml:"tag,omitempty" json:"tag" description:"Tag of the messages, defaults to gitlab-runner"`
+	BufferLimit int    `toml:"buffer_limit,omitzero" json:"buffer_limit" description:"Size, in kilobytes, of the output that can wait to be sent to syslog, defaults to 1024"`
+}
+
+// syslogSink sends a message for each line of the output, prefixed with the
+// runner and the job it belongs to
+type syslogSink struct {
+	w      io.WriteCloser
+	prefix string
+	lines  lineSplitter
+}
+
+func newSyslogSink(config *Config, job Job) (Sink, buffering, error) {
+	if config.Syslog == nil {
+		return nil, buffering{}, nil
+	}
+
+	tag := config.Syslog.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+
+	w, err := dialSyslog(config.Syslog.Network, config.Syslog.Address, tag)
+	if err != nil {
+		return nil, buffering{}, err
+	}
+
+	s := &syslogSink{
+		w:      w,
+		prefix: fmt.Sprintf("runner=%s job=%d ", job.Runner, job.ID),
+	}
+
+	return s, buffering{limit: config.Syslog.BufferLimit}, nil
+}
+
+func (s *syslogSink) Write(p []byte) error {
+	for _, line := range s.lines.lines(p) {
+		err := s.send(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *syslogSink) send(line []byte) error {
+	_, err := s.w.Write(append([]byte(s.prefix), line...))
+	return err
+}
+
+// Flush doesn't send the incomplete last line, so a line is never split in
+// several messages
+func (s *syslogSink) Flush() error {
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	var err error
+	if line := s.lines.flush(); len(line) > 0 {
+		err = s.send(line)
+	}
+
+	closeErr := s.w.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package sinks
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func dialSyslog(network string, address string, tag string) (io.WriteCloser, error) {
+	return syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_USER, tag)
+}
@@ -0,0 +1,50 @@
+//go:build !integration && !windows
+// +build !integration,!windows
+
+package sinks
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, _, err := newSyslogSink(&Config{Syslog: &SyslogConfig{
+		Network: "udp",
+		Address: conn.LocalAddr().String(),
+	}}, testJob)
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write([]byte("first line\nsecond")))
+	require.NoError(t, sink.Flush())
+	require.NoError(t, sink.Write([]byte(" line\nlast")))
+	require.NoError(t, sink.Close())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var messages []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		messages = append(messages, string(buf[:n]))
+	}
+
+	for i, line := range []string{"first line", "second line", "last"} {
+		assert.Contains(t, messages[i], " gitlab-runner[")
+		assert.True(
+			t,
+			strings.HasSuffix(messages[i], "runner=abcdefgh job=10 "+line+"\n"),
+			"unexpected message %q", messages[i],
+		)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package sinks
+
+import (
+	"errors"
+	"io"
+)
+
+func dialSyslog(network string, address string, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog isn't supported on Windows")
+}
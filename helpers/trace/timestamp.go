@@ -0,0 +1,231 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"golang.org/x/text/transform"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+)
+
+// TimestampFormat is the format of the timestamps prefixing the lines of the
+// trace
+type TimestampFormat string
+
+const (
+	// TimestampsElapsed prefixes the lines with the time elapsed since the
+	// trace was created, e.g. 00:01:23.456
+	TimestampsElapsed TimestampFormat = "elapsed"
+	// TimestampsAbsolute prefixes the lines with the UTC time, e.g.
+	// 2021-10-19T07:30:00.123Z
+	TimestampsAbsolute TimestampFormat = "absolute"
+
+	absoluteTimestampLayout = "2006-01-02T15:04:05.000Z"
+
+	// maxSectionMarkerSize is the size after which data that starts like a
+	// section marker, but doesn't end like one, isn't a section marker
+	maxSectionMarkerSize = 1024
+)
+
+var (
+	sectionMarkers   = [][]byte{[]byte("section_start:"), []byte("section_end:")}
+	sectionMarkerEnd = []byte("\r" + helpers.ANSI_CLEAR)
+)
+
+// timestampTransform prefixes each line with a timestamp. The section markers
+// at the beginning of a line, after ANSI escape sequences, are kept at the
+// beginning of the line, followed by the timestamp, so that they're still
+// recognized. Timestamps are only
+// written at the beginning of the lines, so they never split ANSI escape
+// sequences.
+//
+// The transformer is shared by the transformer chains the Buffer creates
+// when the masked values change, so Reset doesn't reset the position in the
+// line.
+type timestampTransform struct {
+	format TimestampFormat
+	start  time.Time
+	now    func() time.Time
+
+	lineStart bool
+}
+
+// Validate returns an error when the format is unknown
+func (f TimestampFormat) Validate() error {
+	switch f {
+	case TimestampsElapsed, TimestampsAbsolute:
+		return nil
+	}
+
+	return fmt.Errorf("unknown timestamp format %q", f)
+}
+
+func newTimestampTransform(format TimestampFormat) (*timestampTransform, error) {
+	err := format.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &timestampTransform{
+		format:    format,
+		start:     time.Now(),
+		now:       time.Now,
+		lineStart: true,
+	}, nil
+}
+
+func (t *timestampTransform) Reset() {}
+
+func (t *timestampTransform) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if t.lineStart {
+			n, complete := sectionMarker(src[nSrc:], atEOF)
+			if !complete {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+
+			if n > 0 {
+				// the marker is copied at once, a partial copy would be followed
+				// by the timestamp on the next call
+				if len(dst[nDst:]) < n {
+					return nDst, nSrc, transform.ErrShortDst
+				}
+
+				err = copyn(dst, src, &nDst, &nSrc, n)
+				if err != nil {
+					return nDst, nSrc, err
+				}
+				continue
+			}
+
+			err = replace(dst, &nDst, &nSrc, t.timestamp(), 0)
+			if err != nil {
+				return nDst, nSrc, err
+			}
+			t.lineStart = false
+		}
+
+		n := bytes.IndexByte(src[nSrc:], '\n') + 1
+		if n == 0 {
+			n = len(src) - nSrc
+		} else {
+			t.lineStart = true
+		}
+
+		err = copyn(dst, src, &nDst, &nSrc, n)
+		if err != nil {
+			// the end of the line wasn't copied yet
+			t.lineStart = false
+			return nDst, nSrc, err
+		}
+	}
+
+	return nDst, nSrc, nil
+}
+
+func (t *timestampTransform) timestamp() []byte {
+	now := t.now()
+
+	if t.format == TimestampsAbsolute {
+		return []byte(now.UTC().Format(absoluteTimestampLayout) + " ")
+	}
+
+	elapsed := now.Sub(t.start)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return []byte(fmt.Sprintf(
+		"%02d:%02d:%02d.%03d ",
+		int(elapsed.Hours()),
+		int(elapsed.Minutes())%60,
+		int(elapsed.Seconds())%60,
+		elapsed.Milliseconds()%1000,
+	))
+}
+
+// sectionMarker returns the size of the section marker at the beginning of
+// src, if any, with the ANSI escape sequences preceding it, e.g. the
+// ANSI_CLEAR written by the shells. It returns false when src may be the
+// beginning of a section marker, but more data is needed to know.
+func sectionMarker(src []byte, atEOF bool) (int, bool) {
+	prefix, complete := ansiSequences(src)
+	if !complete && !atEOF {
+		return 0, false
+	}
+
+	n, complete := sectionMarkerAt(src[prefix:], atEOF)
+	if n > 0 {
+		n += prefix
+	}
+
+	return n, complete
+}
+
+// ansiSequences returns the size of the ANSI CSI sequences at the beginning of
+// src. It returns false when src ends with an incomplete sequence.
+func ansiSequences(src []byte) (int, bool) {
+	n := 0
+	for n < len(src) && src[n] == '\x1b' {
+		i := n + 1
+		if i == len(src) {
+			return n, false
+		}
+		if src[i] != '[' {
+			return n, true
+		}
+
+		// parameters, followed by the final byte
+		i++
+		for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == ';') {
+			i++
+		}
+		if i >= maxSectionMarkerSize {
+			return n, true
+		}
+		if i == len(src) {
+			return n, false
+		}
+		if src[i] < 0x40 || src[i] > 0x7e {
+			return n, true
+		}
+
+		n = i + 1
+	}
+
+	return n, true
+}
+
+func sectionMarkerAt(src []byte, atEOF bool) (int, bool) {
+	for _, marker := range sectionMarkers {
+		if len(src) < len(marker) {
+			if bytes.HasPrefix(marker, src) && !atEOF {
+				return 0, false
+			}
+			continue
+		}
+
+		if !bytes.HasPrefix(src, marker) {
+			continue
+		}
+
+		i := bytes.Index(src, sectionMarkerEnd)
+		if nl := bytes.IndexByte(src, '\n'); nl >= 0 && (i < 0 || nl < i) {
+			return 0, true
+		}
+
+		if i >= 0 && i < maxSectionMarkerSize {
+			return i + len(sectionMarkerEnd), true
+		}
+
+		if i < 0 && len(src) < maxSectionMarkerSize && !atEOF {
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	return 0, true
+}
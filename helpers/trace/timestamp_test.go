@@ -0,0 +1,197 @@
+//go:build !integration
+// +build !integration
+
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/transform"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+)
+
+func newTimestampsTestBuffer(t *testing.T, format TimestampFormat) *Buffer {
+	buffer, err := New(WithTimestamps(format))
+	require.NoError(t, err)
+	t.Cleanup(buffer.Close)
+
+	start := time.Date(2021, 10, 19, 7, 30, 0, 0, time.UTC)
+	now := start
+	buffer.timestamps.start = start
+	buffer.timestamps.now = func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	}
+
+	return buffer
+}
+
+func TestBufferTimestamps(t *testing.T) {
+	sectionStart := "section_start:1634628600:step_script\r" + helpers.ANSI_CLEAR
+	sectionEnd := "section_end:1634628601:step_script\r" + helpers.ANSI_CLEAR
+
+	// the output of BashWriter.SectionStart and BashWriter.SectionEnd
+	bashSectionMarker := helpers.ANSI_CLEAR + "section_start:1634628600:section_script_step_0\r" + helpers.ANSI_CLEAR
+	bashSectionStart := bashSectionMarker + helpers.ANSI_BOLD_GREEN + "$ echo output" + helpers.ANSI_RESET + "\n"
+	bashSectionEnd := helpers.ANSI_CLEAR + "section_end:1634628601:section_script_step_0\r" + helpers.ANSI_CLEAR + "\n"
+
+	tests := map[string]struct {
+		format   TimestampFormat
+		input    string
+		masked   []string
+		expected string
+	}{
+		"elapsed": {
+			format:   TimestampsElapsed,
+			input:    "first line\nsecond line\n\nincomplete",
+			expected: "00:00:01.500 first line\n00:00:03.000 second line\n00:00:04.500 \n00:00:06.000 incomplete",
+		},
+		"absolute": {
+			format:   TimestampsAbsolute,
+			input:    "first line\nsecond line\n",
+			expected: "2021-10-19T07:30:01.500Z first line\n2021-10-19T07:30:03.000Z second line\n",
+		},
+		"section markers": {
+			format: TimestampsElapsed,
+			input:  sectionStart + helpers.ANSI_BOLD_CYAN + "Executing script" + helpers.ANSI_RESET + "\n" + "output\n" + sectionEnd + "\n",
+			expected: sectionStart + "00:00:01.500 " + helpers.ANSI_BOLD_CYAN + "Executing script" + helpers.ANSI_RESET + "\n" +
+				"00:00:03.000 output\n" +
+				sectionEnd + "00:00:04.500 \n",
+		},
+		"consecutive section markers": {
+			format:   TimestampsElapsed,
+			input:    sectionEnd + sectionStart + "output\n",
+			expected: sectionEnd + sectionStart + "00:00:01.500 output\n",
+		},
+		"section marker in a line": {
+			format:   TimestampsElapsed,
+			input:    "output " + sectionStart + "\n",
+			expected: "00:00:01.500 output " + sectionStart + "\n",
+		},
+		"incomplete section marker": {
+			format:   TimestampsElapsed,
+			input:    "section_start:1634628600\nsection_",
+			expected: "00:00:01.500 section_start:1634628600\n00:00:03.000 section_",
+		},
+		"shell section markers": {
+			format: TimestampsElapsed,
+			input: bashSectionStart + "output\n" + bashSectionEnd +
+				helpers.ANSI_CLEAR + "not a section marker\n",
+			expected: bashSectionMarker + "00:00:01.500 " +
+				helpers.ANSI_BOLD_GREEN + "$ echo output" + helpers.ANSI_RESET + "\n" +
+				"00:00:03.000 output\n" +
+				bashSectionEnd[:len(bashSectionEnd)-len("\n")] + "00:00:04.500 \n" +
+				"00:00:06.000 " + helpers.ANSI_CLEAR + "not a section marker\n",
+		},
+		"incomplete escape sequence": {
+			format:   TimestampsElapsed,
+			input:    "\x1b[0",
+			expected: "00:00:01.500 \x1b[0",
+		},
+		"carriage return": {
+			format:   TimestampsElapsed,
+			input:    "progress 10%\rprogress 100%\n",
+			expected: "00:00:01.500 progress 10%\rprogress 100%\n",
+		},
+		"masking": {
+			format:   TimestampsElapsed,
+			input:    "secret: masked_value\nmasked_value\n",
+			masked:   []string{"masked_value"},
+			expected: "00:00:01.500 secret: [MASKED]\n00:00:03.000 [MASKED]\n",
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			for _, chunkSize := range []int{1, 3, len(tc.input)} {
+				buffer := newTimestampsTestBuffer(t, tc.format)
+				buffer.SetMasked(tc.masked)
+
+				input := tc.input
+				for len(input) > 0 {
+					n := chunkSize
+					if n > len(input) {
+						n = len(input)
+					}
+
+					_, err := buffer.Write([]byte(input[:n]))
+					require.NoError(t, err)
+					input = input[n:]
+				}
+				buffer.Finish()
+
+				content, err := buffer.Bytes(0, 10000)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, string(content), "chunk size %d", chunkSize)
+			}
+		})
+	}
+}
+
+func TestTimestampTransformShortDst(t *testing.T) {
+	tests := map[string]string{
+		"section marker":       "section_start:1634628600:step_script\r" + helpers.ANSI_CLEAR,
+		"shell section marker": helpers.ANSI_CLEAR + "section_start:1634628600:section_step_script\r" + helpers.ANSI_CLEAR,
+	}
+
+	for tn, sectionStart := range tests {
+		t.Run(tn, func(t *testing.T) {
+			input := "output\n" + sectionStart + "script\n"
+			expected := "00:00:01.500 output\n" + sectionStart + "00:00:01.500 script\n"
+
+			// every size of dst that fits the section marker, so that the marker is split
+			// at every position when dst is full
+			for size := len(sectionStart); size <= len(expected); size++ {
+				timestamps, err := newTimestampTransform(TimestampsElapsed)
+				require.NoError(t, err)
+				timestamps.start = time.Date(2021, 10, 19, 7, 30, 0, 0, time.UTC)
+				timestamps.now = func() time.Time { return timestamps.start.Add(1500 * time.Millisecond) }
+
+				var output []byte
+				dst := make([]byte, size)
+				src := []byte(input)
+				for {
+					nDst, nSrc, err := timestamps.Transform(dst, src, true)
+					output = append(output, dst[:nDst]...)
+					src = src[nSrc:]
+					if err == nil {
+						break
+					}
+
+					require.ErrorIs(t, err, transform.ErrShortDst, "dst size %d", size)
+					require.True(t, nDst > 0 || nSrc > 0, "no progress with dst size %d", size)
+				}
+
+				assert.Equal(t, expected, string(output), "dst size %d", size)
+			}
+		})
+	}
+}
+
+func TestBufferTimestampsSetMasked(t *testing.T) {
+	buffer := newTimestampsTestBuffer(t, TimestampsElapsed)
+
+	_, err := buffer.Write([]byte("first line\nsecond"))
+	require.NoError(t, err)
+
+	// the writer is recreated when the masked values change, the position in
+	// the line must be kept
+	buffer.SetMasked([]string{"secret"})
+
+	_, err = buffer.Write([]byte(" line secret\n"))
+	require.NoError(t, err)
+	buffer.Finish()
+
+	content, err := buffer.Bytes(0, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, "00:00:01.500 first line\n00:00:03.000 second line [MASKED]\n", string(content))
+}
+
+func TestBufferTimestampsUnknownFormat(t *testing.T) {
+	_, err := New(WithTimestamps("unknown"))
+	assert.EqualError(t, err, `unknown timestamp format "unknown"`)
+}
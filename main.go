@@ -14,7 +14,10 @@ import (
 
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/azure"
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/gcs"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/local"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/runner"
 	_ "gitlab.com/gitlab-org/gitlab-runner/cache/s3"
+	_ "gitlab.com/gitlab-org/gitlab-runner/cache/sftp"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands"
 	_ "gitlab.com/gitlab-org/gitlab-runner/commands/helpers"
 	_ "gitlab.com/gitlab-org/gitlab-runner/executors/anka"
@@ -0,0 +1,227 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	APIEndpointRegisterRunner    APIEndpoint = "register_runner"
+	APIEndpointVerifyRunner      APIEndpoint = "verify_runner"
+	APIEndpointUnregisterRunner  APIEndpoint = "unregister_runner"
+	APIEndpointUploadArtifacts   APIEndpoint = "upload_artifacts"
+	APIEndpointDownloadArtifacts APIEndpoint = "download_artifacts"
+	APIEndpointOther             APIEndpoint = "other"
+)
+
+// apiEndpoint returns the endpoint of a request to the GitLab API, from its
+// URI relative to the API root, e.g. jobs/1/trace
+func apiEndpoint(method, uri string) APIEndpoint {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "runners":
+		if method == http.MethodDelete {
+			return APIEndpointUnregisterRunner
+		}
+		return APIEndpointRegisterRunner
+	case len(parts) == 2 && parts[0] == "runners" && parts[1] == "verify":
+		return APIEndpointVerifyRunner
+	case len(parts) < 2 || parts[0] != "jobs":
+		return APIEndpointOther
+	case len(parts) == 2 && parts[1] == "request":
+		return APIEndpointRequestJob
+	}
+
+	if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return APIEndpointOther
+	}
+
+	switch {
+	case len(parts) == 2:
+		return APIEndpointUpdateJob
+	case len(parts) == 3 && parts[2] == "trace":
+		return APIEndpointPatchTrace
+	case len(parts) == 3 && parts[2] == "artifacts" && method == http.MethodGet:
+		return APIEndpointDownloadArtifacts
+	case len(parts) == 3 && parts[2] == "artifacts":
+		return APIEndpointUploadArtifacts
+	}
+
+	return APIEndpointOther
+}
+
+// statusClass returns the class of a response status, e.g. 2xx, or error
+// when no response was received
+func statusClass(res *http.Response, err error) string {
+	if err != nil || res == nil {
+		return "error"
+	}
+
+	return strconv.Itoa(res.StatusCode/100) + "xx"
+}
+
+// APIMetrics measures the requests sent to the GitLab API and the
+// connections they use
+type APIMetrics struct {
+	requestDuration      *prometheus.HistogramVec
+	requestsInFlight     *prometheus.GaugeVec
+	dnsDuration          *prometheus.HistogramVec
+	tlsHandshakeDuration *prometheus.HistogramVec
+	connections          *prometheus.CounterVec
+}
+
+func NewAPIMetrics() *APIMetrics {
+	return &APIMetrics{
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "gitlab_runner_api_request_duration_seconds",
+				Help: "Time until the response headers of the GitLab API requests are received, " +
+					"partitioned by endpoint and status class.",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			},
+			[]string{"endpoint", "status_class"},
+		),
+		requestsInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gitlab_runner_api_requests_in_flight",
+				Help: "The current number of GitLab API requests waiting for their response, partitioned by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		dnsDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gitlab_runner_api_dns_duration_seconds",
+				Help:    "Time spent resolving the address of the GitLab instance.",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+			},
+			[]string{"url"},
+		),
+		tlsHandshakeDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gitlab_runner_api_tls_handshake_duration_seconds",
+				Help:    "Time spent in the TLS handshakes with the GitLab instance.",
+				Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5},
+			},
+			[]string{"url"},
+		),
+		connections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_api_connections_total",
+				Help: "Total number of connections used by the GitLab API requests, " +
+					"partitioned by protocol and by whether they were reused.",
+			},
+			[]string{"url", "protocol", "reused"},
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *APIMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requestDuration.Describe(ch)
+	m.requestsInFlight.Describe(ch)
+	m.dnsDuration.Describe(ch)
+	m.tlsHandshakeDuration.Describe(ch)
+	m.connections.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *APIMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requestDuration.Collect(ch)
+	m.requestsInFlight.Collect(ch)
+	m.dnsDuration.Collect(ch)
+	m.tlsHandshakeDuration.Collect(ch)
+	m.connections.Collect(ch)
+}
+
+// requestMeasurement measures a single request, from when it's sent to when
+// its response headers are received
+type requestMeasurement struct {
+	metrics  *APIMetrics
+	url      string
+	endpoint APIEndpoint
+	start    time.Time
+
+	dnsStart time.Time
+	tlsStart time.Time
+}
+
+// measure starts measuring a request to url, the returned context must be
+// used for the request. It returns nil when m is nil.
+func (m *APIMetrics) measure(
+	ctx context.Context,
+	url string,
+	endpoint APIEndpoint,
+) (context.Context, *requestMeasurement) {
+	if m == nil {
+		return ctx, nil
+	}
+
+	r := &requestMeasurement{
+		metrics:  m,
+		url:      url,
+		endpoint: endpoint,
+		start:    time.Now(),
+	}
+
+	m.requestsInFlight.WithLabelValues(string(endpoint)).Inc()
+
+	return httptrace.WithClientTrace(ctx, r.clientTrace()), r
+}
+
+func (r *requestMeasurement) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.metrics.dnsDuration.WithLabelValues(r.url).Observe(time.Since(r.dnsStart).Seconds())
+		},
+		TLSHandshakeStart: func() {
+			r.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.metrics.tlsHandshakeDuration.WithLabelValues(r.url).Observe(time.Since(r.tlsStart).Seconds())
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.metrics.connections.
+				WithLabelValues(r.url, connectionProtocol(info), strconv.FormatBool(info.Reused)).
+				Inc()
+		},
+	}
+}
+
+// connectionProtocol returns the protocol negotiated on the connection, h2
+// for HTTP/2
+func connectionProtocol(info httptrace.GotConnInfo) string {
+	if conn, ok := info.Conn.(*tls.Conn); ok {
+		if protocol := conn.ConnectionState().NegotiatedProtocol; protocol != "" {
+			return protocol
+		}
+	}
+
+	return "http/1.1"
+}
+
+// done records the result of the request, it does nothing when r is nil
+func (r *requestMeasurement) done(res *http.Response, err error) {
+	if r == nil {
+		return
+	}
+
+	r.metrics.requestsInFlight.WithLabelValues(string(r.endpoint)).Dec()
+	r.metrics.requestDuration.
+		WithLabelValues(string(r.endpoint), statusClass(res, err)).
+		Observe(time.Since(r.start).Seconds())
+}
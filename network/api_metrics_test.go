@@ -0,0 +1,152 @@
+//go:build !integration
+// +build !integration
+
+package network
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	tests := []struct {
+		method   string
+		uri      string
+		expected APIEndpoint
+	}{
+		{method: http.MethodPost, uri: "runners", expected: APIEndpointRegisterRunner},
+		{method: http.MethodDelete, uri: "runners", expected: APIEndpointUnregisterRunner},
+		{method: http.MethodPost, uri: "runners/verify", expected: APIEndpointVerifyRunner},
+		{method: http.MethodPost, uri: "jobs/request", expected: APIEndpointRequestJob},
+		{method: http.MethodPut, uri: "jobs/10", expected: APIEndpointUpdateJob},
+		{method: http.MethodPatch, uri: "jobs/10/trace", expected: APIEndpointPatchTrace},
+		{method: http.MethodPost, uri: "jobs/10/artifacts?artifact_format=zip", expected: APIEndpointUploadArtifacts},
+		{method: http.MethodGet, uri: "jobs/10/artifacts?direct_download=true", expected: APIEndpointDownloadArtifacts},
+		{method: http.MethodGet, uri: "jobs/name", expected: APIEndpointOther},
+		{method: http.MethodGet, uri: "jobs/10/unknown", expected: APIEndpointOther},
+		{method: http.MethodGet, uri: "test/json", expected: APIEndpointOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.uri, func(t *testing.T) {
+			assert.Equal(t, tt.expected, apiEndpoint(tt.method, tt.uri))
+		})
+	}
+}
+
+func histogramSampleCount(t *testing.T, metrics *APIMetrics, name string) uint64 {
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(metrics))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+		}
+	}
+
+	return count
+}
+
+func TestAPIMetrics(t *testing.T) {
+	tests := map[string]struct {
+		http2            bool
+		expectedProtocol string
+	}{
+		"HTTP/1.1": {
+			http2:            false,
+			expectedProtocol: "http/1.1",
+		},
+		"HTTP/2": {
+			http2:            true,
+			expectedProtocol: "h2",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(correlationIDHeader, "01FJ8QZ6J3KQ5N6XM1W1Q3AYP4")
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			s.EnableHTTP2 = true
+			s.StartTLS()
+			defer s.Close()
+
+			ca, err := ioutil.TempFile("", "cert_")
+			require.NoError(t, err)
+			_ = ca.Close()
+			defer os.Remove(ca.Name())
+			require.NoError(t, writeTLSCertificate(s, ca.Name()))
+
+			config := common.RunnerConfig{
+				RunnerCredentials: common.RunnerCredentials{
+					URL:       s.URL,
+					Token:     validToken,
+					TLSCAFile: ca.Name(),
+				},
+				RunnerSettings: common.RunnerSettings{
+					FeatureFlags: map[string]bool{featureflags.UseHTTP2ForGitLabAPI: tt.http2},
+				},
+			}
+
+			hook := test.NewGlobal()
+			defer hook.Reset()
+			level := logrus.GetLevel()
+			defer logrus.SetLevel(level)
+			logrus.SetLevel(logrus.DebugLevel)
+
+			c := NewGitLabClient()
+			for i := 0; i < 2; i++ {
+				res, ok := c.RequestJob(context.Background(), config, nil)
+				require.Nil(t, res)
+				require.True(t, ok)
+			}
+
+			metrics := c.APIMetrics()
+			newConnections := metrics.connections.WithLabelValues(s.URL, tt.expectedProtocol, "false")
+			reusedConnections := metrics.connections.WithLabelValues(s.URL, tt.expectedProtocol, "true")
+			inFlight := metrics.requestsInFlight.WithLabelValues(string(APIEndpointRequestJob))
+
+			assert.Equal(t, 1.0, testutil.ToFloat64(newConnections))
+			assert.Equal(t, 1.0, testutil.ToFloat64(reusedConnections))
+			assert.Equal(t, 0.0, testutil.ToFloat64(inFlight))
+			requests := histogramSampleCount(t, metrics, "gitlab_runner_api_request_duration_seconds")
+			handshakes := histogramSampleCount(t, metrics, "gitlab_runner_api_tls_handshake_duration_seconds")
+
+			assert.Equal(t, uint64(2), requests)
+			assert.Equal(t, uint64(1), handshakes)
+
+			var entry *logrus.Entry
+			for _, e := range hook.AllEntries() {
+				if e.Message == "GitLab API request" {
+					entry = e
+				}
+			}
+			require.NotNil(t, entry, "the request is logged")
+			assert.Equal(t, "01FJ8QZ6J3KQ5N6XM1W1Q3AYP4", entry.Data["correlation_id"])
+			assert.Equal(t, http.StatusNoContent, entry.Data["status"])
+		})
+	}
+}
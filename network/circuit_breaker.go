@@ -0,0 +1,374 @@
+package network
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	url_helpers "gitlab.com/gitlab-org/gitlab-runner/helpers/url"
+)
+
+// NOTE: The behavior of the circuit breaker as well as the constant values
+// are documented in `docs/configuration/advanced-configuration.md#how-the-gitlab-api-circuit-breaker-works`
+
+const (
+	// circuitBreakerWindow is the number of the last requests whose results
+	// are used to compute the failure ratio
+	circuitBreakerWindow = 20
+	// circuitBreakerMinRequests is the number of results needed before the
+	// circuit breaker can open
+	circuitBreakerMinRequests = 10
+	// circuitBreakerFailureRatio is the ratio of failed requests above which
+	// the circuit breaker opens
+	circuitBreakerFailureRatio = 0.5
+
+	circuitBreakerMinOpenDuration = 15 * time.Second
+	circuitBreakerMaxOpenDuration = 5 * time.Minute
+)
+
+type CircuitBreakerState string
+
+const (
+	// CircuitBreakerClosed lets all the requests through
+	CircuitBreakerClosed CircuitBreakerState = "closed"
+	// CircuitBreakerOpen rejects the job requests, GitLab is failing
+	CircuitBreakerOpen CircuitBreakerState = "open"
+	// CircuitBreakerHalfOpen lets a single job request through, to check
+	// whether GitLab recovered
+	CircuitBreakerHalfOpen CircuitBreakerState = "half_open"
+)
+
+var circuitBreakerStates = []CircuitBreakerState{CircuitBreakerClosed, CircuitBreakerOpen, CircuitBreakerHalfOpen}
+
+type requestOutcome int
+
+const (
+	requestSucceeded requestOutcome = iota
+	requestFailed
+	// requestIgnored is used for the requests that didn't reach GitLab for a
+	// reason unrelated to its health, e.g. they were canceled
+	requestIgnored
+)
+
+// requestOutcomeFromStatus returns the outcome of a request from its status
+// code. 5xx responses and the requests that got no response, e.g. because of
+// a timeout, are failures.
+func requestOutcomeFromStatus(statusCode int) requestOutcome {
+	if statusCode == clientError || statusCode >= 500 {
+		return requestFailed
+	}
+
+	return requestSucceeded
+}
+
+// requestCanceled returns whether a request sent with ctx failed without a
+// response because ctx was canceled or its deadline was exceeded
+func requestCanceled(ctx context.Context, statusCode int) bool {
+	return statusCode < 0 && ctx.Err() != nil
+}
+
+// requestOutcomeFromContext returns the outcome of a request sent with ctx,
+// the requests that failed because ctx was canceled are ignored
+func requestOutcomeFromContext(ctx context.Context, statusCode int) requestOutcome {
+	if requestCanceled(ctx, statusCode) {
+		return requestIgnored
+	}
+
+	return requestOutcomeFromStatus(statusCode)
+}
+
+// CircuitBreakerStatus describes the circuit breaker of a GitLab instance
+type CircuitBreakerStatus struct {
+	URL          string
+	State        CircuitBreakerState
+	FailureRatio float64
+	// RetryIn is the time left before the next job request is let through,
+	// when the circuit breaker is open
+	RetryIn time.Duration
+}
+
+// circuitBreaker tracks the health of a GitLab instance, shared by all the
+// runners using it. When too many requests fail, the job requests are
+// rejected without reaching GitLab, while the trace updates of the running
+// jobs, that have priority, are still sent.
+type circuitBreaker struct {
+	url     string
+	metrics *CircuitBreakers
+
+	lock      sync.Mutex
+	state     CircuitBreakerState
+	results   []bool
+	next      int
+	openUntil time.Time
+	probing   bool
+	backoff   *backoff.Backoff
+
+	now func() time.Time
+}
+
+func newCircuitBreaker(url string, metrics *CircuitBreakers) *circuitBreaker {
+	return &circuitBreaker{
+		url:     url,
+		metrics: metrics,
+		state:   CircuitBreakerClosed,
+		backoff: &backoff.Backoff{
+			Min:    circuitBreakerMinOpenDuration,
+			Max:    circuitBreakerMaxOpenDuration,
+			Factor: 2,
+			Jitter: true,
+		},
+		now: time.Now,
+	}
+}
+
+func (cb *circuitBreaker) log() *logrus.Entry {
+	return logrus.WithField("url", cb.url)
+}
+
+// allow returns whether a request can be sent. Priority requests are always
+// sent. The requests that are allowed must report their outcome with done.
+func (cb *circuitBreaker) allow(endpoint APIEndpoint, priority bool) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == CircuitBreakerOpen && !cb.now().Before(cb.openUntil) {
+		cb.transition(CircuitBreakerHalfOpen)
+	}
+
+	if priority || cb.state == CircuitBreakerClosed {
+		return true
+	}
+
+	if cb.state == CircuitBreakerHalfOpen && !cb.probing {
+		cb.probing = true
+		return true
+	}
+
+	cb.metrics.rejected.WithLabelValues(cb.url, string(endpoint)).Inc()
+
+	return false
+}
+
+// done reports the outcome of a request that was allowed
+func (cb *circuitBreaker) done(priority bool, outcome requestOutcome) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if !priority && cb.state == CircuitBreakerHalfOpen {
+		cb.probing = false
+	}
+
+	if outcome == requestIgnored {
+		return
+	}
+
+	failed := outcome == requestFailed
+	cb.record(failed)
+
+	switch cb.state {
+	case CircuitBreakerClosed:
+		if len(cb.results) >= circuitBreakerMinRequests && cb.failureRatio() >= circuitBreakerFailureRatio {
+			cb.open()
+		}
+	case CircuitBreakerHalfOpen:
+		if failed {
+			cb.open()
+			return
+		}
+
+		cb.results = nil
+		cb.next = 0
+		cb.backoff.Reset()
+		cb.transition(CircuitBreakerClosed)
+	}
+}
+
+func (cb *circuitBreaker) record(failed bool) {
+	if len(cb.results) < circuitBreakerWindow {
+		cb.results = append(cb.results, failed)
+		return
+	}
+
+	cb.results[cb.next] = failed
+	cb.next = (cb.next + 1) % circuitBreakerWindow
+}
+
+func (cb *circuitBreaker) failureRatio() float64 {
+	if len(cb.results) == 0 {
+		return 0
+	}
+
+	failures := 0
+	for _, failed := range cb.results {
+		if failed {
+			failures++
+		}
+	}
+
+	return float64(failures) / float64(len(cb.results))
+}
+
+func (cb *circuitBreaker) open() {
+	duration := cb.backoff.Duration()
+	cb.openUntil = cb.now().Add(duration)
+
+	cb.transition(CircuitBreakerOpen)
+
+	cb.log().
+		WithFields(logrus.Fields{
+			"failure-ratio": cb.failureRatio(),
+			"retry-in":      duration,
+		}).
+		Warningln("Too many GitLab API requests failed, pausing job requests")
+}
+
+func (cb *circuitBreaker) transition(state CircuitBreakerState) {
+	if cb.state == state {
+		return
+	}
+
+	cb.state = state
+	cb.probing = false
+	cb.metrics.transitions.WithLabelValues(cb.url, string(state)).Inc()
+
+	if state != CircuitBreakerOpen {
+		cb.log().WithField("state", state).Infoln("GitLab API circuit breaker state changed")
+	}
+}
+
+func (cb *circuitBreaker) status() CircuitBreakerStatus {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	status := CircuitBreakerStatus{
+		URL:          cb.url,
+		State:        cb.state,
+		FailureRatio: cb.failureRatio(),
+	}
+
+	if cb.state == CircuitBreakerOpen {
+		status.RetryIn = cb.openUntil.Sub(cb.now())
+		if status.RetryIn < 0 {
+			status.RetryIn = 0
+		}
+	}
+
+	return status
+}
+
+// CircuitBreakers holds the circuit breakers of the GitLab instances the
+// runners use, and exports their state as metrics
+type CircuitBreakers struct {
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+
+	state        *prometheus.Desc
+	failureRatio *prometheus.Desc
+	transitions  *prometheus.CounterVec
+	rejected     *prometheus.CounterVec
+}
+
+func NewCircuitBreakers() *CircuitBreakers {
+	return &CircuitBreakers{
+		breakers: make(map[string]*circuitBreaker),
+		state: prometheus.NewDesc(
+			"gitlab_runner_api_circuit_breaker_state",
+			"State of the circuit breaker of the GitLab instance, 1 for the current state",
+			[]string{"url", "state"},
+			nil,
+		),
+		failureRatio: prometheus.NewDesc(
+			"gitlab_runner_api_circuit_breaker_failure_ratio",
+			"Ratio of the last requests to the GitLab instance that failed",
+			[]string{"url"},
+			nil,
+		),
+		transitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_api_circuit_breaker_transitions_total",
+				Help: "Total number of times the circuit breaker of the GitLab instance changed to the state",
+			},
+			[]string{"url", "state"},
+		),
+		rejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_api_circuit_breaker_rejected_requests_total",
+				Help: "Total number of requests rejected by the circuit breaker of the GitLab instance",
+			},
+			[]string{"url", "endpoint"},
+		),
+	}
+}
+
+func (c *CircuitBreakers) get(url string) *circuitBreaker {
+	url = url_helpers.CleanURL(fixCIURL(url))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	breaker := c.breakers[url]
+	if breaker == nil {
+		breaker = newCircuitBreaker(url, c)
+		c.breakers[url] = breaker
+	}
+
+	return breaker
+}
+
+// Statuses returns the status of the circuit breakers, sorted by URL
+func (c *CircuitBreakers) Statuses() []CircuitBreakerStatus {
+	c.lock.Lock()
+	breakers := make([]*circuitBreaker, 0, len(c.breakers))
+	for _, breaker := range c.breakers {
+		breakers = append(breakers, breaker)
+	}
+	c.lock.Unlock()
+
+	statuses := make([]CircuitBreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URL < statuses[j].URL
+	})
+
+	return statuses
+}
+
+// Describe implements prometheus.Collector.
+func (c *CircuitBreakers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.failureRatio
+	c.transitions.Describe(ch)
+	c.rejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *CircuitBreakers) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range c.Statuses() {
+		for _, state := range circuitBreakerStates {
+			value := 0.0
+			if status.State == state {
+				value = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, value, status.URL, string(state))
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.failureRatio,
+			prometheus.GaugeValue,
+			status.FailureRatio,
+			status.URL,
+		)
+	}
+
+	c.transitions.Collect(ch)
+	c.rejected.Collect(ch)
+}
@@ -0,0 +1,213 @@
+//go:build !integration
+// +build !integration
+
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestCircuitBreaker() (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+
+	cb := NewCircuitBreakers().get("https://gitlab.example.com")
+	cb.now = clock.Now
+	cb.backoff.Jitter = false
+
+	return cb, clock
+}
+
+func reportOutcomes(t *testing.T, cb *circuitBreaker, outcome requestOutcome, count int) {
+	for i := 0; i < count; i++ {
+		require.True(t, cb.allow(APIEndpointRequestJob, false))
+		cb.done(false, outcome)
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	tests := map[string]struct {
+		successes     int
+		failures      int
+		expectedState CircuitBreakerState
+	}{
+		"no requests": {
+			expectedState: CircuitBreakerClosed,
+		},
+		"not enough requests": {
+			failures:      circuitBreakerMinRequests - 1,
+			expectedState: CircuitBreakerClosed,
+		},
+		"failure ratio below the threshold": {
+			successes:     6,
+			failures:      4,
+			expectedState: CircuitBreakerClosed,
+		},
+		"failure ratio reaching the threshold": {
+			successes:     5,
+			failures:      5,
+			expectedState: CircuitBreakerOpen,
+		},
+		"failures pushed out of the window": {
+			successes:     circuitBreakerWindow,
+			failures:      0,
+			expectedState: CircuitBreakerClosed,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cb, _ := newTestCircuitBreaker()
+
+			reportOutcomes(t, cb, requestSucceeded, tt.successes)
+			reportOutcomes(t, cb, requestFailed, tt.failures)
+
+			assert.Equal(t, tt.expectedState, cb.status().State)
+		})
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledRequests(t *testing.T) {
+	cb, _ := newTestCircuitBreaker()
+
+	reportOutcomes(t, cb, requestIgnored, circuitBreakerWindow)
+
+	status := cb.status()
+	assert.Equal(t, CircuitBreakerClosed, status.State)
+	assert.Zero(t, status.FailureRatio)
+}
+
+func TestCircuitBreakerRecovery(t *testing.T) {
+	cb, clock := newTestCircuitBreaker()
+
+	reportOutcomes(t, cb, requestFailed, circuitBreakerMinRequests)
+
+	status := cb.status()
+	require.Equal(t, CircuitBreakerOpen, status.State)
+	assert.Equal(t, circuitBreakerMinOpenDuration, status.RetryIn)
+	assert.False(t, cb.allow(APIEndpointRequestJob, false), "job requests are rejected")
+	assert.True(t, cb.allow(APIEndpointPatchTrace, true), "trace updates have priority")
+	cb.done(true, requestFailed)
+
+	clock.now = clock.now.Add(circuitBreakerMinOpenDuration)
+
+	require.True(t, cb.allow(APIEndpointRequestJob, false), "a probe is let through")
+	assert.Equal(t, CircuitBreakerHalfOpen, cb.status().State)
+	assert.False(t, cb.allow(APIEndpointRequestJob, false), "a single probe is let through")
+
+	cb.done(false, requestFailed)
+	status = cb.status()
+	require.Equal(t, CircuitBreakerOpen, status.State)
+	assert.Equal(t, 2*circuitBreakerMinOpenDuration, status.RetryIn, "the open duration backs off")
+
+	clock.now = clock.now.Add(2 * circuitBreakerMinOpenDuration)
+
+	require.True(t, cb.allow(APIEndpointRequestJob, false))
+	cb.done(false, requestIgnored)
+	require.True(t, cb.allow(APIEndpointRequestJob, false), "an ignored probe is replaced")
+	cb.done(false, requestSucceeded)
+
+	status = cb.status()
+	assert.Equal(t, CircuitBreakerClosed, status.State)
+	assert.Zero(t, status.FailureRatio)
+	assert.True(t, cb.allow(APIEndpointRequestJob, false))
+
+	reportOutcomes(t, cb, requestFailed, circuitBreakerMinRequests)
+	assert.Equal(t, circuitBreakerMinOpenDuration, cb.status().RetryIn, "the backoff is reset once closed")
+}
+
+func TestCircuitBreakersAreSharedPerURL(t *testing.T) {
+	breakers := NewCircuitBreakers()
+
+	cb := breakers.get("https://gitlab.example.com/")
+	assert.Same(t, cb, breakers.get("https://gitlab.example.com/ci"))
+	assert.NotSame(t, cb, breakers.get("https://other.example.com"))
+
+	statuses := breakers.Statuses()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "https://gitlab.example.com", statuses[0].URL)
+	assert.Equal(t, "https://other.example.com", statuses[1].URL)
+}
+
+func TestCircuitBreakersMetrics(t *testing.T) {
+	breakers := NewCircuitBreakers()
+	cb := breakers.get("https://gitlab.example.com")
+
+	for i := 0; i < circuitBreakerMinRequests; i++ {
+		require.True(t, cb.allow(APIEndpointRequestJob, false))
+		cb.done(false, requestFailed)
+	}
+	assert.False(t, cb.allow(APIEndpointRequestJob, false))
+
+	//nolint:lll
+	metrics := `
+		# HELP gitlab_runner_api_circuit_breaker_failure_ratio Ratio of the last requests to the GitLab instance that failed
+		# TYPE gitlab_runner_api_circuit_breaker_failure_ratio gauge
+		gitlab_runner_api_circuit_breaker_failure_ratio{url="https://gitlab.example.com"} 1
+		# HELP gitlab_runner_api_circuit_breaker_rejected_requests_total Total number of requests rejected by the circuit breaker of the GitLab instance
+		# TYPE gitlab_runner_api_circuit_breaker_rejected_requests_total counter
+		gitlab_runner_api_circuit_breaker_rejected_requests_total{endpoint="request_job",url="https://gitlab.example.com"} 1
+		# HELP gitlab_runner_api_circuit_breaker_state State of the circuit breaker of the GitLab instance, 1 for the current state
+		# TYPE gitlab_runner_api_circuit_breaker_state gauge
+		gitlab_runner_api_circuit_breaker_state{state="closed",url="https://gitlab.example.com"} 0
+		gitlab_runner_api_circuit_breaker_state{state="half_open",url="https://gitlab.example.com"} 0
+		gitlab_runner_api_circuit_breaker_state{state="open",url="https://gitlab.example.com"} 1
+		# HELP gitlab_runner_api_circuit_breaker_transitions_total Total number of times the circuit breaker of the GitLab instance changed to the state
+		# TYPE gitlab_runner_api_circuit_breaker_transitions_total counter
+		gitlab_runner_api_circuit_breaker_transitions_total{state="open",url="https://gitlab.example.com"} 1
+	`
+
+	assert.NoError(t, testutil.CollectAndCompare(breakers, strings.NewReader(metrics)))
+}
+
+func TestRequestJobSkippedWhenCircuitBreakerIsOpen(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	config := common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{
+			URL:   s.URL,
+			Token: validToken,
+		},
+	}
+
+	c := NewGitLabClient()
+
+	for i := 0; i < circuitBreakerMinRequests+5; i++ {
+		res, ok := c.RequestJob(context.Background(), config, nil)
+		assert.Nil(t, res)
+		assert.True(t, ok, "the runner stays healthy while GitLab is failing")
+	}
+	assert.Equal(t, int32(circuitBreakerMinRequests), atomic.LoadInt32(&requests))
+
+	statuses := c.CircuitBreakers().Statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, CircuitBreakerOpen, statuses[0].State)
+
+	result := c.PatchTrace(config, &common.JobCredentials{ID: 1, Token: validToken}, []byte("trace"), 0)
+	assert.Equal(t, common.PatchFailed, result.State)
+	assert.Equal(t, int32(circuitBreakerMinRequests+1), atomic.LoadInt32(&requests), "trace updates are sent")
+}
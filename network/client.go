@@ -34,6 +34,10 @@ const jsonMimeType = "application/json"
 const applicationXMLMimeType = "application/xml"
 const textXMLMimeType = "text/xml"
 
+// correlationIDHeader is the header of the GitLab responses holding the
+// correlation ID of the request
+const correlationIDHeader = "X-Request-Id"
+
 type requestCredentials interface {
 	GetURL() string
 	GetToken() string
@@ -56,7 +60,11 @@ const (
 
 type client struct {
 	http.Client
-	url             *url.URL
+	url *url.URL
+	// gitlabURL is the URL of the GitLab instance, without credentials, used
+	// to label the metrics
+	gitlabURL       string
+	http2           bool
 	caFile          string
 	certFile        string
 	keyFile         string
@@ -67,6 +75,7 @@ type client struct {
 	lock            sync.Mutex
 
 	requester requester
+	metrics   *APIMetrics
 }
 
 type ResponseTLSData struct {
@@ -108,6 +117,16 @@ func (n *client) ensureTLSConfig() {
 	}
 }
 
+// setHTTP2 enables HTTP/2, the transport is recreated when it changes
+func (n *client) setHTTP2(enabled bool) {
+	if n.http2 == enabled {
+		return
+	}
+
+	n.http2 = enabled
+	n.Transport = nil
+}
+
 func (n *client) addTLSCA(tlsConfig *tls.Config) {
 	// load TLS CA certificate
 	file := n.caFile
@@ -179,6 +198,7 @@ func (n *client) createTransport() {
 			return dialer.Dial(network, addr)
 		},
 		TLSClientConfig:       &tlsConfig,
+		ForceAttemptHTTP2:     n.http2,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
@@ -247,7 +267,13 @@ func (n *client) do(
 
 	n.ensureTLSConfig()
 
+	ctx, measurement := n.metrics.measure(ctx, n.gitlabURL, apiEndpoint(method, uri))
+	req = req.WithContext(ctx)
+
+	started := time.Now()
 	res, err := n.requester.Do(req)
+	measurement.done(res, err)
+	logRequest(req, res, err, time.Since(started))
 	if err != nil {
 		return nil, err
 	}
@@ -257,6 +283,31 @@ func (n *client) do(
 	return res, nil
 }
 
+// logRequest logs the request at debug level, with the correlation ID GitLab
+// returned, to find the request in the logs of GitLab
+func logRequest(req *http.Request, res *http.Response, err error, duration time.Duration) {
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"method":   req.Method,
+		"url":      url_helpers.CleanURL(req.URL.String()),
+		"duration": duration,
+	})
+
+	if err != nil {
+		log.WithError(err).Debugln("GitLab API request failed")
+		return
+	}
+
+	log.WithFields(logrus.Fields{
+		"status":         res.StatusCode,
+		"protocol":       res.Proto,
+		"correlation_id": res.Header.Get(correlationIDHeader),
+	}).Debugln("GitLab API request")
+}
+
 // ErrorResponse is an error type that is returned when there is an issue
 // calling the remote server. It contains the http.Response responsible for
 // the error and the error payload provided by the server.
@@ -533,6 +584,7 @@ func newClient(requestCredentials requestCredentials) (*client, error) {
 
 	c := &client{
 		url:             url,
+		gitlabURL:       url_helpers.CleanURL(fixCIURL(requestCredentials.GetURL())),
 		caFile:          requestCredentials.GetTLSCAFile(),
 		certFile:        requestCredentials.GetTLSCertFile(),
 		keyFile:         requestCredentials.GetTLSKeyFile(),
@@ -18,6 +18,9 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace/sinks"
 )
 
 const clientError = -100
@@ -94,6 +97,16 @@ type GitLabClient struct {
 	lock    sync.Mutex
 
 	requestsStatusesMap *APIRequestStatusesMap
+	traceSpool          *TraceSpool
+	circuitBreakers     *CircuitBreakers
+	apiMetrics          *APIMetrics
+	longPolling         *LongPolling
+}
+
+// featureFlagsCredentials is implemented by the credentials of the runners,
+// whose feature flags configure the client
+type featureFlagsCredentials interface {
+	IsFeatureFlagOn(name string) bool
 }
 
 func (n *GitLabClient) getClient(credentials requestCredentials) (c *client, err error) {
@@ -116,9 +129,14 @@ func (n *GitLabClient) getClient(credentials requestCredentials) (c *client, err
 		if err != nil {
 			return
 		}
+		c.metrics = n.apiMetrics
 		n.clients[key] = c
 	}
 
+	if settings, ok := credentials.(featureFlagsCredentials); ok {
+		c.setHTTP2(settings.IsFeatureFlagOn(featureflags.UseHTTP2ForGitLabAPI))
+	}
+
 	return
 }
 
@@ -352,10 +370,19 @@ func (n *GitLabClient) RequestJob(
 		Session:    sessionInfo,
 	}
 
+	ctx, cancel, polling := n.longPolling.begin(ctx, config, &request)
+	defer cancel()
+
+	breaker := n.circuitBreakers.get(config.URL)
+	if !breaker.allow(APIEndpointRequestJob, false) {
+		config.Log().Debugln("Checking for jobs...", "skipped, GitLab is failing")
+		return nil, true
+	}
+
 	var response common.JobResponse
 	result, statusText, httpResponse := n.doJSON(
 		ctx,
-		&config.RunnerCredentials,
+		&config,
 		http.MethodPost,
 		"jobs/request",
 		http.StatusCreated,
@@ -363,8 +390,17 @@ func (n *GitLabClient) RequestJob(
 		&response,
 	)
 
+	breaker.done(false, requestOutcomeFromContext(ctx, result))
+	n.longPolling.done(ctx, config, polling, result, httpResponse)
 	n.requestsStatusesMap.Append(config.RunnerCredentials.ShortDescription(), APIEndpointRequestJob, result)
 
+	if requestCanceled(ctx, result) {
+		// the request was canceled, e.g. a long polling request when the
+		// runner stops, which doesn't make the runner unhealthy
+		config.Log().WithField("status", statusText).Debugln("Checking for jobs...", "canceled")
+		return nil, true
+	}
+
 	switch result {
 	case http.StatusCreated:
 		config.Log().WithFields(logrus.Fields{
@@ -386,6 +422,11 @@ func (n *GitLabClient) RequestJob(
 	case http.StatusNoContent:
 		config.Log().Debugln("Checking for jobs...", "nothing")
 		return nil, true
+	case http.StatusConflict:
+		// GitLab Workhorse held the request and the job queue changed, the
+		// next request gets the job
+		config.Log().Debugln("Checking for jobs...", "queue changed")
+		return nil, true
 	case clientError:
 		config.Log().WithField("status", statusText).Errorln("Checking for jobs...", "error")
 		return nil, false
@@ -410,15 +451,19 @@ func (n *GitLabClient) UpdateJob(
 		ExitCode:      jobInfo.ExitCode,
 	}
 
+	breaker := n.circuitBreakers.get(config.URL)
+	breaker.allow(APIEndpointUpdateJob, true)
+
 	statusCode, statusText, response := n.doJSON(
 		context.Background(),
-		&config.RunnerCredentials,
+		&config,
 		http.MethodPut,
 		fmt.Sprintf("jobs/%d", jobInfo.ID),
 		http.StatusOK,
 		&request,
 		nil,
 	)
+	breaker.done(true, requestOutcomeFromStatus(statusCode))
 	n.requestsStatusesMap.Append(config.RunnerCredentials.ShortDescription(), APIEndpointUpdateJob, statusCode)
 
 	log := config.Log().WithField("job", jobInfo.ID)
@@ -499,9 +544,12 @@ func (n *GitLabClient) PatchTrace(
 	uri := fmt.Sprintf("jobs/%d/trace", id)
 	request := bytes.NewReader(content)
 
+	breaker := n.circuitBreakers.get(config.URL)
+	breaker.allow(APIEndpointPatchTrace, true)
+
 	response, err := n.doRaw(
 		context.Background(),
-		&config.RunnerCredentials,
+		&config,
 		"PATCH",
 		uri,
 		request,
@@ -509,10 +557,13 @@ func (n *GitLabClient) PatchTrace(
 		headers,
 	)
 	if err != nil {
+		breaker.done(true, requestFailed)
 		config.Log().Errorln("Appending trace to coordinator...", "error", err.Error())
 		return common.NewPatchTraceResult(startOffset, common.PatchFailed, 0)
 	}
 
+	breaker.done(true, requestOutcomeFromStatus(response.StatusCode))
+
 	n.requestsStatusesMap.Append(
 		config.RunnerCredentials.ShortDescription(),
 		APIEndpointPatchTrace,
@@ -821,18 +872,112 @@ func (n *GitLabClient) ProcessJob(
 	config common.RunnerConfig,
 	jobCredentials *common.JobCredentials,
 ) (common.JobTrace, error) {
-	trace, err := newJobTrace(n, config, jobCredentials)
+	var jobTrace *clientJobTrace
+	var err error
+
+	var opts []trace.Option
+	if opt := secretDetectionOption(config); opt != nil {
+		opts = append(opts, opt)
+	}
+
+	tee := sinks.Open(config.TraceSinks, sinks.Job{
+		ID:         jobCredentials.ID,
+		Runner:     config.ShortDescription(),
+		RunnerName: config.Name,
+		URL:        config.URL,
+	}, logrus.StandardLogger())
+	if tee != nil {
+		opts = append(opts, trace.WithTee(tee))
+	}
+	if opt := traceTimestampsOption(config); opt != nil {
+		opts = append(opts, opt)
+	}
+
+	if n.traceSpool != nil {
+		jobTrace, err = n.traceSpool.newJobTrace(n, config, jobCredentials, opts...)
+		if err != nil {
+			logrus.WithError(err).Warningln("Failed to spool the job trace, it will be lost if the runner stops")
+		}
+	}
+
+	if jobTrace == nil {
+		jobTrace, err = newJobTrace(n, config, jobCredentials, opts...)
+		if err != nil {
+			tee.Close()
+			return nil, err
+		}
+	}
+
+	jobTrace.sinks = tee
+	jobTrace.start()
+	return jobTrace, nil
+}
+
+// secretDetectionOption returns the option redacting the secrets found by the detectors. When the custom
+// detectors are invalid, only the built-in detectors are used, the job was already picked up and failing it
+// would leave it stuck.
+func secretDetectionOption(config common.RunnerConfig) trace.Option {
+	detectors, err := config.SecretDetection.Compile()
 	if err != nil {
-		return nil, err
+		config.Log().WithError(err).Warningln("Ignoring the custom detectors of secret_detection")
+
+		builtin := &trace.SecretDetectionConfig{DisabledDetectors: config.SecretDetection.DisabledDetectors}
+		detectors, _ = builtin.Compile()
+	}
+
+	if len(detectors) == 0 {
+		return nil
+	}
+
+	return trace.WithSecretDetectors(detectors)
+}
+
+// traceTimestampsOption returns the option prefixing the lines of the trace with timestamps. An unknown
+// format is only logged, the job was already picked up and failing it would leave it stuck.
+func traceTimestampsOption(config common.RunnerConfig) trace.Option {
+	if config.TraceTimestamps == "" {
+		return nil
 	}
 
-	trace.start()
-	return trace, nil
+	format := trace.TimestampFormat(config.TraceTimestamps)
+
+	err := format.Validate()
+	if err != nil {
+		config.Log().WithError(err).Warningln("Ignoring trace_timestamps")
+		return nil
+	}
+
+	return trace.WithTimestamps(format)
+}
+
+// SetTraceSpool keeps the traces of the jobs in the spool until GitLab
+// receives their final update
+func (n *GitLabClient) SetTraceSpool(spool *TraceSpool) {
+	n.traceSpool = spool
+}
+
+// APIMetrics returns the metrics of the requests the client sends
+func (n *GitLabClient) APIMetrics() *APIMetrics {
+	return n.apiMetrics
+}
+
+// LongPolling returns the long polling state of the job requests
+func (n *GitLabClient) LongPolling() *LongPolling {
+	return n.longPolling
+}
+
+// CircuitBreakers returns the circuit breakers of the GitLab instances the
+// client sends requests to
+func (n *GitLabClient) CircuitBreakers() *CircuitBreakers {
+	return n.circuitBreakers
 }
 
 func NewGitLabClientWithRequestStatusesMap(rsMap *APIRequestStatusesMap) *GitLabClient {
 	return &GitLabClient{
 		requestsStatusesMap: rsMap,
+		circuitBreakers:     NewCircuitBreakers(),
+		apiMetrics:          NewAPIMetrics(),
+		longPolling:         NewLongPolling(),
 	}
 }
 
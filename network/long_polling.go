@@ -0,0 +1,255 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// NOTE: The behavior of long polling as well as the constant values are
+// documented in `docs/configuration/advanced-configuration.md#how-long-polling-works`
+
+const (
+	// lastUpdateHeader is set by GitLab on the job requests it answers, with
+	// the last update of the job queue of the runner. GitLab Workhorse holds
+	// the job requests sending the current last update, until the queue
+	// changes or its long polling duration is reached, and answers them
+	// without it.
+	lastUpdateHeader = "X-GitLab-Last-Update"
+
+	// longPollingRenegotiationInterval is the time after which long polling is
+	// tried again with a GitLab instance that didn't support it
+	longPollingRenegotiationInterval = time.Hour
+
+	jobRequestModeLongPolling  = "long_polling"
+	jobRequestModeShortPolling = "short_polling"
+
+	jobRequestResultJob      = "job"
+	jobRequestResultNoJob    = "no_job"
+	jobRequestResultFailure  = "failure"
+	jobRequestResultCanceled = "canceled"
+)
+
+// runnerPolling is the polling state of a runner
+type runnerPolling struct {
+	// unsupportedAt is when GitLab answered a long polling request for an
+	// unchanged job queue without holding it, long polling isn't used until
+	// it's renegotiated
+	unsupportedAt time.Time
+	// lastRequestEnd is when the last job request of the runner ended
+	lastRequestEnd time.Time
+	// held is whether GitLab held the last long polling request of the runner
+	held bool
+}
+
+// jobRequestPolling is a job request being sent
+type jobRequestPolling struct {
+	runner      string
+	longPolling bool
+	lastUpdate  string
+	start       time.Time
+}
+
+// LongPolling negotiates long polling of the job requests with GitLab, and
+// exports the rate of the job requests and the latency of the job pickups as
+// metrics
+type LongPolling struct {
+	lock    sync.Mutex
+	runners map[string]*runnerPolling
+
+	requests      *prometheus.CounterVec
+	pickupLatency *prometheus.HistogramVec
+
+	now func() time.Time
+}
+
+func NewLongPolling() *LongPolling {
+	return &LongPolling{
+		runners: make(map[string]*runnerPolling),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_job_requests_total",
+				Help: "Total number of job requests, partitioned by runner, polling mode and result.",
+			},
+			[]string{"runner", "mode", "result"},
+		),
+		pickupLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "gitlab_runner_job_pickup_latency_seconds",
+				Help: "Time the runner wasn't waiting for jobs before receiving a job: " +
+					"from the end of its previous job request to the start of the request that received the job.",
+				Buckets: []float64{0.1, 0.5, 1, 2, 3, 5, 10, 30, 60},
+			},
+			[]string{"runner", "mode"},
+		),
+		now: time.Now,
+	}
+}
+
+func (p *LongPolling) runner(config *common.RunnerConfig) *runnerPolling {
+	key := config.UniqueID()
+
+	state := p.runners[key]
+	if state == nil {
+		state = &runnerPolling{}
+		p.runners[key] = state
+	}
+
+	return state
+}
+
+// Enabled returns whether the job requests of the runner are held by GitLab
+// until a job is available: long polling is configured, and GitLab didn't
+// answer a long polling request for an unchanged job queue without holding it
+func (p *LongPolling) Enabled(config common.RunnerConfig) bool {
+	if config.GetLongPollTimeout() <= 0 {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	state := p.runner(&config)
+
+	return state.unsupportedAt.IsZero() || p.now().Sub(state.unsupportedAt) >= longPollingRenegotiationInterval
+}
+
+// Held returns whether GitLab held the last long polling request of the
+// runner until its job queue changed or the timeout was reached. It's false
+// when the request failed, wasn't held, or wasn't sent.
+func (p *LongPolling) Held(config common.RunnerConfig) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.runner(&config).held
+}
+
+// begin prepares a job request, with long polling when it's enabled: the
+// request is canceled after the long polling timeout instead of the timeout of
+// the client. The returned context must be used for the request.
+func (p *LongPolling) begin(
+	ctx context.Context,
+	config common.RunnerConfig,
+	request *common.JobRequest,
+) (context.Context, context.CancelFunc, *jobRequestPolling) {
+	polling := &jobRequestPolling{
+		runner:      config.ShortDescription(),
+		longPolling: p.Enabled(config),
+		lastUpdate:  request.LastUpdate,
+		start:       p.now(),
+	}
+
+	if !polling.longPolling {
+		return ctx, func() {}, polling
+	}
+
+	p.lock.Lock()
+	p.runner(&config).held = false
+	p.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, config.GetLongPollTimeout())
+
+	return ctx, cancel, polling
+}
+
+// done records the result of a job request sent with ctx, and whether GitLab
+// held it
+func (p *LongPolling) done(
+	ctx context.Context,
+	config common.RunnerConfig,
+	polling *jobRequestPolling,
+	result int,
+	res *http.Response,
+) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.now()
+	state := p.runner(&config)
+	lastRequestEnd := state.lastRequestEnd
+	state.lastRequestEnd = now
+
+	mode := jobRequestModeShortPolling
+	if polling.longPolling {
+		mode = jobRequestModeLongPolling
+		p.negotiate(ctx, config, state, polling, result, res, now)
+	}
+
+	if requestCanceled(ctx, result) {
+		p.requests.WithLabelValues(polling.runner, mode, jobRequestResultCanceled).Inc()
+		return
+	}
+
+	switch result {
+	case http.StatusCreated:
+		p.requests.WithLabelValues(polling.runner, mode, jobRequestResultJob).Inc()
+		if !lastRequestEnd.IsZero() {
+			latency := polling.start.Sub(lastRequestEnd)
+			if latency < 0 {
+				latency = 0
+			}
+			p.pickupLatency.WithLabelValues(polling.runner, mode).Observe(latency.Seconds())
+		}
+	case http.StatusNoContent, http.StatusConflict:
+		p.requests.WithLabelValues(polling.runner, mode, jobRequestResultNoJob).Inc()
+	default:
+		p.requests.WithLabelValues(polling.runner, mode, jobRequestResultFailure).Inc()
+	}
+}
+
+// negotiate records whether GitLab Workhorse held the long polling request.
+// Workhorse holds the requests sending the current last update of the job
+// queue of the runner: it answers 409 when the queue changed while the request
+// was held, and 204 without the last update when its long polling duration was
+// reached. When GitLab answers 204 with the last update that was sent, the
+// request wasn't held for an unchanged queue, and long polling isn't
+// supported. The other answers are sent by GitLab for a changed queue, and
+// don't tell whether it's supported.
+func (p *LongPolling) negotiate(
+	ctx context.Context,
+	config common.RunnerConfig,
+	state *runnerPolling,
+	polling *jobRequestPolling,
+	result int,
+	res *http.Response,
+	now time.Time,
+) {
+	if polling.lastUpdate == "" {
+		return
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && result < 0,
+		result == http.StatusConflict,
+		result == http.StatusNoContent && res != nil && res.Header.Get(lastUpdateHeader) == "":
+		if !state.unsupportedAt.IsZero() {
+			config.Log().Infoln("GitLab supports long polling, job requests are held until a job is available")
+		}
+		state.unsupportedAt = time.Time{}
+		state.held = true
+
+	case result == http.StatusNoContent && res != nil && res.Header.Get(lastUpdateHeader) == polling.lastUpdate:
+		if state.unsupportedAt.IsZero() {
+			config.Log().Infoln("GitLab doesn't support long polling, falling back to requesting jobs every check interval")
+		}
+		state.unsupportedAt = now
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *LongPolling) Describe(ch chan<- *prometheus.Desc) {
+	p.requests.Describe(ch)
+	p.pickupLatency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *LongPolling) Collect(ch chan<- prometheus.Metric) {
+	p.requests.Collect(ch)
+	p.pickupLatency.Collect(ch)
+}
@@ -0,0 +1,352 @@
+//go:build !integration
+// +build !integration
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func newLongPollingRunner(url string, timeout int) common.RunnerConfig {
+	return common.RunnerConfig{
+		LongPollTimeout: timeout,
+		RunnerCredentials: common.RunnerCredentials{
+			URL:   url,
+			Token: validToken,
+		},
+	}
+}
+
+// workhorseStub answers the job requests like GitLab behind GitLab
+// Workhorse: with long polling, the requests sending the current last update
+// of the job queue are held until the queue changes or the long polling
+// duration is reached
+type workhorseStub struct {
+	longPolling time.Duration
+
+	lock       sync.Mutex
+	lastUpdate int
+	jobs       int
+	changed    chan struct{}
+}
+
+func newWorkhorseStub(longPolling time.Duration) *workhorseStub {
+	return &workhorseStub{
+		longPolling: longPolling,
+		lastUpdate:  1,
+		changed:     make(chan struct{}),
+	}
+}
+
+func (s *workhorseStub) queueJob() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.jobs++
+	s.lastUpdate++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *workhorseStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request common.JobRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
+	s.lock.Lock()
+	lastUpdate := strconv.Itoa(s.lastUpdate)
+	changed := s.changed
+	s.lock.Unlock()
+
+	if s.longPolling > 0 && request.LastUpdate == lastUpdate {
+		select {
+		case <-changed:
+			w.WriteHeader(http.StatusConflict)
+		case <-time.After(s.longPolling):
+			w.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.jobs > 0 {
+		s.jobs--
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":10,"token":"job-token"}`))
+		return
+	}
+
+	w.Header().Set(lastUpdateHeader, strconv.Itoa(s.lastUpdate))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLongPollingBegin(t *testing.T) {
+	tests := map[string]struct {
+		timeout             int
+		expectedLongPolling bool
+		expectedTimeout     time.Duration
+	}{
+		"long polling disabled": {
+			timeout:             0,
+			expectedLongPolling: false,
+		},
+		"long polling enabled": {
+			timeout:             30,
+			expectedLongPolling: true,
+			expectedTimeout:     30 * time.Second,
+		},
+		"timeout above the maximum": {
+			timeout:             3600,
+			expectedLongPolling: true,
+			expectedTimeout:     common.MaxLongPollTimeout,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			p := NewLongPolling()
+			config := newLongPollingRunner("https://gitlab.example.com", tt.timeout)
+
+			request := common.JobRequest{LastUpdate: "1"}
+			start := time.Now()
+			ctx, cancel, polling := p.begin(context.Background(), config, &request)
+			defer cancel()
+
+			assert.Equal(t, tt.expectedLongPolling, polling.longPolling)
+			assert.Equal(t, "1", polling.lastUpdate)
+
+			deadline, hasDeadline := ctx.Deadline()
+			assert.Equal(t, tt.expectedLongPolling, hasDeadline)
+			if hasDeadline {
+				assert.WithinDuration(t, start.Add(tt.expectedTimeout), deadline, time.Second)
+			}
+		})
+	}
+}
+
+func TestLongPollingNegotiation(t *testing.T) {
+	now := time.Now()
+	p := NewLongPolling()
+	p.now = func() time.Time { return now }
+
+	config := newLongPollingRunner("https://gitlab.example.com", 30)
+
+	request := func(ctx context.Context, lastUpdate string, result int, answeredLastUpdate string) {
+		ctx, cancel, polling := p.begin(ctx, config, &common.JobRequest{LastUpdate: lastUpdate})
+		defer cancel()
+
+		res := &http.Response{StatusCode: result, Header: make(http.Header)}
+		if answeredLastUpdate != "" {
+			res.Header.Set(lastUpdateHeader, answeredLastUpdate)
+		}
+		if result < 0 {
+			res = nil
+		}
+
+		p.done(ctx, config, polling, result, res)
+	}
+
+	background := context.Background()
+	expired, cancel := context.WithDeadline(background, time.Now().Add(-time.Second))
+	defer cancel()
+
+	require.True(t, p.Enabled(config), "long polling is tried first")
+
+	request(background, "", http.StatusNoContent, "1")
+	assert.True(t, p.Enabled(config))
+	assert.False(t, p.Held(config), "a request without the last update isn't held")
+
+	request(background, "1", http.StatusNoContent, "")
+	assert.True(t, p.Enabled(config))
+	assert.True(t, p.Held(config), "Workhorse answers without the last update after its long polling duration")
+
+	request(background, "1", http.StatusServiceUnavailable, "")
+	assert.True(t, p.Enabled(config), "failures don't disable long polling")
+	assert.False(t, p.Held(config))
+
+	request(background, "1", http.StatusConflict, "")
+	assert.True(t, p.Enabled(config))
+	assert.True(t, p.Held(config), "Workhorse answers 409 when the queue changed while it held the request")
+
+	request(background, "1", http.StatusNoContent, "2")
+	assert.True(t, p.Enabled(config), "an answer for a changed queue doesn't disable long polling")
+	assert.False(t, p.Held(config))
+
+	request(background, "2", http.StatusNoContent, "2")
+	assert.False(t, p.Enabled(config), "long polling is disabled when GitLab doesn't hold an unchanged queue")
+	assert.False(t, p.Held(config))
+
+	now = now.Add(longPollingRenegotiationInterval)
+	require.True(t, p.Enabled(config), "long polling is renegotiated")
+
+	request(expired, "2", -1, "")
+	assert.True(t, p.Enabled(config))
+	assert.True(t, p.Held(config), "a request held until the long polling timeout was held")
+
+	request(background, "2", http.StatusCreated, "")
+	assert.True(t, p.Enabled(config))
+	assert.False(t, p.Held(config))
+
+	//nolint:lll
+	metrics := `
+		# HELP gitlab_runner_job_requests_total Total number of job requests, partitioned by runner, polling mode and result.
+		# TYPE gitlab_runner_job_requests_total counter
+		gitlab_runner_job_requests_total{mode="long_polling",result="canceled",runner="` + config.ShortDescription() + `"} 1
+		gitlab_runner_job_requests_total{mode="long_polling",result="failure",runner="` + config.ShortDescription() + `"} 1
+		gitlab_runner_job_requests_total{mode="long_polling",result="job",runner="` + config.ShortDescription() + `"} 1
+		gitlab_runner_job_requests_total{mode="long_polling",result="no_job",runner="` + config.ShortDescription() + `"} 5
+	`
+	err := testutil.CollectAndCompare(p, strings.NewReader(metrics), "gitlab_runner_job_requests_total")
+	assert.NoError(t, err)
+}
+
+func TestLongPollingPickupLatency(t *testing.T) {
+	now := time.Now()
+	p := NewLongPolling()
+	p.now = func() time.Time { return now }
+
+	config := newLongPollingRunner("https://gitlab.example.com", 0)
+
+	_, _, polling := p.begin(context.Background(), config, new(common.JobRequest))
+	p.done(context.Background(), config, polling, http.StatusNoContent, nil)
+
+	now = now.Add(3 * time.Second)
+
+	_, _, polling = p.begin(context.Background(), config, new(common.JobRequest))
+	now = now.Add(time.Second)
+	p.done(context.Background(), config, polling, http.StatusCreated, nil)
+
+	//nolint:lll
+	metrics := `
+		# HELP gitlab_runner_job_pickup_latency_seconds Time the runner wasn't waiting for jobs before receiving a job: from the end of its previous job request to the start of the request that received the job.
+		# TYPE gitlab_runner_job_pickup_latency_seconds histogram
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="0.1"} 0
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="0.5"} 0
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="1"} 0
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="2"} 0
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="3"} 1
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="5"} 1
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="10"} 1
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="30"} 1
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="60"} 1
+		gitlab_runner_job_pickup_latency_seconds_bucket{mode="short_polling",runner="` + config.ShortDescription() + `",le="+Inf"} 1
+		gitlab_runner_job_pickup_latency_seconds_sum{mode="short_polling",runner="` + config.ShortDescription() + `"} 3
+		gitlab_runner_job_pickup_latency_seconds_count{mode="short_polling",runner="` + config.ShortDescription() + `"} 1
+	`
+	err := testutil.CollectAndCompare(p, strings.NewReader(metrics), "gitlab_runner_job_pickup_latency_seconds")
+	assert.NoError(t, err)
+}
+
+func TestRequestJobWithLongPolling(t *testing.T) {
+	tests := map[string]struct {
+		longPolling     time.Duration
+		expectedEnabled bool
+		expectedHeld    bool
+	}{
+		"held by Workhorse": {
+			longPolling:     50 * time.Millisecond,
+			expectedEnabled: true,
+			expectedHeld:    true,
+		},
+		"not held by Workhorse": {
+			longPolling:     0,
+			expectedEnabled: false,
+			expectedHeld:    false,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			s := httptest.NewServer(newWorkhorseStub(tt.longPolling))
+			defer s.Close()
+
+			config := newLongPollingRunner(s.URL, 30)
+			c := NewGitLabClient()
+
+			// the first request gets the last update of the queue
+			for i := 0; i < 2; i++ {
+				res, ok := c.RequestJob(context.Background(), config, nil)
+				assert.Nil(t, res)
+				assert.True(t, ok)
+			}
+
+			assert.Equal(t, tt.expectedEnabled, c.LongPolling().Enabled(config))
+			assert.Equal(t, tt.expectedHeld, c.LongPolling().Held(config))
+		})
+	}
+}
+
+func TestRequestJobWithLongPollingQueueChanged(t *testing.T) {
+	workhorse := newWorkhorseStub(time.Minute)
+	s := httptest.NewServer(workhorse)
+	defer s.Close()
+
+	config := newLongPollingRunner(s.URL, 30)
+	c := NewGitLabClient()
+
+	res, ok := c.RequestJob(context.Background(), config, nil)
+	require.Nil(t, res)
+	require.True(t, ok)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		workhorse.queueJob()
+	}()
+
+	res, ok = c.RequestJob(context.Background(), config, nil)
+	assert.Nil(t, res, "Workhorse doesn't send the job of the held request")
+	assert.True(t, ok)
+	assert.True(t, c.LongPolling().Held(config))
+
+	res, ok = c.RequestJob(context.Background(), config, nil)
+	require.NotNil(t, res)
+	assert.Equal(t, int64(10), res.ID)
+	assert.True(t, ok)
+}
+
+func TestRequestJobCanceled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	config := newLongPollingRunner(s.URL, 30)
+	c := NewGitLabClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res, ok := c.RequestJob(ctx, config, nil)
+	assert.Nil(t, res)
+	assert.True(t, ok, "a canceled request doesn't make the runner unhealthy")
+	assert.False(t, c.LongPolling().Held(config))
+	assert.Equal(
+		t,
+		1.0,
+		testutil.ToFloat64(c.LongPolling().requests.WithLabelValues(
+			config.ShortDescription(),
+			jobRequestModeLongPolling,
+			jobRequestResultCanceled,
+		)),
+	)
+}
@@ -8,6 +8,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/common"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace/sinks"
 )
 
 type clientJobTrace struct {
@@ -19,6 +20,8 @@ type clientJobTrace struct {
 	abortFunc      context.CancelFunc
 
 	buffer *trace.Buffer
+	spool  *spooledJob
+	sinks  *sinks.Tee
 
 	lock          sync.RWMutex
 	state         common.JobState
@@ -54,7 +57,10 @@ func (c *clientJobTrace) complete(err error, failureData common.JobFailureData)
 		c.setFailure(failureData)
 	}
 
+	state, failureReason, exitCode := c.state, c.failureReason, c.exitCode
 	c.lock.Unlock()
+
+	c.spool.complete(state, failureReason, exitCode)
 	c.finish()
 }
 
@@ -207,6 +213,8 @@ func (c *clientJobTrace) finish() {
 	c.finished <- true
 	c.finalUpdate()
 	c.buffer.Close()
+	c.spool.remove()
+	c.sinks.Close()
 }
 
 // incrementalUpdate returns a flag if jobs is supposed
@@ -270,6 +278,8 @@ func (c *clientJobTrace) sendPatch() common.PatchTraceResult {
 		c.sentTime = time.Now()
 		c.sentTrace = result.SentOffset
 		c.lock.Unlock()
+
+		c.spool.sent(result.SentOffset)
 	}
 
 	return result
@@ -364,6 +374,8 @@ func (c *clientJobTrace) sendUpdate() common.UpdateState {
 		c.sentTime = time.Now()
 		c.sentTrace = 0
 		c.lock.Unlock()
+
+		c.spool.sent(0)
 	}
 
 	return result.State
@@ -405,12 +417,22 @@ func newJobTrace(
 	client common.Network,
 	config common.RunnerConfig,
 	jobCredentials *common.JobCredentials,
+	opts ...trace.Option,
 ) (*clientJobTrace, error) {
-	buffer, err := trace.New()
+	buffer, err := trace.New(opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	return newClientJobTrace(client, config, jobCredentials, buffer), nil
+}
+
+func newClientJobTrace(
+	client common.Network,
+	config common.RunnerConfig,
+	jobCredentials *common.JobCredentials,
+	buffer *trace.Buffer,
+) *clientJobTrace {
 	return &clientJobTrace{
 		client:            client,
 		config:            config,
@@ -420,7 +442,7 @@ func newJobTrace(
 		maxTracePatchSize: common.DefaultTracePatchLimit,
 		updateInterval:    common.DefaultUpdateInterval,
 		forceSendInterval: common.MinTraceForceSendInterval,
-	}, nil
+	}
 }
 
 func (c *clientJobTrace) IsJobSuccessful() bool {
@@ -0,0 +1,515 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+)
+
+const (
+	spoolTraceFile = "trace.log"
+	spoolStateFile = "state.json"
+
+	spoolJobActive = "active"
+	spoolJobReplay = "replay"
+
+	spoolReplaySucceeded = "succeeded"
+	spoolReplayDropped   = "dropped"
+	spoolReplayFailed    = "failed"
+
+	defaultSpoolReplayMinBackoff = time.Second
+	defaultSpoolReplayMaxBackoff = 5 * time.Minute
+)
+
+var (
+	spoolJobsDesc = prometheus.NewDesc(
+		"gitlab_runner_trace_spool_jobs",
+		"Number of jobs whose trace and final state are kept in the spool until GitLab receives them",
+		[]string{"state"},
+		nil,
+	)
+	spoolUnsentBytesDesc = prometheus.NewDesc(
+		"gitlab_runner_trace_spool_unsent_bytes",
+		"Number of bytes of the spooled job traces that GitLab didn't receive yet",
+		nil,
+		nil,
+	)
+)
+
+// spooledJobState is everything needed to send the rest of the trace and the
+// final update of a job after the runner restarts
+type spooledJobState struct {
+	Runner        string                  `json:"runner"`
+	RunnerURL     string                  `json:"runner_url"`
+	Credentials   common.JobCredentials   `json:"credentials"`
+	SentOffset    int                     `json:"sent_offset"`
+	TraceResent   bool                    `json:"trace_resent,omitempty"`
+	Finished      bool                    `json:"finished"`
+	State         common.JobState         `json:"state"`
+	FailureReason common.JobFailureReason `json:"failure_reason,omitempty"`
+	ExitCode      int                     `json:"exit_code,omitempty"`
+}
+
+type spooledJob struct {
+	spool  *TraceSpool
+	dir    string
+	replay bool
+
+	lock  sync.Mutex
+	state spooledJobState
+}
+
+// TraceSpool keeps the traces of the jobs, and the updates GitLab didn't
+// receive yet, in a directory. When the runner stops before GitLab receives
+// the final update of a job, the update is replayed on the next start, so the
+// job doesn't stay running forever.
+type TraceSpool struct {
+	dir string
+
+	minReplayBackoff time.Duration
+	maxReplayBackoff time.Duration
+
+	lock sync.Mutex
+	jobs map[string]*spooledJob
+
+	replays *prometheus.CounterVec
+}
+
+// NewTraceSpool creates the spool in dir, and loads the jobs left by the
+// previous runs to replay them
+func NewTraceSpool(dir string) (*TraceSpool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace spool directory: %w", err)
+	}
+
+	s := &TraceSpool{
+		dir:              dir,
+		minReplayBackoff: defaultSpoolReplayMinBackoff,
+		maxReplayBackoff: defaultSpoolReplayMaxBackoff,
+		jobs:             make(map[string]*spooledJob),
+		replays: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_trace_spool_replays_total",
+				Help: "Total number of attempts to replay the spooled job updates, by result",
+			},
+			[]string{"result"},
+		),
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *TraceSpool) load() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("reading trace spool directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		job := &spooledJob{spool: s, dir: filepath.Join(s.dir, entry.Name()), replay: true}
+
+		data, err := ioutil.ReadFile(filepath.Join(job.dir, spoolStateFile))
+		if err == nil {
+			err = json.Unmarshal(data, &job.state)
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("path", job.dir).Warningln("Skipping invalid trace spool entry")
+			continue
+		}
+
+		s.jobs[entry.Name()] = job
+	}
+
+	return nil
+}
+
+// newJobTrace creates the trace of a job that's kept in the spool until GitLab
+// receives its final update
+func (s *TraceSpool) newJobTrace(
+	client common.Network,
+	config common.RunnerConfig,
+	jobCredentials *common.JobCredentials,
+	opts ...trace.Option,
+) (*clientJobTrace, error) {
+	name := fmt.Sprintf("runner-%s-job-%d", config.ShortDescription(), jobCredentials.ID)
+	job := &spooledJob{
+		spool: s,
+		dir:   filepath.Join(s.dir, name),
+		state: spooledJobState{
+			Runner:      config.ShortDescription(),
+			RunnerURL:   config.URL,
+			Credentials: *jobCredentials,
+			State:       common.Running,
+		},
+	}
+
+	err := os.MkdirAll(job.dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace spool entry: %w", err)
+	}
+
+	err = job.save()
+	if err != nil {
+		_ = os.RemoveAll(job.dir)
+		return nil, err
+	}
+
+	buffer, err := trace.New(append(opts, trace.WithFile(job.tracePath()))...)
+	if err != nil {
+		_ = os.RemoveAll(job.dir)
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.jobs[name] = job
+	s.lock.Unlock()
+
+	jobTrace := newClientJobTrace(client, config, jobCredentials, buffer)
+	jobTrace.spool = job
+
+	return jobTrace, nil
+}
+
+// Replay sends the updates of the jobs left by the previous runs, retrying
+// with a backoff until GitLab receives them or ctx is done
+func (s *TraceSpool) Replay(ctx context.Context, client common.Network, runners []*common.RunnerConfig) {
+	var wg sync.WaitGroup
+
+	for _, job := range s.replayJobs() {
+		logger := job.log()
+
+		runner := job.findRunner(runners)
+		if runner == nil {
+			logger.Warningln("Runner of the spooled job is not configured, keeping the job in the spool")
+			continue
+		}
+
+		wg.Add(1)
+		go func(job *spooledJob, runner common.RunnerConfig) {
+			defer wg.Done()
+			s.replayJob(ctx, client, runner, job)
+		}(job, *runner)
+	}
+
+	wg.Wait()
+}
+
+func (s *TraceSpool) replayJobs() []*spooledJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var jobs []*spooledJob
+	for _, job := range s.jobs {
+		if job.replay {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
+
+func (s *TraceSpool) replayJob(ctx context.Context, client common.Network, runner common.RunnerConfig, job *spooledJob) {
+	logger := job.log()
+	logger.Infoln("Replaying the spooled job updates")
+
+	job.interrupt()
+
+	b := &backoff.Backoff{Min: s.minReplayBackoff, Max: s.maxReplayBackoff, Factor: 2, Jitter: true}
+
+	for {
+		result, err := job.send(client, runner)
+		if err == nil {
+			s.replays.WithLabelValues(result).Inc()
+			logger.WithField("result", result).Infoln("Spooled job updates replayed")
+			job.remove()
+			return
+		}
+
+		s.replays.WithLabelValues(spoolReplayFailed).Inc()
+
+		delay := b.Duration()
+		logger.WithError(err).Warningln("Failed to replay the spooled job updates, retrying in", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (s *TraceSpool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolJobsDesc
+	ch <- spoolUnsentBytesDesc
+	s.replays.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *TraceSpool) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	jobs := map[string]int{spoolJobActive: 0, spoolJobReplay: 0}
+	unsent := 0
+	for _, job := range s.jobs {
+		if job.replay {
+			jobs[spoolJobReplay]++
+		} else {
+			jobs[spoolJobActive]++
+		}
+
+		unsent += job.unsentBytes()
+	}
+	s.lock.Unlock()
+
+	for state, count := range jobs {
+		ch <- prometheus.MustNewConstMetric(spoolJobsDesc, prometheus.GaugeValue, float64(count), state)
+	}
+	ch <- prometheus.MustNewConstMetric(spoolUnsentBytesDesc, prometheus.GaugeValue, float64(unsent))
+
+	s.replays.Collect(ch)
+}
+
+func (j *spooledJob) tracePath() string {
+	return filepath.Join(j.dir, spoolTraceFile)
+}
+
+func (j *spooledJob) log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"job":    j.state.Credentials.ID,
+		"runner": j.state.Runner,
+	})
+}
+
+func (j *spooledJob) findRunner(runners []*common.RunnerConfig) *common.RunnerConfig {
+	for _, runner := range runners {
+		if runner.ShortDescription() == j.state.Runner && runner.URL == j.state.RunnerURL {
+			return runner
+		}
+	}
+
+	return nil
+}
+
+// save writes the state of the job atomically, so a crash leaves either the
+// previous or the new state behind
+func (j *spooledJob) save() error {
+	data, err := json.Marshal(j.state)
+	if err != nil {
+		return fmt.Errorf("encoding trace spool state: %w", err)
+	}
+
+	tmpPath := filepath.Join(j.dir, spoolStateFile+".tmp")
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("writing trace spool state: %w", err)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing trace spool state: %w", err)
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(j.dir, spoolStateFile))
+	if err != nil {
+		return fmt.Errorf("writing trace spool state: %w", err)
+	}
+
+	return nil
+}
+
+func (j *spooledJob) update(fn func(state *spooledJobState)) {
+	if j == nil {
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	fn(&j.state)
+
+	err := j.save()
+	if err != nil {
+		j.log().WithError(err).Warningln("Failed to update the trace spool")
+	}
+}
+
+func (j *spooledJob) sent(offset int) {
+	j.update(func(state *spooledJobState) {
+		state.SentOffset = offset
+	})
+}
+
+func (j *spooledJob) complete(jobState common.JobState, failureReason common.JobFailureReason, exitCode int) {
+	if j == nil {
+		return
+	}
+
+	// the final update is only replayed with the trace it describes
+	if file, err := os.OpenFile(j.tracePath(), os.O_WRONLY, 0); err == nil {
+		_ = file.Sync()
+		_ = file.Close()
+	}
+
+	j.update(func(state *spooledJobState) {
+		state.Finished = true
+		state.State = jobState
+		state.FailureReason = failureReason
+		state.ExitCode = exitCode
+	})
+}
+
+// interrupt fails the job that was still running when the runner stopped
+func (j *spooledJob) interrupt() {
+	j.lock.Lock()
+	finished := j.state.Finished
+	j.lock.Unlock()
+
+	if finished {
+		return
+	}
+
+	file, err := os.OpenFile(j.tracePath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		_, _ = fmt.Fprintf(
+			file,
+			"\n%sERROR: Job failed (system failure): the runner stopped while the job was running%s\n",
+			helpers.ANSI_BOLD_RED,
+			helpers.ANSI_RESET,
+		)
+		_ = file.Close()
+	}
+
+	j.complete(common.Failed, common.RunnerSystemFailure, 0)
+}
+
+func (j *spooledJob) remove() {
+	if j == nil {
+		return
+	}
+
+	j.spool.lock.Lock()
+	delete(j.spool.jobs, filepath.Base(j.dir))
+	j.spool.lock.Unlock()
+
+	err := os.RemoveAll(j.dir)
+	if err != nil {
+		j.log().WithError(err).Warningln("Failed to remove the trace spool entry")
+	}
+}
+
+func (j *spooledJob) unsentBytes() int {
+	info, err := os.Stat(j.tracePath())
+	if err != nil {
+		return 0
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if unsent := int(info.Size()) - j.state.SentOffset; unsent > 0 {
+		return unsent
+	}
+
+	return 0
+}
+
+// send sends the rest of the trace and the final update of the job, it returns
+// the result of the replay once GitLab doesn't need them anymore
+func (j *spooledJob) send(client common.Network, runner common.RunnerConfig) (string, error) {
+	content, err := ioutil.ReadFile(j.tracePath())
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading spooled trace: %w", err)
+	}
+
+	j.lock.Lock()
+	state := j.state
+	j.lock.Unlock()
+
+	for offset := state.SentOffset; offset < len(content); {
+		end := offset + common.DefaultTracePatchLimit
+		if end > len(content) {
+			end = len(content)
+		}
+
+		result := client.PatchTrace(runner, &state.Credentials, content[offset:end], offset)
+		switch result.State {
+		case common.PatchSucceeded, common.PatchRangeMismatch:
+			j.sent(result.SentOffset)
+			// GitLab asking again for the range just sent, or for an earlier
+			// one, is retried with the backoff of the replay
+			if result.SentOffset <= offset {
+				return "", fmt.Errorf("sending the spooled trace made no progress, GitLab has %d bytes", result.SentOffset)
+			}
+			offset = result.SentOffset
+		case common.PatchNotFound, common.PatchAbort:
+			return spoolReplayDropped, nil
+		default:
+			return "", errors.New("sending the spooled trace failed")
+		}
+	}
+
+	result := client.UpdateJob(runner, &state.Credentials, common.UpdateJobInfo{
+		ID:            state.Credentials.ID,
+		State:         state.State,
+		FailureReason: state.FailureReason,
+		ExitCode:      state.ExitCode,
+		Output: common.JobTraceOutput{
+			Checksum: fmt.Sprintf("crc32:%08x", crc32.ChecksumIEEE(content)),
+			Bytesize: len(content),
+		},
+	})
+
+	switch result.State {
+	case common.UpdateSucceeded:
+		return spoolReplaySucceeded, nil
+	case common.UpdateNotFound, common.UpdateAbort:
+		return spoolReplayDropped, nil
+	case common.UpdateTraceValidationFailed:
+		// the whole trace is sent again once, GitLab rejecting it again
+		// won't change with more attempts
+		if state.TraceResent {
+			j.log().Warningln("GitLab rejected the spooled trace sent again, dropping the job updates")
+			return spoolReplayDropped, nil
+		}
+
+		j.update(func(state *spooledJobState) {
+			state.SentOffset = 0
+			state.TraceResent = true
+		})
+		return "", errors.New("trace validation failed, sending the whole trace again")
+	default:
+		return "", errors.New("sending the final job update failed")
+	}
+}
@@ -0,0 +1,319 @@
+//go:build !integration
+// +build !integration
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+var spoolRunner = &common.RunnerConfig{
+	RunnerCredentials: common.RunnerCredentials{URL: "https://gitlab.example.com", Token: "spool-runner-token"},
+}
+
+func writeSpoolEntry(t *testing.T, dir string, state spooledJobState, trace string) string {
+	entry := filepath.Join(dir, fmt.Sprintf("runner-%s-job-%d", state.Runner, state.Credentials.ID))
+	require.NoError(t, os.MkdirAll(entry, 0700))
+
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(entry, spoolStateFile), data, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(entry, spoolTraceFile), []byte(trace), 0600))
+
+	return entry
+}
+
+func newSpoolState(finished bool) spooledJobState {
+	state := spooledJobState{
+		Runner:      spoolRunner.ShortDescription(),
+		RunnerURL:   spoolRunner.URL,
+		Credentials: common.JobCredentials{ID: 10, Token: "job-token", URL: spoolRunner.URL},
+		SentOffset:  6,
+		State:       common.Running,
+	}
+
+	if finished {
+		state.Finished = true
+		state.State = common.Failed
+		state.FailureReason = common.ScriptFailure
+		state.ExitCode = 2
+	}
+
+	return state
+}
+
+func TestTraceSpoolJobTrace(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewTraceSpool(dir)
+	require.NoError(t, err)
+
+	mockNetwork := new(common.MockNetwork)
+	defer mockNetwork.AssertExpectations(t)
+
+	credentials := &common.JobCredentials{ID: 20, Token: "job-token"}
+	entry := filepath.Join(dir, fmt.Sprintf("runner-%s-job-20", spoolRunner.ShortDescription()))
+
+	jobTrace, err := spool.newJobTrace(mockNetwork, *spoolRunner, credentials)
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(jobTrace, "job output")
+	require.NoError(t, err)
+
+	var state spooledJobState
+	data, err := ioutil.ReadFile(filepath.Join(entry, spoolStateFile))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &state))
+	assert.Equal(t, common.Running, state.State)
+	assert.Equal(t, *credentials, state.Credentials)
+
+	assertSpoolJobs(t, spool, 1, 0)
+
+	mockNetwork.On("PatchTrace", *spoolRunner, credentials, []byte("job output"), 0).
+		Return(common.NewPatchTraceResult(10, common.PatchSucceeded, 0)).Once()
+	mockNetwork.On("UpdateJob", *spoolRunner, credentials, mock.Anything).
+		Run(func(args mock.Arguments) {
+			// the final state is in the spool until GitLab receives it
+			data, err := ioutil.ReadFile(filepath.Join(entry, spoolStateFile))
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &state))
+		}).
+		Return(common.UpdateJobResult{State: common.UpdateSucceeded}).Once()
+
+	jobTrace.start()
+	jobTrace.Success()
+
+	assert.True(t, state.Finished)
+	assert.Equal(t, common.Success, state.State)
+	assert.Equal(t, 10, state.SentOffset)
+	assert.NoDirExists(t, entry)
+	assertSpoolJobs(t, spool, 0, 0)
+}
+
+func TestTraceSpoolReplay(t *testing.T) {
+	tests := map[string]struct {
+		finished       bool
+		updateResults  []common.UpdateState
+		expectedTrace  string
+		expectedState  common.JobState
+		expectedReason common.JobFailureReason
+		expectedResult string
+	}{
+		"finished job": {
+			finished:       true,
+			updateResults:  []common.UpdateState{common.UpdateSucceeded},
+			expectedTrace:  "output",
+			expectedState:  common.Failed,
+			expectedReason: common.ScriptFailure,
+			expectedResult: spoolReplaySucceeded,
+		},
+		"interrupted job": {
+			updateResults:  []common.UpdateState{common.UpdateSucceeded},
+			expectedTrace:  "output\n\x1b[31;1mERROR: Job failed (system failure): the runner stopped while the job was running\x1b[0;m\n",
+			expectedState:  common.Failed,
+			expectedReason: common.RunnerSystemFailure,
+			expectedResult: spoolReplaySucceeded,
+		},
+		"GitLab unavailable": {
+			finished:       true,
+			updateResults:  []common.UpdateState{common.UpdateFailed, common.UpdateFailed, common.UpdateSucceeded},
+			expectedTrace:  "output",
+			expectedState:  common.Failed,
+			expectedReason: common.ScriptFailure,
+			expectedResult: spoolReplaySucceeded,
+		},
+		"job not found": {
+			finished:       true,
+			updateResults:  []common.UpdateState{common.UpdateNotFound},
+			expectedTrace:  "output",
+			expectedState:  common.Failed,
+			expectedReason: common.ScriptFailure,
+			expectedResult: spoolReplayDropped,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			dir := t.TempDir()
+			state := newSpoolState(tt.finished)
+			entry := writeSpoolEntry(t, dir, state, "spool output")
+
+			spool, err := NewTraceSpool(dir)
+			require.NoError(t, err)
+			spool.minReplayBackoff = time.Millisecond
+			spool.maxReplayBackoff = time.Millisecond
+
+			assertSpoolJobs(t, spool, 0, 1)
+
+			mockNetwork := new(common.MockNetwork)
+			defer mockNetwork.AssertExpectations(t)
+
+			trace := "spool " + tt.expectedTrace
+			mockNetwork.On("PatchTrace", *spoolRunner, &state.Credentials, []byte(tt.expectedTrace), 6).
+				Return(common.NewPatchTraceResult(len(trace), common.PatchSucceeded, 0)).Once()
+
+			for _, result := range tt.updateResults {
+				mockNetwork.On("UpdateJob", *spoolRunner, &state.Credentials, common.UpdateJobInfo{
+					ID:            state.Credentials.ID,
+					State:         tt.expectedState,
+					FailureReason: tt.expectedReason,
+					ExitCode:      state.ExitCode,
+					Output: common.JobTraceOutput{
+						Checksum: fmt.Sprintf("crc32:%08x", crc32.ChecksumIEEE([]byte(trace))),
+						Bytesize: len(trace),
+					},
+				}).Return(common.UpdateJobResult{State: result}).Once()
+			}
+
+			spool.Replay(context.Background(), mockNetwork, []*common.RunnerConfig{spoolRunner})
+
+			assert.NoDirExists(t, entry)
+			assertSpoolJobs(t, spool, 0, 0)
+			assert.Equal(t, 1.0, testutil.ToFloat64(spool.replays.WithLabelValues(tt.expectedResult)))
+			assert.Equal(
+				t,
+				float64(len(tt.updateResults)-1),
+				testutil.ToFloat64(spool.replays.WithLabelValues(spoolReplayFailed)),
+			)
+		})
+	}
+}
+
+func TestTraceSpoolReplayTraceWithoutProgress(t *testing.T) {
+	dir := t.TempDir()
+	state := newSpoolState(true)
+	entry := writeSpoolEntry(t, dir, state, "spool output")
+
+	spool, err := NewTraceSpool(dir)
+	require.NoError(t, err)
+	spool.minReplayBackoff = time.Millisecond
+	spool.maxReplayBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// GitLab keeps asking for the range that was just sent
+	calls := 0
+	mockNetwork := new(common.MockNetwork)
+	defer mockNetwork.AssertExpectations(t)
+	mockNetwork.On("PatchTrace", *spoolRunner, &state.Credentials, []byte("output"), 6).
+		Run(func(mock.Arguments) {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+		}).
+		Return(common.NewPatchTraceResult(6, common.PatchRangeMismatch, 0)).Times(3)
+
+	spool.Replay(ctx, mockNetwork, []*common.RunnerConfig{spoolRunner})
+
+	// every attempt is retried with the backoff
+	assert.Equal(t, 3.0, testutil.ToFloat64(spool.replays.WithLabelValues(spoolReplayFailed)))
+	assert.DirExists(t, entry)
+	assertSpoolJobs(t, spool, 0, 1)
+}
+
+func TestTraceSpoolReplayTraceValidationFailed(t *testing.T) {
+	dir := t.TempDir()
+	state := newSpoolState(true)
+	entry := writeSpoolEntry(t, dir, state, "spool output")
+
+	spool, err := NewTraceSpool(dir)
+	require.NoError(t, err)
+	spool.minReplayBackoff = time.Millisecond
+	spool.maxReplayBackoff = time.Millisecond
+
+	mockNetwork := new(common.MockNetwork)
+	defer mockNetwork.AssertExpectations(t)
+	mockNetwork.On("PatchTrace", *spoolRunner, &state.Credentials, []byte("output"), 6).
+		Return(common.NewPatchTraceResult(12, common.PatchSucceeded, 0)).Once()
+	// the whole trace is sent again once
+	mockNetwork.On("PatchTrace", *spoolRunner, &state.Credentials, []byte("spool output"), 0).
+		Return(common.NewPatchTraceResult(12, common.PatchSucceeded, 0)).Once()
+	mockNetwork.On("UpdateJob", *spoolRunner, &state.Credentials, mock.Anything).
+		Return(common.UpdateJobResult{State: common.UpdateTraceValidationFailed}).Twice()
+
+	spool.Replay(context.Background(), mockNetwork, []*common.RunnerConfig{spoolRunner})
+
+	assert.NoDirExists(t, entry)
+	assertSpoolJobs(t, spool, 0, 0)
+	assert.Equal(t, 1.0, testutil.ToFloat64(spool.replays.WithLabelValues(spoolReplayDropped)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(spool.replays.WithLabelValues(spoolReplayFailed)))
+}
+
+func TestTraceSpoolReplayCanceled(t *testing.T) {
+	dir := t.TempDir()
+	state := newSpoolState(true)
+	state.SentOffset = len("spool output")
+	entry := writeSpoolEntry(t, dir, state, "spool output")
+
+	spool, err := NewTraceSpool(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mockNetwork := new(common.MockNetwork)
+	defer mockNetwork.AssertExpectations(t)
+	mockNetwork.On("UpdateJob", *spoolRunner, &state.Credentials, mock.Anything).
+		Run(func(mock.Arguments) { cancel() }).
+		Return(common.UpdateJobResult{State: common.UpdateFailed}).Once()
+
+	spool.Replay(ctx, mockNetwork, []*common.RunnerConfig{spoolRunner})
+
+	assert.DirExists(t, entry)
+	assertSpoolJobs(t, spool, 0, 1)
+}
+
+func TestTraceSpoolReplayUnknownRunner(t *testing.T) {
+	dir := t.TempDir()
+	entry := writeSpoolEntry(t, dir, newSpoolState(true), "spool output")
+
+	invalidEntry := filepath.Join(dir, "invalid")
+	require.NoError(t, os.MkdirAll(invalidEntry, 0700))
+
+	spool, err := NewTraceSpool(dir)
+	require.NoError(t, err)
+
+	mockNetwork := new(common.MockNetwork)
+	defer mockNetwork.AssertExpectations(t)
+
+	otherRunner := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{URL: spoolRunner.URL, Token: "other-runner-token"},
+	}
+	spool.Replay(context.Background(), mockNetwork, []*common.RunnerConfig{otherRunner})
+
+	assert.DirExists(t, entry)
+
+	metrics := `
+		# HELP gitlab_runner_trace_spool_unsent_bytes Number of bytes of the spooled job traces that GitLab didn't receive yet
+		# TYPE gitlab_runner_trace_spool_unsent_bytes gauge
+		gitlab_runner_trace_spool_unsent_bytes 6
+	`
+	assert.NoError(t, testutil.CollectAndCompare(spool, strings.NewReader(metrics), "gitlab_runner_trace_spool_unsent_bytes"))
+}
+
+func assertSpoolJobs(t *testing.T, spool *TraceSpool, active int, replay int) {
+	metrics := fmt.Sprintf(`
+		# HELP gitlab_runner_trace_spool_jobs Number of jobs whose trace and final state are kept in the spool until GitLab receives them
+		# TYPE gitlab_runner_trace_spool_jobs gauge
+		gitlab_runner_trace_spool_jobs{state="active"} %d
+		gitlab_runner_trace_spool_jobs{state="replay"} %d
+	`, active, replay)
+
+	assert.NoError(t, testutil.CollectAndCompare(spool, strings.NewReader(metrics), "gitlab_runner_trace_spool_jobs"))
+}
@@ -0,0 +1,247 @@
+package session
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	authFailureNetwork           = "network"
+	authFailureClientCertificate = "client_certificate"
+	authFailureToken             = "token"
+	authFailureViewerToken       = "viewer_token"
+	authFailureUnknownSession    = "unknown_session"
+)
+
+// Authenticator checks the requests the session server receives before they
+// reach a session. Sessions check the token of the request themselves.
+type Authenticator interface {
+	// Name is used in the logs and as the reason of the failures in the metrics
+	Name() string
+	Authenticate(r *http.Request) error
+}
+
+type networkAuthenticator struct {
+	networks []*net.IPNet
+}
+
+// NewNetworkAuthenticator only allows requests from clients in the given
+// CIDR ranges
+func NewNetworkAuthenticator(cidrs []string) (Authenticator, error) {
+	a := &networkAuthenticator{}
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing allowed network %q: %w", cidr, err)
+		}
+
+		a.networks = append(a.networks, network)
+	}
+
+	return a, nil
+}
+
+func (a *networkAuthenticator) Name() string {
+	return authFailureNetwork
+}
+
+func (a *networkAuthenticator) Authenticate(r *http.Request) error {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return fmt.Errorf("invalid client address %q", r.RemoteAddr)
+	}
+
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("client address %s is not in the allowed networks", ip)
+}
+
+type clientCertificateAuthenticator struct {
+	roots *x509.CertPool
+}
+
+// NewClientCertificateAuthenticator only allows requests from clients that
+// present a TLS certificate signed by the CA in caFile
+func NewClientCertificateAuthenticator(caFile string) (Authenticator, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %q", caFile)
+	}
+
+	return &clientCertificateAuthenticator{roots: roots}, nil
+}
+
+func (a *clientCertificateAuthenticator) Name() string {
+	return authFailureClientCertificate
+}
+
+func (a *clientCertificateAuthenticator) Authenticate(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("verifying client certificate: %w", err)
+	}
+
+	return nil
+}
+
+type failedAuthAttempts struct {
+	count int
+	since time.Time
+}
+
+// failedAuthLimiter blocks the clients that failed to authenticate too many
+// times, until the window that started with their first failure ends
+type failedAuthLimiter struct {
+	maxAttempts int
+	window      time.Duration
+
+	clients map[string]*failedAuthAttempts
+	now     func() time.Time
+
+	lock sync.Mutex
+}
+
+func newFailedAuthLimiter(maxAttempts int, window time.Duration) *failedAuthLimiter {
+	return &failedAuthLimiter{
+		maxAttempts: maxAttempts,
+		window:      window,
+		clients:     make(map[string]*failedAuthAttempts),
+		now:         time.Now,
+	}
+}
+
+func (l *failedAuthLimiter) blocked(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	attempts := l.attempts(ip)
+
+	return attempts != nil && attempts.count >= l.maxAttempts
+}
+
+func (l *failedAuthLimiter) failed(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for client := range l.clients {
+		l.attempts(client)
+	}
+
+	attempts := l.attempts(ip)
+	if attempts == nil {
+		attempts = &failedAuthAttempts{since: l.now()}
+		l.clients[ip] = attempts
+	}
+
+	attempts.count++
+}
+
+// attempts returns the failed attempts of the client in the current window,
+// the lock must be held
+func (l *failedAuthLimiter) attempts(ip string) *failedAuthAttempts {
+	attempts, ok := l.clients[ip]
+	if !ok {
+		return nil
+	}
+
+	if l.now().Sub(attempts.since) >= l.window {
+		delete(l.clients, ip)
+		return nil
+	}
+
+	return attempts
+}
+
+// AuthMetrics counts the requests the session server rejected
+type AuthMetrics struct {
+	failures    *prometheus.CounterVec
+	rateLimited prometheus.Counter
+}
+
+func NewAuthMetrics() *AuthMetrics {
+	return &AuthMetrics{
+		failures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_session_server_auth_failures_total",
+				Help: "Total number of session server requests that failed to authenticate",
+			},
+			[]string{"reason"},
+		),
+		rateLimited: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_session_server_rate_limited_requests_total",
+				Help: "Total number of session server requests rejected after too many failed authentication attempts",
+			},
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *AuthMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.failures.Describe(ch)
+	m.rateLimited.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *AuthMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.failures.Collect(ch)
+	m.rateLimited.Collect(ch)
+}
+
+type failedAuthReporterKey struct{}
+
+// withFailedAuthReporter lets the sessions report the requests with an invalid
+// token to the server that received them
+func withFailedAuthReporter(r *http.Request, report func(reason string)) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), failedAuthReporterKey{}, report))
+}
+
+func reportFailedAuth(r *http.Request, reason string) {
+	report, ok := r.Context().Value(failedAuthReporterKey{}).(func(reason string))
+	if ok {
+		report(reason)
+	}
+}
+
+// clientIP returns the address of the client that connected to the server.
+// Forwarding headers are ignored, they are set by the clients.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
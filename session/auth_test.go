@@ -0,0 +1,329 @@
+//go:build !integration
+// +build !integration
+
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/certificate"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "session server clients"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) writeFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(path, ca.pem, 0600))
+
+	return path
+}
+
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func TestNetworkAuthenticator(t *testing.T) {
+	authenticator, err := NewNetworkAuthenticator([]string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+	assert.Equal(t, "network", authenticator.Name())
+
+	tests := map[string]struct {
+		remoteAddr    string
+		expectedError bool
+	}{
+		"allowed IPv4":     {remoteAddr: "10.1.2.3:1234"},
+		"allowed IPv6":     {remoteAddr: "[fd00::1]:1234"},
+		"not allowed":      {remoteAddr: "192.168.1.1:1234", expectedError: true},
+		"invalid address":  {remoteAddr: "unknown", expectedError: true},
+		"address w/o port": {remoteAddr: "10.1.2.3"},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/session/id/exec", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			err := authenticator.Authenticate(req)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err = NewNetworkAuthenticator([]string{"10.0.0.1"})
+	assert.Error(t, err)
+}
+
+func TestClientCertificateAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+
+	authenticator, err := NewClientCertificateAuthenticator(ca.writeFile(t))
+	require.NoError(t, err)
+	assert.Equal(t, "client_certificate", authenticator.Name())
+
+	tests := map[string]struct {
+		state         *tls.ConnectionState
+		expectedError string
+	}{
+		"no TLS": {
+			expectedError: "no client certificate",
+		},
+		"no certificate": {
+			state:         &tls.ConnectionState{},
+			expectedError: "no client certificate",
+		},
+		"signed by the CA": {
+			state: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{ca.issue(t, x509.ExtKeyUsageClientAuth).Leaf},
+			},
+		},
+		"signed by another CA": {
+			state: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{otherCA.issue(t, x509.ExtKeyUsageClientAuth).Leaf},
+			},
+			expectedError: "verifying client certificate",
+		},
+		"not for client authentication": {
+			state: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{ca.issue(t, x509.ExtKeyUsageServerAuth).Leaf},
+			},
+			expectedError: "verifying client certificate",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/session/id/exec", nil)
+			req.TLS = tt.state
+
+			err := authenticator.Authenticate(req)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+
+	_, err = NewClientCertificateAuthenticator(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, ioutil.WriteFile(emptyFile, nil, 0600))
+	_, err = NewClientCertificateAuthenticator(emptyFile)
+	assert.Error(t, err)
+}
+
+func TestFailedAuthLimiter(t *testing.T) {
+	now := time.Now()
+
+	limiter := newFailedAuthLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	limiter.failed("10.0.0.1")
+	assert.False(t, limiter.blocked("10.0.0.1"))
+
+	now = now.Add(30 * time.Second)
+	limiter.failed("10.0.0.1")
+	assert.True(t, limiter.blocked("10.0.0.1"))
+	assert.False(t, limiter.blocked("10.0.0.2"))
+
+	// the window started with the first failure
+	now = now.Add(30 * time.Second)
+	assert.False(t, limiter.blocked("10.0.0.1"))
+
+	limiter.failed("10.0.0.2")
+	assert.Len(t, limiter.clients, 1, "expired clients should be removed")
+}
+
+func newAuthTestServer(t *testing.T, config ServerConfig, session *Session) *Server {
+	config.ListenAddress = "127.0.0.1:0"
+
+	server, err := NewServer(config, nil, certificate.X509Generator{}, func(url string) *Session {
+		if session != nil && url == session.Endpoint+"/exec" {
+			return session
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func serveAuthTestRequest(server *Server, remoteAddr string, path string, token string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Authorization", token)
+
+	w := httptest.NewRecorder()
+	server.handleSessionRequest(w, req)
+
+	return w.Code
+}
+
+func TestServerAuthentication(t *testing.T) {
+	sess, err := NewSession(nil)
+	require.NoError(t, err)
+	sess.Token = "validToken"
+
+	metrics := NewAuthMetrics()
+	server := newAuthTestServer(t, ServerConfig{
+		AllowedNetworks:       []string{"10.0.0.0/8"},
+		MaxFailedAuthAttempts: 2,
+		FailedAuthWindow:      time.Minute,
+		Metrics:               metrics,
+	}, sess)
+
+	exec := sess.Endpoint + "/exec"
+
+	// the session has no terminal, a valid request reaches it
+	assert.Equal(t, http.StatusServiceUnavailable, serveAuthTestRequest(server, "10.0.0.1:1234", exec, "validToken"))
+
+	assert.Equal(t, http.StatusForbidden, serveAuthTestRequest(server, "192.168.0.1:1234", exec, "validToken"))
+	assert.Equal(t, http.StatusUnauthorized, serveAuthTestRequest(server, "10.0.0.2:1234", exec, "invalidToken"))
+
+	// requests for unknown sessions, e.g. of finished jobs, don't count toward the limit
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusNotFound, serveAuthTestRequest(server, "10.0.0.2:1234", "/session/unknown/exec", ""))
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, serveAuthTestRequest(server, "10.0.0.2:1234", exec, "validToken"))
+
+	assert.Equal(t, http.StatusUnauthorized, serveAuthTestRequest(server, "10.0.0.2:1234", exec, "invalidToken"))
+
+	// too many failures, even valid requests from the client are rejected
+	assert.Equal(t, http.StatusTooManyRequests, serveAuthTestRequest(server, "10.0.0.2:1234", exec, "validToken"))
+	assert.Equal(t, http.StatusServiceUnavailable, serveAuthTestRequest(server, "10.0.0.1:1234", exec, "validToken"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.failures.WithLabelValues(authFailureNetwork)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.failures.WithLabelValues(authFailureUnknownSession)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.failures.WithLabelValues(authFailureToken)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.rateLimited))
+}
+
+func TestServerInvalidAuthenticationConfig(t *testing.T) {
+	_, err := NewServer(
+		ServerConfig{ListenAddress: "127.0.0.1:0", AllowedNetworks: []string{"invalid"}},
+		nil,
+		certificate.X509Generator{},
+		fakeSessionFinder,
+	)
+	assert.Error(t, err)
+}
+
+func TestServerClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+
+	server := newAuthTestServer(t, ServerConfig{ClientCAFile: ca.writeFile(t)}, nil)
+	go func() {
+		assert.NoError(t, server.Start())
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(server.CertificatePublicKey)
+
+	tests := map[string]struct {
+		certificates       []tls.Certificate
+		expectedStatusCode int
+	}{
+		"no client certificate": {
+			expectedStatusCode: http.StatusForbidden,
+		},
+		"valid client certificate": {
+			certificates:       []tls.Certificate{ca.issue(t, x509.ExtKeyUsageClientAuth)},
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						RootCAs:      roots,
+						Certificates: tt.certificates,
+					},
+				},
+			}
+
+			resp, err := client.Get("https://" + server.tlsListener.Addr().String() + "/session/unknown/exec")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+		})
+	}
+}
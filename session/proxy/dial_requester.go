@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrServiceNotReady is returned by a DialFunc when the service can't accept
+// connections yet, the request is then answered with 503 Service Unavailable
+var ErrServiceNotReady = errors.New("service is not ready yet")
+
+// DialFunc opens a connection to a port of the service
+type DialFunc func(ctx context.Context, settings *Settings, port Port) (net.Conn, error)
+
+// DialRequester proxies the requests to the services over the connections
+// opened by its DialFunc, so executors only need to know how to reach the
+// ports of their services. Responses are streamed as they are received and web
+// socket connections are upgraded end to end.
+type DialRequester struct {
+	dial DialFunc
+}
+
+func NewDialRequester(dial DialFunc) *DialRequester {
+	return &DialRequester{dial: dial}
+}
+
+func (d *DialRequester) ProxyRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+	requestedURI string,
+	port string,
+	settings *Settings,
+) {
+	logger := logrus.WithFields(logrus.Fields{
+		"uri":      r.RequestURI,
+		"method":   r.Method,
+		"port":     port,
+		"settings": settings,
+	})
+
+	portSettings, err := settings.PortByNameOrNumber(port)
+	if err != nil {
+		logger.WithError(err).Errorf("port proxy %q not found", port)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	scheme, err := portSettings.Scheme()
+	if err != nil {
+		logger.WithError(err).Errorf("port proxy %q can't be proxied", port)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.dial(ctx, settings, portSettings)
+		},
+		// Services are reached by their name, which their certificates
+		// are rarely issued for
+		// nolint:gosec
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer transport.CloseIdleConnections()
+
+	host := net.JoinHostPort(settings.ServiceName, strconv.Itoa(portSettings.Number))
+
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = host
+			req.URL.Path = "/" + requestedURI
+			req.URL.RawPath = ""
+			req.Host = host
+
+			// The token of the session isn't meant for the service
+			req.Header.Del("Authorization")
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, ErrServiceNotReady) {
+				logger.WithError(err).Error("services are not ready yet")
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			logger.WithError(err).Error("failed to proxy the request")
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+
+	reverseProxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,168 @@
+//go:build !integration
+// +build !integration
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDialRequesterServer(t *testing.T, backend http.Handler, dial DialFunc) *httptest.Server {
+	service := httptest.NewServer(backend)
+	t.Cleanup(service.Close)
+
+	if dial == nil {
+		dial = func(ctx context.Context, settings *Settings, port Port) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "tcp", service.Listener.Addr().String())
+		}
+	}
+
+	settings := NewProxySettings("service", []Port{
+		{Number: 80, Protocol: "http", Name: "web"},
+		{Number: 5432, Protocol: "tcp", Name: "db"},
+	})
+	requester := NewDialRequester(dial)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /<port>/<uri>, like the session proxy handler parses it
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		requester.ProxyRequest(w, r, parts[1], parts[0], settings)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestDialRequesterHTTP(t *testing.T) {
+	server := newTestDialRequesterServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Equal(t, "service:80", r.Host)
+
+		_, _ = fmt.Fprintf(w, "%s %s?%s", r.Method, r.URL.Path, r.URL.RawQuery)
+	}), nil)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/web/path/to/file?key=value", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "sessionToken")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body := bufio.NewScanner(resp.Body)
+	require.True(t, body.Scan())
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "POST /path/to/file?key=value", body.Text())
+}
+
+func TestDialRequesterStreaming(t *testing.T) {
+	next := make(chan struct{})
+	defer close(next)
+
+	server := newTestDialRequesterServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 2; i++ {
+			_, _ = fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	}), nil)
+
+	resp, err := http.Get(server.URL + "/80/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// each event is received before the service writes the next one
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("event %d\n", i), line)
+
+		next <- struct{}{}
+	}
+}
+
+func TestDialRequesterWebSocket(t *testing.T) {
+	server := newTestDialRequesterServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/socket", r.URL.Path)
+
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		messageType, data, err := conn.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, conn.WriteMessage(messageType, append([]byte("echo: "), data...)))
+	}), nil)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/web/socket", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.Equal(t, "echo: hello", string(data))
+}
+
+func TestDialRequesterFailures(t *testing.T) {
+	tests := map[string]struct {
+		path               string
+		dialErr            error
+		expectedStatusCode int
+	}{
+		"unknown port": {
+			path:               "/8080/",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"port without HTTP": {
+			path:               "/db/",
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		"service not ready": {
+			path:               "/web/",
+			dialErr:            fmt.Errorf("container not running: %w", ErrServiceNotReady),
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		"dial failure": {
+			path:               "/web/",
+			dialErr:            fmt.Errorf("connection refused"),
+			expectedStatusCode: http.StatusBadGateway,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			server := newTestDialRequesterServer(t, http.NotFoundHandler(), func(context.Context, *Settings, Port) (net.Conn, error) {
+				return nil, tt.dialErr
+			})
+
+			resp, err := http.Get(server.URL + tt.path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+		})
+	}
+}
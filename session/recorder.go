@@ -0,0 +1,191 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	asciicastVersion = 2
+
+	asciicastInputEvent  = "i"
+	asciicastOutputEvent = "o"
+
+	// The terminal protocol doesn't carry the size of the terminal, so
+	// recordings use the size a web terminal starts with.
+	recordingWidth  = 80
+	recordingHeight = 24
+)
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder saves everything typed and printed in a terminal session as an
+// asciicast v2 recording. The file is created with the first recorded event,
+// so sessions that nobody connected to don't leave empty recordings behind.
+type Recorder struct {
+	path string
+
+	file    *os.File
+	start   time.Time
+	pending map[string][]byte
+	err     error
+
+	lock sync.Mutex
+}
+
+func NewRecorder(path string) *Recorder {
+	return &Recorder{
+		path:    path,
+		pending: make(map[string][]byte),
+	}
+}
+
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Recorded returns true when at least one event was saved in the recording
+func (r *Recorder) Recorded() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.file != nil
+}
+
+func (r *Recorder) RecordInput(data []byte) error {
+	return r.record(asciicastInputEvent, data)
+}
+
+func (r *Recorder) RecordOutput(data []byte) error {
+	return r.record(asciicastOutputEvent, data)
+}
+
+func (r *Recorder) record(eventType string, data []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.file == nil {
+		r.err = r.open()
+		if r.err != nil {
+			return r.err
+		}
+	}
+
+	// Multi-byte characters can be split between two messages, keep the
+	// incomplete tail until the rest of it arrives.
+	data = append(r.pending[eventType], data...)
+	complete := utf8CompleteLength(data)
+	r.pending[eventType] = append([]byte(nil), data[complete:]...)
+
+	if complete == 0 {
+		return nil
+	}
+
+	r.err = r.writeEvent(eventType, data[:complete])
+
+	return r.err
+}
+
+func (r *Recorder) open() error {
+	err := os.MkdirAll(filepath.Dir(r.path), 0700)
+	if err != nil {
+		return fmt.Errorf("creating recordings directory: %w", err)
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("creating recording: %w", err)
+	}
+
+	r.file = file
+	r.start = time.Now()
+
+	return r.writeLine(asciicastHeader{
+		Version:   asciicastVersion,
+		Width:     recordingWidth,
+		Height:    recordingHeight,
+		Timestamp: r.start.Unix(),
+		Env:       map[string]string{"TERM": "xterm"},
+	})
+}
+
+func (r *Recorder) writeEvent(eventType string, data []byte) error {
+	elapsed := time.Since(r.start).Round(time.Microsecond).Seconds()
+
+	return r.writeLine([]interface{}{elapsed, eventType, string(data)})
+}
+
+func (r *Recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding recording event: %w", err)
+	}
+
+	_, err = r.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing recording: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes the incomplete characters that are left and closes the
+// recording. Nothing can be recorded after it was closed.
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		r.err = os.ErrClosed
+		return nil
+	}
+
+	if r.err == nil {
+		for _, eventType := range []string{asciicastInputEvent, asciicastOutputEvent} {
+			if len(r.pending[eventType]) > 0 {
+				_ = r.writeEvent(eventType, r.pending[eventType])
+			}
+		}
+	}
+
+	if r.err == os.ErrClosed {
+		return nil
+	}
+
+	r.err = os.ErrClosed
+
+	return r.file.Close()
+}
+
+// utf8CompleteLength returns the length of data without a trailing
+// incomplete UTF-8 sequence
+func utf8CompleteLength(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+
+		if utf8.FullRune(data[i:]) {
+			return len(data)
+		}
+
+		return i
+	}
+
+	return len(data)
+}
@@ -0,0 +1,111 @@
+//go:build !integration
+// +build !integration
+
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecording(t *testing.T, path string) (asciicastHeader, [][]interface{}) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.NotEmpty(t, lines)
+
+	var header asciicastHeader
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+
+	var events [][]interface{}
+	for _, line := range lines[1:] {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		require.Len(t, event, 3)
+		events = append(events, event)
+	}
+
+	return header, events
+}
+
+func eventsData(events [][]interface{}) [][2]string {
+	var data [][2]string
+	for _, event := range events {
+		data = append(data, [2]string{event[1].(string), event[2].(string)})
+	}
+
+	return data
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recordings", "session.cast")
+	recorder := NewRecorder(path)
+
+	assert.False(t, recorder.Recorded())
+
+	require.NoError(t, recorder.RecordInput([]byte("ls\r")))
+	require.NoError(t, recorder.RecordOutput([]byte("file\r\n")))
+	// "é" split between two messages
+	require.NoError(t, recorder.RecordOutput([]byte{'a', 0xc3}))
+	require.NoError(t, recorder.RecordOutput([]byte{0xa9, 'b'}))
+	// incomplete "€" that is left when the recording is closed
+	require.NoError(t, recorder.RecordOutput([]byte{0xe2, 0x82}))
+
+	assert.True(t, recorder.Recorded())
+	require.NoError(t, recorder.Close())
+	assert.Error(t, recorder.RecordInput([]byte("exit")))
+
+	header, events := readRecording(t, path)
+	assert.Equal(t, asciicastVersion, header.Version)
+	assert.Equal(t, recordingWidth, header.Width)
+	assert.Equal(t, recordingHeight, header.Height)
+	assert.NotZero(t, header.Timestamp)
+
+	assert.Equal(t, [][2]string{
+		{"i", "ls\r"},
+		{"o", "file\r\n"},
+		{"o", "a"},
+		{"o", "éb"},
+		{"o", "��"},
+	}, eventsData(events))
+
+	for i := 1; i < len(events); i++ {
+		assert.GreaterOrEqual(t, events[i][0].(float64), events[i-1][0].(float64))
+	}
+}
+
+func TestRecorderWithoutEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	recorder := NewRecorder(path)
+
+	require.NoError(t, recorder.Close())
+	assert.False(t, recorder.Recorded())
+	assert.NoFileExists(t, path)
+}
+
+func TestUTF8CompleteLength(t *testing.T) {
+	tests := map[string]struct {
+		data     []byte
+		expected int
+	}{
+		"empty":                  {data: nil, expected: 0},
+		"ascii":                  {data: []byte("abc"), expected: 3},
+		"complete multi-byte":    {data: []byte("aé"), expected: 3},
+		"incomplete two bytes":   {data: []byte{'a', 0xc3}, expected: 1},
+		"incomplete three bytes": {data: []byte{'a', 0xe2, 0x82}, expected: 1},
+		"invalid continuation":   {data: []byte{0x82, 0x82}, expected: 2},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expected, utf8CompleteLength(tt.data))
+		})
+	}
+}
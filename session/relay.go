@@ -0,0 +1,268 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	gitlabterminal "gitlab.com/gitlab-org/gitlab-terminal"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/terminal"
+)
+
+const (
+	terminalSubprotocol       = "terminal.gitlab.com"
+	base64TerminalSubprotocol = "base64.terminal.gitlab.com"
+
+	relayCloseTimeout = 5 * time.Second
+)
+
+var (
+	errPipeListenerClosed = errors.New("pipe listener closed")
+
+	relayUpgrader = &websocket.Upgrader{
+		Subprotocols: []string{terminalSubprotocol, base64TerminalSubprotocol},
+	}
+)
+
+// pipeListener hands a single in-memory connection to an http.Server
+type pipeListener struct {
+	conn  net.Conn
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener(conn net.Conn) *pipeListener {
+	l := &pipeListener{
+		conn:  conn,
+		conns: make(chan net.Conn, 1),
+		done:  make(chan struct{}),
+	}
+	l.conns <- conn
+
+	return l
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errPipeListenerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// terminalResponseWriter only exposes what a terminal needs from the response
+// of the in-process server to upgrade the web socket connection
+type terminalResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w terminalResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response can't be hijacked")
+	}
+
+	return hijacker.Hijack()
+}
+
+// terminalUpstream is the web socket connection to a terminal.Conn that was
+// started in-process, so the session sees every message that is exchanged
+// with the terminal.
+type terminalUpstream struct {
+	conn     *websocket.Conn
+	listener *pipeListener
+	done     chan struct{}
+}
+
+func dialTerminal(
+	conn terminal.Conn,
+	timeoutCh chan error,
+	disconnectCh chan error,
+) (*terminalUpstream, *http.Response, error) {
+	serverConn, clientConn := net.Pipe()
+
+	upstream := &terminalUpstream{
+		listener: newPipeListener(serverConn),
+		done:     make(chan struct{}),
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(upstream.done)
+			conn.Start(terminalResponseWriter{ResponseWriter: w}, r, timeoutCh, disconnectCh)
+		}),
+	}
+	go func() { _ = server.Serve(upstream.listener) }()
+
+	dialer := &websocket.Dialer{
+		NetDial: func(string, string) (net.Conn, error) {
+			return clientConn, nil
+		},
+		Subprotocols: []string{terminalSubprotocol},
+	}
+
+	wsConn, resp, err := dialer.Dial("ws://terminal/exec", nil)
+	if err != nil {
+		_ = clientConn.Close()
+		_ = upstream.listener.Close()
+		return nil, resp, err
+	}
+
+	upstream.conn = wsConn
+
+	return upstream, resp, nil
+}
+
+// Close closes the connection to the terminal and waits until it stopped
+func (u *terminalUpstream) Close() {
+	_ = u.conn.Close()
+	_ = u.listener.Close()
+	<-u.done
+}
+
+// relayTerminal connects the owner of the session to the terminal. Everything
+// the terminal prints is also sent to the viewers, and all of it is saved in
+// the recording of the session.
+func (s *Session) relayTerminal(w http.ResponseWriter, r *http.Request, conn terminal.Conn, logger *logrus.Entry) {
+	upstream, resp, err := dialTerminal(conn, s.TimeoutCh, s.DisconnectCh)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if resp != nil {
+			status = resp.StatusCode
+			_ = resp.Body.Close()
+		}
+
+		logger.WithError(err).Warn("Terminal connection was not upgraded")
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer upstream.Close()
+
+	wsConn, err := relayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.WithError(err).Error("Failed to upgrade terminal connection")
+		return
+	}
+	defer wsConn.Close()
+
+	downstream := gitlabterminal.Wrap(wsConn, wsConn.Subprotocol())
+
+	stopCh := make(chan error, 3)
+	go pingLoop(downstream, stopCh)
+	go s.relayInput(downstream, upstream.conn, stopCh)
+	go s.relayOutput(upstream.conn, downstream, stopCh)
+
+	err = <-stopCh
+	if err != nil {
+		logger.WithError(err).Debug("Terminal relay stopped")
+	}
+
+	_ = downstream.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(relayCloseTimeout),
+	)
+}
+
+func (s *Session) relayInput(from gitlabterminal.Connection, to *websocket.Conn, stopCh chan error) {
+	for {
+		messageType, data, err := from.ReadMessage()
+		if err != nil {
+			stopCh <- err
+			return
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		err = s.record(data, (*Recorder).RecordInput)
+		if err != nil {
+			stopCh <- err
+			return
+		}
+
+		err = to.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			stopCh <- err
+			return
+		}
+	}
+}
+
+func (s *Session) relayOutput(from *websocket.Conn, to gitlabterminal.Connection, stopCh chan error) {
+	for {
+		messageType, data, err := from.ReadMessage()
+		if err != nil {
+			stopCh <- err
+			return
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		// When the recording can't be saved the terminal is disconnected,
+		// nothing can be done in the session without an audit trail.
+		err = s.record(data, (*Recorder).RecordOutput)
+		if err != nil {
+			stopCh <- err
+			return
+		}
+
+		s.broadcast(data)
+
+		err = to.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			stopCh <- err
+			return
+		}
+	}
+}
+
+func (s *Session) record(data []byte, recordFn func(*Recorder, []byte) error) error {
+	recorder := s.getRecorder()
+	if recorder == nil {
+		return nil
+	}
+
+	err := recordFn(recorder, data)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to record terminal session")
+	}
+
+	return err
+}
+
+// pingLoop keeps the connection with the browser alive, the same way the
+// terminal does for connections it upgrades itself
+func pingLoop(conn gitlabterminal.Connection, stopCh chan error) {
+	ticker := time.NewTicker(gitlabterminal.BrowserPingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deadline := time.Now().Add(relayCloseTimeout)
+		err := conn.WriteControl(websocket.PingMessage, nil, deadline)
+		if err != nil {
+			stopCh <- err
+			return
+		}
+	}
+}
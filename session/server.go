@@ -14,6 +14,8 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/certificate"
 )
 
+const defaultFailedAuthWindow = time.Minute
+
 var (
 	ErrInvalidURL = errors.New("url not valid, scheme defined")
 )
@@ -21,11 +23,13 @@ var (
 type sessionFinderFn func(url string) *Session
 
 type Server struct {
-	config        ServerConfig
-	log           *logrus.Entry
-	tlsListener   net.Listener
-	sessionFinder sessionFinderFn
-	httpServer    *http.Server
+	config         ServerConfig
+	log            *logrus.Entry
+	tlsListener    net.Listener
+	sessionFinder  sessionFinderFn
+	httpServer     *http.Server
+	authenticators []Authenticator
+	limiter        *failedAuthLimiter
 
 	CertificatePublicKey []byte
 	AdvertiseAddress     string
@@ -35,6 +39,21 @@ type ServerConfig struct {
 	AdvertiseAddress string
 	ListenAddress    string
 	ShutdownTimeout  time.Duration
+
+	// ClientCAFile requires the clients to present a TLS certificate signed
+	// by the CA in the file
+	ClientCAFile string
+	// AllowedNetworks only allows clients from the CIDR ranges
+	AllowedNetworks []string
+	// Clients that fail to authenticate MaxFailedAuthAttempts times are
+	// rejected until FailedAuthWindow passed since their first failure.
+	// Limiting is disabled when MaxFailedAuthAttempts is 0.
+	MaxFailedAuthAttempts int
+	FailedAuthWindow      time.Duration
+
+	// Authenticators are checked after the ones that are configured above
+	Authenticators []Authenticator
+	Metrics        *AuthMetrics
 }
 
 func NewServer(
@@ -69,6 +88,17 @@ func NewServer(
 		MinVersion:   tls.VersionTLS12,
 	}
 
+	err = server.setAuthenticators()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ClientCAFile != "" {
+		// The certificate is verified by its authenticator, so failures are
+		// counted and limited the same way as any other failure.
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+
 	// We separate out the listener creation here so that we can return an error
 	// if the provided address is invalid or there is some other listener error.
 	listener, err := net.Listen("tcp", server.config.ListenAddress)
@@ -89,6 +119,39 @@ func NewServer(
 	return &server, nil
 }
 
+func (s *Server) setAuthenticators() error {
+	if len(s.config.AllowedNetworks) > 0 {
+		authenticator, err := NewNetworkAuthenticator(s.config.AllowedNetworks)
+		if err != nil {
+			return err
+		}
+
+		s.authenticators = append(s.authenticators, authenticator)
+	}
+
+	if s.config.ClientCAFile != "" {
+		authenticator, err := NewClientCertificateAuthenticator(s.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		s.authenticators = append(s.authenticators, authenticator)
+	}
+
+	s.authenticators = append(s.authenticators, s.config.Authenticators...)
+
+	if s.config.MaxFailedAuthAttempts > 0 {
+		window := s.config.FailedAuthWindow
+		if window <= 0 {
+			window = defaultFailedAuthWindow
+		}
+
+		s.limiter = newFailedAuthLimiter(s.config.MaxFailedAuthAttempts, window)
+	}
+
+	return nil
+}
+
 func (s *Server) getPublicHost() (string, error) {
 	for _, address := range []string{s.config.AdvertiseAddress, s.config.ListenAddress} {
 		if address == "" {
@@ -131,19 +194,60 @@ func (s *Server) setAdvertiseAddress() error {
 }
 
 func (s *Server) handleSessionRequest(w http.ResponseWriter, r *http.Request) {
-	logger := s.log.WithField("uri", r.RequestURI)
+	ip := clientIP(r)
+	logger := s.log.WithFields(logrus.Fields{
+		"uri":    r.RequestURI,
+		"client": ip,
+	})
 	logger.Debug("Processing session request")
 
+	if s.limiter != nil && s.limiter.blocked(ip) {
+		logger.Warn("Too many failed authentication attempts, rejecting request")
+		if s.config.Metrics != nil {
+			s.config.Metrics.rateLimited.Inc()
+		}
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	for _, authenticator := range s.authenticators {
+		err := authenticator.Authenticate(r)
+		if err != nil {
+			logger.WithError(err).WithField("authenticator", authenticator.Name()).Error("Request not authenticated")
+			s.authFailed(ip, authenticator.Name())
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
 	session := s.sessionFinder(r.RequestURI)
 	if session == nil || session.Handler() == nil { //nolint:staticcheck
 		logger.Error("Mux handler not found")
+		s.authFailed(ip, authFailureUnknownSession)
 		http.NotFound(w, r)
 		return
 	}
 
+	r = withFailedAuthReporter(r, func(reason string) {
+		s.authFailed(ip, reason)
+	})
+
 	session.Handler().ServeHTTP(w, r)
 }
 
+func (s *Server) authFailed(ip string, reason string) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.failures.WithLabelValues(reason).Inc()
+	}
+
+	// Sessions are removed when their job finishes, so GitLab itself requests unknown
+	// sessions while a user still has the terminal open. Only the other failures
+	// count toward the limit, which would otherwise block GitLab.
+	if s.limiter != nil && reason != authFailureUnknownSession {
+		s.limiter.failed(ip)
+	}
+}
+
 func (s *Server) Start() error {
 	if s.httpServer == nil {
 		return errors.New("http server not set")
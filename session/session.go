@@ -24,6 +24,10 @@ type Session struct {
 	Endpoint string
 	Token    string
 
+	// MaxViewers is the number of read-only viewers the session can be
+	// shared with. Sharing is disabled when it's 0.
+	MaxViewers int
+
 	mux *http.ServeMux
 
 	interactiveTerminal terminal.InteractiveTerminal
@@ -31,6 +35,11 @@ type Session struct {
 
 	proxyPool proxy.Pool
 
+	viewerToken string
+	viewers     map[*viewer]struct{}
+
+	recorder *Recorder
+
 	// Signal when client disconnects from terminal.
 	DisconnectCh chan error
 	// Signal when terminal session timeout.
@@ -58,6 +67,8 @@ func NewSession(logger *logrus.Entry) (*Session, error) {
 		DisconnectCh: make(chan error),
 		TimeoutCh:    make(chan error),
 
+		viewers: make(map[*viewer]struct{}),
+
 		log: logger,
 	}
 
@@ -96,6 +107,7 @@ func (s *Session) withAuthorization(next http.Handler) http.Handler {
 
 		if s.Token != r.Header.Get("Authorization") {
 			logger.Error("Authorization header is not valid")
+			reportFailedAuth(r, authFailureToken)
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
@@ -111,6 +123,8 @@ func (s *Session) setMux() {
 	s.mux = http.NewServeMux()
 	s.mux.Handle(s.Endpoint+"/proxy/", s.withAuthorization(http.HandlerFunc(s.proxyHandler)))
 	s.mux.Handle(s.Endpoint+"/exec", s.withAuthorization(http.HandlerFunc(s.execHandler)))
+	s.mux.Handle(s.Endpoint+"/share", s.withAuthorization(http.HandlerFunc(s.shareHandler)))
+	s.mux.Handle(s.Endpoint+"/view", s.withViewerAuthorization(http.HandlerFunc(s.viewHandler)))
 }
 
 func (s *Session) proxyHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +184,7 @@ func (s *Session) execHandler(w http.ResponseWriter, r *http.Request) {
 
 	defer s.closeTerminalConn(terminalConn)
 	logger.Debugln("Starting terminal session")
-	terminalConn.Start(w, r, s.TimeoutCh, s.DisconnectCh)
+	s.relayTerminal(w, r, terminalConn, logger)
 }
 
 func (s *Session) terminalAvailable() bool {
@@ -225,6 +239,21 @@ func (s *Session) SetProxyPool(pooler proxy.Pooler) {
 	s.proxyPool = pooler.Pool()
 }
 
+// SetRecorder saves everything typed and printed in the terminal of the
+// session with the recorder
+func (s *Session) SetRecorder(recorder *Recorder) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.recorder = recorder
+}
+
+func (s *Session) getRecorder() *Recorder {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.recorder
+}
+
 //nolint:staticcheck
 func (s *Session) Handler() http.Handler {
 	return s.mux
@@ -251,6 +280,26 @@ func (s *Session) Kill() error {
 	return err
 }
 
+// Close disconnects the terminal and all the viewers of the session, and
+// finishes its recording
+func (s *Session) Close() error {
+	err := s.Kill()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.viewerToken = ""
+	s.closeViewers()
+
+	if s.recorder != nil {
+		if recErr := s.recorder.Close(); recErr != nil && err == nil {
+			err = recErr
+		}
+	}
+
+	return err
+}
+
 // parseProxyParams returns the service, port and requestedURI
 // from a proxy path. Service and port are not optional.
 func parseProxyParams(path string) (service string, port string, uri string, ok bool) {
@@ -0,0 +1,254 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	gitlabterminal "gitlab.com/gitlab-org/gitlab-terminal"
+)
+
+// viewerBufferSize is the number of terminal messages that can wait to be sent
+// to a viewer. Viewers that fall further behind are disconnected, so a slow
+// viewer never slows down the terminal of the owner.
+const viewerBufferSize = 256
+
+type tooManyViewersError struct{}
+
+func (tooManyViewersError) Error() string {
+	return "Too many viewers"
+}
+
+type viewer struct {
+	conn     *websocket.Conn
+	messages chan []byte
+	done     chan struct{}
+}
+
+func (v *viewer) close() {
+	close(v.done)
+}
+
+// ShareResponse is returned to the owner of the session when it's shared with
+// read-only viewers
+type ShareResponse struct {
+	Endpoint      string `json:"endpoint"`
+	Authorization string `json:"authorization"`
+	MaxViewers    int    `json:"max_viewers"`
+}
+
+func (s *Session) withViewerAuthorization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := s.log.WithField("uri", r.RequestURI)
+		logger.Debug("Endpoint session viewer request")
+
+		token := s.getViewerToken()
+		if token == "" || token != r.Header.Get("Authorization") {
+			logger.Error("Viewer authorization header is not valid")
+			reportFailedAuth(r, authFailureViewerToken)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shareHandler lets the owner of the session share it with read-only viewers,
+// or revoke the access of all the viewers
+func (s *Session) shareHandler(w http.ResponseWriter, r *http.Request) {
+	logger := s.log.WithField("uri", r.RequestURI)
+
+	if s.MaxViewers <= 0 {
+		logger.Warn("Sharing terminal sessions is disabled")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		token, err := s.share()
+		if err != nil {
+			logger.WithError(err).Error("Failed to share terminal session")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("Terminal session shared with viewers")
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ShareResponse{
+			Endpoint:      s.Endpoint + "/view",
+			Authorization: token,
+			MaxViewers:    s.MaxViewers,
+		})
+	case http.MethodDelete:
+		s.unshare()
+		logger.Info("Terminal session no longer shared with viewers")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Session) share() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.viewerToken != "" {
+		return s.viewerToken, nil
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.viewerToken = token
+
+	return token, nil
+}
+
+func (s *Session) unshare() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.viewerToken = ""
+	s.closeViewers()
+}
+
+func (s *Session) getViewerToken() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.viewerToken
+}
+
+func (s *Session) viewHandler(w http.ResponseWriter, r *http.Request) {
+	logger := s.log.WithField("uri", r.RequestURI)
+	logger.Debug("View terminal session request")
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		logger.Error("Request is not a web socket connection")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, err := s.addViewer()
+	if err != nil {
+		logger.WithError(err).Warn("Revoking viewer connection")
+		http.Error(w, http.StatusText(http.StatusLocked), http.StatusLocked)
+		return
+	}
+
+	conn, err := relayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.removeViewer(v)
+		logger.WithError(err).Error("Failed to upgrade viewer connection")
+		return
+	}
+	defer conn.Close()
+
+	v.conn = conn
+	defer s.removeViewer(v)
+
+	logger.Info("Viewer connected to terminal session")
+	defer logger.Info("Viewer disconnected from terminal session")
+
+	s.serveViewer(v)
+}
+
+func (s *Session) addViewer() (*viewer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.viewers) >= s.MaxViewers {
+		return nil, tooManyViewersError{}
+	}
+
+	v := &viewer{
+		messages: make(chan []byte, viewerBufferSize),
+		done:     make(chan struct{}),
+	}
+	s.viewers[v] = struct{}{}
+
+	return v, nil
+}
+
+func (s *Session) removeViewer(v *viewer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.viewers[v]; ok {
+		delete(s.viewers, v)
+		v.close()
+	}
+}
+
+// closeViewers disconnects all the viewers, the session lock must be held
+func (s *Session) closeViewers() {
+	for v := range s.viewers {
+		delete(s.viewers, v)
+		v.close()
+	}
+}
+
+// serveViewer sends the output of the terminal to the viewer. Everything the
+// viewer sends is discarded, the connection is read only to handle the control
+// messages of the web socket.
+func (s *Session) serveViewer(v *viewer) {
+	conn := gitlabterminal.Wrap(v.conn, v.conn.Subprotocol())
+
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(gitlabterminal.BrowserPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-v.messages:
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(relayCloseTimeout)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		case <-v.done:
+			_ = conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(relayCloseTimeout),
+			)
+			return
+		}
+	}
+}
+
+// broadcast sends the output of the terminal to all the viewers
+func (s *Session) broadcast(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for v := range s.viewers {
+		select {
+		case v.messages <- data:
+		default:
+			s.log.Warn("Viewer can't keep up with the terminal, disconnecting")
+			delete(s.viewers, v)
+			v.close()
+		}
+	}
+}
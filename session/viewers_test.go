@@ -0,0 +1,237 @@
+//go:build !integration
+// +build !integration
+
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/terminal"
+)
+
+// echoTerminalConn upgrades the connection itself, like the terminals of the
+// executors do, and prints back everything that is typed
+type echoTerminalConn struct {
+	closed chan struct{}
+}
+
+func newEchoTerminalConn() *echoTerminalConn {
+	return &echoTerminalConn{closed: make(chan struct{})}
+}
+
+func (c *echoTerminalConn) Start(w http.ResponseWriter, r *http.Request, timeoutCh, disconnectCh chan error) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{terminalSubprotocol}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		err = conn.WriteMessage(websocket.BinaryMessage, append([]byte("echo: "), data...))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *echoTerminalConn) Close() error {
+	return nil
+}
+
+type echoTerminal struct{}
+
+func (echoTerminal) Connect() (terminal.Conn, error) {
+	return newEchoTerminalConn(), nil
+}
+
+func newSharedTestSession(t *testing.T, maxViewers int) (*Session, *httptest.Server) {
+	sess, err := NewSession(nil)
+	require.NoError(t, err)
+
+	sess.Token = "ownerToken"
+	sess.MaxViewers = maxViewers
+	sess.SetInteractiveTerminal(echoTerminal{})
+
+	server := httptest.NewServer(sess.Handler())
+	t.Cleanup(server.Close)
+
+	return sess, server
+}
+
+func dialSession(t *testing.T, server *httptest.Server, path string, token string, subprotocol string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	header := http.Header{"Authorization": []string{token}}
+
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+
+	assert.Equal(t, subprotocol, conn.Subprotocol())
+
+	return conn
+}
+
+func shareSession(t *testing.T, sess *Session, server *httptest.Server, method string) (*http.Response, ShareResponse) {
+	req, err := http.NewRequest(method, server.URL+sess.Endpoint+"/share", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", sess.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var share ShareResponse
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&share))
+	}
+
+	return resp, share
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) (int, string) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	return messageType, string(data)
+}
+
+func waitForViewers(t *testing.T, sess *Session, count int) {
+	require.Eventually(t, func() bool {
+		sess.lock.Lock()
+		defer sess.lock.Unlock()
+
+		return len(sess.viewers) == count
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestShareWithViewers(t *testing.T) {
+	sess, server := newSharedTestSession(t, 2)
+
+	path := filepath.Join(t.TempDir(), "session.cast")
+	sess.SetRecorder(NewRecorder(path))
+
+	resp, share := shareSession(t, sess, server, http.MethodPost)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, sess.Endpoint+"/view", share.Endpoint)
+	assert.NotEmpty(t, share.Authorization)
+	assert.NotEqual(t, sess.Token, share.Authorization)
+	assert.Equal(t, 2, share.MaxViewers)
+
+	// the viewer token is kept until the access is revoked
+	_, again := shareSession(t, sess, server, http.MethodPost)
+	assert.Equal(t, share.Authorization, again.Authorization)
+
+	owner := dialSession(t, server, sess.Endpoint+"/exec", sess.Token, terminalSubprotocol)
+	viewer := dialSession(t, server, share.Endpoint, share.Authorization, base64TerminalSubprotocol)
+	waitForViewers(t, sess, 1)
+
+	// viewers can't type in the terminal
+	require.NoError(t, viewer.WriteMessage(websocket.TextMessage, []byte("cm0gLXJmIC8=")))
+
+	require.NoError(t, owner.WriteMessage(websocket.BinaryMessage, []byte("ls")))
+
+	messageType, data := readMessage(t, owner)
+	assert.Equal(t, websocket.BinaryMessage, messageType)
+	assert.Equal(t, "echo: ls", data)
+
+	messageType, data = readMessage(t, viewer)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.Equal(t, "ZWNobzogbHM=", data)
+
+	resp, _ = shareSession(t, sess, server, http.MethodDelete)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	waitForViewers(t, sess, 0)
+
+	_, _, err := viewer.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error: %v", err)
+
+	require.NoError(t, owner.Close())
+	require.Eventually(t, func() bool { return !sess.Connected() }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, sess.Close())
+
+	_, events := readRecording(t, path)
+	assert.Equal(t, [][2]string{{"i", "ls"}, {"o", "echo: ls"}}, eventsData(events))
+}
+
+func TestViewFailedRequest(t *testing.T) {
+	tests := map[string]struct {
+		maxViewers         int
+		share              bool
+		connectedViewers   int
+		authorization      func(share ShareResponse) string
+		expectedShareCode  int
+		expectedStatusCode int
+	}{
+		"sharing disabled": {
+			expectedShareCode:  http.StatusForbidden,
+			authorization:      func(ShareResponse) string { return "" },
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"session not shared": {
+			maxViewers:         1,
+			authorization:      func(ShareResponse) string { return "" },
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"owner token": {
+			maxViewers:         1,
+			share:              true,
+			expectedShareCode:  http.StatusOK,
+			authorization:      func(ShareResponse) string { return "ownerToken" },
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"too many viewers": {
+			maxViewers:         1,
+			share:              true,
+			connectedViewers:   1,
+			expectedShareCode:  http.StatusOK,
+			authorization:      func(share ShareResponse) string { return share.Authorization },
+			expectedStatusCode: http.StatusLocked,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			sess, server := newSharedTestSession(t, tt.maxViewers)
+
+			var share ShareResponse
+			if tt.share || tt.maxViewers == 0 {
+				var resp *http.Response
+				resp, share = shareSession(t, sess, server, http.MethodPost)
+				assert.Equal(t, tt.expectedShareCode, resp.StatusCode)
+			}
+
+			for i := 0; i < tt.connectedViewers; i++ {
+				dialSession(t, server, share.Endpoint, share.Authorization, terminalSubprotocol)
+			}
+			waitForViewers(t, sess, tt.connectedViewers)
+
+			dialer := websocket.Dialer{}
+			header := http.Header{"Authorization": []string{tt.authorization(share)}}
+			_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+sess.Endpoint+"/view", header)
+			require.Error(t, err)
+			require.NotNil(t, resp)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+		})
+	}
+}
@@ -39,15 +39,7 @@ func (b *AbstractShell) writeCdBuildDir(w ShellWriter, info common.ShellScriptIn
 }
 
 func (b *AbstractShell) cacheFile(build *common.Build, userKey string) (key, file string) {
-	if build.CacheDir == "" {
-		return
-	}
-
-	// Deduce cache key
-	key = path.Join(build.JobInfo.Name, build.GitInfo.Ref)
-	if userKey != "" {
-		key = build.GetAllVariables().ExpandValue(userKey)
-	}
+	key = cacheKey(build, userKey)
 
 	// Ignore cache without the key
 	if key == "" {
@@ -130,11 +122,7 @@ func (b *AbstractShell) extractCacheOrFallbackCacheWrapper(
 	cacheFile string,
 	cacheKey string,
 ) {
-	cacheFallbackKey := info.Build.GetAllVariables().Get("CACHE_FALLBACK_KEY")
-	if strings.HasSuffix(cacheFallbackKey, "-protected") {
-		// The `-protected` suffix is reserved for protected refs, so we disallow it from user-specified values.
-		cacheFallbackKey = ""
-	}
+	cacheFallbackKey := cacheFallbackKey(info.Build)
 
 	// Execute cache-extractor command. Failure is not fatal.
 	b.guardRunnerCommand(w, info.RunnerCommand, "Extracting cache", func() {
@@ -155,9 +143,8 @@ func (b *AbstractShell) addExtractCacheCommand(
 		"--timeout", strconv.Itoa(info.Build.GetCacheRequestTimeout()),
 	}
 
-	if url := cache.GetCacheDownloadURL(info.Build, cacheKey); url != nil {
-		args = append(args, "--url", url.String())
-	}
+	args = append(args, getCacheDownloadURL(info.Build, cacheKey)...)
+	args = append(args, getCacheChunksArgs(info.Build, false)...)
 
 	w.Noticef("Checking cache for %s...", cacheKey)
 	w.IfCmdWithOutput(info.RunnerCommand, args...)
@@ -681,6 +668,7 @@ func (b *AbstractShell) addCacheUploadCommand(
 
 	// Generate cache upload address
 	args = append(args, getCacheUploadURL(info.Build, cacheKey)...)
+	args = append(args, getCacheChunksArgs(info.Build, true)...)
 
 	env := cache.GetCacheUploadEnv(info.Build, cacheKey)
 
@@ -725,6 +713,43 @@ func getCacheUploadURL(build *common.Build, cacheKey string) []string {
 	return urlArgs
 }
 
+// getCacheDownloadURL will first try to generate the pre-signed URL, then
+// fallback to the GoCloud URL, for the adapters storing the cache on the
+// filesystem of the jobs. The GoCloud URL is opened without credentials.
+func getCacheDownloadURL(build *common.Build, cacheKey string) []string {
+	if downloadURL := cache.GetCacheDownloadURL(build, cacheKey); downloadURL != nil {
+		return []string{"--url", downloadURL.String()}
+	}
+
+	goCloudURL := cache.GetCacheGoCloudURL(build, cacheKey)
+	if goCloudURL == nil {
+		return []string{}
+	}
+
+	return []string{"--gocloud-url", goCloudURL.String()}
+}
+
+// getCacheChunksArgs returns the arguments of the chunked cache format, when
+// it's configured. The helper falls back to the zip format when the cache
+// adapter doesn't support it.
+func getCacheChunksArgs(build *common.Build, archive bool) []string {
+	if build.Runner == nil || build.Runner.Cache == nil ||
+		build.Runner.Cache.GetFormat() != common.CacheFormatChunked {
+		return nil
+	}
+
+	var args []string
+	if archive {
+		args = append(args, "--format", string(common.CacheFormatChunked))
+	}
+
+	if chunksURL := cache.GetCacheChunksURL(build); chunksURL != nil {
+		args = append(args, "--chunks-url", chunksURL.String())
+	}
+
+	return args
+}
+
 func (b *AbstractShell) writeUploadArtifact(w ShellWriter, info common.ShellScriptInfo, artifact common.Artifact) bool {
 	args := []string{
 		"artifacts-uploader",
@@ -547,6 +547,93 @@ func TestWriteWritingArchiveCacheOnFailure(t *testing.T) {
 	}
 }
 
+func TestGetCacheDownloadURL(t *testing.T) {
+	tests := map[string]struct {
+		cacheType    string
+		expectedArgs []string
+	}{
+		"pre-signed URL": {
+			cacheType:    "test",
+			expectedArgs: []string{"--url", "test://download/project/10/key"},
+		},
+		"pre-signed URL preferred to GoCloud URL": {
+			cacheType:    "goCloudTest",
+			expectedArgs: []string{"--url", "test://download/project/10/key"},
+		},
+		"GoCloud URL": {
+			cacheType:    "goCloudOnlyTest",
+			expectedArgs: []string{"--gocloud-url", "gocloud://test"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{
+						Cache: &common.CacheConfig{Type: tt.cacheType, Shared: true},
+					},
+				},
+			}
+			build.JobInfo.ProjectID = 10
+
+			assert.Equal(t, tt.expectedArgs, getCacheDownloadURL(build, "key"))
+		})
+	}
+}
+
+func TestGetCacheChunksArgs(t *testing.T) {
+	tests := map[string]struct {
+		cacheConfig  *common.CacheConfig
+		archive      bool
+		expectedArgs []string
+	}{
+		"no cache config": {
+			cacheConfig:  nil,
+			archive:      true,
+			expectedArgs: nil,
+		},
+		"zip format": {
+			cacheConfig:  &common.CacheConfig{Type: "chunksTest"},
+			archive:      true,
+			expectedArgs: nil,
+		},
+		"chunked format archive": {
+			cacheConfig: &common.CacheConfig{Type: "chunksTest", Format: common.CacheFormatChunked, Shared: true},
+			archive:     true,
+			expectedArgs: []string{
+				"--format", "chunked",
+				"--chunks-url", "test://chunks/project/10/chunks",
+			},
+		},
+		"chunked format extract": {
+			cacheConfig: &common.CacheConfig{Type: "chunksTest", Format: common.CacheFormatChunked, Shared: true},
+			archive:     false,
+			expectedArgs: []string{
+				"--chunks-url", "test://chunks/project/10/chunks",
+			},
+		},
+		"chunked format not supported by the adapter": {
+			cacheConfig:  &common.CacheConfig{Type: "test", Format: common.CacheFormatChunked, Shared: true},
+			archive:      true,
+			expectedArgs: []string{"--format", "chunked"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			build := &common.Build{
+				Runner: &common.RunnerConfig{
+					RunnerSettings: common.RunnerSettings{Cache: tt.cacheConfig},
+				},
+			}
+			build.JobInfo.ProjectID = 10
+
+			assert.Equal(t, tt.expectedArgs, getCacheChunksArgs(build, tt.archive))
+		})
+	}
+}
+
 func TestAbstractShell_writeCleanupBuildDirectoryScript(t *testing.T) {
 	testCases := []struct {
 		name                 string
@@ -0,0 +1,98 @@
+package shells
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// CacheEntry describes a cache of the job handled by the restore_cache or
+// archive_cache stages, with the key computed like in the generated scripts.
+// It's used by executors handling the cache themselves instead of running the scripts.
+type CacheEntry struct {
+	Key         string
+	FallbackKey string
+	Paths       []string
+	Untracked   bool
+	Policy      common.CachePolicy
+	When        common.CacheWhen
+}
+
+func cacheKey(build *common.Build, userKey string) string {
+	if build.CacheDir == "" {
+		return ""
+	}
+
+	// Deduce cache key
+	if userKey != "" {
+		return build.GetAllVariables().ExpandValue(userKey)
+	}
+
+	return path.Join(build.JobInfo.Name, build.GitInfo.Ref)
+}
+
+func cacheFallbackKey(build *common.Build) string {
+	key := build.GetAllVariables().Get("CACHE_FALLBACK_KEY")
+	if strings.HasSuffix(key, "-protected") {
+		// The `-protected` suffix is reserved for protected refs, so we disallow it from user-specified values.
+		return ""
+	}
+
+	return key
+}
+
+// CachesToRestore returns the caches extracted by the restore_cache stage
+func CachesToRestore(build *common.Build) ([]CacheEntry, error) {
+	fallbackKey := cacheFallbackKey(build)
+
+	return cacheEntries(build, common.CachePolicyPull, func(cacheOptions common.Cache) bool {
+		return true
+	}, fallbackKey)
+}
+
+// CachesToArchive returns the caches created by the archive_cache stage,
+// or by the archive_cache_on_failure stage when onSuccess is false
+func CachesToArchive(build *common.Build, onSuccess bool) ([]CacheEntry, error) {
+	return cacheEntries(build, common.CachePolicyPush, func(cacheOptions common.Cache) bool {
+		return cacheOptions.When.ShouldCache(onSuccess)
+	}, "")
+}
+
+func cacheEntries(
+	build *common.Build,
+	policy common.CachePolicy,
+	filter func(cacheOptions common.Cache) bool,
+	fallbackKey string,
+) ([]CacheEntry, error) {
+	var entries []CacheEntry
+
+	for _, cacheOptions := range build.Cache {
+		if !filter(cacheOptions) || (len(cacheOptions.Paths) < 1 && !cacheOptions.Untracked) {
+			continue
+		}
+
+		key := cacheKey(build, cacheOptions.Key)
+		if key == "" {
+			continue
+		}
+
+		if ok, err := cacheOptions.CheckPolicy(policy); err != nil {
+			return nil, fmt.Errorf("%w for %s", err, key)
+		} else if !ok {
+			continue
+		}
+
+		entries = append(entries, CacheEntry{
+			Key:         key,
+			FallbackKey: fallbackKey,
+			Paths:       cacheOptions.Paths,
+			Untracked:   cacheOptions.Untracked,
+			Policy:      cacheOptions.Policy,
+			When:        cacheOptions.When,
+		})
+	}
+
+	return entries, nil
+}
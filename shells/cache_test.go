@@ -0,0 +1,141 @@
+//go:build !integration
+// +build !integration
+
+package shells
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func newCacheEntriesTestBuild(cacheDir string, fallbackKey string, caches ...common.Cache) *common.Build {
+	return &common.Build{
+		BuildDir: "/builds",
+		CacheDir: cacheDir,
+		Runner:   &common.RunnerConfig{},
+		JobResponse: common.JobResponse{
+			JobInfo: common.JobInfo{Name: "test"},
+			GitInfo: common.GitInfo{Ref: "main"},
+			Cache:   caches,
+			Variables: common.JobVariables{
+				{Key: "CACHE_FALLBACK_KEY", Value: fallbackKey},
+				{Key: "KEY_SUFFIX", Value: "deps"},
+			},
+		},
+	}
+}
+
+func TestCachesToRestore(t *testing.T) {
+	tests := map[string]struct {
+		build           *common.Build
+		expectedEntries []CacheEntry
+		expectedErr     bool
+	}{
+		"expanded and default keys": {
+			build: newCacheEntriesTestBuild(
+				"/cache",
+				"fallback",
+				common.Cache{Key: "key-$KEY_SUFFIX", Paths: []string{"vendor"}, Policy: common.CachePolicyPull},
+				common.Cache{Untracked: true},
+			),
+			expectedEntries: []CacheEntry{
+				{
+					Key:         "key-deps",
+					FallbackKey: "fallback",
+					Paths:       []string{"vendor"},
+					Policy:      common.CachePolicyPull,
+				},
+				{
+					Key:         "test/main",
+					FallbackKey: "fallback",
+					Untracked:   true,
+				},
+			},
+		},
+		"protected fallback key is ignored": {
+			build: newCacheEntriesTestBuild(
+				"/cache",
+				"fallback-protected",
+				common.Cache{Key: "key", Paths: []string{"vendor"}},
+			),
+			expectedEntries: []CacheEntry{
+				{Key: "key", Paths: []string{"vendor"}},
+			},
+		},
+		"caches without paths or with push policy are skipped": {
+			build: newCacheEntriesTestBuild(
+				"/cache",
+				"",
+				common.Cache{Key: "no-paths"},
+				common.Cache{Key: "push", Paths: []string{"vendor"}, Policy: common.CachePolicyPush},
+			),
+		},
+		"no cache dir": {
+			build: newCacheEntriesTestBuild("", "", common.Cache{Key: "key", Paths: []string{"vendor"}}),
+		},
+		"unknown policy": {
+			build: newCacheEntriesTestBuild(
+				"/cache",
+				"",
+				common.Cache{Key: "key", Paths: []string{"vendor"}, Policy: "unknown"},
+			),
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			entries, err := CachesToRestore(tt.build)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedEntries, entries)
+		})
+	}
+}
+
+func TestCachesToArchive(t *testing.T) {
+	build := newCacheEntriesTestBuild(
+		"/cache",
+		"fallback",
+		common.Cache{Key: "on-success", Paths: []string{"a"}},
+		common.Cache{Key: "on-failure", Paths: []string{"b"}, When: common.CacheWhenOnFailure},
+		common.Cache{Key: "always", Paths: []string{"c"}, When: common.CacheWhenAlways},
+		common.Cache{Key: "pull", Paths: []string{"d"}, Policy: common.CachePolicyPull},
+	)
+
+	tests := map[string]struct {
+		onSuccess    bool
+		expectedKeys []string
+	}{
+		"on success": {
+			onSuccess:    true,
+			expectedKeys: []string{"on-success", "always"},
+		},
+		"on failure": {
+			onSuccess:    false,
+			expectedKeys: []string{"on-failure", "always"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			entries, err := CachesToArchive(build, tt.onSuccess)
+			require.NoError(t, err)
+
+			var keys []string
+			for _, entry := range entries {
+				keys = append(keys, entry.Key)
+				assert.Empty(t, entry.FallbackKey)
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+		})
+	}
+}